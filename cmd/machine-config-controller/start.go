@@ -5,12 +5,17 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/openshift/machine-config-operator/cmd/common"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	"github.com/openshift/machine-config-operator/pkg/controller/node"
 	"github.com/openshift/machine-config-operator/pkg/controller/render"
 	"github.com/openshift/machine-config-operator/pkg/controller/template"
 	"github.com/openshift/machine-config-operator/pkg/version"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/leaderelection"
 )
 
@@ -27,6 +32,11 @@ var (
 		templates  string
 
 		resourceLockNamespace string
+
+		rejectWeakPasswordHashes bool
+		mergeConflictStrategy    string
+		checkOSImages            bool
+		rolloutPauseConfigMap    string
 	}
 )
 
@@ -34,6 +44,10 @@ func init() {
 	rootCmd.AddCommand(startCmd)
 	startCmd.PersistentFlags().StringVar(&startOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster (testing only)")
 	startCmd.PersistentFlags().StringVar(&startOpts.resourceLockNamespace, "resourcelock-namespace", metav1.NamespaceSystem, "Path to the template files used for creating MachineConfig objects")
+	startCmd.PersistentFlags().BoolVar(&startOpts.rejectWeakPasswordHashes, "reject-weak-password-hashes", false, "Reject MachineConfigs with password hashes using weak algorithms (md5, des)")
+	startCmd.PersistentFlags().StringVar(&startOpts.mergeConflictStrategy, "merge-conflict-strategy", string(mcfgv1.MergeConflictAppend), "How files and systemd units defined differently by several MachineConfigs of a pool are merged: Append, Fail, LastWins or FirstWins")
+	startCmd.PersistentFlags().BoolVar(&startOpts.checkOSImages, "check-os-images", false, "Check that the image of the osImageURL exists in its registry before rendering a MachineConfig using it")
+	startCmd.PersistentFlags().StringVar(&startOpts.rolloutPauseConfigMap, "rollout-pause-configmap", "", "namespace/name of the ConfigMap pausing the rollout of the pools named by its keys, e.g. set by an alert webhook")
 }
 
 func runStartCmd(cmd *cobra.Command, args []string) {
@@ -43,6 +57,17 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 	// To help debugging, immediately log version
 	glog.Infof("Version: %+v", version.Version)
 
+	switch mcfgv1.MergeConflictStrategy(startOpts.mergeConflictStrategy) {
+	case mcfgv1.MergeConflictAppend, mcfgv1.MergeConflictFail, mcfgv1.MergeConflictLastWins, mcfgv1.MergeConflictFirstWins:
+	default:
+		glog.Fatalf("invalid --merge-conflict-strategy %q", startOpts.mergeConflictStrategy)
+	}
+	if startOpts.rolloutPauseConfigMap != "" {
+		if namespace, name, err := cache.SplitMetaNamespaceKey(startOpts.rolloutPauseConfigMap); err != nil || namespace == "" || name == "" {
+			glog.Fatalf("invalid --rollout-pause-configmap %q: must be namespace/name", startOpts.rolloutPauseConfigMap)
+		}
+	}
+
 	cb, err := common.NewClientBuilder(startOpts.kubeconfig)
 	if err != nil {
 		glog.Fatalf("error creating clients: %v", err)
@@ -92,14 +117,44 @@ func startControllers(ctx *common.ControllerContext) error {
 		ctx.InformerFactory.Machineconfiguration().V1().MachineConfigs(),
 		ctx.ClientBuilder.KubeClientOrDie("render-controller"),
 		ctx.ClientBuilder.MachineConfigClientOrDie("render-controller"),
+		startOpts.rejectWeakPasswordHashes,
+		mcfgv1.MergeConflictStrategy(startOpts.mergeConflictStrategy),
+		startOpts.checkOSImages,
 	).Run(2, ctx.Stop)
 
-	go node.New(
+	// only the rollout pause ConfigMap is watched, not all the ConfigMaps of
+	// its namespace.
+	var (
+		pauseInformerFactory                    informers.SharedInformerFactory
+		cmInformer                              coreinformersv1.ConfigMapInformer
+		rolloutPauseNamespace, rolloutPauseName string
+	)
+	if startOpts.rolloutPauseConfigMap != "" {
+		rolloutPauseNamespace, rolloutPauseName, _ = cache.SplitMetaNamespaceKey(startOpts.rolloutPauseConfigMap)
+		pauseInformerFactory = informers.NewFilteredSharedInformerFactory(
+			ctx.ClientBuilder.KubeClientOrDie("rollout-pause-shared-informer"),
+			ctx.ResyncPeriod(),
+			rolloutPauseNamespace,
+			func(opts *metav1.ListOptions) {
+				opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", rolloutPauseName).String()
+			},
+		)
+		cmInformer = pauseInformerFactory.Core().V1().ConfigMaps()
+	}
+
+	nodeController := node.New(
 		ctx.InformerFactory.Machineconfiguration().V1().MachineConfigPools(),
 		ctx.KubeInformerFactory.Core().V1().Nodes(),
+		cmInformer,
 		ctx.ClientBuilder.KubeClientOrDie("node-update-controller"),
 		ctx.ClientBuilder.MachineConfigClientOrDie("node-update-controller"),
-	).Run(2, ctx.Stop)
+		rolloutPauseNamespace,
+		rolloutPauseName,
+	)
+	if pauseInformerFactory != nil {
+		pauseInformerFactory.Start(ctx.Stop)
+	}
+	go nodeController.Run(2, ctx.Stop)
 
 	return nil
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"os"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/openshift/machine-config-operator/cmd/common"
@@ -21,13 +23,25 @@ var (
 	}
 
 	startOpts struct {
-		kubeconfig             string
-		nodeName               string
-		rootMount              string
-		onceFrom               string
-		fromIgnition           bool
-		kubeletHealthzEnabled  bool
-		kubeletHealthzEndpoint string
+		kubeconfig              string
+		nodeName                string
+		rootMount               string
+		onceFrom                string
+		fromIgnition            bool
+		kubeletHealthzEnabled   bool
+		kubeletHealthzEndpoint  string
+		fileDurability          string
+		fileWriteWorkers        int
+		unitRestartDelay        time.Duration
+		nodeReadyTimeout        time.Duration
+		bootConfirmationTimeout time.Duration
+		immutableBaseFiles      []string
+		fileUmask               string
+		defaultFileOwner        string
+		defaultFileGroup        string
+		pullSecret              string
+		applyLogSink            string
+		applyLogSpool           string
 	}
 )
 
@@ -39,6 +53,18 @@ func init() {
 	startCmd.PersistentFlags().StringVar(&startOpts.onceFrom, "once-from", "", "Runs the daemon once using a provided file path or URL endpoint as its machine config or ignition (.ign) file source")
 	startCmd.PersistentFlags().BoolVar(&startOpts.kubeletHealthzEnabled, "kubelet-healthz-enabled", true, "kubelet healthz endpoint monitoring")
 	startCmd.PersistentFlags().StringVar(&startOpts.kubeletHealthzEndpoint, "kubelet-healthz-endpoint", "http://localhost:10248/healthz", "healthz endpoint to check health")
+	startCmd.PersistentFlags().StringVar(&startOpts.fileDurability, "file-durability", daemon.FileDurabilityFsyncFile, "how written files are synced to disk: 'file' fsyncs every file, 'batch' syncs all files at once after they are written")
+	startCmd.PersistentFlags().IntVar(&startOpts.fileWriteWorkers, "file-write-workers", 1, "how many files are written concurrently when applying a config; directories are created before any file is written")
+	startCmd.PersistentFlags().DurationVar(&startOpts.unitRestartDelay, "unit-restart-delay", 0, "how long to wait between restarting systemd units when only units changed; units are restarted in their After= order")
+	startCmd.PersistentFlags().DurationVar(&startOpts.nodeReadyTimeout, "node-ready-timeout", 10*time.Minute, "how long to wait for the node to be Ready after a reboot before marking the update degraded; 0 disables the check")
+	startCmd.PersistentFlags().DurationVar(&startOpts.bootConfirmationTimeout, "boot-confirmation-timeout", 0, "how long the node has to be Ready and pass the health checks after rebooting into a new config; a boot that isn't confirmed in time, or before the node reboots again, is rolled back to the previous config; 0 disables the rollback")
+	startCmd.PersistentFlags().StringSliceVar(&startOpts.immutableBaseFiles, "immutable-base-file", nil, "path of a base file on the node that configs may not change; updates changing it are refused; a path ending in / protects all files under it")
+	startCmd.PersistentFlags().StringVar(&startOpts.fileUmask, "file-umask", "022", "octal umask applied to the default 0666 mode of files written without a mode; the mode of files that have one is kept")
+	startCmd.PersistentFlags().StringVar(&startOpts.defaultFileOwner, "default-file-owner", "", "user name or uid owning the files written without a user; root if not set")
+	startCmd.PersistentFlags().StringVar(&startOpts.defaultFileGroup, "default-file-group", "", "group name or gid owning the files written without a group; root if not set")
+	startCmd.PersistentFlags().StringVar(&startOpts.pullSecret, "pull-secret", "", "path on the node of the registry credentials used to pull OS images; the default podman credentials are used if not set")
+	startCmd.PersistentFlags().StringVar(&startOpts.applyLogSink, "apply-log-sink", "", "http(s)://, syslog:// (UDP) or syslog+tcp:// URL structured apply logs are shipped to; apply logs are not shipped if not set")
+	startCmd.PersistentFlags().StringVar(&startOpts.applyLogSpool, "apply-log-spool", daemon.DefaultApplyLogSpoolPath, "path on the node where apply logs are buffered while the apply log sink is unavailable")
 }
 
 func runStartCmd(cmd *cobra.Command, args []string) {
@@ -55,6 +81,11 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 		glog.Fatalf("Error found when checking operating system: %s", err)
 	}
 
+	fileUmask, err := strconv.ParseUint(startOpts.fileUmask, 8, 32)
+	if err != nil {
+		glog.Fatalf("invalid --file-umask %q: %v", startOpts.fileUmask, err)
+	}
+
 	if startOpts.nodeName == "" {
 		name, ok := os.LookupEnv("NODE_NAME")
 		if !ok || name == "" {
@@ -84,11 +115,32 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 	var dn *daemon.Daemon
 	var ctx *common.ControllerContext
 
-
 	glog.Info("starting node writer")
 	nodeWriter := daemon.NewNodeWriter()
 	go nodeWriter.Run(stopCh)
 
+	var applyLogger *daemon.ApplyLogger
+	if startOpts.applyLogSink != "" {
+		sink, err := daemon.NewApplyLogSink(startOpts.applyLogSink)
+		if err != nil {
+			glog.Fatalf("failed to initialize apply log sink: %v", err)
+		}
+		applyLogger = daemon.NewApplyLogger(startOpts.nodeName, sink, startOpts.applyLogSpool)
+	}
+
+	opts := daemon.Options{
+		FileDurability:          startOpts.fileDurability,
+		FileWriteWorkers:        startOpts.fileWriteWorkers,
+		UnitRestartDelay:        startOpts.unitRestartDelay,
+		NodeReadyTimeout:        startOpts.nodeReadyTimeout,
+		BootConfirmationTimeout: startOpts.bootConfirmationTimeout,
+		ImmutableFiles:          startOpts.immutableBaseFiles,
+		FileUmask:               os.FileMode(fileUmask),
+		DefaultFileOwner:        startOpts.defaultFileOwner,
+		DefaultFileGroup:        startOpts.defaultFileGroup,
+		ApplyLogger:             applyLogger,
+	}
+
 	// If we are asked to run once and it's a valid file system path use
 	// the bare Daemon
 	if startOpts.onceFrom != "" {
@@ -96,11 +148,12 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 			startOpts.rootMount,
 			startOpts.nodeName,
 			operatingSystem,
-			daemon.NewNodeUpdaterClient(),
+			daemon.NewNodeUpdaterClient(startOpts.pullSecret),
 			daemon.NewFileSystemClient(),
 			startOpts.onceFrom,
 			startOpts.kubeletHealthzEnabled,
 			startOpts.kubeletHealthzEndpoint,
+			opts,
 			nodeWriter,
 			exitCh,
 		)
@@ -120,7 +173,7 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 			startOpts.rootMount,
 			startOpts.nodeName,
 			operatingSystem,
-			daemon.NewNodeUpdaterClient(),
+			daemon.NewNodeUpdaterClient(startOpts.pullSecret),
 			cb.MachineConfigClientOrDie(componentName),
 			cb.KubeClientOrDie(componentName),
 			daemon.NewFileSystemClient(),
@@ -128,6 +181,7 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 			ctx.KubeInformerFactory.Core().V1().Nodes(),
 			startOpts.kubeletHealthzEnabled,
 			startOpts.kubeletHealthzEndpoint,
+			opts,
 			nodeWriter,
 			exitCh,
 		)
@@ -146,8 +200,15 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 		glog.Fatalf("unable to change directory to /: %s", err)
 	}
 
+	// the spool lives on the node, so only start shipping apply logs
+	// once we are inside the chroot.
+	if applyLogger != nil {
+		glog.Info("starting apply logger")
+		go applyLogger.Run(stopCh)
+	}
+
 	if startOpts.onceFrom == "" {
-		err = dn.CheckStateOnBoot()
+		err = dn.CheckStateOnBoot(stopCh)
 		if err != nil {
 			glog.Fatalf("error checking initial state of node: %v", err)
 		}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/openshift/machine-config-operator/pkg/server"
 	"github.com/spf13/cobra"
 )
 
@@ -23,6 +29,23 @@ var (
 		isport int
 		cert   string
 		key    string
+
+		sniHostnames []string
+		sniPoolCerts []string
+
+		validateSchema bool
+
+		signingKey      string
+		signingIdentity string
+
+		debugErrors          int
+		debugErrorsTokenFile string
+
+		configTTL time.Duration
+
+		statsTokenFile string
+
+		poolHeaders []string
 	}
 )
 
@@ -32,6 +55,96 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&rootOpts.cert, "cert", "/etc/ssl/mcs/tls.crt", "cert file for TLS")
 	rootCmd.PersistentFlags().StringVar(&rootOpts.key, "key", "/etc/ssl/mcs/tls.key", "key file for TLS")
 	rootCmd.PersistentFlags().IntVar(&rootOpts.isport, "insecure-port", 49501, "insecure port to serve ignition configs")
+	rootCmd.PersistentFlags().StringSliceVar(&rootOpts.sniHostnames, "sni-hostname", nil, "SNI hostname to machine pool mapping in the form <hostname>=<pool>; unknown hostnames are served with --cert")
+	rootCmd.PersistentFlags().StringSliceVar(&rootOpts.sniPoolCerts, "sni-pool-cert", nil, "cert and key files for TLS used for a machine pool in the form <pool>=<cert>:<key>")
+	rootCmd.PersistentFlags().BoolVar(&rootOpts.validateSchema, "validate-schema", false, "validate configs against the JSON schema of their ignition version before serving them")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.signingKey, "signing-key", "", "PEM encoded ECDSA private key used to attach signed provenance attestations to served configs; configs are not signed if not set")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.signingIdentity, "signing-identity", "", "identity recorded as the builder in the attestations of served configs, e.g. a URI or email")
+	rootCmd.PersistentFlags().IntVar(&rootOpts.debugErrors, "debug-errors", 0, "number of recent errors served on the secure port at /debug/errors; 0 disables the endpoint")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.debugErrorsTokenFile, "debug-errors-token-file", "", "file with the bearer token required to read /debug/errors")
+	rootCmd.PersistentFlags().StringVar(&rootOpts.statsTokenFile, "stats-token-file", "", "file with the bearer token required to read the config serving statistics at /stats on the secure port; the endpoint is disabled if not set")
+	rootCmd.PersistentFlags().StringArrayVar(&rootOpts.poolHeaders, "pool-header", nil, "header added to the configs served for a machine pool in the form <pool>=<name>:<value>; may be repeated")
+	rootCmd.PersistentFlags().DurationVar(&rootOpts.configTTL, "config-ttl", 0, "how long served configs are valid, machines fetch an expired config again; 0 disables the expiry")
+}
+
+// newAPIHandlerOptions returns the options of the API handler, the SNI config
+// of the secure port and the handlers of its debug errors and stats endpoints
+// as configured by the flags. The handlers are nil if their endpoint is
+// disabled.
+func newAPIHandlerOptions() (server.APIHandlerOptions, *server.SNIConfig, http.Handler, http.Handler, error) {
+	sni, err := server.ParseSNIConfig(rootOpts.sniHostnames, rootOpts.sniPoolCerts)
+	if err != nil {
+		return server.APIHandlerOptions{}, nil, nil, nil, err
+	}
+	signer, err := server.NewSigner(rootOpts.signingKey, rootOpts.signingIdentity)
+	if err != nil {
+		return server.APIHandlerOptions{}, nil, nil, nil, err
+	}
+	errorLog, debugHandler, err := newDebugErrors()
+	if err != nil {
+		return server.APIHandlerOptions{}, nil, nil, nil, err
+	}
+	stats, statsHandler, err := newStats()
+	if err != nil {
+		return server.APIHandlerOptions{}, nil, nil, nil, err
+	}
+	headers, err := server.ParsePoolHeaders(rootOpts.poolHeaders)
+	if err != nil {
+		return server.APIHandlerOptions{}, nil, nil, nil, err
+	}
+	opts := server.APIHandlerOptions{
+		ValidateSchema: rootOpts.validateSchema,
+		Signer:         signer,
+		ErrorLog:       errorLog,
+		ConfigTTL:      rootOpts.configTTL,
+		Stats:          stats,
+		Headers:        headers,
+	}
+	return opts, sni, debugHandler, statsHandler, nil
+}
+
+// newDebugErrors returns the error log and the handler serving it as
+// configured by the flags. Both are nil if the endpoint is disabled.
+func newDebugErrors() (*server.ErrorLog, http.Handler, error) {
+	if rootOpts.debugErrors <= 0 {
+		return nil, nil, nil
+	}
+	if rootOpts.debugErrorsTokenFile == "" {
+		return nil, nil, fmt.Errorf("--debug-errors-token-file is required with --debug-errors")
+	}
+	token, err := readToken(rootOpts.debugErrorsTokenFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read debug errors token: %v", err)
+	}
+	errorLog := server.NewErrorLog(rootOpts.debugErrors)
+	return errorLog, server.NewErrorLogHandler(errorLog, token), nil
+}
+
+// newStats returns the config serving statistics and the handler serving them
+// as configured by the flags. Both are nil if the endpoint is disabled.
+func newStats() (*server.Stats, http.Handler, error) {
+	if rootOpts.statsTokenFile == "" {
+		return nil, nil, nil
+	}
+	token, err := readToken(rootOpts.statsTokenFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read stats token: %v", err)
+	}
+	stats := server.NewStats()
+	return stats, server.NewStatsHandler(stats, token), nil
+}
+
+// readToken reads the bearer token from the file.
+func readToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
 }
 
 func main() {
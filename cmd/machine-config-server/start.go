@@ -45,9 +45,14 @@ func runStartCmd(cmd *cobra.Command, args []string) {
 		glog.Exitf("Machine Config Server exited with error: %v", err)
 	}
 
-	apiHandler := server.NewServerAPIHandler(cs)
-	secureServer := server.NewAPIServer(apiHandler, rootOpts.sport, false, rootOpts.cert, rootOpts.key)
-	insecureServer := server.NewAPIServer(apiHandler, rootOpts.isport, true, "", "")
+	opts, sni, debugHandler, statsHandler, err := newAPIHandlerOptions()
+	if err != nil {
+		glog.Exitf("Machine Config Server exited with error: %v", err)
+	}
+
+	apiHandler := server.NewServerAPIHandler(cs, opts)
+	secureServer := server.NewAPIServer(apiHandler, rootOpts.sport, false, rootOpts.cert, rootOpts.key, sni, debugHandler, statsHandler)
+	insecureServer := server.NewAPIServer(apiHandler, rootOpts.isport, true, "", "", nil, nil, nil)
 
 	stopCh := make(chan struct{})
 	go secureServer.Serve()
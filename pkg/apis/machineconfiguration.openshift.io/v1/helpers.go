@@ -1,35 +1,250 @@
 package v1
 
 import (
+	"fmt"
+	"reflect"
 	"sort"
+	"strconv"
 
 	ignv2_2 "github.com/coreos/ignition/config/v2_2"
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// MergeConflictStrategy controls how MergeMachineConfigs resolves files, systemd
+// units and dropins that more than one MachineConfig defines differently.
+type MergeConflictStrategy string
+
+const (
+	// MergeConflictAppend keeps the definitions of all the configs in order,
+	// so Ignition writes the last file defined for a path. This is the default.
+	MergeConflictAppend MergeConflictStrategy = "Append"
+	// MergeConflictFail fails the merge on the first conflict.
+	MergeConflictFail MergeConflictStrategy = "Fail"
+	// MergeConflictLastWins keeps the definition of the last config defining it.
+	MergeConflictLastWins MergeConflictStrategy = "LastWins"
+	// MergeConflictFirstWins keeps the definition of the first config defining it.
+	MergeConflictFirstWins MergeConflictStrategy = "FirstWins"
+)
+
 // MergeMachineConfigs combines multiple machineconfig objects into one object.
 // It sorts all the configs in increasing order of their name.
 // It uses the Ign config from first object as base and appends all the rest.
 // It only uses the OSImageURL from first object and ignores it from rest.
-func MergeMachineConfigs(configs []*MachineConfig) *MachineConfig {
+// The HealthChecks of all the objects are combined in the same order.
+// The TuningProfiles of all the objects are combined, see mergeTuningProfiles.
+// Files, systemd units and dropins defined differently by several objects are
+// resolved using the strategy, an empty strategy is MergeConflictAppend.
+func MergeMachineConfigs(configs []*MachineConfig, strategy MergeConflictStrategy) (*MachineConfig, error) {
 	if len(configs) == 0 {
-		return nil
+		return nil, nil
 	}
 	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
 
+	ignConfigs := make([]ignv2_2types.Config, len(configs))
+	for i, c := range configs {
+		ignConfigs[i] = c.Spec.Config
+	}
+	switch strategy {
+	case "", MergeConflictAppend:
+	case MergeConflictFail, MergeConflictLastWins, MergeConflictFirstWins:
+		resolved, err := resolveMergeConflicts(configs, strategy)
+		if err != nil {
+			return nil, err
+		}
+		ignConfigs = resolved
+	default:
+		return nil, fmt.Errorf("unknown merge conflict strategy %q", strategy)
+	}
+
 	outOSImageURL := configs[0].Spec.OSImageURL
-	outIgn := configs[0].Spec.Config
-	for idx := 1; idx < len(configs); idx++ {
-		outIgn = ignv2_2.Append(outIgn, configs[idx].Spec.Config)
+	outIgn := ignConfigs[0]
+	for idx := 1; idx < len(ignConfigs); idx++ {
+		outIgn = ignv2_2.Append(outIgn, ignConfigs[idx])
+	}
+	var outHealthChecks []HealthCheck
+	for _, c := range configs {
+		for _, hc := range c.Spec.HealthChecks {
+			outHealthChecks = append(outHealthChecks, *hc.DeepCopy())
+		}
 	}
 
 	return &MachineConfig{
 		Spec: MachineConfigSpec{
-			OSImageURL: outOSImageURL,
-			Config:     outIgn,
+			OSImageURL:    outOSImageURL,
+			Config:        outIgn,
+			HealthChecks:  outHealthChecks,
+			TuningProfile: mergeTuningProfiles(configs),
 		},
+	}, nil
+}
+
+// mergeTuningProfiles combines the tuning profiles of the sorted configs. The
+// kernel arguments of all the profiles are kept in order without duplicates,
+// a sysctl set by several profiles and the tuned profile are taken from the
+// last profile setting them. Returns nil if no config has a tuning profile.
+func mergeTuningProfiles(configs []*MachineConfig) *TuningProfile {
+	var out *TuningProfile
+	seen := map[string]bool{}
+	for _, c := range configs {
+		tp := c.Spec.TuningProfile
+		if tp == nil {
+			continue
+		}
+		if out == nil {
+			out = &TuningProfile{}
+		}
+		for _, arg := range tp.KernelArguments {
+			if !seen[arg] {
+				seen[arg] = true
+				out.KernelArguments = append(out.KernelArguments, arg)
+			}
+		}
+		for name, value := range tp.Sysctls {
+			if out.Sysctls == nil {
+				out.Sysctls = map[string]string{}
+			}
+			out.Sysctls[name] = value
+		}
+		if tp.TunedProfile != "" {
+			out.TunedProfile = tp.TunedProfile
+		}
+	}
+	return out
+}
+
+// mergeDefinition is the definition of a file, systemd unit or dropin by one of
+// the merged configs.
+type mergeDefinition struct {
+	config int
+	value  interface{}
+	// drop removes the definition from the config.
+	drop func()
+}
+
+// resolveMergeConflicts returns copies of the Ign configs of the sorted
+// configs with the conflicting definitions that lose to the strategy removed.
+// Files appending to a path never conflict, neither do units that only define
+// dropins, an enablement or a mask.
+func resolveMergeConflicts(configs []*MachineConfig, strategy MergeConflictStrategy) ([]ignv2_2types.Config, error) {
+	ignConfigs := make([]ignv2_2types.Config, len(configs))
+	for i, c := range configs {
+		ignConfigs[i] = c.DeepCopy().Spec.Config
+	}
+
+	// definitions are keyed by what they define, keys are kept in the order
+	// they are first defined so conflicts are reported in merge order.
+	var keys []string
+	defs := map[string][]mergeDefinition{}
+	define := func(key string, def mergeDefinition) {
+		if _, ok := defs[key]; !ok {
+			keys = append(keys, key)
+		}
+		defs[key] = append(defs[key], def)
+	}
+	type fileRef struct{ config, file int }
+	type dropinRef struct{ config, unit, dropin int }
+	droppedFiles := map[fileRef]bool{}
+	droppedDropins := map[dropinRef]bool{}
+
+	for ci := range ignConfigs {
+		ign := &ignConfigs[ci]
+		for fi, f := range ign.Storage.Files {
+			if f.Append {
+				continue
+			}
+			ref := fileRef{ci, fi}
+			define("file "+f.Path, mergeDefinition{config: ci, value: f, drop: func() { droppedFiles[ref] = true }})
+		}
+		for ui := range ign.Systemd.Units {
+			u := &ign.Systemd.Units[ui]
+			if u.Contents != "" {
+				define("systemd unit "+u.Name, mergeDefinition{config: ci, value: u.Contents, drop: func() { u.Contents = "" }})
+			}
+			for di, d := range u.Dropins {
+				if d.Contents == "" {
+					continue
+				}
+				ref := dropinRef{ci, ui, di}
+				define(fmt.Sprintf("dropin %s of systemd unit %s", d.Name, u.Name), mergeDefinition{config: ci, value: d.Contents, drop: func() { droppedDropins[ref] = true }})
+			}
+		}
+	}
+
+	for _, key := range keys {
+		kdefs := defs[key]
+		var conflicting *mergeDefinition
+		for i := 1; i < len(kdefs); i++ {
+			if !reflect.DeepEqual(kdefs[0].value, kdefs[i].value) {
+				conflicting = &kdefs[i]
+				break
+			}
+		}
+		if conflicting == nil {
+			continue
+		}
+		switch strategy {
+		case MergeConflictFail:
+			return nil, fmt.Errorf("%s is defined with different contents by MachineConfigs %s and %s", key, configs[kdefs[0].config].Name, configs[conflicting.config].Name)
+		case MergeConflictFirstWins:
+			for _, d := range kdefs[1:] {
+				d.drop()
+			}
+		case MergeConflictLastWins:
+			for _, d := range kdefs[:len(kdefs)-1] {
+				d.drop()
+			}
+		}
+	}
+
+	for ci := range ignConfigs {
+		ign := &ignConfigs[ci]
+		if len(droppedFiles) > 0 {
+			var files []ignv2_2types.File
+			for fi, f := range ign.Storage.Files {
+				if !droppedFiles[fileRef{ci, fi}] {
+					files = append(files, f)
+				}
+			}
+			ign.Storage.Files = files
+		}
+		for ui := range ign.Systemd.Units {
+			u := &ign.Systemd.Units[ui]
+			if len(droppedDropins) == 0 {
+				break
+			}
+			var dropins []ignv2_2types.SystemdDropin
+			for di, d := range u.Dropins {
+				if !droppedDropins[dropinRef{ci, ui, di}] {
+					dropins = append(dropins, d)
+				}
+			}
+			u.Dropins = dropins
+		}
+	}
+	return ignConfigs, nil
+}
+
+// RolloutWaveLabelKey is the node label assigning a machine to a rollout wave of its pool.
+const RolloutWaveLabelKey = "machineconfiguration.openshift.io/rolloutWave"
+
+// IsInPromotedRolloutWave returns true if the machine with the given labels is in a rollout
+// wave of the pool that is promoted to the CurrentMachineConfig. Machines without a wave are
+// in wave 0, machines with an invalid wave are never promoted while the pool has waves.
+func IsInPromotedRolloutWave(pool *MachineConfigPool, labels map[string]string) bool {
+	if pool.Spec.RolloutWave == nil {
+		return true
+	}
+	value, ok := labels[RolloutWaveLabelKey]
+	if !ok {
+		return *pool.Spec.RolloutWave >= 0
+	}
+	wave, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return false
 	}
+	return int32(wave) <= *pool.Spec.RolloutWave
 }
 
 // NewMachineConfigPoolCondition creates a new MachineConfigPool condition.
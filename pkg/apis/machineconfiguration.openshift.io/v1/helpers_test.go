@@ -3,9 +3,12 @@ package v1
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
@@ -139,3 +142,193 @@ func TestRemoveMachineConfigPoolCondition(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeMachineConfigsHealthChecks(t *testing.T) {
+	configs := []*MachineConfig{{
+		ObjectMeta: metav1.ObjectMeta{Name: "10-b"},
+		Spec:       MachineConfigSpec{HealthChecks: []HealthCheck{{Name: "b", Command: []string{"true"}}}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "00-a"},
+		Spec:       MachineConfigSpec{HealthChecks: []HealthCheck{{Name: "a", Command: []string{"true"}}}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "05-none"},
+	}}
+
+	merged, err := MergeMachineConfigs(configs, MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, hc := range merged.Spec.HealthChecks {
+		names = append(names, hc.Name)
+	}
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected health checks %v, got %v", expected, names)
+	}
+
+	// the merged health checks don't share memory with the configs
+	merged.Spec.HealthChecks[0].Command[0] = "false"
+	if got := configs[0].Spec.HealthChecks[0].Command[0]; got != "true" {
+		t.Errorf("expected source health check to be unchanged, got %s", got)
+	}
+}
+
+func TestMergeMachineConfigsConflictStrategies(t *testing.T) {
+	file := func(path, contents string, append bool) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node:          ignv2_2types.Node{Filesystem: "root", Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{Append: append, Contents: ignv2_2types.FileContents{Source: "data:," + contents}},
+		}
+	}
+	newConfigs := func() []*MachineConfig {
+		return []*MachineConfig{{
+			ObjectMeta: metav1.ObjectMeta{Name: "10-b"},
+			Spec: MachineConfigSpec{Config: ignv2_2types.Config{
+				Storage: ignv2_2types.Storage{Files: []ignv2_2types.File{file("/etc/conflict", "b", false), file("/etc/log", "b", true)}},
+				Systemd: ignv2_2types.Systemd{Units: []ignv2_2types.Unit{
+					{Name: "conflict.service", Contents: "b", Dropins: []ignv2_2types.SystemdDropin{{Name: "10-conflict.conf", Contents: "b"}}},
+				}},
+			}},
+		}, {
+			ObjectMeta: metav1.ObjectMeta{Name: "00-a"},
+			Spec: MachineConfigSpec{Config: ignv2_2types.Config{
+				Storage: ignv2_2types.Storage{Files: []ignv2_2types.File{file("/etc/conflict", "a", false), file("/etc/log", "a", true), file("/etc/same", "a", false)}},
+				Systemd: ignv2_2types.Systemd{Units: []ignv2_2types.Unit{
+					{Name: "conflict.service", Contents: "a", Dropins: []ignv2_2types.SystemdDropin{{Name: "10-conflict.conf", Contents: "a"}, {Name: "20-a.conf", Contents: "a"}}},
+				}},
+			}},
+		}, {
+			ObjectMeta: metav1.ObjectMeta{Name: "20-c"},
+			Spec: MachineConfigSpec{Config: ignv2_2types.Config{
+				Storage: ignv2_2types.Storage{Files: []ignv2_2types.File{file("/etc/same", "a", false)}},
+			}},
+		}}
+	}
+	// files and units are summarized as path=contents in merge order.
+	summarize := func(conf ignv2_2types.Config) []string {
+		var out []string
+		for _, f := range conf.Storage.Files {
+			out = append(out, f.Path+"="+f.Contents.Source[len("data:,"):])
+		}
+		for _, u := range conf.Systemd.Units {
+			out = append(out, u.Name+"="+u.Contents)
+			for _, d := range u.Dropins {
+				out = append(out, u.Name+"/"+d.Name+"="+d.Contents)
+			}
+		}
+		return out
+	}
+
+	tests := []struct {
+		strategy MergeConflictStrategy
+		expected []string
+		err      string
+	}{{
+		strategy: MergeConflictAppend,
+		expected: []string{
+			"/etc/conflict=a", "/etc/log=a", "/etc/same=a", "/etc/conflict=b", "/etc/log=b", "/etc/same=a",
+			"conflict.service=a", "conflict.service/10-conflict.conf=a", "conflict.service/20-a.conf=a",
+			"conflict.service=b", "conflict.service/10-conflict.conf=b",
+		},
+	}, {
+		strategy: "",
+		expected: []string{
+			"/etc/conflict=a", "/etc/log=a", "/etc/same=a", "/etc/conflict=b", "/etc/log=b", "/etc/same=a",
+			"conflict.service=a", "conflict.service/10-conflict.conf=a", "conflict.service/20-a.conf=a",
+			"conflict.service=b", "conflict.service/10-conflict.conf=b",
+		},
+	}, {
+		strategy: MergeConflictFail,
+		err:      "file /etc/conflict is defined with different contents by MachineConfigs 00-a and 10-b",
+	}, {
+		strategy: MergeConflictLastWins,
+		expected: []string{
+			"/etc/log=a", "/etc/same=a", "/etc/conflict=b", "/etc/log=b", "/etc/same=a",
+			"conflict.service=", "conflict.service/20-a.conf=a",
+			"conflict.service=b", "conflict.service/10-conflict.conf=b",
+		},
+	}, {
+		strategy: MergeConflictFirstWins,
+		expected: []string{
+			"/etc/conflict=a", "/etc/log=a", "/etc/same=a", "/etc/log=b", "/etc/same=a",
+			"conflict.service=a", "conflict.service/10-conflict.conf=a", "conflict.service/20-a.conf=a",
+			"conflict.service=",
+		},
+	}, {
+		strategy: "Random",
+		err:      `unknown merge conflict strategy "Random"`,
+	}}
+
+	for _, test := range tests {
+		configs := newConfigs()
+		merged, err := MergeMachineConfigs(configs, test.strategy)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: expected error %q, got %v", test.strategy, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.strategy, err)
+			continue
+		}
+		if got := summarize(merged.Spec.Config); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.strategy, test.expected, got)
+		}
+		// resolving conflicts doesn't touch the configs, only their order
+		unchanged := newConfigs()
+		sort.Slice(unchanged, func(i, j int) bool { return unchanged[i].Name < unchanged[j].Name })
+		if !reflect.DeepEqual(configs, unchanged) {
+			t.Errorf("%q: expected the configs to be unchanged", test.strategy)
+		}
+	}
+
+	// units that only add dropins don't conflict
+	configs := newConfigs()
+	configs[0].Spec.Config.Systemd.Units[0] = ignv2_2types.Unit{Name: "conflict.service", Dropins: []ignv2_2types.SystemdDropin{{Name: "30-b.conf", Contents: "b"}}}
+	configs[0].Spec.Config.Storage.Files = nil
+	if _, err := MergeMachineConfigs(configs, MergeConflictFail); err != nil {
+		t.Errorf("expected dropins to not conflict, got: %v", err)
+	}
+}
+
+func TestMergeMachineConfigsTuningProfiles(t *testing.T) {
+	configs := []*MachineConfig{{
+		ObjectMeta: metav1.ObjectMeta{Name: "10-rt"},
+		Spec: MachineConfigSpec{TuningProfile: &TuningProfile{
+			KernelArguments: []string{"nohz_full=2-7", "isolcpus=2-7"},
+			Sysctls:         map[string]string{"kernel.sched_rt_runtime_us": "-1", "vm.stat_interval": "10"},
+			TunedProfile:    "realtime",
+		}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "00-base"},
+		Spec: MachineConfigSpec{TuningProfile: &TuningProfile{
+			KernelArguments: []string{"isolcpus=2-7"},
+			Sysctls:         map[string]string{"vm.stat_interval": "1"},
+			TunedProfile:    "throughput-performance",
+		}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "20-none"},
+	}}
+
+	merged, err := MergeMachineConfigs(configs, MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TuningProfile{
+		KernelArguments: []string{"isolcpus=2-7", "nohz_full=2-7"},
+		Sysctls:         map[string]string{"kernel.sched_rt_runtime_us": "-1", "vm.stat_interval": "10"},
+		TunedProfile:    "realtime",
+	}
+	if !reflect.DeepEqual(merged.Spec.TuningProfile, expected) {
+		t.Errorf("expected tuning profile %+v, got %+v", expected, merged.Spec.TuningProfile)
+	}
+
+	merged, err = MergeMachineConfigs(configs[2:], MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Spec.TuningProfile != nil {
+		t.Errorf("expected no tuning profile, got %+v", merged.Spec.TuningProfile)
+	}
+}
@@ -34,6 +34,15 @@ func (in *MachineConfig) DeepCopyObject() runtime.Object {
 func (in *MachineConfigSpec) DeepCopyInto(out *MachineConfigSpec) {
 	*out = *in
 	out.Config = deepCopyIgnConfig(in.Config)
+	if in.HealthChecks != nil {
+		out.HealthChecks = make([]HealthCheck, len(in.HealthChecks))
+		for i := range in.HealthChecks {
+			in.HealthChecks[i].DeepCopyInto(&out.HealthChecks[i])
+		}
+	}
+	if in.TuningProfile != nil {
+		out.TuningProfile = in.TuningProfile.DeepCopy()
+	}
 	return
 }
 
@@ -0,0 +1,168 @@
+package v1
+
+import (
+	"fmt"
+	"reflect"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// ComputeOverlay returns the smallest MachineConfig that, merged after base
+// with MergeConflictLastWins, gives desired. The overlay has the files that
+// desired adds or changes and the systemd units it adds or changes. A changed
+// unit has its desired contents, enablement and mask, as the daemon only
+// applies the enablement and mask of units with contents, and only the
+// dropins that change.
+// The overlay has no name, it must be named so that it sorts after the
+// MachineConfigs of the pool to be merged after them.
+// An overlay can't remove anything from base, nor change its OSImageURL or
+// the sections other than files and units, so it returns an error if desired
+// does.
+func ComputeOverlay(base, desired *MachineConfig) (*MachineConfig, error) {
+	for _, s := range []struct {
+		section       string
+		base, desired interface{}
+	}{
+		{"osImageURL", base.Spec.OSImageURL, desired.Spec.OSImageURL},
+		{"healthChecks", base.Spec.HealthChecks, desired.Spec.HealthChecks},
+		{"tuningProfile", base.Spec.TuningProfile, desired.Spec.TuningProfile},
+		{"ignition", base.Spec.Config.Ignition, desired.Spec.Config.Ignition},
+		{"networkd", base.Spec.Config.Networkd, desired.Spec.Config.Networkd},
+		{"passwd", base.Spec.Config.Passwd, desired.Spec.Config.Passwd},
+		{"storage.directories", base.Spec.Config.Storage.Directories, desired.Spec.Config.Storage.Directories},
+		{"storage.links", base.Spec.Config.Storage.Links, desired.Spec.Config.Storage.Links},
+		{"storage.disks", base.Spec.Config.Storage.Disks, desired.Spec.Config.Storage.Disks},
+		{"storage.raid", base.Spec.Config.Storage.Raid, desired.Spec.Config.Storage.Raid},
+		{"storage.filesystems", base.Spec.Config.Storage.Filesystems, desired.Spec.Config.Storage.Filesystems},
+	} {
+		if !reflect.DeepEqual(s.base, s.desired) {
+			return nil, fmt.Errorf("%s changes, only files and systemd units can be changed by an overlay", s.section)
+		}
+	}
+
+	files, err := overlayFiles(base.Spec.Config.Storage.Files, desired.Spec.Config.Storage.Files)
+	if err != nil {
+		return nil, err
+	}
+	units, err := overlayUnits(base.Spec.Config.Systemd.Units, desired.Spec.Config.Systemd.Units)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay := &MachineConfig{}
+	overlay.Spec.Config.Ignition.Version = desired.Spec.Config.Ignition.Version
+	overlay.Spec.Config.Storage.Files = files
+	overlay.Spec.Config.Systemd.Units = units
+	return overlay, nil
+}
+
+// overlayFiles returns the files of desired that are new or differ from base,
+// in the order of desired. Files are compared by path with all their entries.
+func overlayFiles(base, desired []ignv2_2types.File) ([]ignv2_2types.File, error) {
+	byPath := func(files []ignv2_2types.File) ([]string, map[string][]ignv2_2types.File) {
+		var paths []string
+		entries := map[string][]ignv2_2types.File{}
+		for _, f := range files {
+			if _, ok := entries[f.Path]; !ok {
+				paths = append(paths, f.Path)
+			}
+			entries[f.Path] = append(entries[f.Path], f)
+		}
+		return paths, entries
+	}
+	basePaths, baseFiles := byPath(base)
+	desiredPaths, desiredFiles := byPath(desired)
+
+	for _, path := range basePaths {
+		if _, ok := desiredFiles[path]; !ok {
+			return nil, fmt.Errorf("file %s is removed, an overlay can't remove files", path)
+		}
+	}
+	var files []ignv2_2types.File
+	for _, path := range desiredPaths {
+		entries := desiredFiles[path]
+		if reflect.DeepEqual(baseFiles[path], entries) {
+			continue
+		}
+		// the last entry is the file written, unless the file is appended to.
+		for _, fs := range [][]ignv2_2types.File{baseFiles[path], entries} {
+			for _, f := range fs {
+				if f.Append {
+					return nil, fmt.Errorf("file %s is appended to, an overlay can't change appended files", path)
+				}
+			}
+		}
+		files = append(files, entries[len(entries)-1])
+	}
+	return files, nil
+}
+
+// overlayUnits returns the units of desired that are new or differ from base,
+// in the order of desired.
+func overlayUnits(base, desired []ignv2_2types.Unit) ([]ignv2_2types.Unit, error) {
+	baseUnits := map[string]ignv2_2types.Unit{}
+	for _, u := range base {
+		baseUnits[u.Name] = u
+	}
+	desiredUnits := map[string]bool{}
+	for _, u := range desired {
+		desiredUnits[u.Name] = true
+	}
+	for _, u := range base {
+		if !desiredUnits[u.Name] {
+			return nil, fmt.Errorf("systemd unit %s is removed, an overlay can't remove units", u.Name)
+		}
+	}
+
+	var units []ignv2_2types.Unit
+	for _, u := range desired {
+		b, ok := baseUnits[u.Name]
+		if !ok {
+			units = append(units, u)
+			continue
+		}
+		if reflect.DeepEqual(b, u) {
+			continue
+		}
+		unit, err := overlayUnit(b, u)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	return units, nil
+}
+
+// overlayUnit returns the desired unit with only the dropins that differ from
+// the base unit of the same name. It returns an error if desired removes the
+// contents, enablement, mask or a dropin of base.
+func overlayUnit(base, desired ignv2_2types.Unit) (ignv2_2types.Unit, error) {
+	unit := ignv2_2types.Unit{Name: desired.Name, Contents: desired.Contents, Enabled: desired.Enabled, Enable: desired.Enable, Mask: desired.Mask}
+	if base.Contents != "" && desired.Contents == "" {
+		return unit, fmt.Errorf("contents of systemd unit %s are removed, an overlay can't remove them", desired.Name)
+	}
+	if (base.Enabled != nil || base.Enable) && desired.Enabled == nil && !desired.Enable {
+		return unit, fmt.Errorf("enablement of systemd unit %s is removed, an overlay can't remove it", desired.Name)
+	}
+	if base.Mask && !desired.Mask {
+		return unit, fmt.Errorf("systemd unit %s is unmasked, an overlay can't unmask units", desired.Name)
+	}
+
+	baseDropins := map[string]ignv2_2types.SystemdDropin{}
+	for _, d := range base.Dropins {
+		baseDropins[d.Name] = d
+	}
+	desiredDropins := map[string]bool{}
+	for _, d := range desired.Dropins {
+		desiredDropins[d.Name] = true
+		if b, ok := baseDropins[d.Name]; !ok || b != d {
+			unit.Dropins = append(unit.Dropins, d)
+		}
+	}
+	for _, d := range base.Dropins {
+		if !desiredDropins[d.Name] {
+			return unit, fmt.Errorf("dropin %s of systemd unit %s is removed, an overlay can't remove dropins", d.Name, desired.Name)
+		}
+	}
+	return unit, nil
+}
@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// effectiveFiles returns the files by path as they end up on the machine.
+// How the daemon applies the merged units is tested in its package.
+func effectiveFiles(conf ignv2_2types.Config) map[string]ignv2_2types.File {
+	files := map[string]ignv2_2types.File{}
+	for _, f := range conf.Storage.Files {
+		files[f.Path] = f
+	}
+	return files
+}
+
+func TestComputeOverlay(t *testing.T) {
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node:          ignv2_2types.Node{Filesystem: "root", Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:," + contents}},
+		}
+	}
+	enabled, disabled := true, false
+	dropin := ignv2_2types.SystemdDropin{Name: "10-env.conf", Contents: "[Service]\nEnvironment=FOO=1\n"}
+	newDropin := ignv2_2types.SystemdDropin{Name: "20-limits.conf", Contents: "[Service]\nLimitNOFILE=65536\n"}
+	foo := ignv2_2types.Unit{Name: "foo.service", Enabled: &enabled, Contents: "[Service]\nExecStart=/usr/bin/foo\n", Dropins: []ignv2_2types.SystemdDropin{dropin}}
+	bar := ignv2_2types.Unit{Name: "bar.service", Contents: "[Service]\nExecStart=/usr/bin/bar\n"}
+
+	base := &MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "00-base"},
+		Spec: MachineConfigSpec{
+			OSImageURL: "quay.io/openshift/os:1",
+			Config: ignv2_2types.Config{
+				Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()},
+				Storage:  ignv2_2types.Storage{Files: []ignv2_2types.File{newFile("/etc/a", "a"), newFile("/etc/b", "b")}},
+				Systemd:  ignv2_2types.Systemd{Units: []ignv2_2types.Unit{foo}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		desired func(*MachineConfig)
+		files   []ignv2_2types.File
+		units   []ignv2_2types.Unit
+		err     string
+	}{{
+		name:    "unchanged",
+		desired: func(*MachineConfig) {},
+	}, {
+		name: "file added",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Storage.Files = append(mc.Spec.Config.Storage.Files, newFile("/etc/c", "c"))
+		},
+		files: []ignv2_2types.File{newFile("/etc/c", "c")},
+	}, {
+		name: "file changed",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Storage.Files[1] = newFile("/etc/b", "changed")
+		},
+		files: []ignv2_2types.File{newFile("/etc/b", "changed")},
+	}, {
+		name: "unit changed",
+		desired: func(mc *MachineConfig) {
+			u := &mc.Spec.Config.Systemd.Units[0]
+			u.Contents = "[Service]\nExecStart=/usr/bin/foo --verbose\n"
+			u.Dropins = append(u.Dropins, newDropin)
+		},
+		units: []ignv2_2types.Unit{{Name: "foo.service", Enabled: &enabled, Contents: "[Service]\nExecStart=/usr/bin/foo --verbose\n", Dropins: []ignv2_2types.SystemdDropin{newDropin}}},
+	}, {
+		name: "unit disabled and unit added",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Systemd.Units[0].Enabled = &disabled
+			mc.Spec.Config.Systemd.Units = append(mc.Spec.Config.Systemd.Units, bar)
+		},
+		units: []ignv2_2types.Unit{{Name: "foo.service", Enabled: &disabled, Contents: foo.Contents}, bar},
+	}, {
+		name: "unit masked",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Systemd.Units[0].Mask = true
+		},
+		units: []ignv2_2types.Unit{{Name: "foo.service", Enabled: &enabled, Mask: true, Contents: foo.Contents}},
+	}, {
+		name: "file removed",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Storage.Files = mc.Spec.Config.Storage.Files[:1]
+		},
+		err: "file /etc/b is removed, an overlay can't remove files",
+	}, {
+		name: "dropin removed",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Systemd.Units[0].Dropins = nil
+		},
+		err: "dropin 10-env.conf of systemd unit foo.service is removed, an overlay can't remove dropins",
+	}, {
+		name: "unit contents removed",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Systemd.Units[0].Contents = ""
+		},
+		err: "contents of systemd unit foo.service are removed, an overlay can't remove them",
+	}, {
+		name: "unit enablement removed",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.Config.Systemd.Units[0].Enabled = nil
+		},
+		err: "enablement of systemd unit foo.service is removed, an overlay can't remove it",
+	}, {
+		name: "os image changed",
+		desired: func(mc *MachineConfig) {
+			mc.Spec.OSImageURL = "quay.io/openshift/os:2"
+		},
+		err: "osImageURL changes, only files and systemd units can be changed by an overlay",
+	}}
+
+	for _, test := range tests {
+		desired := base.DeepCopy()
+		desired.Name = "desired"
+		test.desired(desired)
+
+		overlay, err := ComputeOverlay(base, desired)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if got := overlay.Spec.Config.Storage.Files; !reflect.DeepEqual(got, test.files) {
+			t.Errorf("%s: expected overlay files %v, got: %v", test.name, test.files, got)
+		}
+		if got := overlay.Spec.Config.Systemd.Units; !reflect.DeepEqual(got, test.units) {
+			t.Errorf("%s: expected overlay units %v, got: %v", test.name, test.units, got)
+		}
+
+		// base and overlay merge into the desired config.
+		overlay.Name = "99-overlay"
+		merged, err := MergeMachineConfigs([]*MachineConfig{overlay, base.DeepCopy()}, MergeConflictLastWins)
+		if err != nil {
+			t.Fatalf("%s: unexpected merge error: %v", test.name, err)
+		}
+		if merged.Spec.OSImageURL != desired.Spec.OSImageURL {
+			t.Errorf("%s: expected osImageURL %s, got: %s", test.name, desired.Spec.OSImageURL, merged.Spec.OSImageURL)
+		}
+		if got, exp := effectiveFiles(merged.Spec.Config), effectiveFiles(desired.Spec.Config); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: expected merged files %v, got: %v", test.name, exp, got)
+		}
+	}
+}
@@ -190,6 +190,37 @@ type MachineConfigSpec struct {
 	OSImageURL string `json:"osImageURL"`
 	// Config is a Ignition Config object.
 	Config ignv2_2types.Config `json:"config"`
+	// HealthChecks are run on the machine after the config is applied.
+	HealthChecks []HealthCheck `json:"healthChecks,omitempty"`
+	// TuningProfile is the kernel tuning applied to the machine.
+	TuningProfile *TuningProfile `json:"tuningProfile,omitempty"`
+}
+
+// TuningProfile combines the kernel arguments, sysctls and tuned profile that
+// together tune the machine, e.g. for real-time and low-latency workloads.
+// They are applied together, with a single reboot.
+type TuningProfile struct {
+	// KernelArguments are added to the kernel command line, e.g. isolcpus=2-7 or nohz_full=2-7.
+	KernelArguments []string `json:"kernelArguments,omitempty"`
+	// Sysctls are the kernel parameters to set, by name.
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+	// TunedProfile is the tuned profile to activate, e.g. realtime.
+	TunedProfile string `json:"tunedProfile,omitempty"`
+}
+
+// HealthCheck is a command run on the machine after a MachineConfig is applied
+// to verify the machine works with it.
+type HealthCheck struct {
+	// Name of the health check.
+	Name string `json:"name"`
+	// Command and its arguments, run without a shell.
+	Command []string `json:"command"`
+	// Number of times the command is retried after failing. default is 0.
+	Retries int32 `json:"retries,omitempty"`
+	// Seconds to wait between attempts. default is 10.
+	RetryIntervalSeconds int32 `json:"retryIntervalSeconds,omitempty"`
+	// Seconds after which an attempt is failed. default is 30.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -260,6 +291,23 @@ type MachineConfigPoolSpec struct {
 	// MaxUnavailable specifies the percentage or constant number of machines that can be updating at any given time.
 	// default is 1.
 	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable"`
+
+	// MaxUnavailableCap caps the number of machines computed from MaxUnavailable, so a percentage
+	// can be combined with an absolute limit, e.g. at most 25% but never more than 5 machines.
+	// default is no cap.
+	MaxUnavailableCap *int32 `json:"maxUnavailableCap,omitempty"`
+
+	// RolloutWave is the last rollout wave promoted to the CurrentMachineConfig. Machines are assigned
+	// to a wave with the machineconfiguration.openshift.io/rolloutWave label, machines without it are
+	// in wave 0. Machines in later waves are not updated and keep being served their current config
+	// until their wave is promoted.
+	// default is all waves.
+	RolloutWave *int32 `json:"rolloutWave,omitempty"`
+
+	// MaxRenderedConfigBytes is the largest size of the serialized Ignition config rendered for the pool,
+	// for machines with little memory or storage. Renders exceeding it are rejected.
+	// default is no limit.
+	MaxRenderedConfigBytes *int64 `json:"maxRenderedConfigBytes,omitempty"`
 }
 
 // MachineConfigPoolStatus is the status for MachineConfigPool resource.
@@ -285,6 +333,50 @@ type MachineConfigPoolStatus struct {
 
 	// Represents the latest available observations of current state.
 	Conditions []MachineConfigPoolCondition `json:"conditions"`
+
+	// PendingBlastRadius describes the impact of rolling out the latest generated MachineConfig
+	// when it is not the CurrentMachineConfig yet, e.g. because the pool is pinned.
+	PendingBlastRadius *MachineConfigPoolBlastRadius `json:"pendingBlastRadius,omitempty"`
+
+	// DrainBlockedMachines lists the machines whose drain is blocked by PodDisruptionBudgets that
+	// don't allow any disruption, with the pods that can't be evicted.
+	DrainBlockedMachines []MachineConfigPoolDrainBlock `json:"drainBlockedMachines,omitempty"`
+}
+
+// MachineConfigPoolBlastRadius describes the impact of rolling out a generated MachineConfig to a pool.
+type MachineConfigPoolBlastRadius struct {
+	// The generated MachineConfig that would be rolled out.
+	MachineConfig string `json:"machineConfig"`
+
+	// Number of machines in the pool that would be updated.
+	AffectedMachineCount int32 `json:"affectedMachineCount"`
+
+	// RebootRequired is true if the machines have to reboot to apply the MachineConfig.
+	RebootRequired bool `json:"rebootRequired"`
+
+	// Parts of the MachineConfig that change, e.g. Files or Units.
+	ChangedCategories []string `json:"changedCategories,omitempty"`
+}
+
+// MachineConfigPoolDrainBlock describes a machine whose drain is blocked by PodDisruptionBudgets.
+type MachineConfigPoolDrainBlock struct {
+	// Name of the node being drained.
+	Node string `json:"node"`
+
+	// Pods on the node that can't be evicted.
+	BlockingPods []DrainBlockingPod `json:"blockingPods"`
+}
+
+// DrainBlockingPod is a pod that can't be evicted because of a PodDisruptionBudget.
+type DrainBlockingPod struct {
+	// Namespace of the pod and of the PodDisruptionBudget.
+	Namespace string `json:"namespace"`
+
+	// Name of the pod.
+	Name string `json:"name"`
+
+	// Name of the PodDisruptionBudget that allows no disruption of the pod.
+	PodDisruptionBudget string `json:"podDisruptionBudget"`
 }
 
 // MachineConfigPoolCondition contains condition information for an MachineConfigPool.
@@ -322,6 +414,12 @@ const (
 	// MachineConfigPoolDegraded means the update for one of the machine is not progressing
 	// due to an error
 	MachineConfigPoolDegraded MachineConfigPoolConditionType = "Degraded"
+	// MachineConfigPoolRenderDegraded means the MachineConfig for the machineconfigpool
+	// could not be rendered and the render is being retried.
+	MachineConfigPoolRenderDegraded MachineConfigPoolConditionType = "RenderDegraded"
+	// MachineConfigPoolRolloutPaused means no more machines of the machineconfigpool are
+	// updated because an external signal, e.g. a firing alert, paused the rollout.
+	MachineConfigPoolRolloutPaused MachineConfigPoolConditionType = "RolloutPaused"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
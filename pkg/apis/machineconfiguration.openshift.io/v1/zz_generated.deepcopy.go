@@ -102,6 +102,43 @@ func (in *ControllerConfigSpec) DeepCopy() *ControllerConfigSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrainBlockingPod) DeepCopyInto(out *DrainBlockingPod) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrainBlockingPod.
+func (in *DrainBlockingPod) DeepCopy() *DrainBlockingPod {
+	if in == nil {
+		return nil
+	}
+	out := new(DrainBlockingPod)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheck) DeepCopyInto(out *HealthCheck) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheck.
+func (in *HealthCheck) DeepCopy() *HealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MCOConfig) DeepCopyInto(out *MCOConfig) {
 	*out = *in
@@ -239,6 +276,27 @@ func (in *MachineConfigPool) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineConfigPoolBlastRadius) DeepCopyInto(out *MachineConfigPoolBlastRadius) {
+	*out = *in
+	if in.ChangedCategories != nil {
+		in, out := &in.ChangedCategories, &out.ChangedCategories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineConfigPoolBlastRadius.
+func (in *MachineConfigPoolBlastRadius) DeepCopy() *MachineConfigPoolBlastRadius {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineConfigPoolBlastRadius)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MachineConfigPoolCondition) DeepCopyInto(out *MachineConfigPoolCondition) {
 	*out = *in
@@ -256,6 +314,27 @@ func (in *MachineConfigPoolCondition) DeepCopy() *MachineConfigPoolCondition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineConfigPoolDrainBlock) DeepCopyInto(out *MachineConfigPoolDrainBlock) {
+	*out = *in
+	if in.BlockingPods != nil {
+		in, out := &in.BlockingPods, &out.BlockingPods
+		*out = make([]DrainBlockingPod, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineConfigPoolDrainBlock.
+func (in *MachineConfigPoolDrainBlock) DeepCopy() *MachineConfigPoolDrainBlock {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineConfigPoolDrainBlock)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MachineConfigPoolList) DeepCopyInto(out *MachineConfigPoolList) {
 	*out = *in
@@ -307,6 +386,21 @@ func (in *MachineConfigPoolSpec) DeepCopyInto(out *MachineConfigPoolSpec) {
 		*out = new(intstr.IntOrString)
 		**out = **in
 	}
+	if in.MaxUnavailableCap != nil {
+		in, out := &in.MaxUnavailableCap, &out.MaxUnavailableCap
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RolloutWave != nil {
+		in, out := &in.RolloutWave, &out.RolloutWave
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxRenderedConfigBytes != nil {
+		in, out := &in.MaxRenderedConfigBytes, &out.MaxRenderedConfigBytes
+		*out = new(int64)
+		**out = **in
+	}
 	return
 }
 
@@ -330,6 +424,18 @@ func (in *MachineConfigPoolStatus) DeepCopyInto(out *MachineConfigPoolStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PendingBlastRadius != nil {
+		in, out := &in.PendingBlastRadius, &out.PendingBlastRadius
+		*out = new(MachineConfigPoolBlastRadius)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DrainBlockedMachines != nil {
+		in, out := &in.DrainBlockedMachines, &out.DrainBlockedMachines
+		*out = make([]MachineConfigPoolDrainBlock, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -342,3 +448,31 @@ func (in *MachineConfigPoolStatus) DeepCopy() *MachineConfigPoolStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TuningProfile) DeepCopyInto(out *TuningProfile) {
+	*out = *in
+	if in.KernelArguments != nil {
+		in, out := &in.KernelArguments, &out.KernelArguments
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Sysctls != nil {
+		in, out := &in.Sysctls, &out.Sysctls
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TuningProfile.
+func (in *TuningProfile) DeepCopy() *TuningProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(TuningProfile)
+	in.DeepCopyInto(out)
+	return out
+}
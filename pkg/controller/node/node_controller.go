@@ -62,19 +62,33 @@ type Controller struct {
 
 	mcpLister  mcfglistersv1.MachineConfigPoolLister
 	nodeLister corelisterv1.NodeLister
+	// cmLister lists the rollout pause ConfigMap, nil if there is none.
+	cmLister corelisterv1.ConfigMapLister
 
 	mcpListerSynced  cache.InformerSynced
 	nodeListerSynced cache.InformerSynced
+	cmListerSynced   cache.InformerSynced
+
+	// rolloutPauseNamespace and rolloutPauseName are the rollout pause
+	// ConfigMap, the external signal pausing the rollout of pools, e.g. set
+	// by an alert webhook. Each key is the name of a pool whose rollout is
+	// paused and its value the reason. Clearing the key resumes the rollout.
+	rolloutPauseNamespace string
+	rolloutPauseName      string
 
 	queue workqueue.RateLimitingInterface
 }
 
-// New returns a new node controller.
+// New returns a new node controller. cmInformer informs about the rollout
+// pause ConfigMap rolloutPauseName in rolloutPauseNamespace, if it is nil
+// rollouts can't be paused by a ConfigMap.
 func New(
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
 	nodeInformer coreinformersv1.NodeInformer,
+	cmInformer coreinformersv1.ConfigMapInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
+	rolloutPauseNamespace, rolloutPauseName string,
 ) *Controller {
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(glog.Infof)
@@ -85,6 +99,9 @@ func New(
 		kubeClient:    kubeClient,
 		eventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "machineconfigcontroller-nodecontroller"}),
 		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-nodecontroller"),
+
+		rolloutPauseNamespace: rolloutPauseNamespace,
+		rolloutPauseName:      rolloutPauseName,
 	}
 
 	mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -105,6 +122,17 @@ func New(
 	ctrl.nodeLister = nodeInformer.Lister()
 	ctrl.mcpListerSynced = mcpInformer.Informer().HasSynced
 	ctrl.nodeListerSynced = nodeInformer.Informer().HasSynced
+	ctrl.cmListerSynced = func() bool { return true }
+
+	if cmInformer != nil {
+		cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    ctrl.addRolloutPause,
+			UpdateFunc: ctrl.updateRolloutPause,
+			DeleteFunc: ctrl.deleteRolloutPause,
+		})
+		ctrl.cmLister = cmInformer.Lister()
+		ctrl.cmListerSynced = cmInformer.Informer().HasSynced
+	}
 
 	return ctrl
 }
@@ -117,7 +145,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	glog.Info("Starting MachineConfigController-NodeController")
 	defer glog.Info("Shutting down MachineConfigController-NodeController")
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.nodeListerSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.nodeListerSynced, ctrl.cmListerSynced) {
 		return
 	}
 
@@ -390,6 +418,13 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 	if pool.Spec.Paused {
 		return ctrl.syncStatusOnly(pool)
 	}
+	// nodes already updating finish their update, no new ones are started.
+	if reason, paused, err := ctrl.rolloutPauseReason(pool); err != nil {
+		return err
+	} else if paused {
+		glog.V(2).Infof("Rollout of pool %s is paused: %s", pool.Name, reason)
+		return ctrl.syncStatusOnly(pool)
+	}
 	selector, err := metav1.LabelSelectorAsSelector(pool.Spec.MachineSelector)
 	if err != nil {
 		return err
@@ -472,9 +507,14 @@ func getCandidateMachines(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node,
 
 	var candidates []*corev1.Node
 	for idx, node := range nodes {
-		if _, ok := actedMap[node.Name]; !ok {
-			candidates = append(candidates, nodes[idx])
+		if _, ok := actedMap[node.Name]; ok {
+			continue
+		}
+		// nodes in rollout waves that aren't promoted yet keep their config.
+		if !mcfgv1.IsInPromotedRolloutWave(pool, node.Labels) {
+			continue
 		}
+		candidates = append(candidates, nodes[idx])
 	}
 
 	if int32(len(candidates)) <= progress {
@@ -483,6 +523,9 @@ func getCandidateMachines(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node,
 	return candidates[:progress]
 }
 
+// maxUnavailable returns how many of the nodes of the pool can be unavailable
+// at once: the MaxUnavailable count or percentage of the nodes, capped by
+// MaxUnavailableCap, and at least 1.
 func maxUnavailable(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) (int32, error) {
 	intOrPercent := intstrutil.FromInt(1)
 	if pool.Spec.MaxUnavailable != nil {
@@ -493,6 +536,14 @@ func maxUnavailable(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) (int32
 	if err != nil {
 		return 0, err
 	}
+	if limit := pool.Spec.MaxUnavailableCap; limit != nil {
+		if *limit < 0 {
+			return 0, fmt.Errorf("invalid maxUnavailableCap %d: must not be negative", *limit)
+		}
+		if int(*limit) < maxunavail {
+			maxunavail = int(*limit)
+		}
+	}
 	if maxunavail == 0 {
 		maxunavail = 1
 	}
@@ -20,6 +20,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	kubeinformers "k8s.io/client-go/informers"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
@@ -39,6 +40,10 @@ type fixture struct {
 
 	mcpLister  []*mcfgv1.MachineConfigPool
 	nodeLister []*corev1.Node
+	// watchRolloutPause makes the controller watch the rollout pause
+	// ConfigMap, rolloutPause if it exists.
+	watchRolloutPause bool
+	rolloutPause      *corev1.ConfigMap
 
 	kubeactions []core.Action
 	actions     []core.Action
@@ -75,8 +80,15 @@ func (f *fixture) newController() (*Controller, informers.SharedInformerFactory,
 
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
 	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
-	c := New(i.Machineconfiguration().V1().MachineConfigPools(), k8sI.Core().V1().Nodes(),
-		f.kubeclient, f.client)
+	var cmInformer coreinformersv1.ConfigMapInformer
+	if f.watchRolloutPause {
+		cmInformer = k8sI.Core().V1().ConfigMaps()
+		if f.rolloutPause != nil {
+			cmInformer.Informer().GetIndexer().Add(f.rolloutPause)
+		}
+	}
+	c := New(i.Machineconfiguration().V1().MachineConfigPools(), k8sI.Core().V1().Nodes(), cmInformer,
+		f.kubeclient, f.client, testRolloutPauseNamespace, testRolloutPauseName)
 
 	c.mcpListerSynced = alwaysReady
 	c.nodeListerSynced = alwaysReady
@@ -202,7 +214,9 @@ func filterInformerActions(actions []core.Action) []core.Action {
 			(action.Matches("list", "machineconfigpools") ||
 				action.Matches("watch", "machineconfigpools") ||
 				action.Matches("list", "nodes") ||
-				action.Matches("watch", "nodes")) {
+				action.Matches("watch", "nodes") ||
+				action.Matches("list", "configmaps") ||
+				action.Matches("watch", "configmaps")) {
 			continue
 		}
 		ret = append(ret, action)
@@ -356,6 +370,61 @@ func TestMaxUnavailable(t *testing.T) {
 	}
 }
 
+func int32Ptr(i int32) *int32 { return &i }
+
+// TestMaxUnavailableCap verifies that a percentage combined with an absolute
+// cap allows the lower of the two across pool sizes.
+func TestMaxUnavailableCap(t *testing.T) {
+	tests := []struct {
+		nodes      int
+		maxUnavail *intstr.IntOrString
+		cap        *int32
+
+		expected int32
+		err      bool
+	}{
+		// "at most 25% but never more than 5 nodes"
+		{nodes: 1, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(5), expected: 1},
+		{nodes: 4, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(5), expected: 1},
+		{nodes: 10, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(5), expected: 2},
+		{nodes: 20, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(5), expected: 5},
+		{nodes: 23, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(5), expected: 5},
+		{nodes: 100, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(5), expected: 5},
+		// without a cap the percentage applies
+		{nodes: 100, maxUnavail: intStrPtr(intstr.FromString("25%")), expected: 25},
+		// an absolute count is capped too
+		{nodes: 10, maxUnavail: intStrPtr(intstr.FromInt(4)), cap: int32Ptr(3), expected: 3},
+		{nodes: 10, maxUnavail: intStrPtr(intstr.FromInt(2)), cap: int32Ptr(3), expected: 2},
+		// the default of 1 is never raised by the cap
+		{nodes: 10, cap: int32Ptr(3), expected: 1},
+		// at least one node is always updated
+		{nodes: 10, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(0), expected: 1},
+		{nodes: 10, maxUnavail: intStrPtr(intstr.FromString("25%")), cap: int32Ptr(-1), err: true},
+	}
+
+	for idx, test := range tests {
+		t.Run(fmt.Sprintf("case#%d", idx), func(t *testing.T) {
+			var nodes []*corev1.Node
+			for i := 0; i < test.nodes; i++ {
+				nodes = append(nodes, newNode(fmt.Sprintf("node-%d", i), "", ""))
+			}
+			pool := &mcfgv1.MachineConfigPool{
+				Spec: mcfgv1.MachineConfigPoolSpec{
+					MaxUnavailable:    test.maxUnavail,
+					MaxUnavailableCap: test.cap,
+				},
+			}
+			got, err := maxUnavailable(pool, nodes)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error: %v, got: %v", test.err, err)
+			}
+			if got != test.expected {
+				t.Fatalf("mismatch maxUnavailable for %d nodes: got %d want: %d", test.nodes, got, test.expected)
+			}
+		})
+	}
+}
+
 func TestGetCandidateMachines(t *testing.T) {
 	tests := []struct {
 		nodes    []*corev1.Node
@@ -438,6 +507,35 @@ func TestGetCandidateMachines(t *testing.T) {
 	}
 }
 
+func TestGetCandidateMachinesRolloutWave(t *testing.T) {
+	wave := int32(0)
+	pool := &mcfgv1.MachineConfigPool{
+		Spec: mcfgv1.MachineConfigPoolSpec{
+			RolloutWave: &wave,
+		},
+		Status: mcfgv1.MachineConfigPoolStatus{
+			CurrentMachineConfig: "v1",
+		},
+	}
+	nodes := []*corev1.Node{
+		newNodeWithLabel("node-0", "v0", "v0", map[string]string{mcfgv1.RolloutWaveLabelKey: "1"}),
+		newNodeWithLabel("node-1", "v0", "v0", map[string]string{mcfgv1.RolloutWaveLabelKey: "0"}),
+		newNodeWithLabel("node-2", "v0", "v0", map[string]string{mcfgv1.RolloutWaveLabelKey: "2"}),
+		newNode("node-3", "v0", "v0"),
+	}
+
+	got := getCandidateMachines(pool, nodes, 4)
+	if expected := []*corev1.Node{nodes[1], nodes[3]}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mismatch: got %v want: %v", got, expected)
+	}
+
+	wave = 1
+	got = getCandidateMachines(pool, nodes, 4)
+	if expected := []*corev1.Node{nodes[0], nodes[1], nodes[3]}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("mismatch: got %v want: %v", got, expected)
+	}
+}
+
 func TestMakeProgress(t *testing.T) {
 	tests := []struct {
 		nodes []*corev1.Node
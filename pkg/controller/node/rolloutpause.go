@@ -0,0 +1,111 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func (ctrl *Controller) addRolloutPause(obj interface{}) {
+	glog.V(4).Infof("Adding rollout pause ConfigMap %s", ctrl.rolloutPauseName)
+	ctrl.enqueueAllPools()
+}
+
+func (ctrl *Controller) updateRolloutPause(old, cur interface{}) {
+	oldCM := old.(*corev1.ConfigMap)
+	curCM := cur.(*corev1.ConfigMap)
+	if oldCM.ResourceVersion == curCM.ResourceVersion {
+		return
+	}
+	glog.V(4).Infof("Updating rollout pause ConfigMap %s", ctrl.rolloutPauseName)
+	ctrl.enqueueAllPools()
+}
+
+func (ctrl *Controller) deleteRolloutPause(obj interface{}) {
+	if _, ok := obj.(*corev1.ConfigMap); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Couldn't get object from tombstone %#v", obj))
+			return
+		}
+		if _, ok := tombstone.Obj.(*corev1.ConfigMap); !ok {
+			utilruntime.HandleError(fmt.Errorf("Tombstone contained object that is not a ConfigMap %#v", obj))
+			return
+		}
+	}
+	glog.V(4).Infof("Deleting rollout pause ConfigMap %s", ctrl.rolloutPauseName)
+	ctrl.enqueueAllPools()
+}
+
+func (ctrl *Controller) enqueueAllPools() {
+	pools, err := ctrl.mcpLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("Couldn't list MachineConfigPools: %v", err))
+		return
+	}
+	for _, pool := range pools {
+		ctrl.enqueueMachineConfigPool(pool)
+	}
+}
+
+// rolloutPauseEnabled returns true if the controller watches a rollout pause
+// ConfigMap.
+func (ctrl *Controller) rolloutPauseEnabled() bool {
+	return ctrl.cmLister != nil
+}
+
+// rolloutPauseReason returns the reason the rollout of the pool is paused,
+// and whether it is.
+func (ctrl *Controller) rolloutPauseReason(pool *mcfgv1.MachineConfigPool) (string, bool, error) {
+	if !ctrl.rolloutPauseEnabled() {
+		return "", false, nil
+	}
+	cm, err := ctrl.cmLister.ConfigMaps(ctrl.rolloutPauseNamespace).Get(ctrl.rolloutPauseName)
+	if errors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	reason, ok := cm.Data[pool.Name]
+	if !ok {
+		return "", false, nil
+	}
+	if reason == "" {
+		reason = fmt.Sprintf("Paused by ConfigMap %s/%s", ctrl.rolloutPauseNamespace, ctrl.rolloutPauseName)
+	}
+	return reason, true, nil
+}
+
+// setRolloutPausedCondition records in the status whether the rollout is
+// paused, with the reason given by the pause signal as the message.
+func setRolloutPausedCondition(status *mcfgv1.MachineConfigPoolStatus, message string, paused bool) {
+	if paused {
+		cond := mcfgv1.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolRolloutPaused, corev1.ConditionTrue, "RolloutPausedBySignal", message)
+		mcfgv1.SetMachineConfigPoolCondition(status, *cond)
+		return
+	}
+	cond := mcfgv1.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolRolloutPaused, corev1.ConditionFalse, "", "")
+	mcfgv1.SetMachineConfigPoolCondition(status, *cond)
+}
+
+// recordRolloutPauseChange emits an event when the rollout of the pool is
+// paused or resumed.
+func (ctrl *Controller) recordRolloutPauseChange(pool *mcfgv1.MachineConfigPool, reason string, paused bool) {
+	cond := mcfgv1.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolRolloutPaused)
+	wasPaused := cond != nil && cond.Status == corev1.ConditionTrue
+	switch {
+	case paused && !wasPaused:
+		glog.Infof("Pausing the rollout of pool %s: %s", pool.Name, reason)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeWarning, "RolloutPaused", "Rollout paused: %s", reason)
+	case !paused && wasPaused:
+		glog.Infof("Resuming the rollout of pool %s", pool.Name)
+		ctrl.eventRecorder.Eventf(pool, corev1.EventTypeNormal, "RolloutResumed", "Rollout resumed")
+	}
+}
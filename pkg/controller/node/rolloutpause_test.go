@@ -0,0 +1,162 @@
+package node
+
+import (
+	"encoding/json"
+	"testing"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	testRolloutPauseNamespace = "openshift-machine-config-operator"
+	testRolloutPauseName      = "machine-config-rollout-pause"
+)
+
+func newRolloutPause(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testRolloutPauseNamespace, Name: testRolloutPauseName},
+		Data:       data,
+	}
+}
+
+// newRolloutPauseFixture returns a fixture with a pool where node-1 is to be
+// updated.
+func newRolloutPauseFixture(t *testing.T) (*fixture, *mcfgv1.MachineConfigPool, []*corev1.Node) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), intStrPtr(intstr.FromInt(1)), "v1")
+	nodes := []*corev1.Node{
+		newNodeWithLabel("node-0", "v1", "v1", map[string]string{"node-role": "master"}),
+		newNodeWithLabel("node-1", "v0", "v0", map[string]string{"node-role": "master"}),
+	}
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.nodeLister = append(f.nodeLister, nodes...)
+	for idx := range nodes {
+		f.kubeobjects = append(f.kubeobjects, nodes[idx])
+	}
+	f.watchRolloutPause = true
+	return f, mcp, nodes
+}
+
+// TestRolloutPaused sets the pause signal of a pool and verifies no node is
+// started on the update and the reason is recorded.
+func TestRolloutPaused(t *testing.T) {
+	f, mcp, nodes := newRolloutPauseFixture(t)
+	f.rolloutPause = newRolloutPause(map[string]string{
+		mcp.Name:              "Alert KubeNodeNotReady is firing",
+		"test-cluster-worker": "Alert KubeletDown is firing",
+	})
+
+	expMcp := mcp.DeepCopy()
+	expMcp.Status = calculateStatus(mcp, nodes)
+	setRolloutPausedCondition(&expMcp.Status, "Alert KubeNodeNotReady is firing", true)
+	f.expectUpdateMachineConfigPoolStatus(expMcp)
+
+	f.run(getKey(mcp, t))
+}
+
+// TestSetRolloutPausedCondition verifies the reason of the pause signal is
+// the message of the condition.
+func TestSetRolloutPausedCondition(t *testing.T) {
+	status := mcfgv1.MachineConfigPoolStatus{}
+	setRolloutPausedCondition(&status, "Alert KubeNodeNotReady is firing", true)
+	cond := mcfgv1.GetMachineConfigPoolCondition(status, mcfgv1.MachineConfigPoolRolloutPaused)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != "RolloutPausedBySignal" || cond.Message != "Alert KubeNodeNotReady is firing" {
+		t.Fatalf("expected the pool to be paused by the signal with its reason as the message, got: %+v", cond)
+	}
+
+	setRolloutPausedCondition(&status, "", false)
+	cond = mcfgv1.GetMachineConfigPoolCondition(status, mcfgv1.MachineConfigPoolRolloutPaused)
+	if cond == nil || cond.Status != corev1.ConditionFalse || cond.Reason != "" || cond.Message != "" {
+		t.Errorf("expected the pool to be resumed, got: %+v", cond)
+	}
+}
+
+// TestRolloutPausedWithoutReason verifies a pool paused without a reason
+// records the ConfigMap pausing it.
+func TestRolloutPausedWithoutReason(t *testing.T) {
+	f, mcp, nodes := newRolloutPauseFixture(t)
+	f.rolloutPause = newRolloutPause(map[string]string{mcp.Name: ""})
+
+	expMcp := mcp.DeepCopy()
+	expMcp.Status = calculateStatus(mcp, nodes)
+	setRolloutPausedCondition(&expMcp.Status, "Paused by ConfigMap openshift-machine-config-operator/machine-config-rollout-pause", true)
+	f.expectUpdateMachineConfigPoolStatus(expMcp)
+
+	f.run(getKey(mcp, t))
+}
+
+// TestRolloutResumed clears the pause signal of a paused pool and verifies
+// the next node is started on the update.
+func TestRolloutResumed(t *testing.T) {
+	for _, pause := range []*corev1.ConfigMap{
+		newRolloutPause(map[string]string{"test-cluster-worker": "Alert KubeletDown is firing"}),
+		// the ConfigMap was deleted.
+		nil,
+	} {
+		f, mcp, nodes := newRolloutPauseFixture(t)
+		setRolloutPausedCondition(&mcp.Status, "Alert KubeNodeNotReady is firing", true)
+		f.rolloutPause = pause
+
+		f.expectGetNodeAction(nodes[1])
+		expNode := nodes[1].DeepCopy()
+		expNode.Annotations[daemon.DesiredMachineConfigAnnotationKey] = "v1"
+		oldData, err := json.Marshal(nodes[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		newData, err := json.Marshal(expNode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exppatch, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, corev1.Node{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.expectPatchNodeAction(expNode, exppatch)
+		expMcp := mcp.DeepCopy()
+		expMcp.Status = calculateStatus(mcp, nodes)
+		setRolloutPausedCondition(&expMcp.Status, "", false)
+		f.expectUpdateMachineConfigPoolStatus(expMcp)
+
+		f.run(getKey(mcp, t))
+	}
+}
+
+// TestRecordRolloutPauseChange verifies an event is emitted when the rollout
+// of a pool is paused and when it is resumed.
+func TestRecordRolloutPauseChange(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ctrl := &Controller{eventRecorder: recorder}
+	mcp := newMachineConfigPool("test-cluster-master", nil, nil, "v1")
+
+	ctrl.recordRolloutPauseChange(mcp, "Alert KubeNodeNotReady is firing", true)
+	setRolloutPausedCondition(&mcp.Status, "Alert KubeNodeNotReady is firing", true)
+	// still paused, nothing changes.
+	ctrl.recordRolloutPauseChange(mcp, "Alert KubeNodeNotReady is firing", true)
+	ctrl.recordRolloutPauseChange(mcp, "", false)
+	close(recorder.Events)
+
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	exp := []string{
+		"Warning RolloutPaused Rollout paused: Alert KubeNodeNotReady is firing",
+		"Normal RolloutResumed Rollout resumed",
+	}
+	if len(events) != len(exp) {
+		t.Fatalf("expected events %v, got %v", exp, events)
+	}
+	for i := range exp {
+		if events[i] != exp[i] {
+			t.Errorf("expected event %q, got %q", exp[i], events[i])
+		}
+	}
+}
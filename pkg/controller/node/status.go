@@ -21,6 +21,14 @@ func (ctrl *Controller) syncStatusOnly(pool *mcfgv1.MachineConfigPool) error {
 	}
 
 	newStatus := calculateStatus(pool, nodes)
+	if ctrl.rolloutPauseEnabled() {
+		reason, paused, err := ctrl.rolloutPauseReason(pool)
+		if err != nil {
+			return err
+		}
+		ctrl.recordRolloutPauseChange(pool, reason, paused)
+		setRolloutPausedCondition(&newStatus, reason, paused)
+	}
 	if equality.Semantic.DeepEqual(pool.Status, newStatus) {
 		return nil
 	}
@@ -54,6 +62,8 @@ func calculateStatus(pool *mcfgv1.MachineConfigPool, nodes []*corev1.Node) mcfgv
 	}
 
 	status.CurrentMachineConfig = pool.Status.CurrentMachineConfig
+	status.PendingBlastRadius = pool.Status.PendingBlastRadius
+	status.DrainBlockedMachines = getDrainBlockedMachines(nodes)
 
 	conditions := pool.Status.Conditions
 	for i := range conditions {
@@ -181,3 +191,18 @@ func getDegradedMachines(currentConfig string, nodes []*corev1.Node) []*corev1.N
 	}
 	return degraded
 }
+
+func getDrainBlockedMachines(nodes []*corev1.Node) []mcfgv1.MachineConfigPoolDrainBlock {
+	var blocked []mcfgv1.MachineConfigPoolDrainBlock
+	for _, node := range nodes {
+		if node.Annotations == nil {
+			continue
+		}
+		pods := daemon.ParseDrainBlockingPods(node.Annotations[daemon.MachineConfigDaemonDrainBlockedByAnnotationKey])
+		if len(pods) == 0 {
+			continue
+		}
+		blocked = append(blocked, mcfgv1.MachineConfigPoolDrainBlock{Node: node.Name, BlockingPods: pods})
+	}
+	return blocked
+}
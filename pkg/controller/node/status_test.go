@@ -654,3 +654,37 @@ func TestCalculateStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateStatusDrainBlocked(t *testing.T) {
+	blocked := newNodeWithReady("node-1", "v0", "v1", corev1.ConditionTrue)
+	blocked.Annotations[daemon.MachineConfigDaemonDrainBlockedByAnnotationKey] = "db/db-0:db-pdb,web/web-0:web-pdb"
+	cleared := newNodeWithReady("node-2", "v0", "v1", corev1.ConditionTrue)
+	cleared.Annotations[daemon.MachineConfigDaemonDrainBlockedByAnnotationKey] = ""
+	nodes := []*corev1.Node{
+		newNodeWithReady("node-0", "v1", "v1", corev1.ConditionTrue),
+		blocked,
+		cleared,
+	}
+	pool := &mcfgv1.MachineConfigPool{
+		Status: mcfgv1.MachineConfigPoolStatus{
+			CurrentMachineConfig: "v1",
+		},
+	}
+
+	status := calculateStatus(pool, nodes)
+	exp := []mcfgv1.MachineConfigPoolDrainBlock{{
+		Node: "node-1",
+		BlockingPods: []mcfgv1.DrainBlockingPod{
+			{Namespace: "db", Name: "db-0", PodDisruptionBudget: "db-pdb"},
+			{Namespace: "web", Name: "web-0", PodDisruptionBudget: "web-pdb"},
+		},
+	}}
+	if !reflect.DeepEqual(status.DrainBlockedMachines, exp) {
+		t.Fatalf("mismatch DrainBlockedMachines: got %v want: %v", status.DrainBlockedMachines, exp)
+	}
+
+	delete(blocked.Annotations, daemon.MachineConfigDaemonDrainBlockedByAnnotationKey)
+	if status := calculateStatus(pool, nodes); status.DrainBlockedMachines != nil {
+		t.Fatalf("expected no DrainBlockedMachines, got: %v", status.DrainBlockedMachines)
+	}
+}
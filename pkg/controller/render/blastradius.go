@@ -0,0 +1,82 @@
+package render
+
+import (
+	"reflect"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/liveupdate"
+)
+
+// Changed categories reported in the blast radius of a generated MachineConfig.
+const (
+	BlastRadiusCategoryOSImageURL  = "OSImageURL"
+	BlastRadiusCategoryTuning      = "TuningProfile"
+	BlastRadiusCategoryFiles       = "Files"
+	BlastRadiusCategoryUdevRules   = "UdevRules"
+	BlastRadiusCategoryCATrust     = "CATrustAnchors"
+	BlastRadiusCategorySysusers    = "Sysusers"
+	BlastRadiusCategoryTmpfiles    = "Tmpfiles"
+	BlastRadiusCategoryDirectories = "Directories"
+	BlastRadiusCategoryLinks       = "Links"
+	BlastRadiusCategoryDisks       = "Disks"
+	BlastRadiusCategoryFilesystems = "Filesystems"
+	BlastRadiusCategoryRaid        = "Raid"
+	BlastRadiusCategoryUnits       = "Units"
+	BlastRadiusCategoryNetworkd    = "Networkd"
+	BlastRadiusCategoryPasswd      = "Passwd"
+	BlastRadiusCategoryIgnition    = "Ignition"
+)
+
+// computeBlastRadius analyzes the impact of rolling out pending to the machines
+// of pool that are on current.
+func computeBlastRadius(pool *mcfgv1.MachineConfigPool, current, pending *mcfgv1.MachineConfig) *mcfgv1.MachineConfigPoolBlastRadius {
+	br := &mcfgv1.MachineConfigPoolBlastRadius{
+		MachineConfig:     pending.Name,
+		ChangedCategories: changedCategories(current, pending),
+	}
+	if len(br.ChangedCategories) == 0 {
+		return br
+	}
+	br.AffectedMachineCount = pool.Status.MachineCount
+	br.RebootRequired = liveupdate.RebootRequired(current, pending)
+	return br
+}
+
+// changedCategories lists the parts of the MachineConfig that differ between
+// current and pending.
+func changedCategories(current, pending *mcfgv1.MachineConfig) []string {
+	var categories []string
+	changed := func(category string, old, new interface{}) {
+		if !reflect.DeepEqual(old, new) {
+			categories = append(categories, category)
+		}
+	}
+
+	changed(BlastRadiusCategoryOSImageURL, current.Spec.OSImageURL, pending.Spec.OSImageURL)
+	changed(BlastRadiusCategoryTuning, current.Spec.TuningProfile, pending.Spec.TuningProfile)
+
+	oldIgn, newIgn := current.Spec.Config, pending.Spec.Config
+	oldRules, oldFiles := liveupdate.SplitFiles(oldIgn.Storage.Files, liveupdate.IsUdevRule)
+	newRules, newFiles := liveupdate.SplitFiles(newIgn.Storage.Files, liveupdate.IsUdevRule)
+	oldAnchors, oldFiles := liveupdate.SplitFiles(oldFiles, liveupdate.IsCATrustAnchor)
+	newAnchors, newFiles := liveupdate.SplitFiles(newFiles, liveupdate.IsCATrustAnchor)
+	oldSysusers, oldFiles := liveupdate.SplitFiles(oldFiles, liveupdate.IsSysusersConfig)
+	newSysusers, newFiles := liveupdate.SplitFiles(newFiles, liveupdate.IsSysusersConfig)
+	oldTmpfiles, oldFiles := liveupdate.SplitFiles(oldFiles, liveupdate.IsTmpfilesConfig)
+	newTmpfiles, newFiles := liveupdate.SplitFiles(newFiles, liveupdate.IsTmpfilesConfig)
+	changed(BlastRadiusCategoryFiles, oldFiles, newFiles)
+	changed(BlastRadiusCategoryUdevRules, oldRules, newRules)
+	changed(BlastRadiusCategoryCATrust, oldAnchors, newAnchors)
+	changed(BlastRadiusCategorySysusers, oldSysusers, newSysusers)
+	changed(BlastRadiusCategoryTmpfiles, oldTmpfiles, newTmpfiles)
+	changed(BlastRadiusCategoryDirectories, oldIgn.Storage.Directories, newIgn.Storage.Directories)
+	changed(BlastRadiusCategoryLinks, oldIgn.Storage.Links, newIgn.Storage.Links)
+	changed(BlastRadiusCategoryDisks, oldIgn.Storage.Disks, newIgn.Storage.Disks)
+	changed(BlastRadiusCategoryFilesystems, oldIgn.Storage.Filesystems, newIgn.Storage.Filesystems)
+	changed(BlastRadiusCategoryRaid, oldIgn.Storage.Raid, newIgn.Storage.Raid)
+	changed(BlastRadiusCategoryUnits, oldIgn.Systemd, newIgn.Systemd)
+	changed(BlastRadiusCategoryNetworkd, oldIgn.Networkd, newIgn.Networkd)
+	changed(BlastRadiusCategoryPasswd, oldIgn.Passwd, newIgn.Passwd)
+	changed(BlastRadiusCategoryIgnition, oldIgn.Ignition, newIgn.Ignition)
+	return categories
+}
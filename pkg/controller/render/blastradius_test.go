@@ -0,0 +1,140 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestComputeBlastRadius(t *testing.T) {
+	file := func(path, source string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node:          ignv2_2types.Node{Filesystem: "root", Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: source}},
+		}
+	}
+	pool := newMachineConfigPool("test-cluster-master", nil, "current")
+	pool.Status.MachineCount = 3
+	current := newMachineConfig("current", nil, "://dummy", []ignv2_2types.File{
+		file("/etc/motd", "data:,hello"),
+		file("/etc/udev/rules.d/99-test.rules", "data:,old"),
+	})
+
+	tests := []struct {
+		name       string
+		pending    func(*mcfgv1.MachineConfig)
+		affected   int32
+		reboot     bool
+		categories []string
+	}{{
+		name:    "unchanged",
+		pending: func(*mcfgv1.MachineConfig) {},
+	}, {
+		name: "udev rules only",
+		pending: func(mc *mcfgv1.MachineConfig) {
+			mc.Spec.Config.Storage.Files[1] = file("/etc/udev/rules.d/99-test.rules", "data:,new")
+		},
+		affected:   3,
+		reboot:     false,
+		categories: []string{BlastRadiusCategoryUdevRules},
+	}, {
+		name: "ca trust anchors only",
+		pending: func(mc *mcfgv1.MachineConfig) {
+			mc.Spec.Config.Storage.Files = append(mc.Spec.Config.Storage.Files, file("/etc/pki/ca-trust/source/anchors/corp-ca.pem", "data:,ca"))
+		},
+		affected:   3,
+		reboot:     false,
+		categories: []string{BlastRadiusCategoryCATrust},
+	}, {
+		name: "units only",
+		pending: func(mc *mcfgv1.MachineConfig) {
+			mc.Spec.Config.Systemd.Units = []ignv2_2types.Unit{{Name: "test.service", Contents: "[Unit]\nX-MachineConfigRestartInPlace=true\n"}}
+		},
+		affected:   3,
+		reboot:     false,
+		categories: []string{BlastRadiusCategoryUnits},
+	}, {
+		name: "units not restarting in place",
+		pending: func(mc *mcfgv1.MachineConfig) {
+			mc.Spec.Config.Systemd.Units = []ignv2_2types.Unit{{Name: "test.service", Contents: "[Unit]\n"}}
+		},
+		affected:   3,
+		reboot:     true,
+		categories: []string{BlastRadiusCategoryUnits},
+	}, {
+		name: "files",
+		pending: func(mc *mcfgv1.MachineConfig) {
+			mc.Spec.Config.Storage.Files[0] = file("/etc/motd", "data:,goodbye")
+		},
+		affected:   3,
+		reboot:     true,
+		categories: []string{BlastRadiusCategoryFiles},
+	}, {
+		name: "os image and udev rules",
+		pending: func(mc *mcfgv1.MachineConfig) {
+			mc.Spec.OSImageURL = "quay.io/openshift/os:1"
+			mc.Spec.Config.Storage.Files[1] = file("/etc/udev/rules.d/99-test.rules", "data:,new")
+		},
+		affected:   3,
+		reboot:     true,
+		categories: []string{BlastRadiusCategoryOSImageURL, BlastRadiusCategoryUdevRules},
+	}}
+
+	for _, test := range tests {
+		pending := current.DeepCopy()
+		pending.Name = "pending"
+		test.pending(pending)
+
+		br := computeBlastRadius(pool, current, pending)
+		if br.MachineConfig != "pending" {
+			t.Errorf("%s: expected blast radius of pending, got %s", test.name, br.MachineConfig)
+		}
+		if br.AffectedMachineCount != test.affected {
+			t.Errorf("%s: expected %d affected machines, got %d", test.name, test.affected, br.AffectedMachineCount)
+		}
+		if br.RebootRequired != test.reboot {
+			t.Errorf("%s: expected reboot required %v, got %v", test.name, test.reboot, br.RebootRequired)
+		}
+		if !reflect.DeepEqual(br.ChangedCategories, test.categories) {
+			t.Errorf("%s: expected changed categories %v, got %v", test.name, test.categories, br.ChangedCategories)
+		}
+	}
+}
+
+func TestPinnedPoolReportsBlastRadius(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "old-generated-config")
+	mcp.Annotations = map[string]string{PinnedPoolAnnotationKey: "true"}
+	mcp.Status.MachineCount = 2
+	mcs := []*mcfgv1.MachineConfig{
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:1", nil),
+	}
+	current := newMachineConfig("old-generated-config", nil, "://dummy", nil)
+
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mcs[0], current)
+	f.objects = append(f.objects, mcs[0], current)
+
+	gmc, err := generateMachineConfig(mcp, mcs, mcfgv1.MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.mcLister = append(f.mcLister, gmc)
+	f.objects = append(f.objects, gmc)
+
+	// the pool stays on its config, but reports what rolling out the generated config does.
+	expPool := mcp.DeepCopy()
+	expPool.Status.PendingBlastRadius = &mcfgv1.MachineConfigPoolBlastRadius{
+		MachineConfig:        gmc.Name,
+		AffectedMachineCount: 2,
+		RebootRequired:       true,
+		ChangedCategories:    []string{BlastRadiusCategoryOSImageURL},
+	}
+	f.expectUpdateMachineConfigPoolStatus(expPool)
+
+	f.run(getKey(mcp, t))
+}
@@ -0,0 +1,181 @@
+package render
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// duplicateFileContents describes byte-identical contents shared by files
+// with different paths.
+type duplicateFileContents struct {
+	// Paths are the paths of the files sharing the contents, sorted.
+	Paths []string
+	// Size is the size of the decoded contents in bytes.
+	Size int
+}
+
+// dedupFiles removes the files from the storage section that are already
+// written by an identical entry for the same path later in the config. Ignition
+// writes files in order, so the later entry makes the earlier ones redundant.
+// Appended files are kept, appending twice isn't the same as appending once.
+// Returns the number of entries removed.
+func dedupFiles(conf *ignv2_2types.Config) int {
+	files := conf.Storage.Files
+	var out []ignv2_2types.File
+	for i, f := range files {
+		if !f.Append && hasLaterDuplicate(files[i+1:], f) {
+			continue
+		}
+		out = append(out, f)
+	}
+	removed := len(files) - len(out)
+	if removed > 0 {
+		conf.Storage.Files = out
+	}
+	return removed
+}
+
+func hasLaterDuplicate(later []ignv2_2types.File, f ignv2_2types.File) bool {
+	for _, l := range later {
+		if reflect.DeepEqual(l, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupUnits removes the systemd units that are identical to an earlier unit
+// of the config. Returns the number of entries removed.
+func dedupUnits(conf *ignv2_2types.Config) int {
+	units := conf.Systemd.Units
+	var out []ignv2_2types.Unit
+	for _, u := range units {
+		if hasDuplicateUnit(out, u) {
+			continue
+		}
+		out = append(out, u)
+	}
+	removed := len(units) - len(out)
+	if removed > 0 {
+		conf.Systemd.Units = out
+	}
+	return removed
+}
+
+func hasDuplicateUnit(units []ignv2_2types.Unit, u ignv2_2types.Unit) bool {
+	for _, o := range units {
+		if reflect.DeepEqual(o, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// findUnitConflicts returns an error naming the first systemd unit, or dropin
+// of a unit, that the configs define with different contents. A config that
+// only adds dropins to a unit of another config doesn't conflict with it.
+func findUnitConflicts(configs []*mcfgv1.MachineConfig) error {
+	// defined maps units and dropins to the config that defined them first
+	// and their contents.
+	type definition struct {
+		config   string
+		contents string
+	}
+	defined := map[string]definition{}
+	conflicts := func(key, config, contents string) (string, bool) {
+		if contents == "" {
+			return "", false
+		}
+		d, ok := defined[key]
+		if !ok {
+			defined[key] = definition{config: config, contents: contents}
+			return "", false
+		}
+		return d.config, d.contents != contents
+	}
+
+	for _, mc := range configs {
+		for _, u := range mc.Spec.Config.Systemd.Units {
+			if other, ok := conflicts(u.Name, mc.Name, u.Contents); ok {
+				return fmt.Errorf("systemd unit %s is defined with different contents by MachineConfigs %s and %s", u.Name, other, mc.Name)
+			}
+			for _, d := range u.Dropins {
+				if other, ok := conflicts(u.Name+"/"+d.Name, mc.Name, d.Contents); ok {
+					return fmt.Errorf("dropin %s of systemd unit %s is defined with different contents by MachineConfigs %s and %s", d.Name, u.Name, other, mc.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findDuplicateFileContents returns the groups of files with different paths
+// that embed byte-identical contents. Ignition has no way to share contents
+// between files, so these are kept but are worth reporting. Files whose
+// contents are not inline data URLs are ignored.
+func findDuplicateFileContents(conf ignv2_2types.Config) []duplicateFileContents {
+	type group struct {
+		paths map[string]bool
+		size  int
+	}
+	groups := map[[sha256.Size]byte]*group{}
+	var order [][sha256.Size]byte
+	for _, f := range conf.Storage.Files {
+		d, err := dataurl.DecodeString(f.Contents.Source)
+		if err != nil || len(d.Data) == 0 {
+			continue
+		}
+		// compressed and uncompressed contents are different files even if
+		// the bytes happen to match.
+		sum := sha256.Sum256(append([]byte(f.Contents.Compression+"\x00"), d.Data...))
+		g, ok := groups[sum]
+		if !ok {
+			g = &group{paths: map[string]bool{}, size: len(d.Data)}
+			groups[sum] = g
+			order = append(order, sum)
+		}
+		g.paths[f.Path] = true
+	}
+
+	var dups []duplicateFileContents
+	for _, sum := range order {
+		g := groups[sum]
+		if len(g.paths) < 2 {
+			continue
+		}
+		var paths []string
+		for p := range g.paths {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		dups = append(dups, duplicateFileContents{Paths: paths, Size: g.size})
+	}
+	return dups
+}
+
+// duplicatedBytes returns the number of bytes that the duplicate contents
+// add to the config.
+func duplicatedBytes(dups []duplicateFileContents) int {
+	var total int
+	for _, d := range dups {
+		total += d.Size * (len(d.Paths) - 1)
+	}
+	return total
+}
+
+// describeDuplicateFileContents returns a human readable list of the files
+// sharing contents.
+func describeDuplicateFileContents(dups []duplicateFileContents) string {
+	var groups []string
+	for _, d := range dups {
+		groups = append(groups, fmt.Sprintf("%s (%d bytes)", strings.Join(d.Paths, ", "), d.Size))
+	}
+	return strings.Join(groups, "; ")
+}
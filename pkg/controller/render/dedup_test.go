@@ -0,0 +1,207 @@
+package render
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/vincent-petithory/dataurl"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newFile(path, contents string, mode int) ignv2_2types.File {
+	return ignv2_2types.File{
+		Node: ignv2_2types.Node{Filesystem: "root", Path: path},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{
+			Mode:     &mode,
+			Contents: ignv2_2types.FileContents{Source: dataurl.EncodeBytes([]byte(contents))},
+		},
+	}
+}
+
+func filePaths(files []ignv2_2types.File) []string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path+"="+f.Contents.Source)
+	}
+	return paths
+}
+
+func TestDedupFiles(t *testing.T) {
+	appended := newFile("/etc/motd", "hello", 0644)
+	appended.Append = true
+
+	tests := []struct {
+		name    string
+		files   []ignv2_2types.File
+		removed int
+		want    []ignv2_2types.File
+	}{{
+		name:    "identical entries for the same path",
+		files:   []ignv2_2types.File{newFile("/etc/a", "a", 0644), newFile("/etc/b", "b", 0644), newFile("/etc/a", "a", 0644)},
+		removed: 1,
+		want:    []ignv2_2types.File{newFile("/etc/b", "b", 0644), newFile("/etc/a", "a", 0644)},
+	}, {
+		name:    "same contents different paths are kept",
+		files:   []ignv2_2types.File{newFile("/etc/a", "same", 0644), newFile("/etc/b", "same", 0644)},
+		removed: 0,
+		want:    []ignv2_2types.File{newFile("/etc/a", "same", 0644), newFile("/etc/b", "same", 0644)},
+	}, {
+		name:    "same path different contents are kept",
+		files:   []ignv2_2types.File{newFile("/etc/a", "a", 0644), newFile("/etc/a", "b", 0644)},
+		removed: 0,
+		want:    []ignv2_2types.File{newFile("/etc/a", "a", 0644), newFile("/etc/a", "b", 0644)},
+	}, {
+		name:    "same path different mode are kept",
+		files:   []ignv2_2types.File{newFile("/etc/a", "a", 0644), newFile("/etc/a", "a", 0600)},
+		removed: 0,
+		want:    []ignv2_2types.File{newFile("/etc/a", "a", 0644), newFile("/etc/a", "a", 0600)},
+	}, {
+		name:    "appends are kept",
+		files:   []ignv2_2types.File{appended, appended},
+		removed: 0,
+		want:    []ignv2_2types.File{appended, appended},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := ignv2_2types.Config{Storage: ignv2_2types.Storage{Files: test.files}}
+			if removed := dedupFiles(&conf); removed != test.removed {
+				t.Errorf("expected %d entries removed, got %d", test.removed, removed)
+			}
+			if !reflect.DeepEqual(conf.Storage.Files, test.want) {
+				t.Errorf("expected files %v, got %v", filePaths(test.want), filePaths(conf.Storage.Files))
+			}
+		})
+	}
+}
+
+func TestFindDuplicateFileContents(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	conf := ignv2_2types.Config{Storage: ignv2_2types.Storage{Files: []ignv2_2types.File{
+		newFile("/etc/pki/ca-1.crt", large, 0644),
+		newFile("/etc/unique", "unique", 0644),
+		newFile("/etc/pki/ca-2.crt", large, 0644),
+		newFile("/etc/pki/ca-1.crt", large, 0600),
+		newFile("/etc/x", "small", 0644),
+		newFile("/etc/y", "small", 0644),
+	}}}
+
+	dups := findDuplicateFileContents(conf)
+	expected := []duplicateFileContents{
+		{Paths: []string{"/etc/pki/ca-1.crt", "/etc/pki/ca-2.crt"}, Size: 4096},
+		{Paths: []string{"/etc/x", "/etc/y"}, Size: 5},
+	}
+	if !reflect.DeepEqual(dups, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, dups)
+	}
+	if got := duplicatedBytes(dups); got != 4101 {
+		t.Errorf("expected 4101 duplicated bytes, got %d", got)
+	}
+}
+
+// TestGenerateMachineConfigDedup verifies that identical files embedded by
+// several source configs end up once in the generated config.
+func TestGenerateMachineConfigDedup(t *testing.T) {
+	large := newFile("/etc/large", strings.Repeat("x", 8192), 0644)
+	pool := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+	configs := []*mcfgv1.MachineConfig{
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{large}),
+		newMachineConfig("05-extra", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{large, newFile("/etc/other", "other", 0644)}),
+	}
+
+	generated, err := generateMachineConfig(pool, configs, mcfgv1.MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generated.Spec.Config.Storage.Files) != 2 {
+		t.Fatalf("expected 2 files, got %v", filePaths(generated.Spec.Config.Storage.Files))
+	}
+
+	merged, err := mcfgv1.MergeMachineConfigs(configs, mcfgv1.MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedData, err := json.Marshal(merged.Spec.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generatedData, err := json.Marshal(generated.Spec.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	largeData, err := json.Marshal(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the removed entry and its separating comma
+	if saved := len(mergedData) - len(generatedData); saved != len(largeData)+1 {
+		t.Errorf("expected generated config to be %d bytes smaller, got %d", len(largeData)+1, saved)
+	}
+}
+
+func TestGenerateMachineConfigUnits(t *testing.T) {
+	newConfig := func(name string, units ...ignv2_2types.Unit) *mcfgv1.MachineConfig {
+		mc := newMachineConfig(name, map[string]string{"node-role": "master"}, "://dummy", nil)
+		mc.Spec.Config.Systemd.Units = units
+		return mc
+	}
+	enabled := true
+	unit := ignv2_2types.Unit{Name: "foo.service", Enabled: &enabled, Contents: "[Service]\nExecStart=/usr/bin/foo\n"}
+	changed := ignv2_2types.Unit{Name: "foo.service", Enabled: &enabled, Contents: "[Service]\nExecStart=/usr/bin/foo --verbose\n"}
+	dropin := ignv2_2types.Unit{Name: "foo.service", Dropins: []ignv2_2types.SystemdDropin{{Name: "10-env.conf", Contents: "[Service]\nEnvironment=FOO=1\n"}}}
+	changedDropin := ignv2_2types.Unit{Name: "foo.service", Dropins: []ignv2_2types.SystemdDropin{{Name: "10-env.conf", Contents: "[Service]\nEnvironment=FOO=2\n"}}}
+	bar := ignv2_2types.Unit{Name: "bar.service", Contents: "[Service]\nExecStart=/usr/bin/bar\n"}
+	pool := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+
+	tests := []struct {
+		name     string
+		configs  []*mcfgv1.MachineConfig
+		strategy mcfgv1.MergeConflictStrategy
+		units    []ignv2_2types.Unit
+		err      string
+	}{{
+		name:    "identical units are merged",
+		configs: []*mcfgv1.MachineConfig{newConfig("00-a", unit, bar), newConfig("05-b", unit)},
+		units:   []ignv2_2types.Unit{unit, bar},
+	}, {
+		name:    "dropins extend a unit of another config",
+		configs: []*mcfgv1.MachineConfig{newConfig("00-a", unit), newConfig("05-b", dropin)},
+		units:   []ignv2_2types.Unit{unit, dropin},
+	}, {
+		name:    "units with different contents conflict",
+		configs: []*mcfgv1.MachineConfig{newConfig("05-b", changed), newConfig("00-a", unit, bar)},
+		err:     "systemd unit foo.service is defined with different contents by MachineConfigs 00-a and 05-b",
+	}, {
+		name:    "dropins with different contents conflict",
+		configs: []*mcfgv1.MachineConfig{newConfig("00-a", unit, dropin), newConfig("05-b", changedDropin)},
+		err:     "dropin 10-env.conf of systemd unit foo.service is defined with different contents by MachineConfigs 00-a and 05-b",
+	}, {
+		name:     "the last unit wins the conflict",
+		configs:  []*mcfgv1.MachineConfig{newConfig("05-b", changed), newConfig("00-a", unit, bar)},
+		strategy: mcfgv1.MergeConflictLastWins,
+		units:    []ignv2_2types.Unit{{Name: "foo.service", Enabled: &enabled}, bar, changed},
+	}, {
+		name:     "the first dropin wins the conflict",
+		configs:  []*mcfgv1.MachineConfig{newConfig("00-a", unit, dropin), newConfig("05-b", changedDropin)},
+		strategy: mcfgv1.MergeConflictFirstWins,
+		units:    []ignv2_2types.Unit{unit, dropin, {Name: "foo.service"}},
+	}}
+	for _, test := range tests {
+		generated, err := generateMachineConfig(pool, test.configs, test.strategy)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if got := generated.Spec.Config.Systemd.Units; !reflect.DeepEqual(got, test.units) {
+			t.Errorf("%s: expected units %v, got: %v", test.name, test.units, got)
+		}
+	}
+}
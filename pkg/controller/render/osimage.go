@@ -0,0 +1,130 @@
+package render
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// osImageManifestTimeout bounds how long checking that an OS image exists in
+// its registry may take.
+const osImageManifestTimeout = 10 * time.Second
+
+var (
+	// osImageRegistryRe matches a registry host, with an optional port.
+	osImageRegistryRe = regexp.MustCompile(`^(localhost|[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)+|\[[0-9a-fA-F:]+\])(:[0-9]+)?$`)
+	// osImagePathComponentRe matches a component of a repository path.
+	osImagePathComponentRe = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*$`)
+	osImageTagRe           = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	osImageDigestRe        = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// osImageReference is a parsed osImageURL, an image pullspec of the form
+// registry/repository[:tag][@digest].
+type osImageReference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// reference returns the tag or the digest to fetch the manifest of the image
+// by, preferring the digest.
+func (r osImageReference) reference() string {
+	if r.digest != "" {
+		return r.digest
+	}
+	if r.tag != "" {
+		return r.tag
+	}
+	return "latest"
+}
+
+// isUnspecifiedOSImageURL returns true if the osImageURL leaves the OS of the
+// machines alone, the same way the daemon treats it.
+func isUnspecifiedOSImageURL(url string) bool {
+	// The ://dummy syntax is legacy
+	return url == "" || url == "://dummy"
+}
+
+// parseOSImageURL parses the osImageURL into an image reference.
+func parseOSImageURL(url string) (osImageReference, error) {
+	var ref osImageReference
+	if strings.Contains(url, "://") {
+		return ref, fmt.Errorf("must be an image pullspec without a scheme, e.g. quay.io/openshift/os@sha256:<digest>")
+	}
+	name := url
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.digest = name[:i], name[i+1:]
+		if !osImageDigestRe.MatchString(ref.digest) {
+			return ref, fmt.Errorf("digest %q is not a sha256 digest of 64 lowercase hex characters", ref.digest)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.tag = name[:i], name[i+1:]
+		if !osImageTagRe.MatchString(ref.tag) {
+			return ref, fmt.Errorf("tag %q is invalid", ref.tag)
+		}
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || !osImageRegistryRe.MatchString(parts[0]) {
+		return ref, fmt.Errorf("must start with the registry host, e.g. quay.io/")
+	}
+	ref.registry, ref.repository = parts[0], parts[1]
+	for _, c := range strings.Split(ref.repository, "/") {
+		if !osImagePathComponentRe.MatchString(c) {
+			return ref, fmt.Errorf("repository path component %q is invalid", c)
+		}
+	}
+	return ref, nil
+}
+
+// validateOSImageURL checks that the osImageURL is unspecified or a well
+// formed image pullspec.
+func validateOSImageURL(url string) error {
+	if isUnspecifiedOSImageURL(url) {
+		return nil
+	}
+	_, err := parseOSImageURL(url)
+	return err
+}
+
+// newOSImageChecker returns a function checking that the image the osImageURL
+// references exists, with a HEAD request for its manifest to the registry
+// over scheme. Registries that require credentials to tell are trusted.
+func newOSImageChecker(client *http.Client, scheme string) func(string) error {
+	return func(url string) error {
+		ref, err := parseOSImageURL(url)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodHead, fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, ref.registry, ref.repository, ref.reference()), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", strings.Join([]string{
+			"application/vnd.docker.distribution.manifest.v2+json",
+			"application/vnd.docker.distribution.manifest.list.v2+json",
+			"application/vnd.oci.image.manifest.v1+json",
+			"application/vnd.oci.image.index.v1+json",
+		}, ", "))
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("registry %s is unreachable: %v", ref.registry, err)
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			// the registry is up, the nodes pull with their own credentials.
+			return nil
+		case resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("manifest %s of image %s/%s does not exist", ref.reference(), ref.registry, ref.repository)
+		default:
+			return fmt.Errorf("registry %s returned %s for the manifest of %s", ref.registry, resp.Status, url)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package render
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testOSImageDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestValidateOSImageURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{url: "", valid: true},
+		{url: "://dummy", valid: true},
+		{url: "quay.io/openshift/os:4.1", valid: true},
+		{url: "registry.svc.ci.openshift.org/ocp/4.1-art-latest@" + testOSImageDigest, valid: true},
+		{url: "localhost:5000/os", valid: true},
+		{url: "docker://quay.io/openshift/os:4.1", valid: false},
+		{url: "openshift/os:4.1", valid: false},
+		{url: "quay.io", valid: false},
+		{url: "quay.io/openshift/OS:4.1", valid: false},
+		{url: "quay.io/openshift/os:4.1 ", valid: false},
+		{url: "quay.io/openshift/os@sha256:0123", valid: false},
+	}
+	for _, test := range tests {
+		err := validateOSImageURL(test.url)
+		if test.valid && err != nil {
+			t.Errorf("%q: expected the osImageURL to be valid, got: %v", test.url, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q: expected the osImageURL to be invalid", test.url)
+		}
+	}
+}
+
+func TestGenerateMachineConfigInvalidOSImageURL(t *testing.T) {
+	pool := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+	mc := newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:4.1:latest", nil)
+	_, err := generateMachineConfig(pool, []*mcfgv1.MachineConfig{mc}, mcfgv1.MergeConflictAppend)
+	if err == nil {
+		t.Fatal("expected an error for a malformed osImageURL")
+	}
+	if exp := `invalid osImageURL "quay.io/openshift/os:4.1:latest" of MachineConfig 00-test-cluster-master`; !strings.Contains(err.Error(), exp) {
+		t.Errorf("expected the error to contain %q, got: %v", exp, err)
+	}
+}
+
+func TestOSImageChecker(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/v2/openshift/os/manifests/"+testOSImageDigest, r.URL.Path == "/v2/openshift/os/manifests/latest":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/private/os/manifests/latest":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	host := strings.TrimPrefix(registry.URL, "http://")
+	check := newOSImageChecker(registry.Client(), "http")
+
+	for _, url := range []string{host + "/openshift/os@" + testOSImageDigest, host + "/openshift/os", host + "/private/os"} {
+		if err := check(url); err != nil {
+			t.Errorf("%s: expected the image to be available, got: %v", url, err)
+		}
+	}
+	if err := check(host + "/openshift/os:missing"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected a missing image to be reported, got: %v", err)
+	}
+
+	registry.Close()
+	if err := check(host + "/openshift/os"); err == nil || !strings.Contains(err.Error(), "is unreachable") {
+		t.Errorf("expected an unreachable registry to be reported, got: %v", err)
+	}
+}
+
+func TestUnavailableOSImageNotRendered(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+	mc := newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:typo", nil)
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mc)
+	f.objects = append(f.objects, mc)
+
+	c, i := f.newController()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+
+	var checked []string
+	c.checkOSImage = func(url string) error {
+		checked = append(checked, url)
+		return fmt.Errorf("registry quay.io is unreachable")
+	}
+	err := c.syncHandler(getKey(mcp, t))
+	if err == nil || !strings.Contains(err.Error(), "osImageURL quay.io/openshift/os:typo is unavailable") {
+		t.Errorf("expected the unavailable osImageURL to fail the sync, got: %v", err)
+	}
+	if len(checked) != 1 {
+		t.Errorf("expected the osImageURL to be checked once, got: %v", checked)
+	}
+	for _, action := range filterInformerActions(f.client.Actions()) {
+		if action.GetVerb() == "create" {
+			t.Errorf("expected no MachineConfig to be created, got: %+v", action)
+		}
+	}
+
+	// an available image is rendered.
+	c.checkOSImage = func(string) error { return nil }
+	if err := c.syncHandler(getKey(mcp, t)); err != nil {
+		t.Errorf("expected the sync to succeed, got: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package render
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"time"
 
@@ -34,6 +35,11 @@ const (
 	//
 	// 5ms, 10ms, 20ms, 40ms, 80ms, 160ms, 320ms, 640ms, 1.3s, 2.6s, 5.1s, 10.2s, 20.4s, 41s, 82s
 	maxRetries = 15
+
+	// PinnedPoolAnnotationKey is set to "true" on a MachineConfigPool to keep the pool on
+	// its current MachineConfig. New generated MachineConfigs are still created for review,
+	// but the pool is only moved to them once the annotation is removed.
+	PinnedPoolAnnotationKey = "machineconfiguration.openshift.io/pinned"
 )
 
 // controllerKind contains the schema.GroupVersionKind for this controller type.
@@ -54,6 +60,14 @@ type Controller struct {
 	mcListerSynced  cache.InformerSynced
 
 	queue workqueue.RateLimitingInterface
+
+	// rejectWeakPasswordHashes rejects MachineConfigs with password hashes using weak algorithms.
+	rejectWeakPasswordHashes bool
+	// mergeConflictStrategy resolves files and units defined differently by the MachineConfigs of a pool.
+	mergeConflictStrategy mcfgv1.MergeConflictStrategy
+	// checkOSImage, when set, checks that the image of the osImageURL exists before a
+	// MachineConfig using it is rendered.
+	checkOSImage func(string) error
 }
 
 // New returns a new render controller.
@@ -62,6 +76,9 @@ func New(
 	mcInformer mcfginformersv1.MachineConfigInformer,
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
+	rejectWeakPasswordHashes bool,
+	mergeConflictStrategy mcfgv1.MergeConflictStrategy,
+	checkOSImages bool,
 ) *Controller {
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(glog.Infof)
@@ -71,6 +88,12 @@ func New(
 		client:        mcfgClient,
 		eventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "machineconfigcontroller-rendercontroller"}),
 		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "machineconfigcontroller-rendercontroller"),
+
+		rejectWeakPasswordHashes: rejectWeakPasswordHashes,
+		mergeConflictStrategy:    mergeConflictStrategy,
+	}
+	if checkOSImages {
+		ctrl.checkOSImage = newOSImageChecker(&http.Client{Timeout: osImageManifestTimeout}, "https")
 	}
 
 	mcpInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -124,9 +147,26 @@ func (ctrl *Controller) updateMachineConfigPool(old, cur interface{}) {
 	oldPool := old.(*mcfgv1.MachineConfigPool)
 	curPool := cur.(*mcfgv1.MachineConfigPool)
 
+	// the status is what the controllers report about the pool, the render
+	// failures included, and doesn't change what the pool renders, so a
+	// failed render reporting its retries doesn't enqueue the pool again.
+	if isStatusOnlyUpdate(oldPool, curPool) {
+		return
+	}
 	glog.V(4).Infof("Updating MachineConfigPool %s", oldPool.Name)
 	ctrl.enqueueMachineConfigPool(curPool)
 }
+
+// isStatusOnlyUpdate returns true if only the status of the pool changed.
+// Resyncs, which change nothing, aren't status only updates.
+func isStatusOnlyUpdate(oldPool, curPool *mcfgv1.MachineConfigPool) bool {
+	if reflect.DeepEqual(oldPool.Status, curPool.Status) {
+		return false
+	}
+	oldMeta, curMeta := oldPool.ObjectMeta.DeepCopy(), curPool.ObjectMeta.DeepCopy()
+	oldMeta.ResourceVersion, curMeta.ResourceVersion = "", ""
+	return reflect.DeepEqual(oldMeta, curMeta) && reflect.DeepEqual(oldPool.Spec, curPool.Spec)
+}
 func (ctrl *Controller) deleteMachineConfigPool(obj interface{}) {
 	pool, ok := obj.(*mcfgv1.MachineConfigPool)
 	if !ok {
@@ -351,9 +391,16 @@ func (ctrl *Controller) processNextWorkItem() bool {
 func (ctrl *Controller) handleErr(err error, key interface{}) {
 	if err == nil {
 		ctrl.queue.Forget(key)
+		if err := ctrl.syncRenderDegraded(key.(string), 0, nil); err != nil {
+			glog.V(2).Infof("Error clearing render failure of machineconfigpool %v: %v", key, err)
+		}
 		return
 	}
 
+	if err := ctrl.syncRenderDegraded(key.(string), ctrl.queue.NumRequeues(key)+1, err); err != nil {
+		glog.V(2).Infof("Error reporting render failure of machineconfigpool %v: %v", key, err)
+	}
+
 	if ctrl.queue.NumRequeues(key) < maxRetries {
 		glog.V(2).Infof("Error syncing machineconfigpool %v: %v", key, err)
 		ctrl.queue.AddRateLimited(key)
@@ -366,6 +413,62 @@ func (ctrl *Controller) handleErr(err error, key interface{}) {
 	ctrl.queue.AddAfter(key, 1*time.Minute)
 }
 
+// syncRenderDegraded reports the render failure of the pool with the given key
+// and how many attempts to render failed in the RenderDegraded condition of the
+// pool. The condition is cleared once the pool renders again.
+func (ctrl *Controller) syncRenderDegraded(key string, attempts int, renderErr error) error {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	cached, err := ctrl.mcpLister.Get(name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if renderErr == nil {
+		cond := mcfgv1.GetMachineConfigPoolCondition(cached.Status, mcfgv1.MachineConfigPoolRenderDegraded)
+		if cond == nil || cond.Status != v1.ConditionTrue {
+			return nil
+		}
+	}
+
+	// the render may have updated the status, get the latest pool.
+	pool, err := ctrl.client.MachineconfigurationV1().MachineConfigPools().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !setRenderDegradedCondition(&pool.Status, attempts, renderErr) {
+		return nil
+	}
+	_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().UpdateStatus(pool)
+	return err
+}
+
+// setRenderDegradedCondition sets the RenderDegraded condition of the status
+// for the render error, it returns false if the condition is unchanged.
+func setRenderDegradedCondition(status *mcfgv1.MachineConfigPoolStatus, attempts int, renderErr error) bool {
+	cond := mcfgv1.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolRenderDegraded, v1.ConditionFalse, "", "")
+	if renderErr != nil {
+		cond = mcfgv1.NewMachineConfigPoolCondition(mcfgv1.MachineConfigPoolRenderDegraded, v1.ConditionTrue, "RenderFailed",
+			fmt.Sprintf("Failed to render MachineConfig in %d attempts, retrying: %v", attempts, renderErr))
+	}
+	if cur := mcfgv1.GetMachineConfigPoolCondition(*status, cond.Type); cur != nil {
+		if cur.Status == cond.Status && cur.Reason == cond.Reason && cur.Message == cond.Message {
+			return false
+		}
+		// unlike SetMachineConfigPoolCondition, the message is updated with the attempts.
+		if cur.Status == cond.Status {
+			cond.LastTransitionTime = cur.LastTransitionTime
+		}
+	}
+	mcfgv1.RemoveMachineConfigPoolCondition(status, cond.Type)
+	status.Conditions = append(status.Conditions, *cond)
+	return true
+}
+
 // syncMachineConfigPool will sync the machineconfig pool with the given key.
 // This function is not meant to be invoked concurrently with the same key.
 func (ctrl *Controller) syncMachineConfigPool(key string) error {
@@ -410,6 +513,13 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return fmt.Errorf("no MachineConfigs found matching selector %v", selector)
 	}
 
+	for _, mc := range mcs {
+		if err := validateMachineConfig(mc, ctrl.rejectWeakPasswordHashes); err != nil {
+			ctrl.eventRecorder.Eventf(pool, v1.EventTypeWarning, "InvalidMachineConfig", "MachineConfig %s is invalid: %v", mc.Name, err)
+			return fmt.Errorf("MachineConfig %s is invalid: %v", mc.Name, err)
+		}
+	}
+
 	glog.V(4).Infof("Syncing generated machineconfig for pool %s using (%d) machineconfigs", pool.GetName(), len(mcs))
 	return ctrl.syncGeneratedMachineConfig(pool, mcs)
 }
@@ -419,13 +529,23 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 		return nil
 	}
 
-	generated, err := generateMachineConfig(pool, configs)
+	generated, err := generateMachineConfig(pool, configs, ctrl.mergeConflictStrategy)
 	if err != nil {
+		ctrl.eventRecorder.Eventf(pool, v1.EventTypeWarning, "GenerateFailed", "Could not generate MachineConfig: %v", err)
 		return err
 	}
 
 	_, err = ctrl.mcLister.Get(generated.Name)
 	if apierrors.IsNotFound(err) {
+		if ctrl.checkOSImage != nil && !isUnspecifiedOSImageURL(generated.Spec.OSImageURL) {
+			if err := ctrl.checkOSImage(generated.Spec.OSImageURL); err != nil {
+				ctrl.eventRecorder.Eventf(pool, v1.EventTypeWarning, "OSImageUnavailable", "osImageURL %s of generated MachineConfig %s is unavailable: %v", generated.Spec.OSImageURL, generated.Name, err)
+				return fmt.Errorf("osImageURL %s is unavailable: %v", generated.Spec.OSImageURL, err)
+			}
+		}
+		if dups := findDuplicateFileContents(generated.Spec.Config); len(dups) > 0 {
+			ctrl.eventRecorder.Eventf(pool, v1.EventTypeWarning, "DuplicateFileContents", "Generated MachineConfig %s embeds %d bytes of file contents more than once: %s", generated.Name, duplicatedBytes(dups), describeDuplicateFileContents(dups))
+		}
 		_, err = ctrl.client.MachineconfigurationV1().MachineConfigs().Create(generated)
 	}
 	if err != nil {
@@ -434,10 +554,22 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 
 	if pool.Status.CurrentMachineConfig == generated.Name {
 		_, _, err = resourceapply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), generated)
+		if err != nil || pool.Status.PendingBlastRadius == nil {
+			return err
+		}
+		pool.Status.PendingBlastRadius = nil
+		_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().UpdateStatus(pool)
 		return err
 	}
 
+	if isPoolPinned(pool) && pool.Status.CurrentMachineConfig != "" {
+		glog.V(2).Infof("MachineConfigPool %s is pinned to %s, not advancing to %s", pool.Name, pool.Status.CurrentMachineConfig, generated.Name)
+		ctrl.eventRecorder.Eventf(pool, v1.EventTypeNormal, "Pinned", "Pool is pinned to %s; generated MachineConfig %s is available for review", pool.Status.CurrentMachineConfig, generated.Name)
+		return ctrl.syncPendingBlastRadius(pool, generated)
+	}
+
 	pool.Status.CurrentMachineConfig = generated.Name
+	pool.Status.PendingBlastRadius = nil
 	_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().UpdateStatus(pool)
 	if err != nil {
 		return err
@@ -472,8 +604,63 @@ func (ctrl *Controller) syncGeneratedMachineConfig(pool *mcfgv1.MachineConfigPoo
 	return nil
 }
 
-func generateMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig) (*mcfgv1.MachineConfig, error) {
-	merged := mcfgv1.MergeMachineConfigs(configs)
+// syncPendingBlastRadius reports the blast radius of rolling out the generated
+// MachineConfig in the status of the pool that isn't rolling it out yet.
+func (ctrl *Controller) syncPendingBlastRadius(pool *mcfgv1.MachineConfigPool, generated *mcfgv1.MachineConfig) error {
+	current, err := ctrl.mcLister.Get(pool.Status.CurrentMachineConfig)
+	if apierrors.IsNotFound(err) {
+		glog.Warningf("Current MachineConfig %s of pool %s not found, cannot compute blast radius of %s", pool.Status.CurrentMachineConfig, pool.Name, generated.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	br := computeBlastRadius(pool, current, generated)
+	if reflect.DeepEqual(pool.Status.PendingBlastRadius, br) {
+		return nil
+	}
+	pool.Status.PendingBlastRadius = br
+	_, err = ctrl.client.MachineconfigurationV1().MachineConfigPools().UpdateStatus(pool)
+	return err
+}
+
+// isPoolPinned returns true if the pool has been pinned to its current MachineConfig.
+func isPoolPinned(pool *mcfgv1.MachineConfigPool) bool {
+	return pool.Annotations[PinnedPoolAnnotationKey] == "true"
+}
+
+func generateMachineConfig(pool *mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineConfig, strategy mcfgv1.MergeConflictStrategy) (*mcfgv1.MachineConfig, error) {
+	merged, err := mcfgv1.MergeMachineConfigs(configs, strategy)
+	if err != nil {
+		return nil, err
+	}
+	// configs are sorted by the merge, so conflicts are reported in merge order.
+	// Appending keeps conflicting units, which are rejected.
+	if strategy == "" || strategy == mcfgv1.MergeConflictAppend {
+		if err := findUnitConflicts(configs); err != nil {
+			return nil, err
+		}
+	}
+	if err := findUIDCollisions(configs); err != nil {
+		return nil, err
+	}
+	if removed := dedupFiles(&merged.Spec.Config); removed > 0 {
+		glog.V(2).Infof("Removed %d duplicate file entries from generated MachineConfig for pool %s", removed, pool.Name)
+	}
+	if removed := dedupUnits(&merged.Spec.Config); removed > 0 {
+		glog.V(2).Infof("Removed %d duplicate systemd unit entries from generated MachineConfig for pool %s", removed, pool.Name)
+	}
+	if normalized := normalizeFileModes(&merged.Spec.Config); normalized > 0 {
+		glog.V(2).Infof("Normalized %d file modes written in octal in generated MachineConfig for pool %s", normalized, pool.Name)
+	}
+	// the merged osImageURL is the one of the first config.
+	if err := validateOSImageURL(merged.Spec.OSImageURL); err != nil {
+		return nil, fmt.Errorf("invalid osImageURL %q of MachineConfig %s: %v", merged.Spec.OSImageURL, configs[0].Name, err)
+	}
+	if err := validateRenderedConfigSize(pool, merged); err != nil {
+		return nil, err
+	}
 	hashedName, err := getMachineConfigHashedName(merged)
 	if err != nil {
 		return nil, err
@@ -500,8 +687,13 @@ func RunBootstrap(pools []*mcfgv1.MachineConfigPool, configs []*mcfgv1.MachineCo
 		if err != nil {
 			return nil, nil, err
 		}
+		for _, mc := range pcs {
+			if err := validateMachineConfig(mc, false); err != nil {
+				return nil, nil, fmt.Errorf("MachineConfig %s is invalid: %v", mc.Name, err)
+			}
+		}
 
-		generated, err := generateMachineConfig(pool, pcs)
+		generated, err := generateMachineConfig(pool, pcs, mcfgv1.MergeConflictAppend)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -1,7 +1,9 @@
 package render
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,12 +12,14 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
 	informers "github.com/openshift/machine-config-operator/pkg/generated/informers/externalversions"
 	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/diff"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
@@ -79,7 +83,7 @@ func (f *fixture) newController() (*Controller, informers.SharedInformerFactory)
 
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
 	c := New(i.Machineconfiguration().V1().MachineConfigPools(), i.Machineconfiguration().V1().MachineConfigs(),
-		k8sfake.NewSimpleClientset(), f.client)
+		k8sfake.NewSimpleClientset(), f.client, false, mcfgv1.MergeConflictAppend, false)
 
 	c.mcpListerSynced = alwaysReady
 	c.mcListerSynced = alwaysReady
@@ -231,8 +235,8 @@ func TestCreatesGeneratedMachineConfig(t *testing.T) {
 		},
 	}}
 	mcs := []*mcfgv1.MachineConfig{
-		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "dummy://", []ignv2_2types.File{files[0]}),
-		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "dummy://1", []ignv2_2types.File{files[1]}),
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{files[0]}),
+		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:1", []ignv2_2types.File{files[1]}),
 	}
 
 	f.mcpLister = append(f.mcpLister, mcp)
@@ -256,10 +260,10 @@ func TestUpdatesGeneratedMachineConfig(t *testing.T) {
 		},
 	}}
 	mcs := []*mcfgv1.MachineConfig{
-		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "dummy://", []ignv2_2types.File{files[0]}),
-		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "dummy://1", []ignv2_2types.File{files[1]}),
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{files[0]}),
+		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:1", []ignv2_2types.File{files[1]}),
 	}
-	gmc, err := generateMachineConfig(mcp, mcs)
+	gmc, err := generateMachineConfig(mcp, mcs, mcfgv1.MergeConflictAppend)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -275,7 +279,7 @@ func TestUpdatesGeneratedMachineConfig(t *testing.T) {
 	f.mcLister = append(f.mcLister, gmc)
 	f.objects = append(f.objects, gmc)
 
-	expmc, err := generateMachineConfig(mcp, mcs)
+	expmc, err := generateMachineConfig(mcp, mcs, mcfgv1.MergeConflictAppend)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -299,10 +303,10 @@ func TestDoNothing(t *testing.T) {
 		},
 	}}
 	mcs := []*mcfgv1.MachineConfig{
-		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "dummy://", []ignv2_2types.File{files[0]}),
-		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "dummy://1", []ignv2_2types.File{files[1]}),
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{files[0]}),
+		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:1", []ignv2_2types.File{files[1]}),
 	}
-	gmc, err := generateMachineConfig(mcp, mcs)
+	gmc, err := generateMachineConfig(mcp, mcs, mcfgv1.MergeConflictAppend)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -322,6 +326,224 @@ func TestDoNothing(t *testing.T) {
 	f.run(getKey(mcp, t))
 }
 
+func TestPinnedPoolDoesNotAdvance(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "old-generated-config")
+	mcp.Annotations = map[string]string{PinnedPoolAnnotationKey: "true"}
+	files := []ignv2_2types.File{{
+		Node: ignv2_2types.Node{
+			Path: "/dummy/0",
+		},
+	}, {
+		Node: ignv2_2types.Node{
+			Path: "/dummy/1",
+		},
+	}}
+	mcs := []*mcfgv1.MachineConfig{
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{files[0]}),
+		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:1", []ignv2_2types.File{files[1]}),
+	}
+
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mcs...)
+	for idx := range mcs {
+		f.objects = append(f.objects, mcs[idx])
+	}
+
+	expmc, err := generateMachineConfig(mcp, mcs, mcfgv1.MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the generated config is created for review, but the pool is not updated.
+	f.expectCreateMachineConfigAction(expmc)
+
+	f.run(getKey(mcp, t))
+}
+
+func TestUnpinnedPoolAdvances(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "old-generated-config")
+	mcp.Annotations = map[string]string{PinnedPoolAnnotationKey: "false"}
+	files := []ignv2_2types.File{{
+		Node: ignv2_2types.Node{
+			Path: "/dummy/0",
+		},
+	}, {
+		Node: ignv2_2types.Node{
+			Path: "/dummy/1",
+		},
+	}}
+	mcs := []*mcfgv1.MachineConfig{
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{files[0]}),
+		newMachineConfig("05-extra-master", map[string]string{"node-role": "master"}, "quay.io/openshift/os:1", []ignv2_2types.File{files[1]}),
+	}
+	gmc, err := generateMachineConfig(mcp, mcs, mcfgv1.MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mcs...)
+	for idx := range mcs {
+		f.objects = append(f.objects, mcs[idx])
+	}
+	f.mcLister = append(f.mcLister, gmc)
+	f.objects = append(f.objects, gmc)
+
+	expPool := mcp.DeepCopy()
+	expPool.Status.CurrentMachineConfig = gmc.Name
+	f.expectUpdateMachineConfigPoolStatus(expPool)
+	for idx := range mcs {
+		f.expectPatchMachineConfigAction(mcs[idx], nil)
+	}
+
+	f.run(getKey(mcp, t))
+}
+
+func TestInvalidMachineConfig(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+	hash := "not-a-hash"
+	mc := newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", nil)
+	mc.Spec.Config.Passwd.Users = []ignv2_2types.PasswdUser{{Name: "core", PasswordHash: &hash}}
+
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mc)
+	f.objects = append(f.objects, mc)
+
+	f.runExpectError(getKey(mcp, t))
+}
+
+func TestRenderFailureRetried(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+	mc := newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", nil)
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mc)
+	f.objects = append(f.objects, mc)
+
+	c, i := f.newController()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+
+	// creating the generated MachineConfig conflicts twice.
+	failures := 0
+	f.client.PrependReactor("create", "machineconfigs", func(core.Action) (bool, runtime.Object, error) {
+		if failures == 2 {
+			return false, nil, nil
+		}
+		failures++
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "machineconfigs"}, "rendered", fmt.Errorf("conflict"))
+	})
+	getPool := func() *mcfgv1.MachineConfigPool {
+		pool, err := f.client.MachineconfigurationV1().MachineConfigPools().Get(mcp.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pool
+	}
+
+	key := getKey(mcp, t)
+	c.queue.Add(key)
+	for attempt := 1; attempt <= 2; attempt++ {
+		c.processNextWorkItem()
+		if got := c.queue.NumRequeues(key); got != attempt {
+			t.Fatalf("expected the pool to be requeued %d times, got: %d", attempt, got)
+		}
+		cond := mcfgv1.GetMachineConfigPoolCondition(getPool().Status, mcfgv1.MachineConfigPoolRenderDegraded)
+		if cond == nil || cond.Status != "True" || cond.Reason != "RenderFailed" {
+			t.Fatalf("expected the pool to be RenderDegraded, got: %+v", cond)
+		}
+		if exp := fmt.Sprintf("in %d attempts", attempt); !strings.Contains(cond.Message, exp) || !strings.Contains(cond.Message, "conflict") {
+			t.Errorf("expected the message to report the error %s, got: %s", exp, cond.Message)
+		}
+	}
+
+	// wait for the condition to be seen before the render succeeds.
+	if err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		pool, err := c.mcpLister.Get(mcp.Name)
+		if err != nil {
+			return false, err
+		}
+		return mcfgv1.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolRenderDegraded) != nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.processNextWorkItem()
+	if got := c.queue.NumRequeues(key); got != 0 {
+		t.Errorf("expected the retries to be reset, got: %d", got)
+	}
+	pool := getPool()
+	if pool.Status.CurrentMachineConfig == "" {
+		t.Errorf("expected the pool to be rendered")
+	}
+	if cond := mcfgv1.GetMachineConfigPoolCondition(pool.Status, mcfgv1.MachineConfigPoolRenderDegraded); cond == nil || cond.Status != "False" {
+		t.Errorf("expected the RenderDegraded condition to be cleared, got: %+v", cond)
+	}
+}
+
+func TestRenderFailureStatusNotRequeued(t *testing.T) {
+	f := newFixture(t)
+	mcp := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+	mc := newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", nil)
+	f.mcpLister = append(f.mcpLister, mcp)
+	f.objects = append(f.objects, mcp)
+	f.mcLister = append(f.mcLister, mc)
+	f.objects = append(f.objects, mc)
+
+	c, _ := f.newController()
+	enqueued := 0
+	c.enqueueMachineConfigPool = func(*mcfgv1.MachineConfigPool) {
+		enqueued++
+	}
+
+	// creating the generated MachineConfig always conflicts.
+	f.client.PrependReactor("create", "machineconfigs", func(core.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "machineconfigs"}, "rendered", fmt.Errorf("conflict"))
+	})
+	getPool := func() *mcfgv1.MachineConfigPool {
+		pool, err := f.client.MachineconfigurationV1().MachineConfigPools().Get(mcp.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pool
+	}
+
+	// every attempt reports its count, and the status written doesn't
+	// enqueue the pool again.
+	key := getKey(mcp, t)
+	old := getPool()
+	for attempt := 1; attempt <= 3; attempt++ {
+		c.queue.Add(key)
+		c.processNextWorkItem()
+		cur := getPool()
+		cond := mcfgv1.GetMachineConfigPoolCondition(cur.Status, mcfgv1.MachineConfigPoolRenderDegraded)
+		if exp := fmt.Sprintf("in %d attempts", attempt); cond == nil || !strings.Contains(cond.Message, exp) {
+			t.Fatalf("expected the condition to report the error %s, got: %+v", exp, cond)
+		}
+		c.updateMachineConfigPool(old, cur)
+		old = cur
+	}
+	if enqueued != 0 {
+		t.Errorf("expected the status writes not to enqueue the pool, got %d enqueues", enqueued)
+	}
+
+	// resyncs and changes other than the status still do.
+	c.updateMachineConfigPool(old, old)
+	changed := old.DeepCopy()
+	changed.Labels = map[string]string{"changed": "true"}
+	c.updateMachineConfigPool(old, changed)
+	if enqueued != 2 {
+		t.Errorf("expected the resync and the label change to enqueue the pool, got %d enqueues", enqueued)
+	}
+}
+
 func getKey(config *mcfgv1.MachineConfigPool, t *testing.T) string {
 	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(config)
 	if err != nil {
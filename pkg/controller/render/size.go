@@ -0,0 +1,79 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// maxSizeContributors is the number of the largest entries of a rendered
+// config reported when it is too large.
+const maxSizeContributors = 5
+
+// sizeContributor is an entry of a rendered config and its serialized size.
+type sizeContributor struct {
+	name string
+	size int
+}
+
+// configSizeContributors returns the serialized size of every file, systemd
+// and networkd unit, user and group of the config, largest first.
+func configSizeContributors(conf ignv2_2types.Config) []sizeContributor {
+	var contributors []sizeContributor
+	add := func(name string, entry interface{}) {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		contributors = append(contributors, sizeContributor{name: name, size: len(data)})
+	}
+	for _, f := range conf.Storage.Files {
+		add("file "+f.Path, f)
+	}
+	for _, u := range conf.Systemd.Units {
+		add("systemd unit "+u.Name, u)
+	}
+	for _, u := range conf.Networkd.Units {
+		add("networkd unit "+u.Name, u)
+	}
+	for _, u := range conf.Passwd.Users {
+		add("user "+u.Name, u)
+	}
+	for _, g := range conf.Passwd.Groups {
+		add("group "+g.Name, g)
+	}
+
+	sort.SliceStable(contributors, func(i, j int) bool { return contributors[i].size > contributors[j].size })
+	return contributors
+}
+
+// validateRenderedConfigSize returns an error naming the largest entries of
+// the generated MachineConfig if its serialized Ignition config is larger than
+// the pool allows.
+func validateRenderedConfigSize(pool *mcfgv1.MachineConfigPool, generated *mcfgv1.MachineConfig) error {
+	if pool.Spec.MaxRenderedConfigBytes == nil {
+		return nil
+	}
+	data, err := json.Marshal(generated.Spec.Config)
+	if err != nil {
+		return err
+	}
+	max := *pool.Spec.MaxRenderedConfigBytes
+	if int64(len(data)) <= max {
+		return nil
+	}
+
+	contributors := configSizeContributors(generated.Spec.Config)
+	if len(contributors) > maxSizeContributors {
+		contributors = contributors[:maxSizeContributors]
+	}
+	var largest []string
+	for _, c := range contributors {
+		largest = append(largest, fmt.Sprintf("%s (%d bytes)", c.name, c.size))
+	}
+	return fmt.Errorf("rendered config is %d bytes, more than the %d bytes allowed by pool %s; largest contributors: %s", len(data), max, pool.Name, strings.Join(largest, ", "))
+}
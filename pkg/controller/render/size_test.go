@@ -0,0 +1,66 @@
+package render
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenerateMachineConfigSizeLimit(t *testing.T) {
+	configs := []*mcfgv1.MachineConfig{
+		newMachineConfig("00-test-cluster-master", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{
+			newFile("/etc/small", "small", 0644),
+			newFile("/etc/large", strings.Repeat("x", 4096), 0644),
+		}),
+		newMachineConfig("05-extra", map[string]string{"node-role": "master"}, "://dummy", []ignv2_2types.File{
+			newFile("/etc/medium", strings.Repeat("x", 1024), 0644),
+		}),
+	}
+	configs[1].Spec.Config.Systemd.Units = []ignv2_2types.Unit{{Name: "foo.service", Contents: strings.Repeat("x", 2048)}}
+	pool := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+
+	generated, err := generateMachineConfig(pool, configs, mcfgv1.MergeConflictAppend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(generated.Spec.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(data))
+
+	limit := func(max int64) *mcfgv1.MachineConfigPool {
+		p := pool.DeepCopy()
+		p.Spec.MaxRenderedConfigBytes = &max
+		return p
+	}
+	for _, max := range []int64{size, size + 1} {
+		if _, err := generateMachineConfig(limit(max), configs, mcfgv1.MergeConflictAppend); err != nil {
+			t.Errorf("expected a config of %d bytes to be rendered with a limit of %d bytes, got: %v", size, max, err)
+		}
+	}
+
+	_, err = generateMachineConfig(limit(size-1), configs, mcfgv1.MergeConflictAppend)
+	if err == nil {
+		t.Fatalf("expected a config of %d bytes to be rejected with a limit of %d bytes", size, size-1)
+	}
+	for _, part := range []string{"rendered config is", "allowed by pool test-cluster-master", "largest contributors: file /etc/large ("} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("expected the error to contain %q, got: %v", part, err)
+		}
+	}
+	// contributors are reported largest first.
+	order := []string{"file /etc/large", "systemd unit foo.service", "file /etc/medium", "file /etc/small"}
+	last := -1
+	for _, name := range order {
+		idx := strings.Index(err.Error(), name)
+		if idx < last {
+			t.Errorf("expected %s to be reported after the larger contributors, got: %v", name, err)
+		}
+		last = idx
+	}
+}
@@ -0,0 +1,184 @@
+package render
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// cryptFormat describes a crypt(3) password hash format.
+type cryptFormat struct {
+	name string
+	re   *regexp.Regexp
+	// weak is true for algorithms that are considered too weak to be used.
+	weak bool
+}
+
+// cryptFormats lists the password hash formats that are recognized.
+var cryptFormats = []cryptFormat{
+	{name: "sha512", re: regexp.MustCompile(`^\$6\$(rounds=[0-9]+\$)?[./0-9A-Za-z]{1,16}\$[./0-9A-Za-z]{86}$`)},
+	{name: "sha256", re: regexp.MustCompile(`^\$5\$(rounds=[0-9]+\$)?[./0-9A-Za-z]{1,16}\$[./0-9A-Za-z]{43}$`)},
+	{name: "bcrypt", re: regexp.MustCompile(`^\$2[abxy]?\$[0-9]{2}\$[./0-9A-Za-z]{53}$`)},
+	{name: "yescrypt", re: regexp.MustCompile(`^\$y\$[./0-9A-Za-z]+\$[./0-9A-Za-z]*\$[./0-9A-Za-z]{43}$`)},
+	{name: "md5", re: regexp.MustCompile(`^\$1\$[./0-9A-Za-z]{0,8}\$[./0-9A-Za-z]{22}$`), weak: true},
+	{name: "des", re: regexp.MustCompile(`^[./0-9A-Za-z]{13}$`), weak: true},
+}
+
+// validatePasswordHash checks that the hash is in a recognized crypt format.
+// Hashes using weak algorithms are rejected if rejectWeak is set.
+// A hash may be prefixed with "!" to mark the account as locked.
+func validatePasswordHash(hash string, rejectWeak bool) error {
+	hash = strings.TrimPrefix(hash, "!")
+	if hash == "" || hash == "*" {
+		// no password, login with a password is disabled.
+		return nil
+	}
+	for _, f := range cryptFormats {
+		if !f.re.MatchString(hash) {
+			continue
+		}
+		if f.weak && rejectWeak {
+			return fmt.Errorf("password hash uses weak algorithm %s", f.name)
+		}
+		return nil
+	}
+	return fmt.Errorf("password hash is not in a recognized crypt format")
+}
+
+// maxFileMode is the largest file mode allowed in a MachineConfig, setuid,
+// setgid and sticky bits are not supported.
+const maxFileMode = 0777
+
+// normalizeFileMode returns the file mode intended by mode. Ignition modes are
+// decimal, so a mode that is out of range but whose decimal digits are a valid
+// octal mode, such as 644 for 0644, is taken to be that octal mode. Modes whose
+// value gives the owner fewer permissions than the group or others while their
+// decimal digits are a valid octal mode, such as 444 for 0674, are ambiguous
+// and rejected.
+func normalizeFileMode(mode int) (int, error) {
+	if mode < 0 {
+		return 0, fmt.Errorf("mode %d is negative", mode)
+	}
+	octal, err := strconv.ParseInt(strconv.Itoa(mode), 8, 64)
+	looksOctal := err == nil && int(octal) != mode
+	if mode > maxFileMode {
+		if looksOctal && octal <= maxFileMode {
+			return int(octal), nil
+		}
+		if looksOctal {
+			return 0, fmt.Errorf("mode %d (%#o) is outside 0-0777, setuid, setgid and sticky bits are not supported", mode, octal)
+		}
+		return 0, fmt.Errorf("mode %d is outside 0-0777, modes are decimal, e.g. 420 for 0644", mode)
+	}
+	owner, group, other := mode>>6&7, mode>>3&7, mode&7
+	if looksOctal && (owner < group || owner < other) {
+		return 0, fmt.Errorf("mode %d (%#o) is ambiguous, use %d for %#o or write the mode in decimal", mode, mode, octal, octal)
+	}
+	return mode, nil
+}
+
+// normalizeFileModes replaces the file and directory modes of the config with
+// the modes they are intended to be. It returns the number of modes replaced.
+// Modes that can't be normalized are left as is.
+func normalizeFileModes(conf *ignv2_2types.Config) int {
+	replaced := 0
+	normalize := func(mode *int) *int {
+		if mode == nil {
+			return nil
+		}
+		n, err := normalizeFileMode(*mode)
+		if err != nil || n == *mode {
+			return mode
+		}
+		replaced++
+		return &n
+	}
+	for i := range conf.Storage.Files {
+		conf.Storage.Files[i].Mode = normalize(conf.Storage.Files[i].Mode)
+	}
+	for i := range conf.Storage.Directories {
+		conf.Storage.Directories[i].Mode = normalize(conf.Storage.Directories[i].Mode)
+	}
+	return replaced
+}
+
+// findUIDCollisions returns an error naming the first two users with different
+// names that the configs create with the same uid.
+func findUIDCollisions(configs []*mcfgv1.MachineConfig) error {
+	// defined maps uids to the first config and user created with them.
+	type definition struct {
+		config string
+		user   string
+	}
+	defined := map[int]definition{}
+	for _, mc := range configs {
+		for _, u := range mc.Spec.Config.Passwd.Users {
+			if u.UID == nil {
+				continue
+			}
+			d, ok := defined[*u.UID]
+			if !ok {
+				defined[*u.UID] = definition{config: mc.Name, user: u.Name}
+				continue
+			}
+			if d.user == u.Name {
+				continue
+			}
+			if d.config == mc.Name {
+				return fmt.Errorf("users %s and %s of MachineConfig %s have the same uid %d", d.user, u.Name, mc.Name, *u.UID)
+			}
+			return fmt.Errorf("users %s and %s of MachineConfigs %s and %s have the same uid %d", d.user, u.Name, d.config, mc.Name, *u.UID)
+		}
+	}
+	return nil
+}
+
+// validateMachineConfig validates the parts of the MachineConfig that Ignition
+// doesn't validate itself.
+func validateMachineConfig(config *mcfgv1.MachineConfig, rejectWeakPasswordHashes bool) error {
+	for _, u := range config.Spec.Config.Passwd.Users {
+		if u.PasswordHash == nil {
+			continue
+		}
+		if err := validatePasswordHash(*u.PasswordHash, rejectWeakPasswordHashes); err != nil {
+			return fmt.Errorf("invalid passwordHash for user %q: %v", u.Name, err)
+		}
+	}
+	for _, g := range config.Spec.Config.Passwd.Groups {
+		if err := validatePasswordHash(g.PasswordHash, rejectWeakPasswordHashes); err != nil {
+			return fmt.Errorf("invalid passwordHash for group %q: %v", g.Name, err)
+		}
+	}
+	for _, f := range config.Spec.Config.Storage.Files {
+		if f.Mode == nil {
+			continue
+		}
+		if _, err := normalizeFileMode(*f.Mode); err != nil {
+			return fmt.Errorf("invalid mode for file %q: %v", f.Path, err)
+		}
+	}
+	for _, d := range config.Spec.Config.Storage.Directories {
+		if d.Mode == nil {
+			continue
+		}
+		if _, err := normalizeFileMode(*d.Mode); err != nil {
+			return fmt.Errorf("invalid mode for directory %q: %v", d.Path, err)
+		}
+	}
+	for _, hc := range config.Spec.HealthChecks {
+		if hc.Name == "" {
+			return fmt.Errorf("health check with command %q has no name", hc.Command)
+		}
+		if len(hc.Command) == 0 || hc.Command[0] == "" {
+			return fmt.Errorf("health check %q has no command", hc.Name)
+		}
+		if hc.Retries < 0 || hc.RetryIntervalSeconds < 0 || hc.TimeoutSeconds < 0 {
+			return fmt.Errorf("health check %q has negative retries, retryIntervalSeconds or timeoutSeconds", hc.Name)
+		}
+	}
+	return nil
+}
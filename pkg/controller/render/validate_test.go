@@ -0,0 +1,203 @@
+package render
+
+import (
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePasswordHash(t *testing.T) {
+	tests := []struct {
+		name       string
+		hash       string
+		rejectWeak bool
+		valid      bool
+	}{
+		{name: "sha512", hash: "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/", valid: true},
+		{name: "sha256", hash: "$5$saltsalt$gOjOtoMpVhru2uyjeJSEc/JaLQWOXMNmlOnj6T4AtC.", valid: true},
+		{name: "bcrypt", hash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", valid: true},
+		{name: "locked sha512", hash: "!$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/", valid: true},
+		{name: "disabled", hash: "*", valid: true},
+		{name: "md5", hash: "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", valid: true},
+		{name: "md5 rejected as weak", hash: "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/", rejectWeak: true, valid: false},
+		{name: "sha512 not rejected as weak", hash: "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/", rejectWeak: true, valid: true},
+		{name: "truncated sha512", hash: "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2", valid: false},
+		{name: "plain text", hash: "password", valid: false},
+		{name: "unknown algorithm", hash: "$9$saltsalt$qjXMvbEw8oaL.CzflDtaK/", valid: false},
+	}
+
+	for _, test := range tests {
+		err := validatePasswordHash(test.hash, test.rejectWeak)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected hash to be valid, got: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected hash to be invalid", test.name)
+		}
+	}
+}
+
+func TestValidateMachineConfigPasswordHash(t *testing.T) {
+	malformed := "$6$not-a-hash"
+	mc := &mcfgv1.MachineConfig{
+		Spec: mcfgv1.MachineConfigSpec{
+			Config: ignv2_2types.Config{
+				Passwd: ignv2_2types.Passwd{
+					Users: []ignv2_2types.PasswdUser{{Name: "core", PasswordHash: &malformed}},
+				},
+			},
+		},
+	}
+
+	err := validateMachineConfig(mc, false)
+	if err == nil {
+		t.Fatal("expected error for malformed passwordHash")
+	}
+	if exp := `invalid passwordHash for user "core": password hash is not in a recognized crypt format`; err.Error() != exp {
+		t.Errorf("expected error %q, got %q", exp, err.Error())
+	}
+}
+
+func TestNormalizeFileMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     int
+		expected int
+		valid    bool
+	}{
+		{name: "octal 0644", mode: 0644, expected: 0644, valid: true},
+		{name: "octal 0755", mode: 0755, expected: 0755, valid: true},
+		{name: "octal 0400", mode: 0400, expected: 0400, valid: true},
+		{name: "octal 0777", mode: 0777, expected: 0777, valid: true},
+		{name: "zero", mode: 0, expected: 0, valid: true},
+		{name: "octal digits 644", mode: 644, expected: 0644, valid: true},
+		{name: "octal digits 755", mode: 755, expected: 0755, valid: true},
+		{name: "octal digits 600", mode: 600, expected: 0600, valid: true},
+		{name: "out of range", mode: 1000, valid: false},
+		{name: "out of range not octal", mode: 999, valid: false},
+		{name: "sticky bit", mode: 1777, valid: false},
+		{name: "setuid", mode: 04755, valid: false},
+		{name: "negative", mode: -1, valid: false},
+		{name: "ambiguous 444", mode: 444, valid: false},
+		{name: "ambiguous 10", mode: 10, valid: false},
+	}
+
+	for _, test := range tests {
+		mode, err := normalizeFileMode(test.mode)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected mode to be valid, got: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected mode to be invalid, got: %#o", test.name, mode)
+		}
+		if test.valid && mode != test.expected {
+			t.Errorf("%s: expected mode %#o, got: %#o", test.name, test.expected, mode)
+		}
+	}
+}
+
+func TestValidateMachineConfigFileMode(t *testing.T) {
+	mode := 999
+	mc := &mcfgv1.MachineConfig{
+		Spec: mcfgv1.MachineConfigSpec{
+			Config: ignv2_2types.Config{
+				Storage: ignv2_2types.Storage{
+					Files: []ignv2_2types.File{{
+						Node:          ignv2_2types.Node{Path: "/etc/foo"},
+						FileEmbedded1: ignv2_2types.FileEmbedded1{Mode: &mode},
+					}},
+				},
+			},
+		},
+	}
+
+	err := validateMachineConfig(mc, false)
+	if err == nil {
+		t.Fatal("expected error for out of range mode")
+	}
+	if exp := `invalid mode for file "/etc/foo": mode 999 is outside 0-0777, modes are decimal, e.g. 420 for 0644`; err.Error() != exp {
+		t.Errorf("expected error %q, got %q", exp, err.Error())
+	}
+}
+
+func TestNormalizeFileModes(t *testing.T) {
+	octalDigits, correct := 644, 0600
+	conf := ignv2_2types.Config{
+		Storage: ignv2_2types.Storage{
+			Files: []ignv2_2types.File{
+				{Node: ignv2_2types.Node{Path: "/etc/foo"}, FileEmbedded1: ignv2_2types.FileEmbedded1{Mode: &octalDigits}},
+				{Node: ignv2_2types.Node{Path: "/etc/bar"}, FileEmbedded1: ignv2_2types.FileEmbedded1{Mode: &correct}},
+				{Node: ignv2_2types.Node{Path: "/etc/baz"}},
+			},
+			Directories: []ignv2_2types.Directory{
+				{Node: ignv2_2types.Node{Path: "/etc/dir"}, DirectoryEmbedded1: ignv2_2types.DirectoryEmbedded1{Mode: &octalDigits}},
+			},
+		},
+	}
+
+	if n := normalizeFileModes(&conf); n != 2 {
+		t.Errorf("expected 2 modes to be normalized, got: %d", n)
+	}
+	if got := *conf.Storage.Files[0].Mode; got != 0644 {
+		t.Errorf("expected mode 0644, got: %#o", got)
+	}
+	if got := *conf.Storage.Files[1].Mode; got != 0600 {
+		t.Errorf("expected mode 0600, got: %#o", got)
+	}
+	if conf.Storage.Files[2].Mode != nil {
+		t.Errorf("expected no mode, got: %#o", *conf.Storage.Files[2].Mode)
+	}
+	if got := *conf.Storage.Directories[0].Mode; got != 0644 {
+		t.Errorf("expected mode 0644, got: %#o", got)
+	}
+	// the modes of the source configs are not modified
+	if octalDigits != 644 {
+		t.Errorf("expected source mode to be left alone, got: %d", octalDigits)
+	}
+}
+
+func TestGenerateMachineConfigUIDCollisions(t *testing.T) {
+	newConfig := func(name string, users ...ignv2_2types.PasswdUser) *mcfgv1.MachineConfig {
+		mc := newMachineConfig(name, map[string]string{"node-role": "master"}, "://dummy", nil)
+		mc.Spec.Config.Passwd.Users = users
+		return mc
+	}
+	newUser := func(name string, uid int) ignv2_2types.PasswdUser {
+		return ignv2_2types.PasswdUser{Name: name, UID: &uid}
+	}
+	pool := newMachineConfigPool("test-cluster-master", metav1.AddLabelToSelector(&metav1.LabelSelector{}, "node-role", "master"), "")
+
+	tests := []struct {
+		name    string
+		configs []*mcfgv1.MachineConfig
+		err     string
+	}{{
+		name:    "distinct uids",
+		configs: []*mcfgv1.MachineConfig{newConfig("00-a", newUser("core", 1000), ignv2_2types.PasswdUser{Name: "admin"}), newConfig("05-b", newUser("backup", 1001), ignv2_2types.PasswdUser{Name: "monitor"})},
+	}, {
+		name:    "the same user in several configs",
+		configs: []*mcfgv1.MachineConfig{newConfig("00-a", newUser("core", 1000)), newConfig("05-b", newUser("core", 1000))},
+	}, {
+		name:    "users of different configs collide",
+		configs: []*mcfgv1.MachineConfig{newConfig("05-b", newUser("admin", 1000)), newConfig("00-a", newUser("core", 1000))},
+		err:     "users core and admin of MachineConfigs 00-a and 05-b have the same uid 1000",
+	}, {
+		name:    "users of a config collide",
+		configs: []*mcfgv1.MachineConfig{newConfig("00-a", newUser("core", 1000), newUser("admin", 1000))},
+		err:     "users core and admin of MachineConfig 00-a have the same uid 1000",
+	}}
+	for _, test := range tests {
+		_, err := generateMachineConfig(pool, test.configs, mcfgv1.MergeConflictAppend)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+		}
+	}
+}
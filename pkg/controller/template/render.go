@@ -122,8 +122,9 @@ func generateMachineConfigForName(config *renderConfig, role, name, path string)
 
 	// walk all role dirs, with later ones taking precedence
 	for _, platformDir := range platformDirs {
-		// magic param
+		// magic params
 		var walkDest *map[string]string
+		var validate func(path string, data []byte) error
 
 		walkFn := func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -149,11 +150,15 @@ func generateMachineConfigForName(config *renderConfig, role, name, path string)
 			if err != nil {
 				return err
 			}
+			if err := validate(path, renderedData); err != nil {
+				return err
+			}
 			(*walkDest)[info.Name()] = string(renderedData)
 			return nil
 		}
 
 		walkDest = &files
+		validate = validateFileTemplate
 		p := filepath.Join(platformDir, filesDir)
 		exists, err := existsDir(p)
 		if err != nil {
@@ -166,6 +171,7 @@ func generateMachineConfigForName(config *renderConfig, role, name, path string)
 		}
 
 		walkDest = &units
+		validate = validateUnitTemplate
 		p = filepath.Join(platformDir, unitsDir)
 		exists, err = existsDir(p)
 		if err != nil {
@@ -256,7 +262,9 @@ func transpileToIgn(files, units []string) (*ignv2_2types.Config, error) {
 }
 
 // renderTemplate renders a template file with values from a renderConfig
-// returns the rendered file data
+// returns the rendered file data. Referencing a missing key or rendering an
+// undefined value is an error, so a template is either fully expanded or not
+// rendered at all.
 func renderTemplate(config renderConfig, path string, b []byte) ([]byte, error) {
 
 	funcs := sprig.TxtFuncMap()
@@ -265,7 +273,7 @@ func renderTemplate(config renderConfig, path string, b []byte) ([]byte, error)
 	funcs["etcdPeerCertDNSNames"] = etcdPeerCertDNSNames
 	funcs["apiServerURL"] = apiServerURL
 	funcs["cloudProvider"] = cloudProvider
-	tmpl, err := template.New(path).Funcs(funcs).Parse(string(b))
+	tmpl, err := template.New(path).Option("missingkey=error").Funcs(funcs).Parse(string(b))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template %s: %v", path, err)
 	}
@@ -274,10 +282,42 @@ func renderTemplate(config renderConfig, path string, b []byte) ([]byte, error)
 	if err := tmpl.Execute(buf, config); err != nil {
 		return nil, fmt.Errorf("failed to execute template: %v", err)
 	}
+	for i, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, noValue) {
+			return nil, fmt.Errorf("failed to execute template %s: line %d of the output has an undefined value", path, i+1)
+		}
+	}
 
 	return buf.Bytes(), nil
 }
 
+// noValue is what text/template renders for undefined values.
+const noValue = "<no value>"
+
+// validateFileTemplate checks that the rendered template is a valid file.
+func validateFileTemplate(path string, data []byte) error {
+	f := new(cttypes.File)
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return fmt.Errorf("rendered template %s is not a valid file: %v", path, err)
+	}
+	if f.Path == "" {
+		return fmt.Errorf("rendered template %s is not a valid file: path is empty", path)
+	}
+	return nil
+}
+
+// validateUnitTemplate checks that the rendered template is a valid systemd unit.
+func validateUnitTemplate(path string, data []byte) error {
+	u := new(cttypes.SystemdUnit)
+	if err := yaml.Unmarshal(data, u); err != nil {
+		return fmt.Errorf("rendered template %s is not a valid systemd unit: %v", path, err)
+	}
+	if u.Name == "" {
+		return fmt.Errorf("rendered template %s is not a valid systemd unit: name is empty", path)
+	}
+	return nil
+}
+
 var skipKeyValidate = regexp.MustCompile(`^[_a-z]\w*$`)
 
 // Keys labled with skip ie. {{skip "key"}}, don't need to be templated in now because at Ignition request they will be templated in with query params
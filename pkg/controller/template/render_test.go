@@ -241,6 +241,122 @@ func TestSkipMissing(t *testing.T) {
 	}
 }
 
+func TestRenderTemplateUndefined(t *testing.T) {
+	config := renderConfig{&mcfgv1.ControllerConfigSpec{ClusterName: "my-test-cluster"}, ""}
+
+	got, err := renderTemplate(config, "valid.yaml", []byte("name: {{.ClusterName}}\n"))
+	if err != nil {
+		t.Fatalf("expected nil error, got: %v", err)
+	}
+	if string(got) != "name: my-test-cluster\n" {
+		t.Fatalf("mismatch got: %s want: name: my-test-cluster", got)
+	}
+
+	cases := []struct {
+		tmpl string
+		loc  string
+		err  string
+	}{{
+		// undefined variable
+		tmpl: "name: foo\ncontents: {{$undefined}}\n",
+		loc:  "undefined.yaml:2:",
+		err:  `undefined variable "$undefined"`,
+	}, {
+		// undefined field
+		tmpl: "name: {{.Undefined}}\n",
+		loc:  "undefined.yaml:1:",
+		err:  "can't evaluate field Undefined",
+	}, {
+		// missing map key
+		tmpl: "name: foo\ncontents: {{(dict \"a\" \"b\").c}}\n",
+		loc:  "undefined.yaml:2:",
+		err:  `map has no entry for key "c"`,
+	}, {
+		// undefined value
+		tmpl: "name: foo\ncontents: {{first list}}\n",
+		loc:  "undefined.yaml",
+		err:  "line 2 of the output has an undefined value",
+	}}
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("case #%d", idx), func(t *testing.T) {
+			got, err := renderTemplate(config, "undefined.yaml", []byte(c.tmpl))
+			if err == nil {
+				t.Fatalf("expected an error, got: %s", got)
+			}
+			if got != nil {
+				t.Fatalf("expected no output, got: %s", got)
+			}
+			if !strings.Contains(err.Error(), c.loc) || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("expected error at %s to contain %q, got: %v", c.loc, c.err, err)
+			}
+		})
+	}
+}
+
+func TestGenerateMachineConfigsInvalidTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTemplate := func(kind, name, data string) string {
+		t.Helper()
+		for _, platform := range []string{"_base", "aws"} {
+			if err := os.MkdirAll(filepath.Join(dir, "worker", "00-worker", platform, kind), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		path := filepath.Join(dir, "worker", "00-worker", "_base", kind, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	config := &renderConfig{&mcfgv1.ControllerConfigSpec{Platform: "aws", ClusterName: "my-test-cluster"}, ""}
+
+	writeTemplate(filesDir, "cluster.yaml", "filesystem: root\npath: /etc/cluster\ncontents:\n  inline: {{.ClusterName}}\n")
+	writeTemplate(unitsDir, "cluster.service.yaml", "name: cluster.service\nenabled: true\n")
+	cfgs, err := generateMachineConfigs(config, dir)
+	if err != nil {
+		t.Fatalf("expected nil error, got: %v", err)
+	}
+	if len(cfgs) != 1 || len(cfgs[0].Spec.Config.Storage.Files) != 1 || len(cfgs[0].Spec.Config.Systemd.Units) != 1 {
+		t.Fatalf("expected one config with a file and a unit, got: %v", cfgs)
+	}
+
+	cases := []struct {
+		kind string
+		tmpl string
+		err  string
+	}{{
+		kind: filesDir,
+		tmpl: "filesystem: root\npath: /etc/cluster\ncontents:\n  inline: {{.Undefined}}\n",
+		err:  "can't evaluate field Undefined",
+	}, {
+		kind: filesDir,
+		tmpl: "contents:\n  inline: {{.ClusterName}}\n",
+		err:  "is not a valid file: path is empty",
+	}, {
+		kind: unitsDir,
+		tmpl: "name: [cluster.service\n",
+		err:  "is not a valid systemd unit",
+	}}
+	for idx, c := range cases {
+		t.Run(fmt.Sprintf("case #%d", idx), func(t *testing.T) {
+			path := writeTemplate(c.kind, "broken.yaml", c.tmpl)
+			defer os.Remove(path)
+			cfgs, err := generateMachineConfigs(config, dir)
+			if err == nil {
+				t.Fatalf("expected an error, got configs: %v", cfgs)
+			}
+			if !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("expected error for %s to contain %q, got: %v", path, c.err, err)
+			}
+		})
+	}
+}
+
 const (
 	templateDir = "../../../templates"
 	resultDir   = "./test_data/templates"
@@ -0,0 +1,176 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/vincent-petithory/dataurl"
+)
+
+// RuntimeConfigDiffAnnotationKey is set on the generated MachineConfigs to the
+// diff of their kubelet and crio configs against the previous generation; it
+// is kept until they change again.
+const RuntimeConfigDiffAnnotationKey = "machineconfiguration.openshift.io/runtimeConfigDiff"
+
+// runtimeUnits are the units of the container runtime and kubelet.
+var runtimeUnits = map[string]bool{
+	"crio.service":    true,
+	"kubelet.service": true,
+}
+
+// isRuntimeFile returns true if the file at path configures the container
+// runtime or kubelet.
+func isRuntimeFile(path string) bool {
+	return path == "/etc/kubernetes/kubelet.conf" ||
+		strings.HasPrefix(path, "/etc/crio/") ||
+		strings.HasPrefix(path, "/etc/sysconfig/crio") ||
+		strings.HasPrefix(path, "/etc/containers/")
+}
+
+// runtimeConfig returns the contents of the kubelet and crio files, units and
+// dropins of the config by name. The contents of the entries appending to a
+// file are appended to it.
+func runtimeConfig(conf ignv2_2types.Config) map[string]string {
+	entries := map[string]string{}
+	for _, f := range conf.Storage.Files {
+		if !isRuntimeFile(f.Path) {
+			continue
+		}
+		contents := f.Contents.Source
+		if data, err := dataurl.DecodeString(f.Contents.Source); err == nil {
+			contents = string(data.Data)
+		}
+		name := "file " + f.Path
+		if f.Append {
+			contents = entries[name] + contents
+		}
+		entries[name] = contents
+	}
+	for _, u := range conf.Systemd.Units {
+		if !runtimeUnits[u.Name] {
+			continue
+		}
+		entries["unit "+u.Name] = u.Contents
+		for _, d := range u.Dropins {
+			entries["unit "+u.Name+"/"+d.Name] = d.Contents
+		}
+	}
+	return entries
+}
+
+// runtimeConfigChange is the diff of a kubelet or crio file, unit or dropin.
+type runtimeConfigChange struct {
+	name string
+	diff []string
+}
+
+// diffRuntimeConfigs returns the changes of the kubelet and crio configs from
+// the previous to the current generated MachineConfig, sorted by name.
+func diffRuntimeConfigs(previous, current *mcfgv1.MachineConfig) []runtimeConfigChange {
+	from, to := runtimeConfig(previous.Spec.Config), runtimeConfig(current.Spec.Config)
+	var names []string
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []runtimeConfigChange
+	for _, name := range names {
+		if from[name] == to[name] {
+			continue
+		}
+		changes = append(changes, runtimeConfigChange{name: name, diff: diffLines(from[name], to[name])})
+	}
+	return changes
+}
+
+// diffLines returns the lines removed from a, prefixed with "-", and added
+// to b, prefixed with "+", in order, leaving out the lines they share.
+func diffLines(a, b string) []string {
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	}
+	x, y := split(a), split(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+x[i])
+			i++
+		default:
+			diff = append(diff, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, "+"+y[j])
+	}
+	return diff
+}
+
+// formatRuntimeConfigChanges returns the changes as a diff of every changed
+// file, unit and dropin under a "--- <name>" header.
+func formatRuntimeConfigChanges(changes []runtimeConfigChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&b, "--- %s\n", c.name)
+		for _, line := range c.diff {
+			fmt.Fprintln(&b, line)
+		}
+	}
+	return b.String()
+}
+
+// summarizeRuntimeConfigChanges returns the names of the changed entries with
+// the number of lines they add and remove.
+func summarizeRuntimeConfigChanges(changes []runtimeConfigChange) string {
+	var summary []string
+	for _, c := range changes {
+		added, removed := 0, 0
+		for _, line := range c.diff {
+			if strings.HasPrefix(line, "+") {
+				added++
+			} else {
+				removed++
+			}
+		}
+		summary = append(summary, fmt.Sprintf("%s (+%d -%d)", c.name, added, removed))
+	}
+	return strings.Join(summary, ", ")
+}
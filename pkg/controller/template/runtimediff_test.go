@@ -0,0 +1,136 @@
+package template
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		diff []string
+	}{
+		{a: "", b: "", diff: nil},
+		{a: "a\nb\nc\n", b: "a\nb\nc\n", diff: nil},
+		{a: "a\nb\nc\n", b: "a\nB\nc\nd\n", diff: []string{"-b", "+B", "+d"}},
+		{a: "", b: "a\n", diff: []string{"+a"}},
+		{a: "a\nb\n", b: "", diff: []string{"-a", "-b"}},
+	}
+	for _, test := range tests {
+		if diff := diffLines(test.a, test.b); !reflect.DeepEqual(diff, test.diff) {
+			t.Errorf("diff of %q and %q: expected %v, got %v", test.a, test.b, test.diff, diff)
+		}
+	}
+}
+
+// setKubeletConf replaces maxPods in the kubelet config of the MachineConfig.
+func setKubeletConf(t *testing.T, mc *mcfgv1.MachineConfig, from, to string) {
+	for i, f := range mc.Spec.Config.Storage.Files {
+		if f.Path != "/etc/kubernetes/kubelet.conf" {
+			continue
+		}
+		if !strings.Contains(f.Contents.Source, from) {
+			t.Fatalf("expected the kubelet config to contain %q", from)
+		}
+		mc.Spec.Config.Storage.Files[i].Contents.Source = strings.Replace(f.Contents.Source, from, to, 1)
+		return
+	}
+	t.Fatal("expected a kubelet config")
+}
+
+func TestDiffRuntimeConfigs(t *testing.T) {
+	mcs, err := getMachineConfigsForControllerConfig(templateDir, newControllerConfig("test-cluster"), []byte(`{"dummy": "dummy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := mcs[0]
+	previous := current.DeepCopy()
+	setKubeletConf(t, previous, "maxPods%3A%20250", "maxPods%3A%20500")
+	// files outside of the kubelet and crio configs are left out.
+	previous.Spec.Config.Storage.Files = append(previous.Spec.Config.Storage.Files, ignv2_2types.File{
+		Node:          ignv2_2types.Node{Path: "/etc/motd"},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,hello"}},
+	})
+	for i, u := range previous.Spec.Config.Systemd.Units {
+		if u.Name == "kubelet.service" {
+			previous.Spec.Config.Systemd.Units[i].Contents = strings.Replace(u.Contents, "--container-runtime=remote", "--container-runtime=docker", 1)
+		}
+	}
+
+	changes := diffRuntimeConfigs(previous, current)
+	if len(changes) != 2 {
+		t.Fatalf("expected the kubelet config and unit to change, got %v", changes)
+	}
+	if exp := (runtimeConfigChange{name: "file /etc/kubernetes/kubelet.conf", diff: []string{"-maxPods: 500", "+maxPods: 250"}}); !reflect.DeepEqual(changes[0], exp) {
+		t.Errorf("expected change %v, got %v", exp, changes[0])
+	}
+	if unit := changes[1]; unit.name != "unit kubelet.service" || len(unit.diff) != 2 ||
+		!strings.HasSuffix(unit.diff[0], "--container-runtime=docker \\") || !strings.HasSuffix(unit.diff[1], "--container-runtime=remote \\") {
+		t.Errorf("expected the container runtime flag of the kubelet unit to change, got %v", unit)
+	}
+	if summary := summarizeRuntimeConfigChanges(changes); summary != "file /etc/kubernetes/kubelet.conf (+1 -1), unit kubelet.service (+1 -1)" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+	if changes := diffRuntimeConfigs(current, current); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+// TestRuntimeConfigChangesRecorded simulates an upgrade that changes the
+// generated kubelet config and verifies the diff is recorded.
+func TestRuntimeConfigChangesRecorded(t *testing.T) {
+	f := newFixture(t)
+	cc := newControllerConfig("test-cluster")
+	ps := newPullSecret("coreos-pull-secret", []byte(`{"dummy": "dummy"}`))
+	mcs, err := getMachineConfigsForControllerConfig(templateDir, cc, []byte(`{"dummy": "dummy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the configs generated by the previous version.
+	for _, mc := range mcs {
+		setKubeletConf(t, mc, "maxPods%3A%20250", "maxPods%3A%20500")
+	}
+
+	f.ccLister = append(f.ccLister, cc)
+	f.kubeobjects = append(f.kubeobjects, ps)
+	f.objects = append(f.objects, cc)
+	for idx := range mcs {
+		f.mcLister = append(f.mcLister, mcs[idx])
+		f.objects = append(f.objects, mcs[idx])
+	}
+
+	c, i := f.newController()
+	recorder := record.NewFakeRecorder(10)
+	c.eventRecorder = recorder
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	if err := c.syncHandler(getKey(cc, t)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mc := range mcs {
+		updated, err := f.client.MachineconfigurationV1().MachineConfigs().Get(mc.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := "--- file /etc/kubernetes/kubelet.conf\n-maxPods: 500\n+maxPods: 250\n"; updated.Annotations[RuntimeConfigDiffAnnotationKey] != exp {
+			t.Errorf("%s: expected the diff %q to be recorded, got %q", mc.Name, exp, updated.Annotations[RuntimeConfigDiffAnnotationKey])
+		}
+	}
+	if len(recorder.Events) != len(mcs) {
+		t.Fatalf("expected %d events, got %d", len(mcs), len(recorder.Events))
+	}
+	for range mcs {
+		event := <-recorder.Events
+		if !strings.Contains(event, "RuntimeConfigChanged") || !strings.Contains(event, "file /etc/kubernetes/kubelet.conf (+1 -1)") {
+			t.Errorf("unexpected event %q", event)
+		}
+	}
+}
@@ -354,6 +354,7 @@ func (ctrl *Controller) syncControllerConfig(key string) error {
 	}
 
 	for idx := range mcs {
+		ctrl.recordRuntimeConfigChanges(cfg, mcs[idx])
 		_, updated, err := resourceapply.ApplyMachineConfig(ctrl.client.MachineconfigurationV1(), mcs[idx])
 		if err != nil {
 			return err
@@ -366,6 +367,26 @@ func (ctrl *Controller) syncControllerConfig(key string) error {
 	return nil
 }
 
+// recordRuntimeConfigChanges records the diff of the kubelet and crio configs
+// of the generated MachineConfig against its previous generation, if they
+// changed, on the MachineConfig and in an event on the ControllerConfig.
+func (ctrl *Controller) recordRuntimeConfigChanges(cfg *mcfgv1.ControllerConfig, mc *mcfgv1.MachineConfig) {
+	previous, err := ctrl.mcLister.Get(mc.Name)
+	if err != nil {
+		// there is nothing to diff against for new MachineConfigs.
+		return
+	}
+	changes := diffRuntimeConfigs(previous, mc)
+	if len(changes) == 0 {
+		return
+	}
+	if mc.Annotations == nil {
+		mc.Annotations = map[string]string{}
+	}
+	mc.Annotations[RuntimeConfigDiffAnnotationKey] = formatRuntimeConfigChanges(changes)
+	ctrl.eventRecorder.Eventf(cfg, v1.EventTypeNormal, "RuntimeConfigChanged", "Generated MachineConfig %s changes %s; see its %s annotation for the diff", mc.Name, summarizeRuntimeConfigChanges(changes), RuntimeConfigDiffAnnotationKey)
+}
+
 func getMachineConfigsForControllerConfig(templatesDir string, config *mcfgv1.ControllerConfig, pullSecretRaw []byte) ([]*mcfgv1.MachineConfig, error) {
 	buf := &bytes.Buffer{}
 	if err := json.Compact(buf, pullSecretRaw); err != nil {
@@ -330,11 +330,16 @@ func TestUpdateMachineConfig(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	// the diff of the kubelet and crio configs against the stale config is recorded.
+	updated := expmcs[len(expmcs)-1]
+	updated.Annotations = map[string]string{
+		RuntimeConfigDiffAnnotationKey: formatRuntimeConfigChanges(diffRuntimeConfigs(mcs[len(mcs)-1], updated)),
+	}
 	f.expectGetSecretAction(ps)
 	for idx := range expmcs {
 		f.expectGetMachineConfigAction(expmcs[idx])
 	}
-	f.expectUpdateMachineConfigAction(expmcs[len(expmcs)-1])
+	f.expectUpdateMachineConfigAction(updated)
 
 	f.run(getKey(cc, t))
 }
@@ -0,0 +1,348 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultApplyLogSpoolPath is where apply logs are buffered on the node
+	// while the sink is unavailable.
+	DefaultApplyLogSpoolPath = "/var/lib/machine-config-daemon/apply-log.json"
+
+	// ApplyLogResultStarted is logged when a phase of an update starts.
+	ApplyLogResultStarted = "Started"
+	// ApplyLogResultSucceeded is logged when a phase of an update succeeded.
+	ApplyLogResultSucceeded = "Succeeded"
+	// ApplyLogResultFailed is logged when a phase of an update failed.
+	ApplyLogResultFailed = "Failed"
+
+	// ApplyLogPhaseUpdate is the update as a whole.
+	ApplyLogPhaseUpdate = "Update"
+	// ApplyLogPhaseReconcile checks the new config can be applied in place.
+	ApplyLogPhaseReconcile = "Reconcile"
+	// ApplyLogPhasePodDisruption records whether the update disrupts the running pods.
+	ApplyLogPhasePodDisruption = "RecordPodDisruption"
+	// ApplyLogPhaseFiles writes the files and units of the new config.
+	ApplyLogPhaseFiles = "UpdateFiles"
+	// ApplyLogPhaseUdevRules reloads changed udev rules in place.
+	ApplyLogPhaseUdevRules = "ReloadUdevRules"
+	// ApplyLogPhaseCATrust extracts changed CA trust anchors in place.
+	ApplyLogPhaseCATrust = "UpdateCATrust"
+	// ApplyLogPhaseSysusersTmpfiles applies changed sysusers.d and tmpfiles.d configs in place.
+	ApplyLogPhaseSysusersTmpfiles = "ApplySysusersTmpfiles"
+	// ApplyLogPhaseUnits restarts changed units in place.
+	ApplyLogPhaseUnits = "RestartUnits"
+	// ApplyLogPhaseEnvironmentFiles restarts the units whose environment files changed in place.
+	ApplyLogPhaseEnvironmentFiles = "RestartEnvironmentFileUnits"
+	// ApplyLogPhaseOS updates the OS image.
+	ApplyLogPhaseOS = "UpdateOS"
+	// ApplyLogPhaseTuningProfile stages the tuning profile for the reboot.
+	ApplyLogPhaseTuningProfile = "ApplyTuningProfile"
+	// ApplyLogPhaseRebootApproval waits for the reboot into the staged update to be approved.
+	ApplyLogPhaseRebootApproval = "WaitRebootApproval"
+	// ApplyLogPhaseDrain drains the node.
+	ApplyLogPhaseDrain = "Drain"
+	// ApplyLogPhaseBootCheckpoint records the reboot into the new config, to be confirmed after it.
+	ApplyLogPhaseBootCheckpoint = "StageBootCheckpoint"
+	// ApplyLogPhaseReboot reboots into the new config.
+	ApplyLogPhaseReboot = "Reboot"
+
+	// defaultApplyLogBufferSize is the number of entries buffered while the
+	// sink is unavailable. The oldest entries are dropped first.
+	defaultApplyLogBufferSize = 1000
+	// defaultApplyLogRetryInterval is how often delivery of the buffered
+	// entries is retried.
+	defaultApplyLogRetryInterval = 30 * time.Second
+	// applyLogSinkTimeout bounds a single delivery to the sink.
+	applyLogSinkTimeout = 10 * time.Second
+	// applyLogSyslogTag is the tag of the syslog messages.
+	applyLogSyslogTag = "machine-config-daemon"
+)
+
+// ApplyLogEntry is a structured log entry of a phase of an update.
+type ApplyLogEntry struct {
+	Time   time.Time `json:"time"`
+	Node   string    `json:"node"`
+	Config string    `json:"config"`
+	Phase  string    `json:"phase"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// ApplyLogSink delivers apply log entries to a central store.
+type ApplyLogSink interface {
+	Send(entries []ApplyLogEntry) error
+}
+
+// NewApplyLogSink returns the sink for the sink URL. http(s) URLs get the
+// entries POSTed as a JSON array, syslog://host:port and syslog+tcp://host:port
+// URLs get one JSON encoded syslog message per entry over UDP or TCP.
+func NewApplyLogSink(sinkURL string) (ApplyLogSink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid apply log sink %q: %v", sinkURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpApplyLogSink{url: sinkURL, client: &http.Client{Timeout: applyLogSinkTimeout}}, nil
+	case "syslog":
+		return &syslogApplyLogSink{network: "udp", address: u.Host}, nil
+	case "syslog+tcp":
+		return &syslogApplyLogSink{network: "tcp", address: u.Host}, nil
+	}
+	return nil, fmt.Errorf("Invalid apply log sink %q: unsupported scheme %q", sinkURL, u.Scheme)
+}
+
+// httpApplyLogSink POSTs the entries to an HTTP endpoint.
+type httpApplyLogSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpApplyLogSink) Send(entries []ApplyLogEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("apply log sink %s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// syslogApplyLogSink sends the entries to a remote syslog server.
+type syslogApplyLogSink struct {
+	network string
+	address string
+}
+
+// Send writes the messages in the format of log/syslog, which can't bound the
+// time it takes to dial and write to the server.
+func (s *syslogApplyLogSink) Send(entries []ApplyLogEntry) error {
+	conn, err := net.DialTimeout(s.network, s.address, applyLogSinkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(applyLogSinkTimeout)); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		priority := syslog.LOG_INFO | syslog.LOG_DAEMON
+		if e.Result == ApplyLogResultFailed {
+			priority = syslog.LOG_ERR | syslog.LOG_DAEMON
+		}
+		msg := fmt.Sprintf("<%d>%s %s %s[%d]: %s\n", priority, time.Now().Format(time.RFC3339), hostname, applyLogSyslogTag, os.Getpid(), data)
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyLogger buffers the apply log entries of the node and delivers them to
+// a sink, retrying while the sink is unavailable. Entries that could not be
+// delivered are also kept in a spool file so they survive reboots.
+// A nil ApplyLogger discards all entries.
+type ApplyLogger struct {
+	node      string
+	sink      ApplyLogSink
+	spoolPath string
+
+	bufferSize    int
+	retryInterval time.Duration
+
+	// sendMu serializes the deliveries to the sink, which are made without
+	// holding mu so logging doesn't wait for the sink.
+	sendMu  sync.Mutex
+	mu      sync.Mutex
+	pending []ApplyLogEntry
+	// dropped counts the entries dropped from the buffer once it is full.
+	dropped int
+	wakeCh  chan struct{}
+}
+
+// NewApplyLogger returns an ApplyLogger for the node delivering entries to the
+// sink. Undelivered entries are spooled at spoolPath, if set.
+func NewApplyLogger(node string, sink ApplyLogSink, spoolPath string) *ApplyLogger {
+	return &ApplyLogger{
+		node:          node,
+		sink:          sink,
+		spoolPath:     spoolPath,
+		bufferSize:    defaultApplyLogBufferSize,
+		retryInterval: defaultApplyLogRetryInterval,
+		wakeCh:        make(chan struct{}, 1),
+	}
+}
+
+// Log records the result of the phase of the update to config.
+func (l *ApplyLogger) Log(config, phase, result string, err error) {
+	if l == nil {
+		return
+	}
+	entry := ApplyLogEntry{
+		Time:   time.Now().UTC(),
+		Node:   l.node,
+		Config: config,
+		Phase:  phase,
+		Result: result,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	l.pending = append(l.pending, entry)
+	if len(l.pending) > l.bufferSize {
+		dropped := len(l.pending) - l.bufferSize
+		glog.Warningf("Apply log buffer is full; dropping %d entries", dropped)
+		l.pending = l.pending[dropped:]
+		l.dropped += dropped
+	}
+	l.mu.Unlock()
+
+	select {
+	case l.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Flush delivers the pending entries to the sink. On failure the entries are
+// kept for the next attempt and written to the spool file.
+func (l *ApplyLogger) Flush() error {
+	if l == nil {
+		return nil
+	}
+	l.sendMu.Lock()
+	defer l.sendMu.Unlock()
+
+	l.mu.Lock()
+	entries := append([]ApplyLogEntry(nil), l.pending...)
+	dropped := l.dropped
+	l.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	err := l.sink.Send(entries)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.spool()
+		return fmt.Errorf("Failed to deliver %d apply log entries: %v", len(entries), err)
+	}
+	// the entries logged while sending come after the ones sent, some of
+	// which may have been dropped from the buffer meanwhile.
+	if sent := len(entries) - (l.dropped - dropped); sent > 0 {
+		l.pending = l.pending[sent:]
+	}
+	l.spool()
+	return nil
+}
+
+// Pending returns the number of entries waiting to be delivered.
+func (l *ApplyLogger) Pending() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pending)
+}
+
+// Run loads the spooled entries and delivers entries as they are logged,
+// retrying failed deliveries every retry interval. It returns when stop is
+// closed. Intended to be run via a goroutine.
+func (l *ApplyLogger) Run(stop <-chan struct{}) {
+	l.loadSpool()
+
+	ticker := time.NewTicker(l.retryInterval)
+	defer ticker.Stop()
+	for {
+		if err := l.Flush(); err != nil {
+			glog.Warningf("%v; retrying in %v", err, l.retryInterval)
+		}
+		select {
+		case <-stop:
+			return
+		case <-l.wakeCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+// spool writes the pending entries to the spool file, removing it when there
+// are none. Must be called with mu held.
+func (l *ApplyLogger) spool() {
+	if l.spoolPath == "" {
+		return
+	}
+	if len(l.pending) == 0 {
+		if err := os.Remove(l.spoolPath); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Failed to remove apply log spool %s: %v", l.spoolPath, err)
+		}
+		return
+	}
+	data, err := json.Marshal(l.pending)
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(l.spoolPath), DefaultDirectoryPermissions); err == nil {
+			err = ioutil.WriteFile(l.spoolPath, data, 0600)
+		}
+	}
+	if err != nil {
+		glog.Warningf("Failed to write apply log spool %s: %v", l.spoolPath, err)
+	}
+}
+
+// loadSpool prepends the entries from the spool file to the pending entries.
+func (l *ApplyLogger) loadSpool() {
+	if l.spoolPath == "" {
+		return
+	}
+	// entries can't be prepended while a delivery is in flight.
+	l.sendMu.Lock()
+	defer l.sendMu.Unlock()
+	data, err := ioutil.ReadFile(l.spoolPath)
+	if os.IsNotExist(err) {
+		return
+	}
+	var spooled []ApplyLogEntry
+	if err == nil {
+		err = json.Unmarshal(data, &spooled)
+	}
+	if err != nil {
+		glog.Warningf("Failed to read apply log spool %s: %v", l.spoolPath, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(spooled, l.pending...)
+	if len(l.pending) > l.bufferSize {
+		l.pending = l.pending[len(l.pending)-l.bufferSize:]
+	}
+}
@@ -0,0 +1,342 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeApplyLogSink is an ApplyLogSink that fails the first failures sends and
+// records the entries of the sends that succeeded.
+type fakeApplyLogSink struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	delivered []ApplyLogEntry
+}
+
+func (s *fakeApplyLogSink) Send(entries []ApplyLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("sink unavailable")
+	}
+	s.delivered = append(s.delivered, entries...)
+	return nil
+}
+
+func (s *fakeApplyLogSink) phases() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var phases []string
+	for _, e := range s.delivered {
+		phases = append(phases, e.Phase+"/"+e.Result)
+	}
+	return phases
+}
+
+// TestApplyLoggerRetry verifies that entries are kept while the sink is
+// unavailable and delivered in order once it is back.
+func TestApplyLoggerRetry(t *testing.T) {
+	sink := &fakeApplyLogSink{failures: 2}
+	l := NewApplyLogger("node-0", sink, "")
+
+	l.Log("config-1", ApplyLogPhaseFiles, ApplyLogResultSucceeded, nil)
+	if err := l.Flush(); err == nil {
+		t.Fatal("expected flush to fail while the sink is unavailable")
+	}
+	l.Log("config-1", ApplyLogPhaseOS, ApplyLogResultFailed, fmt.Errorf("pivot failed"))
+	if err := l.Flush(); err == nil {
+		t.Fatal("expected flush to fail while the sink is unavailable")
+	}
+	if l.Pending() != 2 {
+		t.Fatalf("expected 2 pending entries, got %d", l.Pending())
+	}
+
+	if err := l.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed: %v", err)
+	}
+	if l.Pending() != 0 {
+		t.Fatalf("expected no pending entries, got %d", l.Pending())
+	}
+	if sink.attempts != 3 {
+		t.Errorf("expected 3 delivery attempts, got %d", sink.attempts)
+	}
+	expected := []string{"UpdateFiles/Succeeded", "UpdateOS/Failed"}
+	if got := sink.phases(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v delivered, got %v", expected, got)
+	}
+	if e := sink.delivered[1]; e.Node != "node-0" || e.Config != "config-1" || e.Error != "pivot failed" {
+		t.Errorf("unexpected entry delivered: %+v", e)
+	}
+}
+
+// TestApplyLoggerBufferSize verifies that the oldest entries are dropped once
+// the buffer is full.
+func TestApplyLoggerBufferSize(t *testing.T) {
+	sink := &fakeApplyLogSink{failures: 1}
+	l := NewApplyLogger("node-0", sink, "")
+	l.bufferSize = 2
+
+	l.Log("config-1", ApplyLogPhaseFiles, ApplyLogResultSucceeded, nil)
+	l.Log("config-1", ApplyLogPhaseOS, ApplyLogResultSucceeded, nil)
+	l.Log("config-1", ApplyLogPhaseReboot, ApplyLogResultStarted, nil)
+	l.Flush()
+	if err := l.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed: %v", err)
+	}
+	expected := []string{"UpdateOS/Succeeded", "Reboot/Started"}
+	if got := sink.phases(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v delivered, got %v", expected, got)
+	}
+}
+
+// TestApplyLoggerSpool verifies that undelivered entries survive a restart of
+// the daemon through the spool file.
+func TestApplyLoggerSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apply-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "spool", "apply-log.json")
+
+	down := &fakeApplyLogSink{failures: 1}
+	l := NewApplyLogger("node-0", down, spool)
+	l.Log("config-1", ApplyLogPhaseReboot, ApplyLogResultStarted, nil)
+	if err := l.Flush(); err == nil {
+		t.Fatal("expected flush to fail while the sink is unavailable")
+	}
+	if _, err := os.Stat(spool); err != nil {
+		t.Fatalf("expected entries to be spooled: %v", err)
+	}
+
+	// after the reboot
+	up := &fakeApplyLogSink{}
+	l = NewApplyLogger("node-0", up, spool)
+	l.loadSpool()
+	l.Log("config-1", ApplyLogPhaseUpdate, ApplyLogResultStarted, nil)
+	if err := l.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed: %v", err)
+	}
+	expected := []string{"Reboot/Started", "Update/Started"}
+	if got := up.phases(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v delivered, got %v", expected, got)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("expected spool to be removed once delivered, got %v", err)
+	}
+}
+
+// TestApplyLoggerRun verifies that Run delivers logged entries and retries
+// on the retry interval while the sink is unavailable.
+func TestApplyLoggerRun(t *testing.T) {
+	sink := &fakeApplyLogSink{failures: 2}
+	l := NewApplyLogger("node-0", sink, "")
+	l.retryInterval = 10 * time.Millisecond
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		l.Run(stop)
+		close(done)
+	}()
+	l.Log("config-1", ApplyLogPhaseUnits, ApplyLogResultSucceeded, nil)
+
+	deadline := time.After(5 * time.Second)
+	for l.Pending() != 0 || len(sink.phases()) == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("entries were not delivered: %d pending", l.Pending())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	close(stop)
+	<-done
+
+	if got := sink.phases(); !reflect.DeepEqual(got, []string{"RestartUnits/Succeeded"}) {
+		t.Errorf("unexpected entries delivered: %v", got)
+	}
+	if sink.attempts < 3 {
+		t.Errorf("expected at least 3 delivery attempts, got %d", sink.attempts)
+	}
+}
+
+// blockingApplyLogSink is an ApplyLogSink whose sends wait for release.
+type blockingApplyLogSink struct {
+	sending chan struct{}
+	release chan struct{}
+	fakeApplyLogSink
+}
+
+func (s *blockingApplyLogSink) Send(entries []ApplyLogEntry) error {
+	s.sending <- struct{}{}
+	<-s.release
+	return s.fakeApplyLogSink.Send(entries)
+}
+
+// TestApplyLoggerLogDuringFlush verifies that logging doesn't wait for a
+// delivery in flight, and that the entries logged meanwhile stay pending.
+func TestApplyLoggerLogDuringFlush(t *testing.T) {
+	sink := &blockingApplyLogSink{sending: make(chan struct{}), release: make(chan struct{})}
+	l := NewApplyLogger("node-0", sink, "")
+	l.bufferSize = 2
+
+	l.Log("config-1", ApplyLogPhaseFiles, ApplyLogResultSucceeded, nil)
+	l.Log("config-1", ApplyLogPhaseOS, ApplyLogResultSucceeded, nil)
+	errCh := make(chan error)
+	go func() {
+		errCh <- l.Flush()
+	}()
+	<-sink.sending
+
+	logged := make(chan struct{})
+	go func() {
+		// drops UpdateFiles, which is being sent, from the buffer.
+		l.Log("config-1", ApplyLogPhaseReboot, ApplyLogResultStarted, nil)
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected logging not to wait for the delivery")
+	}
+
+	close(sink.release)
+	if err := <-errCh; err != nil {
+		t.Fatalf("expected flush to succeed: %v", err)
+	}
+	if l.Pending() != 1 {
+		t.Fatalf("expected the entry logged during the delivery to be pending, got %d pending", l.Pending())
+	}
+	go func() { <-sink.sending }()
+	if err := l.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed: %v", err)
+	}
+	expected := []string{"UpdateFiles/Succeeded", "UpdateOS/Succeeded", "Reboot/Started"}
+	if got := sink.phases(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v delivered, got %v", expected, got)
+	}
+}
+
+// TestSyslogApplyLogSink verifies entries are sent as one syslog message each,
+// with the priority of their result.
+func TestSyslogApplyLogSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	sink, err := NewApplyLogSink("syslog+tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send([]ApplyLogEntry{
+		{Node: "node-0", Config: "config-1", Phase: ApplyLogPhaseFiles, Result: ApplyLogResultSucceeded},
+		{Node: "node-0", Config: "config-1", Phase: ApplyLogPhaseOS, Result: ApplyLogResultFailed, Error: "pivot failed"},
+	}); err != nil {
+		t.Fatalf("expected send to succeed: %v", err)
+	}
+	lines := <-received
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 messages, got %q", lines)
+	}
+	for i, prefix := range []string{"<30>", "<27>"} {
+		if !strings.HasPrefix(lines[i], prefix) || !strings.Contains(lines[i], " machine-config-daemon[") {
+			t.Errorf("expected message %q to start with %s and be tagged machine-config-daemon", lines[i], prefix)
+		}
+	}
+	if !strings.HasSuffix(lines[1], `"error":"pivot failed"}`) {
+		t.Errorf("expected message to end with the JSON entry, got %q", lines[1])
+	}
+}
+
+// TestHTTPApplyLogSink verifies entries are POSTed as JSON and that error
+// responses are reported so the entries are retried.
+func TestHTTPApplyLogSink(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusServiceUnavailable
+	var received []ApplyLogEntry
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if status == http.StatusOK {
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Errorf("failed to decode entries: %v", err)
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	sink, err := NewApplyLogSink(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewApplyLogger("node-0", sink, "")
+	l.Log("config-1", ApplyLogPhaseFiles, ApplyLogResultFailed, fmt.Errorf("permission denied"))
+	if err := l.Flush(); err == nil {
+		t.Fatal("expected flush to fail on 503")
+	}
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	if err := l.Flush(); err != nil {
+		t.Fatalf("expected flush to succeed: %v", err)
+	}
+	if len(received) != 1 || received[0].Phase != ApplyLogPhaseFiles || received[0].Error != "permission denied" {
+		t.Errorf("unexpected entries received: %+v", received)
+	}
+}
+
+func TestNewApplyLogSink(t *testing.T) {
+	for _, sinkURL := range []string{"http://logs:8080/apply", "https://logs/apply", "syslog://logs:514", "syslog+tcp://logs:514"} {
+		if _, err := NewApplyLogSink(sinkURL); err != nil {
+			t.Errorf("expected %s to be valid: %v", sinkURL, err)
+		}
+	}
+	if _, err := NewApplyLogSink("ftp://logs"); err == nil {
+		t.Error("expected unsupported scheme to be rejected")
+	}
+}
+
+// TestNilApplyLogger verifies that logging without a sink is a no-op.
+func TestNilApplyLogger(t *testing.T) {
+	var l *ApplyLogger
+	l.Log("config-1", ApplyLogPhaseFiles, ApplyLogResultSucceeded, nil)
+	if err := l.Flush(); err != nil {
+		t.Errorf("expected nil logger flush to succeed: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// bootCheckpointPath is where the checkpoint of a reboot into a new
+	// config is kept. /var is shared by the deployments, so it survives
+	// the reboot and a rollback.
+	bootCheckpointPath = "/var/lib/machine-config-daemon/boot-checkpoint.json"
+	// bootIDPath is the ID of the current boot, changed on every boot.
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+)
+
+// bootCheckpoint is the pending confirmation of a reboot into a new config.
+// It is written before rebooting and removed once the node confirmed it
+// works with the config.
+type bootCheckpoint struct {
+	// Config is the config the node rebooted into.
+	Config string `json:"config"`
+	// PreviousConfig is the config the node rolls back to.
+	PreviousConfig string `json:"previousConfig"`
+	// NewDeployment is true if the reboot boots a new rpm-ostree
+	// deployment, which rolling back replaces with the previous one.
+	NewDeployment bool `json:"newDeployment"`
+	// StagedBootID is the boot in which the reboot was triggered.
+	StagedBootID string `json:"stagedBootID"`
+	// BootID is the first boot into the config, empty until the node came
+	// up from the reboot.
+	BootID string `json:"bootID,omitempty"`
+	// ConfirmBy is when the boot into the config must be confirmed by.
+	ConfirmBy time.Time `json:"confirmBy"`
+}
+
+// bootCheckpointAction is what the daemon does with a boot checkpoint when it
+// starts.
+type bootCheckpointAction int
+
+const (
+	// bootCheckpointNone means there is no boot to confirm.
+	bootCheckpointNone bootCheckpointAction = iota
+	// bootCheckpointConfirm means the boot into the config is confirmed
+	// once the node is healthy.
+	bootCheckpointConfirm
+	// bootCheckpointRollback means the boot into the config was never
+	// confirmed and is rolled back.
+	bootCheckpointRollback
+)
+
+// evaluateBootCheckpoint returns what to do with the checkpoint in the boot
+// with the ID. The first time the daemon starts after the reboot into the
+// config, the boot is recorded in the checkpoint and has until timeout to be
+// confirmed. A checkpoint still there in a later boot means the node rebooted
+// or crashed before confirming its boot into the config.
+func evaluateBootCheckpoint(cp *bootCheckpoint, bootID string, now time.Time, timeout time.Duration) bootCheckpointAction {
+	switch {
+	case cp == nil:
+		return bootCheckpointNone
+	case bootID == cp.StagedBootID:
+		// the daemon restarted before the reboot happened.
+		return bootCheckpointNone
+	case cp.BootID == "":
+		cp.BootID = bootID
+		cp.ConfirmBy = now.Add(timeout)
+		return bootCheckpointConfirm
+	case cp.BootID != bootID, now.After(cp.ConfirmBy):
+		return bootCheckpointRollback
+	}
+	return bootCheckpointConfirm
+}
+
+// readBootID returns the ID of the current boot.
+func readBootID(fs FileSystemClient) (string, error) {
+	data, err := fs.ReadFile(bootIDPath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read boot ID: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readBootCheckpoint returns the boot checkpoint, nil if there is none.
+func readBootCheckpoint(fs FileSystemClient) (*bootCheckpoint, error) {
+	data, err := fs.ReadFile(bootCheckpointPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read boot checkpoint: %v", err)
+	}
+	var cp bootCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("Failed to parse boot checkpoint %s: %v", bootCheckpointPath, err)
+	}
+	return &cp, nil
+}
+
+// writeBootCheckpoint writes the boot checkpoint.
+func writeBootCheckpoint(fs FileSystemClient, cp *bootCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(filepath.Dir(bootCheckpointPath), DefaultDirectoryPermissions); err != nil {
+		return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(bootCheckpointPath), err)
+	}
+	if err := fs.WriteFile(bootCheckpointPath, data, DefaultFilePermissions); err != nil {
+		return fmt.Errorf("Failed to write boot checkpoint: %v", err)
+	}
+	return nil
+}
+
+// removeBootCheckpoint removes the boot checkpoint, if there is one.
+func removeBootCheckpoint(fs FileSystemClient) error {
+	if err := fs.Remove(bootCheckpointPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove boot checkpoint: %v", err)
+	}
+	return nil
+}
+
+// stageBootCheckpoint writes the checkpoint of the reboot from the old into
+// the new config, if boot confirmation is enabled.
+func (dn *Daemon) stageBootCheckpoint(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	if dn.bootConfirmationTimeout == 0 {
+		return nil
+	}
+	bootID, err := readBootID(dn.fileSystemClient)
+	if err != nil {
+		return err
+	}
+	osUpdated := !dn.isUnspecifiedOS(newConfig.Spec.OSImageURL) && newConfig.Spec.OSImageURL != oldConfig.Spec.OSImageURL
+	// kernel arguments are staged in a new deployment as well.
+	deleted, added := kernelArgumentChanges(oldConfig.Spec.TuningProfile, newConfig.Spec.TuningProfile)
+	cp := &bootCheckpoint{
+		Config:         newConfig.GetName(),
+		PreviousConfig: oldConfig.GetName(),
+		NewDeployment:  dn.OperatingSystem == MachineConfigDaemonOSRHCOS && (osUpdated || len(deleted) > 0 || len(added) > 0),
+		StagedBootID:   bootID,
+	}
+	glog.Infof("Boot into config %s must be confirmed within %v of the reboot", cp.Config, dn.bootConfirmationTimeout)
+	return writeBootCheckpoint(dn.fileSystemClient, cp)
+}
+
+// checkBootCheckpoint looks for the checkpoint of a reboot into a new config
+// when the daemon starts. It returns the checkpoint if the boot is yet to be
+// confirmed. A boot that was never confirmed is rolled back and the node
+// rebooted into the previous config.
+func (dn *Daemon) checkBootCheckpoint() (*bootCheckpoint, error) {
+	if dn.bootConfirmationTimeout == 0 {
+		return nil, nil
+	}
+	cp, err := readBootCheckpoint(dn.fileSystemClient)
+	if err != nil || cp == nil {
+		return nil, err
+	}
+	bootID, err := readBootID(dn.fileSystemClient)
+	if err != nil {
+		return nil, err
+	}
+
+	switch evaluateBootCheckpoint(cp, bootID, time.Now(), dn.bootConfirmationTimeout) {
+	case bootCheckpointConfirm:
+		return cp, writeBootCheckpoint(dn.fileSystemClient, cp)
+	case bootCheckpointRollback:
+		return nil, dn.rollbackBoot(cp)
+	}
+	return nil, nil
+}
+
+// confirmBootCheckpoint confirms the boot into the config of the checkpoint
+// once the node is healthy with it. If that took past the confirmation
+// deadline the boot is rolled back instead.
+func (dn *Daemon) confirmBootCheckpoint(cp *bootCheckpoint) error {
+	if cp == nil {
+		return nil
+	}
+	if time.Now().After(cp.ConfirmBy) {
+		return dn.rollbackBoot(cp)
+	}
+	glog.Infof("Boot into config %s confirmed", cp.Config)
+	return removeBootCheckpoint(dn.fileSystemClient)
+}
+
+// rollbackBoot rolls back the unconfirmed boot and reboots into the previous
+// config. This function shouldn't actually return.
+func (dn *Daemon) rollbackBoot(cp *bootCheckpoint) error {
+	if err := dn.revertBootCheckpoint(cp, Run); err != nil {
+		return err
+	}
+	return dn.reboot(fmt.Sprintf("Node will reboot to roll back from config %s to %s", cp.Config, cp.PreviousConfig))
+}
+
+// revertBootCheckpoint restores the files, units and tuning profile of the
+// previous config, makes the previous deployment the default if the reboot
+// booted a new one and marks the node degraded, so the config isn't retried
+// until it is looked at.
+func (dn *Daemon) revertBootCheckpoint(cp *bootCheckpoint, run func(string, ...string) error) error {
+	glog.Warningf("Boot into config %s was not confirmed; rolling back to config %s", cp.Config, cp.PreviousConfig)
+	if dn.recorder != nil {
+		dn.recorder.Eventf(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: dn.name}}, corev1.EventTypeWarning, "BootNotConfirmed",
+			"Boot into config %s was not confirmed, rolling back to config %s", cp.Config, cp.PreviousConfig)
+	}
+
+	oldConfig, err := getMachineConfig(dn.client.MachineconfigurationV1().MachineConfigs(), cp.PreviousConfig)
+	if err != nil {
+		return err
+	}
+	newConfig, err := getMachineConfig(dn.client.MachineconfigurationV1().MachineConfigs(), cp.Config)
+	if err != nil {
+		return err
+	}
+	// the kernel arguments are part of the deployment rolled back below.
+	if err := dn.restoreConfig(newConfig, oldConfig, run); err != nil {
+		return err
+	}
+	if cp.NewDeployment {
+		if err := run("rpm-ostree", "rollback"); err != nil {
+			return fmt.Errorf("Failed to roll back to the previous deployment: %v", err)
+		}
+	}
+	// the rollback is done, make sure it isn't done again after the reboot.
+	if err := removeBootCheckpoint(dn.fileSystemClient); err != nil {
+		return err
+	}
+
+	dn.nodeWriter.SetUpdateDegradedIgnoreErr(fmt.Errorf("boot into config %s was not confirmed within %v; rolled back to config %s", cp.Config, dn.bootConfirmationTimeout, cp.PreviousConfig),
+		dn.kubeClient.CoreV1().Nodes(), dn.name)
+	return nil
+}
+
+// restoreConfig puts the files, units and tuning profile of oldConfig back in
+// place of those of newConfig. The kernel arguments are left alone, they are
+// part of the deployment.
+func (dn *Daemon) restoreConfig(newConfig, oldConfig *mcfgv1.MachineConfig, run func(string, ...string) error) error {
+	if err := dn.updateFiles(newConfig, oldConfig); err != nil {
+		return err
+	}
+	withoutKernelArguments := func(tp *mcfgv1.TuningProfile) *mcfgv1.TuningProfile {
+		if tp == nil {
+			return nil
+		}
+		tp = tp.DeepCopy()
+		tp.KernelArguments = nil
+		return tp
+	}
+	return applyTuningProfile(withoutKernelArguments(newConfig.Spec.TuningProfile), withoutKernelArguments(oldConfig.Spec.TuningProfile), false, dn.fileSystemClient, run)
+}
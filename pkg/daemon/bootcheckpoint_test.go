@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// bootFsClient keeps the files in memory.
+type bootFsClient struct {
+	FsClient
+	files map[string]string
+}
+
+func (f *bootFsClient) MkdirAll(name string, perm os.FileMode) error {
+	return nil
+}
+
+func (f *bootFsClient) ReadFile(filename string) ([]byte, error) {
+	data, ok := f.files[filename]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+	return []byte(data), nil
+}
+
+func (f *bootFsClient) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	f.files[filename] = string(data)
+	return nil
+}
+
+func (f *bootFsClient) Remove(name string) error {
+	if _, ok := f.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+func TestEvaluateBootCheckpoint(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		cp     *bootCheckpoint
+		bootID string
+		action bootCheckpointAction
+	}{{
+		name:   "no checkpoint",
+		bootID: "boot-1",
+		action: bootCheckpointNone,
+	}, {
+		name:   "not rebooted yet",
+		cp:     &bootCheckpoint{StagedBootID: "boot-1"},
+		bootID: "boot-1",
+		action: bootCheckpointNone,
+	}, {
+		name:   "first boot into the config",
+		cp:     &bootCheckpoint{StagedBootID: "boot-1"},
+		bootID: "boot-2",
+		action: bootCheckpointConfirm,
+	}, {
+		name:   "daemon restarted within the window",
+		cp:     &bootCheckpoint{StagedBootID: "boot-1", BootID: "boot-2", ConfirmBy: now.Add(time.Minute)},
+		bootID: "boot-2",
+		action: bootCheckpointConfirm,
+	}, {
+		name:   "daemon restarted past the window",
+		cp:     &bootCheckpoint{StagedBootID: "boot-1", BootID: "boot-2", ConfirmBy: now.Add(-time.Minute)},
+		bootID: "boot-2",
+		action: bootCheckpointRollback,
+	}, {
+		name:   "rebooted again without confirming",
+		cp:     &bootCheckpoint{StagedBootID: "boot-1", BootID: "boot-2", ConfirmBy: now.Add(time.Minute)},
+		bootID: "boot-3",
+		action: bootCheckpointRollback,
+	}}
+	for _, test := range tests {
+		if action := evaluateBootCheckpoint(test.cp, test.bootID, now, 10*time.Minute); action != test.action {
+			t.Errorf("%s: expected action %v, got %v", test.name, test.action, action)
+		}
+	}
+
+	cp := &bootCheckpoint{StagedBootID: "boot-1"}
+	evaluateBootCheckpoint(cp, "boot-2", now, 10*time.Minute)
+	if cp.BootID != "boot-2" || !cp.ConfirmBy.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("expected the first boot into the config to be recorded, got %+v", cp)
+	}
+}
+
+// TestBootCheckpointConfirmed reboots into a new config and verifies the
+// checkpoint is removed once the boot is confirmed.
+func TestBootCheckpointConfirmed(t *testing.T) {
+	fs := &bootFsClient{files: map[string]string{bootIDPath: "boot-1\n"}}
+	d := &Daemon{
+		fileSystemClient:        fs,
+		bootConfirmationTimeout: 10 * time.Minute,
+	}
+	oldConfig := &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: "old"}}
+	newConfig := &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: "new"}}
+	if err := d.stageBootCheckpoint(oldConfig, newConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	// the daemon restarting before the reboot has nothing to confirm.
+	if cp, err := d.checkBootCheckpoint(); err != nil || cp != nil {
+		t.Fatalf("expected nothing to confirm before the reboot, got %+v, %v", cp, err)
+	}
+
+	fs.files[bootIDPath] = "boot-2\n"
+	cp, err := d.checkBootCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Config != "new" || cp.PreviousConfig != "old" || cp.BootID != "boot-2" {
+		t.Fatalf("expected the boot into config new to be confirmed, got %+v", cp)
+	}
+	// the boot is recorded in case the node goes down before confirming it.
+	if persisted, err := readBootCheckpoint(fs); err != nil || persisted == nil || persisted.BootID != "boot-2" {
+		t.Errorf("expected the boot to be recorded, got %+v, %v", persisted, err)
+	}
+
+	if err := d.confirmBootCheckpoint(cp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.files[bootCheckpointPath]; ok {
+		t.Errorf("expected the checkpoint to be removed once the boot is confirmed")
+	}
+}
+
+// TestBootCheckpointRollback simulates a node crashing after rebooting into a
+// new OS and verifies the next boot rolls back to the previous deployment and
+// marks the node degraded.
+func TestBootCheckpointRollback(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	oldConfig := &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "old"},
+		Spec:       mcfgv1.MachineConfigSpec{OSImageURL: "quay.io/openshift/os:1"},
+	}
+	newConfig := &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "new"},
+		Spec:       mcfgv1.MachineConfigSpec{OSImageURL: "quay.io/openshift/os:2"},
+	}
+	fs := &bootFsClient{files: map[string]string{bootIDPath: "boot-1\n"}}
+	recorder := record.NewFakeRecorder(10)
+	d := &Daemon{
+		name:            "nodeName",
+		OperatingSystem: MachineConfigDaemonOSRHCOS,
+		client:          fake.NewSimpleClientset(oldConfig, newConfig),
+		kubeClient: k8sfake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name: "nodeName",
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey:     "old",
+				DesiredMachineConfigAnnotationKey:     "new",
+				MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateWorking,
+			},
+		}}),
+		recorder:                recorder,
+		fileSystemClient:        fs,
+		fileDurability:          FileDurabilityFsyncFile,
+		bootConfirmationTimeout: 10 * time.Minute,
+		nodeWriter:              nw,
+	}
+	if err := d.stageBootCheckpoint(oldConfig, newConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node boots into the new config, then crashes before confirming it.
+	fs.files[bootIDPath] = "boot-2\n"
+	if cp, err := d.checkBootCheckpoint(); err != nil || cp == nil {
+		t.Fatalf("expected the boot to be confirmed, got %+v, %v", cp, err)
+	}
+	fs.files[bootIDPath] = "boot-3\n"
+
+	cp, err := readBootCheckpoint(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the new config changes the OS image, which is staged in a new deployment.
+	if !cp.NewDeployment {
+		t.Fatalf("expected the OS image update to stage a new deployment, got %+v", cp)
+	}
+	if action := evaluateBootCheckpoint(cp, "boot-3", time.Now(), d.bootConfirmationTimeout); action != bootCheckpointRollback {
+		t.Fatalf("expected the unconfirmed boot to be rolled back, got %v", action)
+	}
+	var commands []string
+	run := func(name string, args ...string) error {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+	if err := d.revertBootCheckpoint(cp, run); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []string{"rpm-ostree rollback"}; !reflect.DeepEqual(commands, exp) {
+		t.Errorf("expected commands %v, got %v", exp, commands)
+	}
+	if _, ok := fs.files[bootCheckpointPath]; ok {
+		t.Errorf("expected the checkpoint to be removed by the rollback")
+	}
+	node, err := d.kubeClient.CoreV1().Nodes().Get("nodeName", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := node.Annotations[MachineConfigDaemonStateAnnotationKey]; state != MachineConfigDaemonStateDegraded {
+		t.Errorf("expected the node to be degraded, got %s", state)
+	}
+	select {
+	case event := <-recorder.Events:
+		if exp := "Warning BootNotConfirmed Boot into config new was not confirmed, rolling back to config old"; event != exp {
+			t.Errorf("expected event %q, got %q", exp, event)
+		}
+	default:
+		t.Errorf("expected an event for the rollback")
+	}
+}
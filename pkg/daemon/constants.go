@@ -13,6 +13,31 @@ const (
 	MachineConfigDaemonStateDone = "Done"
 	// MachineConfigDaemonStateDegraded is set by daemon when update cannot be applied.
 	MachineConfigDaemonStateDegraded = "Degraded"
+	// MachineConfigDaemonStateWaitingForRebootApproval is set by daemon when an update is staged and the
+	// reboot into it waits for approval.
+	MachineConfigDaemonStateWaitingForRebootApproval = "WaitingForRebootApproval"
+	// MachineConfigDaemonRebootStartAnnotationKey is set by daemon to the time at which it triggered a reboot.
+	MachineConfigDaemonRebootStartAnnotationKey = "machineconfiguration.openshift.io/rebootStart"
+	// MachineConfigDaemonRebootDowntimeAnnotationKey is set by daemon to the time it took from triggering
+	// the last reboot to reporting Done after that reboot.
+	MachineConfigDaemonRebootDowntimeAnnotationKey = "machineconfiguration.openshift.io/rebootDowntime"
+	// MachineConfigDaemonVersionAnnotationKey is set by daemon to the version of the daemon running on the machine.
+	MachineConfigDaemonVersionAnnotationKey = "machineconfiguration.openshift.io/daemonVersion"
+	// MachineConfigDaemonForceSyncAnnotationKey is set by an admin to any non empty value to make the
+	// daemon re-apply the desired config, correcting any drift. The daemon clears it once handled.
+	MachineConfigDaemonForceSyncAnnotationKey = "machineconfiguration.openshift.io/forceSync"
+	// MachineConfigDaemonDrainBlockedByAnnotationKey is set by daemon while the drain of the node is blocked
+	// by PodDisruptionBudgets, to the pods that can't be evicted as namespace/pod:pdb separated by commas.
+	MachineConfigDaemonDrainBlockedByAnnotationKey = "machineconfiguration.openshift.io/drainBlockedBy"
+	// MachineConfigDaemonRebootApprovedAnnotationKey is set by an admin or a policy engine to the name of
+	// the config the node may reboot into, on nodes that require reboot approval.
+	MachineConfigDaemonRebootApprovedAnnotationKey = "machineconfiguration.openshift.io/rebootApproved"
+	// MachineConfigDaemonRebootApprovalRequiredLabelKey is set to "true" on nodes that may only reboot
+	// into a new config once the reboot is approved.
+	MachineConfigDaemonRebootApprovalRequiredLabelKey = "machineconfiguration.openshift.io/rebootApprovalRequired"
+	// MachineConfigDaemonPodDisruptionConditionType is the node condition set by daemon before applying an
+	// update, True if the update restarts the container runtime or kubelet in place and disrupts running pods.
+	MachineConfigDaemonPodDisruptionConditionType = "MachineConfigPodDisruption"
 
 	// MachineConfigDaemonOSRHCOS denotes RHCOS
 	MachineConfigDaemonOSRHCOS = "RHCOS"
@@ -25,6 +50,18 @@ const (
 	MachineConfigMCFileType = "MACHINECONFIG"
 	// MachineConfigIgnitionFileType denotes when an Ignition config has provided
 	MachineConfigIgnitionFileType = "IGNITION"
+	// MachineConfigIgnitionV3FileType denotes when an Ignition v3 config has been provided
+	MachineConfigIgnitionV3FileType = "IGNITIONV3"
+
+	// FileDurabilityFsyncFile denotes that every file written is fsynced on its own
+	FileDurabilityFsyncFile = "file"
+	// FileDurabilityFsyncBatch denotes that all the files written are synced at once at the end
+	FileDurabilityFsyncBatch = "batch"
+
+	// SecretFileSourceScheme is the scheme of file sources that reference a secret mounted on the
+	// machine, e.g. secret:///etc/kubernetes/secrets/token. The secret is read when the file is
+	// written so it never has to be embedded in the MachineConfig.
+	SecretFileSourceScheme = "secret"
 
 	// MachineConfigOnceFromRemoteConfig denotes that the config was pulled from a remote source
 	MachineConfigOnceFromRemoteConfig = "REMOTE"
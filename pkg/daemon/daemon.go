@@ -19,7 +19,8 @@ import (
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	mcfgclientset "github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned"
 	mcfgclientv1 "github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/typed/machineconfiguration.openshift.io/v1"
-	"github.com/vincent-petithory/dataurl"
+	"github.com/openshift/machine-config-operator/pkg/liveupdate"
+	"github.com/openshift/machine-config-operator/pkg/version"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -73,10 +74,47 @@ type Daemon struct {
 	kubeletHealthzEnabled  bool
 	kubeletHealthzEndpoint string
 
+	// fileDurability defines how written files are synced to disk
+	fileDurability string
+
+	// fileWriteWorkers is how many files are written concurrently
+	fileWriteWorkers int
+
+	// unitRestartDelay is how long to wait between restarting two units
+	// that changed in place
+	unitRestartDelay time.Duration
+
+	// nodeReadyTimeout is how long to wait for the node to be Ready after a
+	// reboot before marking the update degraded, zero disables the check
+	nodeReadyTimeout time.Duration
+
+	// bootConfirmationTimeout is how long the node has to confirm it is
+	// healthy after rebooting into a new config before the boot is rolled
+	// back, zero disables the rollback
+	bootConfirmationTimeout time.Duration
+
+	// immutableFiles are the base files configs may not change, paths
+	// ending in a slash protect all files under them
+	immutableFiles []string
+
+	// fileMode is the mode of the files written without one, zero for
+	// DefaultFilePermissions
+	fileMode os.FileMode
+	// fileUser and fileGroup own the files written without an owner,
+	// nil for root
+	fileUser  *ignv2_2types.NodeUser
+	fileGroup *ignv2_2types.NodeGroup
+
 	nodeWriter *NodeWriter
 
+	// applyLogger ships structured apply logs to a central sink, nil if
+	// no sink is configured
+	applyLogger *ApplyLogger
+
 	// channel used by callbacks to signal Run() of an error
 	exitCh chan<- error
+	// stopCh is closed when the daemon stops, set by CheckStateOnBoot() and Run()
+	stopCh <-chan struct{}
 }
 
 const (
@@ -93,8 +131,42 @@ const (
 	kubeletHealthzPollingInterval  = time.Duration(30 * time.Second)
 	kubeletHealthzTimeout          = time.Duration(30 * time.Second)
 	kubeletHealthzFailureThreshold = 3
+
+	nodeReadyPollInterval = 10 * time.Second
 )
 
+// Options are the settings of how the daemon applies configs.
+type Options struct {
+	// FileDurability defines how written files are synced to disk,
+	// FileDurabilityFsyncFile or FileDurabilityFsyncBatch.
+	FileDurability string
+	// FileWriteWorkers is how many files are written concurrently.
+	FileWriteWorkers int
+	// UnitRestartDelay is how long to wait between restarting two units
+	// that changed in place.
+	UnitRestartDelay time.Duration
+	// NodeReadyTimeout is how long to wait for the node to be Ready after a
+	// reboot before marking the update degraded, zero disables the check.
+	NodeReadyTimeout time.Duration
+	// BootConfirmationTimeout is how long the node has to confirm it is
+	// healthy after rebooting into a new config before the boot is rolled
+	// back, zero disables the rollback.
+	BootConfirmationTimeout time.Duration
+	// ImmutableFiles are the base files configs may not change, paths
+	// ending in a slash protect all files under them.
+	ImmutableFiles []string
+	// FileUmask is applied to 0666 for the mode of the files written
+	// without one.
+	FileUmask os.FileMode
+	// DefaultFileOwner and DefaultFileGroup own the files written without
+	// an owner, by name or numeric id; empty for root.
+	DefaultFileOwner string
+	DefaultFileGroup string
+	// ApplyLogger ships structured apply logs to a central sink, nil if no
+	// sink is configured.
+	ApplyLogger *ApplyLogger
+}
+
 // New sets up the systemd and kubernetes connections needed to update the
 // machine.
 func New(
@@ -106,10 +178,19 @@ func New(
 	onceFrom string,
 	kubeletHealthzEnabled bool,
 	kubeletHealthzEndpoint string,
+	opts Options,
 	nodeWriter *NodeWriter,
 	exitCh chan<- error,
 ) (*Daemon, error) {
 
+	if opts.FileUmask&^os.ModePerm != 0 || defaultModeForUmask(opts.FileUmask) == 0 {
+		return nil, fmt.Errorf("Invalid file umask %#o", opts.FileUmask)
+	}
+
+	if opts.FileDurability != FileDurabilityFsyncFile && opts.FileDurability != FileDurabilityFsyncBatch {
+		return nil, fmt.Errorf("Invalid file durability mode %q", opts.FileDurability)
+	}
+
 	loginClient, err := login1.New()
 	if err != nil {
 		return nil, fmt.Errorf("Error establishing connection to logind dbus: %v", err)
@@ -125,18 +206,28 @@ func New(
 		glog.Infof("Booted osImageURL: %s (%s)", osImageURL, osVersion)
 	}
 	dn := &Daemon{
-		name:                   nodeName,
-		OperatingSystem:        operatingSystem,
-		NodeUpdaterClient:      nodeUpdaterClient,
-		loginClient:            loginClient,
-		rootMount:              rootMount,
-		fileSystemClient:       fileSystemClient,
-		bootedOSImageURL:       osImageURL,
-		onceFrom:               onceFrom,
-		kubeletHealthzEnabled:  kubeletHealthzEnabled,
-		kubeletHealthzEndpoint: kubeletHealthzEndpoint,
-		nodeWriter:             nodeWriter,
-		exitCh:                 exitCh,
+		name:                    nodeName,
+		OperatingSystem:         operatingSystem,
+		NodeUpdaterClient:       nodeUpdaterClient,
+		loginClient:             loginClient,
+		rootMount:               rootMount,
+		fileSystemClient:        fileSystemClient,
+		bootedOSImageURL:        osImageURL,
+		onceFrom:                onceFrom,
+		kubeletHealthzEnabled:   kubeletHealthzEnabled,
+		kubeletHealthzEndpoint:  kubeletHealthzEndpoint,
+		fileDurability:          opts.FileDurability,
+		fileWriteWorkers:        opts.FileWriteWorkers,
+		unitRestartDelay:        opts.UnitRestartDelay,
+		nodeReadyTimeout:        opts.NodeReadyTimeout,
+		bootConfirmationTimeout: opts.BootConfirmationTimeout,
+		immutableFiles:          opts.ImmutableFiles,
+		fileMode:                defaultModeForUmask(opts.FileUmask),
+		fileUser:                parseFileUser(opts.DefaultFileOwner),
+		fileGroup:               parseFileGroup(opts.DefaultFileGroup),
+		nodeWriter:              nodeWriter,
+		applyLogger:             opts.ApplyLogger,
+		exitCh:                  exitCh,
 	}
 
 	return dn, nil
@@ -156,6 +247,7 @@ func NewClusterDrivenDaemon(
 	nodeInformer coreinformersv1.NodeInformer,
 	kubeletHealthzEnabled bool,
 	kubeletHealthzEndpoint string,
+	opts Options,
 	nodeWriter *NodeWriter,
 	exitCh chan<- error,
 ) (*Daemon, error) {
@@ -168,6 +260,7 @@ func NewClusterDrivenDaemon(
 		onceFrom,
 		kubeletHealthzEnabled,
 		kubeletHealthzEndpoint,
+		opts,
 		nodeWriter,
 		exitCh,
 	)
@@ -183,6 +276,7 @@ func NewClusterDrivenDaemon(
 	eventBroadcaster.StartLogging(glog.V(2).Infof)
 	eventBroadcaster.StartRecordingToSink(&clientsetcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
 	dn.recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigdaemon", Host: nodeName})
+	nodeWriter.SetEventRecorder(dn.recorder)
 
 	if err = loadNodeAnnotations(dn.kubeClient.CoreV1().Nodes(), nodeName); err != nil {
 		return nil, err
@@ -201,6 +295,7 @@ func NewClusterDrivenDaemon(
 // responsible for triggering callbacks to handle updates. Successful
 // updates shouldn't return, and should just reboot the node.
 func (dn *Daemon) Run(stopCh <-chan struct{}, exitCh <-chan error) error {
+	dn.stopCh = stopCh
 	if dn.kubeletHealthzEnabled {
 		glog.Info("Enabling Kubelet Healthz Monitor")
 		go dn.runKubeletHealthzMonitor(stopCh, dn.exitCh)
@@ -217,6 +312,9 @@ func (dn *Daemon) Run(stopCh <-chan struct{}, exitCh <-chan error) error {
 			glog.V(2).Info("Daemon running directly from Ignition")
 			ignConfig := genericConfig.(ignv2_2types.Config)
 			return dn.runOnceFromIgnition(ignConfig)
+		} else if configType == MachineConfigIgnitionV3FileType {
+			glog.V(2).Info("Daemon running directly from Ignition v3")
+			return dn.runOnceFromIgnitionV3(genericConfig.(*ignV3Config))
 		} else if configType == MachineConfigMCFileType {
 			glog.V(2).Info("Daemon running directly from MachineConfig")
 			mcConfig := genericConfig.(*(mcfgv1.MachineConfig))
@@ -229,6 +327,12 @@ func (dn *Daemon) Run(stopCh <-chan struct{}, exitCh <-chan error) error {
 		return dn.nodeWriter.SetUpdateDegradedMsgIgnoreErr("failed to sync cache", dn.kubeClient.CoreV1().Nodes(), dn.name)
 	}
 
+	// Report our version so the operator can detect daemons that are
+	// out of sync with it.
+	if err := dn.nodeWriter.SetDaemonVersion(dn.kubeClient.CoreV1().Nodes(), dn.name, version.Raw); err != nil {
+		glog.Warningf("Failed to report daemon version: %v", err)
+	}
+
 	// Block on exit channel. The node informer will send callbacks through
 	// handleNodeUpdate(). If a failure happens there, it writes to the channel.
 	// The HealthzMonitor goroutine also writes to this channel if the threshold
@@ -299,7 +403,19 @@ func (dn *Daemon) getHealth() error {
 //    because of a machine reboot. validate the current machine state is the
 //    desired machine state. if we aren't try updating again. if we are, update
 //    the current state annotation accordingly.
-func (dn *Daemon) CheckStateOnBoot() error {
+//
+// stopCh is the same channel later passed to Run(), so that an update started
+// here stops waiting when the daemon stops.
+func (dn *Daemon) CheckStateOnBoot(stopCh <-chan struct{}) error {
+	dn.stopCh = stopCh
+
+	// roll back a boot into a config that was never confirmed first, as
+	// the failed boot may have left the node degraded.
+	bootCheckpoint, err := dn.checkBootCheckpoint()
+	if err != nil {
+		return dn.nodeWriter.SetUpdateDegradedIgnoreErr(err, dn.kubeClient.CoreV1().Nodes(), dn.name)
+	}
+
 	// sanity check we're not already in a degraded state
 	if state, err := getNodeAnnotationExt(dn.kubeClient.CoreV1().Nodes(), dn.name, MachineConfigDaemonStateAnnotationKey, true); err != nil {
 		// try to set to degraded... because we failed to check if we're degraded
@@ -313,14 +429,27 @@ func (dn *Daemon) CheckStateOnBoot() error {
 	}
 
 	// validate machine state
-	isDesired, dcAnnotation, err := dn.isDesiredMachineState()
+	isDesired, desiredConfig, err := dn.isDesiredMachineState()
 	if err != nil {
 		return dn.nodeWriter.SetUpdateDegradedIgnoreErr(err, dn.kubeClient.CoreV1().Nodes(), dn.name)
 	}
 
 	if isDesired {
+		// make sure the node made it back into the cluster before
+		// declaring the update done.
+		if err := dn.verifyNodeRejoined(); err != nil {
+			return dn.nodeWriter.SetUpdateDegradedIgnoreErr(err, dn.kubeClient.CoreV1().Nodes(), dn.name)
+		}
+		// and that it works with the config.
+		if err := dn.checkHealth(desiredConfig); err != nil {
+			return dn.nodeWriter.SetUpdateDegradedIgnoreErr(err, dn.kubeClient.CoreV1().Nodes(), dn.name)
+		}
+		// which confirms the boot into it.
+		if err := dn.confirmBootCheckpoint(bootCheckpoint); err != nil {
+			return dn.nodeWriter.SetUpdateDegradedIgnoreErr(err, dn.kubeClient.CoreV1().Nodes(), dn.name)
+		}
 		// we got the machine state we wanted. set the update complete!
-		if err := dn.completeUpdate(dcAnnotation); err != nil {
+		if err := dn.completeUpdate(desiredConfig.GetName()); err != nil {
 			return dn.nodeWriter.SetUpdateDegradedIgnoreErr(err, dn.kubeClient.CoreV1().Nodes(), dn.name)
 		}
 	} else if err := dn.triggerUpdate(); err != nil {
@@ -330,6 +459,63 @@ func (dn *Daemon) CheckStateOnBoot() error {
 	return nil
 }
 
+// verifyNodeRejoined waits for the node to be Ready if we came up from a
+// reboot triggered by the daemon. It returns an error with the reported node
+// status if the node isn't Ready within the node ready timeout.
+func (dn *Daemon) verifyNodeRejoined() error {
+	if dn.nodeReadyTimeout == 0 {
+		return nil
+	}
+	start, err := getNodeAnnotationExt(dn.kubeClient.CoreV1().Nodes(), dn.name, MachineConfigDaemonRebootStartAnnotationKey, true)
+	if err != nil {
+		return err
+	}
+	if start == "" {
+		// we didn't come up from a reboot triggered by the daemon
+		return nil
+	}
+
+	glog.Infof("Waiting up to %v for node to be Ready after reboot", dn.nodeReadyTimeout)
+	var node *corev1.Node
+	err = wait.PollImmediate(nodeReadyPollInterval, dn.nodeReadyTimeout, func() (bool, error) {
+		n, err := dn.kubeClient.CoreV1().Nodes().Get(dn.name, metav1.GetOptions{})
+		if err != nil {
+			glog.Infof("While getting node %s, got: %v. Retrying...", dn.name, err)
+			return false, nil
+		}
+		node = n
+		return isNodeReady(n), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("Node did not rejoin the cluster within %v after reboot: %s", dn.nodeReadyTimeout, nodeReadyDiagnostics(node))
+	}
+	return err
+}
+
+// isNodeReady returns true if the node reports the NodeReady condition.
+func isNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nodeReadyDiagnostics describes the NodeReady condition of the node.
+func nodeReadyDiagnostics(node *corev1.Node) string {
+	if node == nil {
+		return "node could not be fetched"
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type != corev1.NodeReady {
+			continue
+		}
+		return fmt.Sprintf("Ready=%s reason: %q message: %q last heartbeat: %s", c.Status, c.Reason, c.Message, c.LastHeartbeatTime.UTC().Format(time.RFC3339))
+	}
+	return "node does not report a Ready condition; kubelet may not have started"
+}
+
 // runOnceFromMachineConfig utilizes a parsed machineConfig and executes in onceFrom
 // mode. If the content was remote, it executes cluster calls, otherwise it assumes
 // no cluster is present yet.
@@ -370,6 +556,15 @@ func (dn *Daemon) runOnceFromIgnition(ignConfig ignv2_2types.Config) error {
 	return dn.reboot("runOnceFromIgnition complete")
 }
 
+// runOnceFromIgnitionV3 is runOnceFromIgnition for Ignition v3 configs, which
+// are applied as they are rather than translated.
+func (dn *Daemon) runOnceFromIgnitionV3(ignConfig *ignV3Config) error {
+	if err := dn.writeIgnitionV3(ignConfig); err != nil {
+		return err
+	}
+	return dn.reboot("runOnceFromIgnition complete")
+}
+
 // handleNodeUpdate is the gatekeeper handler for informer callbacks detecting
 // node changes. If an update is requested by the controller, we assume that
 // that means something changed and pass over to execution methods no matter what.
@@ -379,6 +574,15 @@ func (dn *Daemon) handleNodeUpdate(old, cur interface{}) {
 
 	// First check if the node that was updated is this daemon's node
 	if node.Name == dn.name {
+		// An admin asked us to re-apply the desired config
+		if node.Annotations[MachineConfigDaemonForceSyncAnnotationKey] != "" {
+			if err := dn.forceSync(); err != nil {
+				glog.Infof("Unable to force sync: %s", err)
+				dn.exitCh <- err
+			}
+			return
+		}
+
 		// Pass to the shared update prep method
 		needUpdate, err := dn.prepUpdateFromCluster()
 		if err != nil {
@@ -399,6 +603,103 @@ func (dn *Daemon) handleNodeUpdate(old, cur interface{}) {
 	return
 }
 
+// forceSync re-applies the desired config of the node without waiting for it
+// to change, correcting any drift of the files, units and OS from it. If the
+// desired config differs from the current one, the regular update is run.
+// Unlike the regular update, it also runs on degraded nodes, as re-applying
+// the config is how they are repaired.
+func (dn *Daemon) forceSync() error {
+	glog.Info("Force sync requested")
+	// clear the request first, so that it is handled only once
+	if err := dn.nodeWriter.ClearForceSync(dn.kubeClient.CoreV1().Nodes(), dn.name); err != nil {
+		return err
+	}
+
+	node, err := dn.kubeClient.CoreV1().Nodes().Get(dn.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Annotations[DesiredMachineConfigAnnotationKey] != node.Annotations[CurrentMachineConfigAnnotationKey] {
+		return dn.executeUpdateFromCluster()
+	}
+	desiredConfig, err := getMachineConfig(dn.client.MachineconfigurationV1().MachineConfigs(), node.Annotations[DesiredMachineConfigAnnotationKey])
+	if err != nil {
+		return err
+	}
+	return dn.syncMachineConfig(desiredConfig)
+}
+
+// splitDriftedUnits splits the drifted units into the ones that are restarted
+// in place and the ones that take a reboot, the same way the changes of an
+// update are.
+func splitDriftedUnits(units []ignv2_2types.Unit) ([]ignv2_2types.Unit, []ignv2_2types.Unit) {
+	var restart, reboot []ignv2_2types.Unit
+	for _, u := range units {
+		if liveupdate.UnitRestartsInPlace(u) {
+			restart = append(restart, u)
+		} else {
+			reboot = append(reboot, u)
+		}
+	}
+	return restart, reboot
+}
+
+// syncMachineConfig rewrites the files and units of the config the node is
+// already on and restarts the drifted units that restart in place. A drifted
+// OS or drifted units that take a reboot are corrected by the regular update
+// into the config instead, which drains and reboots the node.
+func (dn *Daemon) syncMachineConfig(config *mcfgv1.MachineConfig) error {
+	if err := dn.nodeWriter.SetUpdateWorking(dn.kubeClient.CoreV1().Nodes(), dn.name); err != nil {
+		return err
+	}
+
+	var driftedFiles int
+	for _, f := range config.Spec.Config.Storage.Files {
+		if !dn.checkFiles([]ignv2_2types.File{f}) {
+			driftedFiles++
+		}
+	}
+	var driftedUnits []ignv2_2types.Unit
+	for _, u := range config.Spec.Config.Systemd.Units {
+		if !dn.checkUnits([]ignv2_2types.Unit{u}) {
+			driftedUnits = append(driftedUnits, u)
+		}
+	}
+	glog.Infof("Re-applying machineconfig %s; %d files and %d units drifted", config.GetName(), driftedFiles, len(driftedUnits))
+
+	isDesiredOS := true
+	if dn.OperatingSystem == MachineConfigDaemonOSRHCOS {
+		var err error
+		if isDesiredOS, err = dn.checkOS(config.Spec.OSImageURL); err != nil {
+			return err
+		}
+	}
+	restartUnits, rebootUnits := splitDriftedUnits(driftedUnits)
+	if !isDesiredOS || len(rebootUnits) > 0 {
+		for _, u := range rebootUnits {
+			glog.Infof("Drifted unit %s doesn't restart in place; rebooting to correct it", u.Name)
+		}
+		// the node is on the config apart from the booted OS, so updating
+		// from it stages the OS of the config and checkpoints the boot
+		// into the new deployment.
+		oldConfig := config.DeepCopy()
+		if !isDesiredOS {
+			oldConfig.Spec.OSImageURL = dn.bootedOSImageURL
+		}
+		return dn.update(oldConfig, config)
+	}
+
+	if err := dn.updateFiles(config, config); err != nil {
+		return err
+	}
+	if len(restartUnits) > 0 {
+		if err := dn.reloadAndRestartUnits(restartUnits); err != nil {
+			return err
+		}
+	}
+	return dn.completeLiveUpdate(config)
+}
+
 // prepUpdateFromCluster handles the shared update prepping functionality for
 // flows that expect the cluster to already be available. Returns true if an
 // update is required, false otherwise.
@@ -461,9 +762,34 @@ func (dn *Daemon) completeUpdate(dcAnnotation string) error {
 		return err
 	}
 
+	dn.recordRebootDowntime(node)
+
 	return nil
 }
 
+// recordRebootDowntime measures the time from the reboot being triggered to
+// the update being reported as done, and records it on the node so the
+// controller can estimate how long rollouts take. Failures are logged but
+// don't fail the update.
+func (dn *Daemon) recordRebootDowntime(node *corev1.Node) {
+	start, ok := node.Annotations[MachineConfigDaemonRebootStartAnnotationKey]
+	if !ok || start == "" {
+		// we didn't come up from a reboot triggered by the daemon
+		return
+	}
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		glog.Warningf("Unable to parse reboot start time %q: %v", start, err)
+		return
+	}
+
+	downtime := time.Since(startTime).Round(time.Second)
+	glog.Infof("Reboot downtime: %v", downtime)
+	if err := dn.nodeWriter.SetRebootDowntime(dn.kubeClient.CoreV1().Nodes(), dn.name, downtime); err != nil {
+		glog.Warningf("Unable to record reboot downtime: %v", err)
+	}
+}
+
 // triggerUpdateWithMachineConfig starts the update using the desired config and queries the cluster for
 // the current config. If all configs should be pulled from the cluster use triggerUpdate().
 func (dn *Daemon) triggerUpdateWithMachineConfig(desiredConfig *mcfgv1.MachineConfig) error {
@@ -503,23 +829,23 @@ func (dn *Daemon) triggerUpdate() error {
 // and checks if all are present on the node. Returns true iff there are no
 // mismatches (e.g. files, units, OS version), as well as the config that was
 // evaluated if the state is what the machine wants to be in.
-func (dn *Daemon) isDesiredMachineState() (bool, string, error) {
+func (dn *Daemon) isDesiredMachineState() (bool, *mcfgv1.MachineConfig, error) {
 	ccAnnotation, err := getNodeAnnotation(dn.kubeClient.CoreV1().Nodes(), dn.name, CurrentMachineConfigAnnotationKey)
 	if err != nil {
-		return false, "", err
+		return false, nil, err
 	}
 	dcAnnotation, err := getNodeAnnotation(dn.kubeClient.CoreV1().Nodes(), dn.name, DesiredMachineConfigAnnotationKey)
 	if err != nil {
-		return false, "", err
+		return false, nil, err
 	}
 
 	currentConfig, err := getMachineConfig(dn.client.MachineconfigurationV1().MachineConfigs(), ccAnnotation)
 	if err != nil {
-		return false, "", err
+		return false, nil, err
 	}
 	desiredConfig, err := getMachineConfig(dn.client.MachineconfigurationV1().MachineConfigs(), dcAnnotation)
 	if err != nil {
-		return false, "", err
+		return false, nil, err
 	}
 
 	// if we can't reconcile the changes between the old config and the new
@@ -528,10 +854,10 @@ func (dn *Daemon) isDesiredMachineState() (bool, string, error) {
 	// changes.
 	reconcilable, err := dn.reconcilable(currentConfig, desiredConfig)
 	if err != nil {
-		return false, "", err
+		return false, nil, err
 	}
 	if !reconcilable {
-		return false, "", nil
+		return false, nil, nil
 	}
 
 	isDesiredOS := false
@@ -543,18 +869,18 @@ func (dn *Daemon) isDesiredMachineState() (bool, string, error) {
 	} else {
 		isDesiredOS, err = dn.checkOS(desiredConfig.Spec.OSImageURL)
 		if err != nil {
-			return false, "", err
+			return false, nil, err
 		}
 	}
 
 	if dn.checkFiles(desiredConfig.Spec.Config.Storage.Files) &&
 		dn.checkUnits(desiredConfig.Spec.Config.Systemd.Units) &&
 		isDesiredOS {
-		return true, dcAnnotation, nil
+		return true, desiredConfig, nil
 	}
 
 	// error is nil, as we successfully decided that validate is false
-	return false, "", nil
+	return false, nil, nil
 }
 
 // isUnspecifiedOS says whether an osImageURL is "unspecified",
@@ -614,16 +940,17 @@ func (dn *Daemon) checkUnits(units []ignv2_2types.Unit) bool {
 // target config.
 func (dn *Daemon) checkFiles(files []ignv2_2types.File) bool {
 	for _, f := range files {
-		mode := DefaultFilePermissions
-		if f.Mode != nil {
-			mode = os.FileMode(*f.Mode)
-		}
-		contents, err := dataurl.DecodeString(f.Contents.Source)
+		contents, mode, err := dn.fileContents(f)
 		if err != nil {
 			glog.Errorf("couldn't parse file: %v", err)
 			return false
 		}
-		if status := checkFileContentsAndMode(f.Path, string(contents.Data), mode); !status {
+		path, err := dn.writablePath(f.Path)
+		if err != nil {
+			glog.Errorf("couldn't find file: %v", err)
+			return false
+		}
+		if status := checkFileContentsAndMode(path, string(contents), mode); !status {
 			return false
 		}
 	}
@@ -687,6 +1014,19 @@ func ValidPath(path string) bool {
 	return false
 }
 
+// fetchOnceFrom reads the content and the response headers from the remote
+// endpoint at url.
+func (dn *Daemon) fetchOnceFrom(url string) ([]byte, http.Header, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	// Read the body content from the request
+	content, err := dn.fileSystemClient.ReadAll(resp.Body)
+	return content, resp.Header, err
+}
+
 // SenseAndLoadOnceFrom gets a hold of the content for supported onceFrom configurations,
 // parses to verify the type, and returns back the genericInterface, the type description,
 // if it was local or remote, and error.
@@ -697,13 +1037,7 @@ func (dn *Daemon) SenseAndLoadOnceFrom() (interface{}, string, string, error) {
 	// Read the content from a remote endpoint if requested
 	if strings.HasPrefix(dn.onceFrom, "http://") || strings.HasPrefix(dn.onceFrom, "https://") {
 		contentFrom = MachineConfigOnceFromRemoteConfig
-		resp, err := http.Get(dn.onceFrom)
-		if err != nil {
-			return nil, "", contentFrom, err
-		}
-		defer resp.Body.Close()
-		// Read the body content from the request
-		content, err = dn.fileSystemClient.ReadAll(resp.Body)
+		content, err = fetchUnexpiredConfig(dn.onceFrom, dn.fetchOnceFrom, time.Now, time.Sleep)
 		if err != nil {
 			return nil, "", contentFrom, err
 		}
@@ -721,7 +1055,17 @@ func (dn *Daemon) SenseAndLoadOnceFrom() (interface{}, string, string, error) {
 		}
 	}
 
-	// Try each supported parser
+	// Try each supported parser, Ignition v3 configs are picked by their
+	// version as the v2 parser doesn't know about them.
+	if version := ignitionConfigVersion(content); isIgnitionV3(version) {
+		ignConfig, err := parseIgnitionV3(content)
+		if err != nil {
+			return nil, "", contentFrom, err
+		}
+		glog.V(2).Infof("onceFrom file is of type Ignition v3")
+		return ignConfig, MachineConfigIgnitionV3FileType, contentFrom, nil
+	}
+
 	ignConfig, _, err := ignv2.Parse(content)
 	if err == nil && ignConfig.Ignition.Version != "" {
 		glog.V(2).Infof("onceFrom file is of type Ignition")
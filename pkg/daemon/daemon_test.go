@@ -1,8 +1,21 @@
 package daemon
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 var pathtests = []struct {
@@ -25,3 +38,365 @@ func TestValidPath(t *testing.T) {
 		}
 	}
 }
+
+// TestRebootDowntime simulates a reboot cycle and verifies the downtime is
+// recorded on the node once the update completes.
+func TestRebootDowntime(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	kubeClient := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nodeName",
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey: "old",
+				DesiredMachineConfigAnnotationKey: "new",
+			},
+		},
+	})
+	d := Daemon{
+		name:       "nodeName",
+		kubeClient: kubeClient,
+		nodeWriter: nw,
+	}
+
+	// the reboot is triggered...
+	rebootStart := time.Now().Add(-90 * time.Second)
+	if err := nw.SetRebootStart(kubeClient.CoreV1().Nodes(), d.name, rebootStart); err != nil {
+		t.Fatalf("Expected no error setting reboot start. Got %s.", err)
+	}
+	// ...and the daemon completes the update after coming back up
+	if err := d.completeUpdate("new"); err != nil {
+		t.Fatalf("Expected no error completing update. Got %s.", err)
+	}
+
+	node, err := kubeClient.CoreV1().Nodes().Get(d.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	downtime, err := time.ParseDuration(node.Annotations[MachineConfigDaemonRebootDowntimeAnnotationKey])
+	if err != nil {
+		t.Fatalf("Expected a valid reboot downtime. Got %s.", err)
+	}
+	if downtime < 90*time.Second || downtime > 100*time.Second {
+		t.Errorf("Expected reboot downtime of about 90s. Got %v.", downtime)
+	}
+	if start := node.Annotations[MachineConfigDaemonRebootStartAnnotationKey]; start != "" {
+		t.Errorf("Expected reboot start to be cleared. Got %q.", start)
+	}
+	if current := node.Annotations[CurrentMachineConfigAnnotationKey]; current != "new" {
+		t.Errorf("Expected current config to be new. Got %q.", current)
+	}
+
+	// completing an update without a preceding reboot leaves the last downtime alone
+	if err := d.completeUpdate("new"); err != nil {
+		t.Fatalf("Expected no error completing update. Got %s.", err)
+	}
+	node, err = kubeClient.CoreV1().Nodes().Get(d.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[MachineConfigDaemonRebootDowntimeAnnotationKey]; got != downtime.String() {
+		t.Errorf("Expected reboot downtime to stay %v. Got %s.", downtime, got)
+	}
+}
+
+// TestForceSync verifies that a force sync request re-applies the current
+// config, correcting drifted files, and is cleared once handled.
+func TestForceSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-force-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tampered := filepath.Join(dir, "etc", "tampered")
+	removed := filepath.Join(dir, "etc", "removed")
+	config := &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "config"},
+		Spec: mcfgv1.MachineConfigSpec{
+			Config: ignv2_2types.Config{
+				Storage: ignv2_2types.Storage{
+					Files: []ignv2_2types.File{{
+						Node:          ignv2_2types.Node{Path: tampered},
+						FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,expected"}},
+					}, {
+						Node:          ignv2_2types.Node{Path: removed},
+						FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,expected"}},
+					}},
+				},
+			},
+		},
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	kubeClient := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nodeName",
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey:     "config",
+				DesiredMachineConfigAnnotationKey:     "config",
+				MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateDone,
+			},
+		},
+	})
+	exitCh := make(chan error, 1)
+	d := Daemon{
+		name:             "nodeName",
+		client:           fake.NewSimpleClientset(config),
+		kubeClient:       kubeClient,
+		fileSystemClient: NewFileSystemClient(),
+		nodeWriter:       nw,
+		exitCh:           exitCh,
+	}
+
+	// the node is on its config, then an admin tampers with it
+	if err := d.writeFiles(config.Spec.Config.Storage.Files); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tampered, []byte("tampered"), DefaultFilePermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+
+	getNode := func() *corev1.Node {
+		node, err := kubeClient.CoreV1().Nodes().Get(d.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+
+	// without a request the drift isn't noticed
+	d.handleNodeUpdate(nil, getNode())
+	if checkFileContentsAndMode(tampered, "expected", DefaultFilePermissions) {
+		t.Fatal("Expected drift to be left alone without a force sync request")
+	}
+
+	node := getNode()
+	node.Annotations[MachineConfigDaemonForceSyncAnnotationKey] = "1"
+	if _, err := kubeClient.CoreV1().Nodes().Update(node); err != nil {
+		t.Fatal(err)
+	}
+	d.handleNodeUpdate(nil, getNode())
+	select {
+	case err := <-exitCh:
+		t.Fatalf("Expected no error force syncing. Got %s.", err)
+	default:
+	}
+
+	for _, path := range []string{tampered, removed} {
+		if !checkFileContentsAndMode(path, "expected", DefaultFilePermissions) {
+			t.Errorf("Expected drifted file %s to be corrected", path)
+		}
+	}
+	node = getNode()
+	if got := node.Annotations[MachineConfigDaemonForceSyncAnnotationKey]; got != "" {
+		t.Errorf("Expected force sync request to be cleared. Got %q.", got)
+	}
+	if got := node.Annotations[MachineConfigDaemonStateAnnotationKey]; got != MachineConfigDaemonStateDone {
+		t.Errorf("Expected state to be Done. Got %q.", got)
+	}
+}
+
+// TestForceSyncDegraded verifies that force syncing a degraded node repairs
+// it.
+func TestForceSyncDegraded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-force-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tampered := filepath.Join(dir, "tampered")
+	config := &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: "config"}}
+	config.Spec.Config.Storage.Files = []ignv2_2types.File{{
+		Node:          ignv2_2types.Node{Path: tampered},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,expected"}},
+	}}
+	if err := ioutil.WriteFile(tampered, []byte("tampered"), DefaultFilePermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nodeName",
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey:         "config",
+				DesiredMachineConfigAnnotationKey:         "config",
+				MachineConfigDaemonStateAnnotationKey:     MachineConfigDaemonStateDegraded,
+				MachineConfigDaemonForceSyncAnnotationKey: "1",
+			},
+		},
+	}
+	kubeClient := k8sfake.NewSimpleClientset(node)
+	exitCh := make(chan error, 1)
+	d := Daemon{
+		name:             "nodeName",
+		client:           fake.NewSimpleClientset(config),
+		kubeClient:       kubeClient,
+		fileSystemClient: NewFileSystemClient(),
+		nodeWriter:       nw,
+		exitCh:           exitCh,
+	}
+	d.handleNodeUpdate(nil, node)
+	select {
+	case err := <-exitCh:
+		t.Fatalf("Expected no error force syncing a degraded node. Got %s.", err)
+	default:
+	}
+
+	if !checkFileContentsAndMode(tampered, "expected", DefaultFilePermissions) {
+		t.Errorf("Expected drifted file %s to be corrected", tampered)
+	}
+	node, err = kubeClient.CoreV1().Nodes().Get(d.name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[MachineConfigDaemonStateAnnotationKey]; got != MachineConfigDaemonStateDone {
+		t.Errorf("Expected state to be Done. Got %q.", got)
+	}
+}
+
+// TestForceSyncOSDrift verifies that a drifted OS is corrected by the regular
+// update, which waits for the approval of the reboot on nodes requiring it.
+func TestForceSyncOSDrift(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-force-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	config := &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: "config"}}
+	config.Spec.OSImageURL = "quay.io/openshift/os:2"
+	kubeClient := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "nodeName",
+			Labels: map[string]string{MachineConfigDaemonRebootApprovalRequiredLabelKey: "true"},
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey:     "config",
+				DesiredMachineConfigAnnotationKey:     "config",
+				MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateDone,
+			},
+		},
+	})
+	recorder := record.NewFakeRecorder(10)
+	daemonStopCh := make(chan struct{})
+	d := Daemon{
+		name:              "nodeName",
+		OperatingSystem:   MachineConfigDaemonOSRHCOS,
+		NodeUpdaterClient: RpmOstreeClientMock{RunPivotReturns: []error{nil}},
+		bootedOSImageURL:  "quay.io/openshift/os:1",
+		client:            fake.NewSimpleClientset(config),
+		kubeClient:        kubeClient,
+		fileSystemClient:  rootFsClient{root: root},
+		nodeWriter:        nw,
+		recorder:          recorder,
+		stopCh:            daemonStopCh,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- d.forceSync()
+	}()
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "WaitingForRebootApproval") {
+			t.Errorf("Unexpected event: %s", event)
+		}
+	case err := <-done:
+		t.Fatalf("Expected the reboot to wait for approval. Got %v.", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the reboot to wait for approval")
+	}
+	close(daemonStopCh)
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "approved") {
+		t.Errorf("Expected the daemon to stop waiting for approval. Got %v.", err)
+	}
+}
+
+// TestSplitDriftedUnits verifies that only the drifted units that opt in and
+// aren't node critical are restarted in place.
+func TestSplitDriftedUnits(t *testing.T) {
+	inPlace := "[Unit]\nX-MachineConfigRestartInPlace=true\n"
+	units := []ignv2_2types.Unit{
+		{Name: "foo.service", Contents: inPlace},
+		{Name: "bar.service", Contents: "[Unit]\n"},
+		{Name: "crio.service", Contents: inPlace},
+	}
+	restart, reboot := splitDriftedUnits(units)
+	if len(restart) != 1 || restart[0].Name != "foo.service" {
+		t.Errorf("Expected only foo.service to be restarted. Got %v.", restart)
+	}
+	if len(reboot) != 2 || reboot[0].Name != "bar.service" || reboot[1].Name != "crio.service" {
+		t.Errorf("Expected bar.service and crio.service to take a reboot. Got %v.", reboot)
+	}
+}
+
+// TestVerifyNodeRejoined simulates coming back from a reboot with a node that
+// rejoined the cluster and one that didn't.
+func TestVerifyNodeRejoined(t *testing.T) {
+	newNode := func(rebootStart string, status corev1.ConditionStatus) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "nodeName",
+				Annotations: map[string]string{
+					MachineConfigDaemonRebootStartAnnotationKey: rebootStart,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{
+					Type:    corev1.NodeReady,
+					Status:  status,
+					Reason:  "NodeStatusUnknown",
+					Message: "Kubelet stopped posting node status.",
+				}},
+			},
+		}
+	}
+	rebootStart := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	// the node rejoined
+	d := Daemon{
+		name:             "nodeName",
+		kubeClient:       k8sfake.NewSimpleClientset(newNode(rebootStart, corev1.ConditionTrue)),
+		nodeReadyTimeout: 100 * time.Millisecond,
+	}
+	if err := d.verifyNodeRejoined(); err != nil {
+		t.Errorf("Expected no error for a rejoined node. Got %s.", err)
+	}
+
+	// the kubelet never came back
+	d.kubeClient = k8sfake.NewSimpleClientset(newNode(rebootStart, corev1.ConditionUnknown))
+	err := d.verifyNodeRejoined()
+	if err == nil {
+		t.Fatal("Expected an error for a node that did not rejoin")
+	}
+	if !strings.Contains(err.Error(), "Kubelet stopped posting node status.") {
+		t.Errorf("Expected the error to include the node status. Got %s.", err)
+	}
+
+	// without a reboot from the daemon there is nothing to verify
+	d.kubeClient = k8sfake.NewSimpleClientset(newNode("", corev1.ConditionUnknown))
+	if err := d.verifyNodeRejoined(); err != nil {
+		t.Errorf("Expected no error without a reboot. Got %s.", err)
+	}
+}
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	drain "github.com/openshift/kubernetes-drain"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// drainBlockedPollInterval is how often the pods blocking a drain are looked
+// up while the drain runs.
+const drainBlockedPollInterval = 15 * time.Second
+
+// drainNode drains the node, reporting the pods whose eviction is blocked by
+// PodDisruptionBudgets on the node while the drain is blocked.
+func (dn *Daemon) drainNode(node *corev1.Node) error {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchDrainBlockingPods(dn.kubeClient, node.Name, drainBlockedPollInterval, stop, func(value string) error {
+			return dn.nodeWriter.SetDrainBlockedBy(dn.kubeClient.CoreV1().Nodes(), dn.name, value)
+		})
+	}()
+
+	err := drain.Drain(dn.kubeClient, []*corev1.Node{node}, &drain.DrainOptions{
+		DeleteLocalData:    true,
+		Force:              true,
+		GracePeriodSeconds: 600,
+		IgnoreDaemonsets:   true,
+	})
+	close(stop)
+	<-done
+	return err
+}
+
+// watchDrainBlockingPods looks up the pods blocking the drain of the node every
+// interval and reports them whenever they change, until stop is closed. Pods
+// that were reported blocking are cleared by reporting an empty value.
+func watchDrainBlockingPods(client kubernetes.Interface, node string, interval time.Duration, stop <-chan struct{}, report func(string) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := ""
+	for {
+		select {
+		case <-stop:
+			if reported != "" {
+				if err := report(""); err != nil {
+					glog.Warningf("Unable to clear the pods blocking the drain: %v", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+
+		pods, err := findDrainBlockingPods(client, node)
+		if err != nil {
+			glog.Warningf("Unable to find the pods blocking the drain: %v", err)
+			continue
+		}
+		value := formatDrainBlockingPods(pods)
+		if value == reported {
+			continue
+		}
+		if value != "" {
+			glog.Infof("Drain of node %s is blocked by PodDisruptionBudgets: %s", node, value)
+		}
+		if err := report(value); err != nil {
+			glog.Warningf("Unable to report the pods blocking the drain: %v", err)
+			continue
+		}
+		reported = value
+	}
+}
+
+// findDrainBlockingPods returns the pods on the node that can't be evicted
+// because a PodDisruptionBudget selecting them allows no disruption.
+func findDrainBlockingPods(client kubernetes.Interface, node string) ([]mcfgv1.DrainBlockingPod, error) {
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods on node %s: %v", node, err)
+	}
+
+	pdbs := map[string][]policyv1beta1.PodDisruptionBudget{}
+	var blocking []mcfgv1.DrainBlockingPod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != node || !isEvictedOnDrain(pod) {
+			continue
+		}
+		nsPDBs, ok := pdbs[pod.Namespace]
+		if !ok {
+			list, err := client.PolicyV1beta1().PodDisruptionBudgets(pod.Namespace).List(metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list PodDisruptionBudgets in namespace %s: %v", pod.Namespace, err)
+			}
+			nsPDBs = list.Items
+			pdbs[pod.Namespace] = nsPDBs
+		}
+		for _, pdb := range nsPDBs {
+			if pdb.Status.PodDisruptionsAllowed > 0 {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			// like the eviction API, an empty selector selects no pods.
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			blocking = append(blocking, mcfgv1.DrainBlockingPod{
+				Namespace:           pod.Namespace,
+				Name:                pod.Name,
+				PodDisruptionBudget: pdb.Name,
+			})
+		}
+	}
+
+	sort.Slice(blocking, func(i, j int) bool {
+		return formatDrainBlockingPod(blocking[i]) < formatDrainBlockingPod(blocking[j])
+	})
+	return blocking, nil
+}
+
+// isEvictedOnDrain returns true if the pod is evicted by the drain, i.e. it is
+// running and neither a mirror pod nor managed by a DaemonSet.
+func isEvictedOnDrain(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// formatDrainBlockingPod formats the pod as namespace/name:pdb.
+func formatDrainBlockingPod(pod mcfgv1.DrainBlockingPod) string {
+	return fmt.Sprintf("%s/%s:%s", pod.Namespace, pod.Name, pod.PodDisruptionBudget)
+}
+
+// formatDrainBlockingPods formats the pods for the drain blocked annotation.
+func formatDrainBlockingPods(pods []mcfgv1.DrainBlockingPod) string {
+	var vals []string
+	for _, pod := range pods {
+		vals = append(vals, formatDrainBlockingPod(pod))
+	}
+	return strings.Join(vals, ",")
+}
+
+// ParseDrainBlockingPods parses the value of the drain blocked annotation.
+// Malformed entries are skipped.
+func ParseDrainBlockingPods(value string) []mcfgv1.DrainBlockingPod {
+	var pods []mcfgv1.DrainBlockingPod
+	for _, entry := range strings.Split(value, ",") {
+		podPDB := strings.SplitN(entry, ":", 2)
+		if len(podPDB) != 2 {
+			continue
+		}
+		parts := strings.SplitN(podPDB[0], "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		pods = append(pods, mcfgv1.DrainBlockingPod{
+			Namespace:           parts[0],
+			Name:                parts[1],
+			PodDisruptionBudget: podPDB[1],
+		})
+	}
+	return pods
+}
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newDrainTestPod(namespace, name, node string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func newDrainTestPDB(namespace, name string, selector map[string]string, allowed int32) *policyv1beta1.PodDisruptionBudget {
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       policyv1beta1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: selector}},
+		Status:     policyv1beta1.PodDisruptionBudgetStatus{PodDisruptionsAllowed: allowed},
+	}
+}
+
+func newDrainTestClient() *k8sfake.Clientset {
+	daemonSetPod := newDrainTestPod("openshift-sdn", "sdn-abcde", "node-0", map[string]string{"app": "sdn"})
+	daemonSetPod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "sdn", Controller: boolToPtr(true)}}
+	finishedPod := newDrainTestPod("db", "db-job", "node-0", map[string]string{"app": "db"})
+	finishedPod.Status.Phase = corev1.PodSucceeded
+
+	objs := []runtime.Object{
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0", Annotations: map[string]string{}}},
+		newDrainTestPod("db", "db-0", "node-0", map[string]string{"app": "db"}),
+		newDrainTestPod("db", "db-1", "node-1", map[string]string{"app": "db"}),
+		newDrainTestPod("web", "web-0", "node-0", map[string]string{"app": "web"}),
+		newDrainTestPod("web", "web-1", "node-0", map[string]string{"app": "cache"}),
+		daemonSetPod,
+		finishedPod,
+		// blocks db-0, the others allow disruptions or don't select any pod on the node.
+		newDrainTestPDB("db", "db-pdb", map[string]string{"app": "db"}, 0),
+		newDrainTestPDB("web", "web-pdb", map[string]string{"app": "web"}, 1),
+		newDrainTestPDB("openshift-sdn", "sdn-pdb", map[string]string{"app": "sdn"}, 0),
+		newDrainTestPDB("web", "all-pdb", nil, 0),
+	}
+	return k8sfake.NewSimpleClientset(objs...)
+}
+
+func boolToPtr(b bool) *bool {
+	return &b
+}
+
+func TestFindDrainBlockingPods(t *testing.T) {
+	client := newDrainTestClient()
+	pods, err := findDrainBlockingPods(client, "node-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []mcfgv1.DrainBlockingPod{{Namespace: "db", Name: "db-0", PodDisruptionBudget: "db-pdb"}}
+	if !reflect.DeepEqual(pods, exp) {
+		t.Errorf("expected blocking pods %v, got: %v", exp, pods)
+	}
+
+	// once the PDB allows a disruption nothing blocks the drain.
+	pdb, err := client.PolicyV1beta1().PodDisruptionBudgets("db").Get("db-pdb", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdb.Status.PodDisruptionsAllowed = 1
+	if _, err := client.PolicyV1beta1().PodDisruptionBudgets("db").Update(pdb); err != nil {
+		t.Fatal(err)
+	}
+	if pods, err := findDrainBlockingPods(client, "node-0"); err != nil || len(pods) != 0 {
+		t.Errorf("expected no blocking pods, got: %v, error: %v", pods, err)
+	}
+}
+
+func TestDrainBlockingPodsAnnotation(t *testing.T) {
+	pods := []mcfgv1.DrainBlockingPod{
+		{Namespace: "db", Name: "db-0", PodDisruptionBudget: "db-pdb"},
+		{Namespace: "web", Name: "web-0", PodDisruptionBudget: "web-pdb"},
+	}
+	value := formatDrainBlockingPods(pods)
+	if exp := "db/db-0:db-pdb,web/web-0:web-pdb"; value != exp {
+		t.Errorf("expected %q, got: %q", exp, value)
+	}
+	if got := ParseDrainBlockingPods(value); !reflect.DeepEqual(got, pods) {
+		t.Errorf("expected %v, got: %v", pods, got)
+	}
+	if got := ParseDrainBlockingPods(""); got != nil {
+		t.Errorf("expected no pods for an empty value, got: %v", got)
+	}
+	if got := ParseDrainBlockingPods("db-0,db/db-0"); got != nil {
+		t.Errorf("expected malformed entries to be skipped, got: %v", got)
+	}
+}
+
+func TestWatchDrainBlockingPods(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+	client := newDrainTestClient()
+	nodes := client.CoreV1().Nodes()
+
+	reported := make(chan string, 10)
+	report := func(value string) error {
+		if err := nw.SetDrainBlockedBy(nodes, "node-0", value); err != nil {
+			return err
+		}
+		reported <- value
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchDrainBlockingPods(client, "node-0", time.Millisecond, stop, report)
+	}()
+
+	select {
+	case value := <-reported:
+		if exp := "db/db-0:db-pdb"; value != exp {
+			t.Fatalf("expected %q to be reported, got: %q", exp, value)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the blocking pods to be reported")
+	}
+	node, err := nodes.Get("node-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[MachineConfigDaemonDrainBlockedByAnnotationKey]; got != "db/db-0:db-pdb" {
+		t.Errorf("expected the blocking pods on the node, got: %q", got)
+	}
+
+	// the blocking pods are cleared once the drain is done.
+	close(stop)
+	<-done
+	node, err = nodes.Get("node-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[MachineConfigDaemonDrainBlockedByAnnotationKey]; got != "" {
+		t.Errorf("expected the blocking pods to be cleared, got: %q", got)
+	}
+}
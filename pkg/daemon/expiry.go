@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// maxConfigRefetches is how many times an expired config is fetched
+	// again before giving up.
+	maxConfigRefetches = 3
+	// configRefetchInterval is the time between fetching an expired config
+	// and fetching it again.
+	configRefetchInterval = 5 * time.Second
+)
+
+// configExpiry returns when the config expires, from the Expires header the
+// Machine Config Server served it with. ok is false for configs that don't
+// expire.
+func configExpiry(header http.Header) (time.Time, bool, error) {
+	value := header.Get("Expires")
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	expires, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to parse Expires header %q: %v", value, err)
+	}
+	return expires, true, nil
+}
+
+// fetchUnexpiredConfig fetches the content from url, fetching it again if it
+// has expired, as a cached copy would be. Content served without an Expires
+// header is returned as is.
+func fetchUnexpiredConfig(url string, fetch func(string) ([]byte, http.Header, error), now func() time.Time, sleep func(time.Duration)) ([]byte, error) {
+	for i := 0; ; i++ {
+		content, header, err := fetch(url)
+		if err != nil {
+			return nil, err
+		}
+		expires, ok, err := configExpiry(header)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || now().Before(expires) {
+			return content, nil
+		}
+		if i == maxConfigRefetches {
+			return nil, fmt.Errorf("config from %s expired at %s, still expired after fetching it %d more times", url, expires.Format(time.RFC3339), maxConfigRefetches)
+		}
+		glog.Infof("Config from %s expired at %s; fetching it again in %v", url, expires.Format(time.RFC3339), configRefetchInterval)
+		sleep(configRefetchInterval)
+	}
+}
@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// expiringResponse is a response to the fetch of a config.
+type expiringResponse struct {
+	content string
+	expires string
+}
+
+func TestFetchUnexpiredConfig(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	expired := expiringResponse{"expired", "Tue, 01 Jan 2019 11:00:00 GMT"}
+	valid := expiringResponse{"valid", "Tue, 01 Jan 2019 13:00:00 GMT"}
+
+	tests := []struct {
+		name      string
+		responses []expiringResponse
+		fetches   int
+		expected  string
+		err       bool
+	}{{
+		name:      "valid",
+		responses: []expiringResponse{valid},
+		fetches:   1,
+		expected:  "valid",
+	}, {
+		name:      "no expiry",
+		responses: []expiringResponse{{content: "config"}},
+		fetches:   1,
+		expected:  "config",
+	}, {
+		name:      "expired then valid",
+		responses: []expiringResponse{expired, expired, valid},
+		fetches:   3,
+		expected:  "valid",
+	}, {
+		name:      "always expired",
+		responses: []expiringResponse{expired, expired, expired, expired, expired},
+		fetches:   maxConfigRefetches + 1,
+		err:       true,
+	}, {
+		name:      "invalid expiry",
+		responses: []expiringResponse{{"config", "tomorrow"}},
+		fetches:   1,
+		err:       true,
+	}}
+
+	for _, test := range tests {
+		fetches := 0
+		fetch := func(u string) ([]byte, http.Header, error) {
+			if u != "https://mcs/config/worker" {
+				return nil, nil, fmt.Errorf("unexpected url %s", u)
+			}
+			fetches++
+			resp := test.responses[fetches-1]
+			header := http.Header{}
+			if resp.expires != "" {
+				header.Set("Expires", resp.expires)
+			}
+			return []byte(resp.content), header, nil
+		}
+		slept := 0
+		sleep := func(d time.Duration) { slept++ }
+
+		content, err := fetchUnexpiredConfig("https://mcs/config/worker", fetch, func() time.Time { return now }, sleep)
+		if test.err != (err != nil) {
+			t.Errorf("%s: expected error %t, got: %v", test.name, test.err, err)
+		}
+		if string(content) != test.expected {
+			t.Errorf("%s: expected content %s, got: %s", test.name, test.expected, content)
+		}
+		if fetches != test.fetches {
+			t.Errorf("%s: expected %d fetches, got: %d", test.name, test.fetches, fetches)
+		}
+		if exp := test.fetches - 1; !test.err && slept != exp {
+			t.Errorf("%s: expected %d waits before fetching again, got: %d", test.name, exp, slept)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"os"
+	"strconv"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// defaultModeForUmask returns the mode of the files written without one when
+// umask is applied, the same way open(2) applies it to 0666.
+func defaultModeForUmask(umask os.FileMode) os.FileMode {
+	return 0666 &^ umask
+}
+
+// parseFileUser returns the user owning files given a user name or a uid, nil
+// if owner is empty.
+func parseFileUser(owner string) *ignv2_2types.NodeUser {
+	if owner == "" {
+		return nil
+	}
+	if id, err := strconv.Atoi(owner); err == nil {
+		return &ignv2_2types.NodeUser{ID: &id}
+	}
+	return &ignv2_2types.NodeUser{Name: owner}
+}
+
+// parseFileGroup returns the group owning files given a group name or a gid,
+// nil if group is empty.
+func parseFileGroup(group string) *ignv2_2types.NodeGroup {
+	if group == "" {
+		return nil
+	}
+	if id, err := strconv.Atoi(group); err == nil {
+		return &ignv2_2types.NodeGroup{ID: &id}
+	}
+	return &ignv2_2types.NodeGroup{Name: group}
+}
+
+// defaultFileMode returns the mode of the files of the config written without
+// one.
+func (dn *Daemon) defaultFileMode() os.FileMode {
+	if dn.fileMode == 0 {
+		return DefaultFilePermissions
+	}
+	return dn.fileMode
+}
+
+// withDefaultOwnership returns the file owned by the default user and group
+// wherever it doesn't set its own.
+func (dn *Daemon) withDefaultOwnership(f ignv2_2types.File) ignv2_2types.File {
+	if f.User == nil {
+		f.User = dn.fileUser
+	}
+	if f.Group == nil {
+		f.Group = dn.fileGroup
+	}
+	return f
+}
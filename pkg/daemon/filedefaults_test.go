@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestWriteFilesDefaultMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-filedefaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	explicit := 0644
+	files := []ignv2_2types.File{{
+		Node:          ignv2_2types.Node{Path: filepath.Join(dir, "default")},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,default"}},
+	}, {
+		Node:          ignv2_2types.Node{Path: filepath.Join(dir, "explicit")},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,explicit"}, Mode: &explicit},
+	}}
+	d := Daemon{
+		fileSystemClient: FsClient{},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileMode:         defaultModeForUmask(0077),
+	}
+	if err := d.writeFiles(files); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	if !checkFileContentsAndMode(files[0].Path, "default", 0600) {
+		t.Errorf("expected the file without a mode to be written with the umask applied")
+	}
+	if !checkFileContentsAndMode(files[1].Path, "explicit", 0644) {
+		t.Errorf("expected the file with a mode to keep it")
+	}
+	// the same mode is expected when validating the files on disk.
+	if !d.checkFiles(files) {
+		t.Errorf("expected the written files to be valid")
+	}
+
+	// without a umask the default mode is kept.
+	if mode := (&Daemon{}).defaultFileMode(); mode != DefaultFilePermissions {
+		t.Errorf("expected mode %v, got %v", DefaultFilePermissions, mode)
+	}
+	if mode := defaultModeForUmask(0022); mode != DefaultFilePermissions {
+		t.Errorf("expected mode %v for umask 022, got %v", DefaultFilePermissions, mode)
+	}
+}
+
+func TestWriteFilesDefaultOwnership(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-filedefaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uid, gid := os.Getuid(), os.Getgid()
+	owned := ignv2_2types.File{
+		Node: ignv2_2types.Node{
+			Path:  filepath.Join(dir, "owned"),
+			User:  &ignv2_2types.NodeUser{ID: &uid},
+			Group: &ignv2_2types.NodeGroup{ID: &gid},
+		},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,owned"}},
+	}
+	unowned := ignv2_2types.File{
+		Node:          ignv2_2types.Node{Path: filepath.Join(dir, "unowned")},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,unowned"}},
+	}
+	d := Daemon{
+		fileSystemClient: FsClient{},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileUser:         parseFileUser("mcd-no-such-user"),
+		fileGroup:        parseFileGroup("mcd-no-such-group"),
+	}
+
+	// explicit owners win over the defaults, so the unknown defaults are never looked up.
+	if err := d.writeFiles([]ignv2_2types.File{owned}); err != nil {
+		t.Errorf("expected the file with an owner to keep it, got: %v", err)
+	}
+	err = d.writeFiles([]ignv2_2types.File{unowned})
+	if err == nil || !strings.Contains(err.Error(), "Failed to retrieve file ownership") {
+		t.Errorf("expected the default owner to be used for the file without one, got: %v", err)
+	}
+
+	d.fileUser, d.fileGroup = parseFileUser(""), parseFileGroup("")
+	if got := d.withDefaultOwnership(unowned); got.User != nil || got.Group != nil {
+		t.Errorf("expected no owner without defaults, got %+v and %+v", got.User, got.Group)
+	}
+
+	d.fileUser, d.fileGroup = parseFileUser("1000"), parseFileGroup("wheel")
+	got := d.withDefaultOwnership(unowned)
+	if id := 1000; !reflect.DeepEqual(got.User, &ignv2_2types.NodeUser{ID: &id}) {
+		t.Errorf("expected the default uid, got %+v", got.User)
+	}
+	if !reflect.DeepEqual(got.Group, &ignv2_2types.NodeGroup{Name: "wheel"}) {
+		t.Errorf("expected the default group, got %+v", got.Group)
+	}
+	if got := d.withDefaultOwnership(owned); got.User != owned.User || got.Group != owned.Group {
+		t.Errorf("expected the explicit owner to win, got %+v and %+v", got.User, got.Group)
+	}
+}
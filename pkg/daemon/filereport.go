@@ -0,0 +1,212 @@
+package daemon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// fileReportPath is where the outcome of each file of the last update
+	// is reported.
+	fileReportPath = "/var/lib/machine-config-daemon/file-report.json"
+
+	// FileResultWritten is reported for a file written to disk.
+	FileResultWritten = "Written"
+	// FileResultUnchanged is reported for a file skipped because it was
+	// already on disk with its contents, mode and ownership.
+	FileResultUnchanged = "SkippedUnchanged"
+	// FileResultRemoved is reported for a file of the previous config that
+	// was removed because the config doesn't have it anymore.
+	FileResultRemoved = "Removed"
+	// FileResultModified is reported for a file of the previous config that
+	// the config doesn't have anymore but was left in place, because it was
+	// modified outside of MachineConfigs.
+	FileResultModified = "SkippedModified"
+	// FileResultFailed is reported for a file that failed to be written,
+	// removed or rolled back.
+	FileResultFailed = "Failed"
+	// FileResultRolledBack is reported for a file restored to what it was
+	// before the update, because writing another file failed.
+	FileResultRolledBack = "RolledBack"
+)
+
+// FileOutcome is the outcome of applying a file of a config.
+type FileOutcome struct {
+	Path   string `json:"path"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// SHA256 is the hash of the contents of a file written or found
+	// unchanged, the next update checks against it that the file wasn't
+	// modified before removing it.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// contentsHash returns the hash of the contents as reported in FileOutcome.
+func contentsHash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileReport is the outcome of applying each file of a config, and of
+// removing the files of the previous config it doesn't have, in path order.
+// Files that weren't attempted because an earlier one failed are not listed,
+// nor are the files of the previous config when writing a file failed.
+type FileReport struct {
+	Time   time.Time     `json:"time"`
+	Config string        `json:"config"`
+	Files  []FileOutcome `json:"files"`
+}
+
+// fileRecorder collects the outcomes of the files of an update, it is safe
+// for concurrent use. A nil fileRecorder discards them.
+type fileRecorder struct {
+	mu       sync.Mutex
+	outcomes []FileOutcome
+}
+
+// record records the result of applying the file at path.
+func (r *fileRecorder) record(path, result string, err error) {
+	if r == nil {
+		return
+	}
+	outcome := FileOutcome{Path: path, Result: result}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, outcome)
+}
+
+// recordContents records the result of applying the file at path, which is
+// on disk with the contents.
+func (r *fileRecorder) recordContents(path, result string, contents []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, FileOutcome{Path: path, Result: result, SHA256: contentsHash(contents)})
+}
+
+// report returns the report of the recorded outcomes for config. Entries for
+// the same path keep the order they were applied in.
+func (r *fileRecorder) report(config string) *FileReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := append([]FileOutcome{}, r.outcomes...)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return &FileReport{Time: time.Now().UTC(), Config: config, Files: files}
+}
+
+// summary returns how many files had each result, e.g. "2 written, 1 failed".
+func (rep *FileReport) summary() string {
+	counts := map[string]int{}
+	for _, f := range rep.Files {
+		counts[f.Result]++
+	}
+	var buf bytes.Buffer
+	for _, result := range []struct{ result, name string }{
+		{FileResultWritten, "written"},
+		{FileResultUnchanged, "unchanged"},
+		{FileResultRemoved, "removed"},
+		{FileResultModified, "kept modified"},
+		{FileResultFailed, "failed"},
+		{FileResultRolledBack, "rolled back"},
+	} {
+		if buf.Len() > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%d %s", counts[result.result], result.name)
+	}
+	return buf.String()
+}
+
+// writeFileReport writes the report of the files recorded for config, so the
+// file that broke an update can be found on the node. Failing to write the
+// report doesn't fail the update.
+func (dn *Daemon) writeFileReport(config string, r *fileRecorder) {
+	rep := r.report(config)
+	glog.Infof("Files of config %s: %s", config, rep.summary())
+	for _, f := range rep.Files {
+		switch f.Result {
+		case FileResultFailed:
+			glog.Warningf("File %q failed: %s", f.Path, f.Error)
+		case FileResultModified:
+			glog.Warningf("File %q was removed from the config but modified outside of MachineConfigs, it was kept", f.Path)
+		}
+	}
+
+	data, err := json.Marshal(rep)
+	if err == nil {
+		if err = dn.fileSystemClient.MkdirAll(filepath.Dir(fileReportPath), DefaultDirectoryPermissions); err == nil {
+			err = dn.fileSystemClient.WriteFile(fileReportPath, data, DefaultFilePermissions)
+		}
+	}
+	if err != nil {
+		glog.Warningf("Failed to write file report %s: %v", fileReportPath, err)
+	}
+}
+
+// fileUnchanged returns true if the file is already on disk with the
+// contents, mode and ownership it is written with.
+func (dn *Daemon) fileUnchanged(w fileWrite) bool {
+	info, err := dn.fileSystemClient.Stat(w.path)
+	if err != nil || info == nil || !info.Mode().IsRegular() || info.Mode().Perm() != w.mode {
+		return false
+	}
+	if w.chown {
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || int(st.Uid) != w.uid || int(st.Gid) != w.gid {
+			return false
+		}
+	}
+	contents, err := dn.fileSystemClient.ReadFile(w.path)
+	return err == nil && bytes.Equal(contents, w.contents)
+}
+
+// readFileReport returns the file report of the last update, nil if there is
+// none.
+func readFileReport(fs FileSystemClient) (*FileReport, error) {
+	data, err := fs.ReadFile(fileReportPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file report: %v", err)
+	}
+	var rep FileReport
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, fmt.Errorf("Failed to parse file report %s: %v", fileReportPath, err)
+	}
+	return &rep, nil
+}
+
+// writtenContents returns the hash of the contents of the files the report
+// left on disk as the daemon wrote them, by path. Files rolled back, removed
+// or that failed aren't.
+func (rep *FileReport) writtenContents() map[string]string {
+	written := map[string]string{}
+	if rep == nil {
+		return written
+	}
+	for _, f := range rep.Files {
+		if f.SHA256 != "" {
+			written[f.Path] = f.SHA256
+		} else {
+			delete(written, f.Path)
+		}
+	}
+	return written
+}
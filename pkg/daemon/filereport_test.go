@@ -0,0 +1,429 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestFileReport applies a config whose files are unchanged, new, changed
+// and failing, and verifies the outcome of each file is reported.
+func TestFileReport(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-file-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range map[string]string{
+		"/etc/unchanged": "unchanged",
+		"/etc/changed":   "old",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, path), []byte(contents), DefaultFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a directory is in the way of the failing file.
+	if err := os.MkdirAll(filepath.Join(root, "etc", "failing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "config"},
+		Spec: mcfgv1.MachineConfigSpec{
+			Config: ignv2_2types.Config{
+				Storage: ignv2_2types.Storage{
+					Files: []ignv2_2types.File{
+						newFile("/etc/unchanged", "unchanged"),
+						newFile("/etc/new", "new"),
+						newFile("/etc/changed", "new"),
+						newFile("/etc/failing", "failing"),
+					},
+				},
+			},
+		},
+	}
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	if err := d.updateFiles(&mcfgv1.MachineConfig{}, config); err == nil {
+		t.Fatal("expected writing the files to fail")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, fileReportPath))
+	if err != nil {
+		t.Fatalf("expected the file report to be written: %v", err)
+	}
+	var report FileReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Config != "config" {
+		t.Errorf("expected the report of config config, got %s", report.Config)
+	}
+	var results []string
+	for _, f := range report.Files {
+		results = append(results, f.Path+" "+f.Result)
+		if (f.Result == FileResultFailed) != (f.Error != "") {
+			t.Errorf("expected only the failed file %s to have an error, got %q", f.Path, f.Error)
+		}
+	}
+	exp := []string{
+		"/etc/changed " + FileResultWritten,
+		"/etc/changed " + FileResultRolledBack,
+		"/etc/failing " + FileResultFailed,
+		"/etc/new " + FileResultWritten,
+		"/etc/new " + FileResultRolledBack,
+		"/etc/unchanged " + FileResultUnchanged,
+	}
+	if !reflect.DeepEqual(results, exp) {
+		t.Errorf("expected outcomes %v, got %v", exp, results)
+	}
+	if summary := report.summary(); summary != "2 written, 1 unchanged, 0 removed, 0 kept modified, 1 failed, 2 rolled back" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+	if !checkFileContentsAndMode(filepath.Join(root, "/etc/changed"), "old", DefaultFilePermissions) {
+		t.Errorf("expected the changed file to be rolled back")
+	}
+}
+
+// TestFileReportUnresolved verifies a file failing before any file is written
+// is reported.
+func TestFileReportUnresolved(t *testing.T) {
+	d := &Daemon{fileSystemClient: FsClient{}}
+	files := &fileRecorder{}
+	err := d.writeFilesRecorded([]ignv2_2types.File{{
+		Node: ignv2_2types.Node{Path: "/etc/token"},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{
+			Contents: ignv2_2types.FileContents{Source: "secret://relative/path"},
+		},
+	}}, files)
+	if err == nil {
+		t.Fatal("expected the secret not to be resolved")
+	}
+	report := files.report("config")
+	if len(report.Files) != 1 || report.Files[0].Path != "/etc/token" || report.Files[0].Result != FileResultFailed || !strings.Contains(report.Files[0].Error, "relative") {
+		t.Errorf("expected the unresolved file to be reported as failed, got %+v", report.Files)
+	}
+}
+
+// TestFileUnchanged verifies a file is only skipped when its contents, mode and
+// ownership all match.
+func TestFileUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-unchanged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{fileSystemClient: FsClient{}}
+	w := fileWrite{name: path, path: path, contents: []byte("contents"), mode: 0600}
+	if !d.fileUnchanged(w) {
+		t.Errorf("expected the file to be unchanged")
+	}
+	for _, changed := range []fileWrite{
+		{name: path, path: path, contents: []byte("contents"), mode: DefaultFilePermissions},
+		{name: path, path: path, contents: []byte("other"), mode: 0600},
+		{name: path, path: path, contents: []byte("contents"), mode: 0600, chown: true, uid: os.Getuid() + 1, gid: os.Getgid()},
+		{name: dir, path: dir, contents: []byte("contents"), mode: 0600},
+	} {
+		if d.fileUnchanged(changed) {
+			t.Errorf("expected %+v to be changed", changed)
+		}
+	}
+}
+
+// TestUpdateFilesRemovesStaleFiles shrinks a config and verifies the files it
+// doesn't have anymore are removed, unless they were modified on the node.
+func TestUpdateFilesRemovesStaleFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-stale-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	newConfig := func(name string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: mcfgv1.MachineConfigSpec{
+				Config: ignv2_2types.Config{Storage: ignv2_2types.Storage{Files: files}},
+			},
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range map[string]string{
+		"/etc/kept":     "kept",
+		"/etc/stale":    "stale",
+		"/etc/modified": "edited by the admin",
+		"/etc/twice":    "second",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, path), []byte(contents), DefaultFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldConfig := newConfig("old",
+		newFile("/etc/kept", "kept"),
+		newFile("/etc/stale", "stale"),
+		newFile("/etc/modified", "modified"),
+		// already removed from the node.
+		newFile("/etc/gone", "gone"),
+		// the last entry for a path is the one on disk.
+		newFile("/etc/twice", "first"),
+		newFile("/etc/twice", "second"),
+	)
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	if err := d.updateFiles(oldConfig, newConfig("new", newFile("/etc/kept", "kept"))); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, exists := range map[string]bool{
+		"/etc/kept":     true,
+		"/etc/stale":    false,
+		"/etc/modified": true,
+		"/etc/twice":    false,
+	} {
+		if _, err := os.Stat(filepath.Join(root, path)); exists != (err == nil) {
+			t.Errorf("expected %s to exist: %v, got: %v", path, exists, err)
+		}
+	}
+	if !checkFileContentsAndMode(filepath.Join(root, "/etc/modified"), "edited by the admin", DefaultFilePermissions) {
+		t.Errorf("expected the modified file to be left as is")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, fileReportPath))
+	if err != nil {
+		t.Fatalf("expected the file report to be written: %v", err)
+	}
+	var report FileReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	var results []string
+	for _, f := range report.Files {
+		results = append(results, f.Path+" "+f.Result)
+	}
+	exp := []string{
+		"/etc/kept " + FileResultUnchanged,
+		"/etc/modified " + FileResultModified,
+		"/etc/stale " + FileResultRemoved,
+		"/etc/twice " + FileResultRemoved,
+	}
+	if !reflect.DeepEqual(results, exp) {
+		t.Errorf("expected outcomes %v, got %v", exp, results)
+	}
+	if summary := report.summary(); summary != "0 written, 1 unchanged, 2 removed, 1 kept modified, 0 failed, 0 rolled back" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+// TestUpdateFilesRemovesStaleSecretFile writes a file from a secret, rotates
+// and removes the secret, and verifies the file is removed once the config
+// doesn't have it anymore, as it is checked against the contents written.
+func TestUpdateFilesRemovesStaleSecretFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-stale-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	newSecretFile := func(path, secret string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "secret://" + secret},
+			},
+		}
+	}
+	newConfig := func(name string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: mcfgv1.MachineConfigSpec{
+				Config: ignv2_2types.Config{Storage: ignv2_2types.Storage{Files: files}},
+			},
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "secrets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rotated", "removed", "tampered"} {
+		if err := ioutil.WriteFile(filepath.Join(root, "secrets", name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldConfig := newConfig("old",
+		newSecretFile("/etc/rotated", "/secrets/rotated"),
+		newSecretFile("/etc/removed", "/secrets/removed"),
+		newSecretFile("/etc/tampered", "/secrets/tampered"),
+	)
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	if err := d.updateFiles(&mcfgv1.MachineConfig{}, oldConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "secrets", "rotated"), []byte("new secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "secrets", "removed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc", "tampered"), []byte("edited by the admin"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.updateFiles(oldConfig, newConfig("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, exists := range map[string]bool{
+		"/etc/rotated":  false,
+		"/etc/removed":  false,
+		"/etc/tampered": true,
+	} {
+		if _, err := os.Stat(filepath.Join(root, path)); exists != (err == nil) {
+			t.Errorf("expected %s to exist: %v, got: %v", path, exists, err)
+		}
+	}
+	report, err := readFileReport(d.fileSystemClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := report.summary(); summary != "0 written, 0 unchanged, 2 removed, 1 kept modified, 0 failed, 0 rolled back" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	// without a record of the contents written, the secret isn't read to
+	// check the file.
+	if err := os.Remove(filepath.Join(root, fileReportPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.updateFiles(oldConfig, newConfig("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc", "tampered")); err != nil {
+		t.Errorf("expected the unrecorded secret file to be kept: %v", err)
+	}
+	report, err = readFileReport(d.fileSystemClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Result != FileResultFailed || !strings.Contains(report.Files[0].Error, "secret") {
+		t.Errorf("expected the unrecorded secret file to be reported as failed, got %+v", report.Files)
+	}
+}
+
+// TestUpdateFilesRemovesStaleAppendedFile verifies a file Ignition appended to
+// is removed once the config doesn't have it anymore, unless it was modified.
+func TestUpdateFilesRemovesStaleAppendedFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-stale-append")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	newFile := func(path, contents string, append bool) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Append:   append,
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	newConfig := func(name string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: mcfgv1.MachineConfigSpec{
+				Config: ignv2_2types.Config{Storage: ignv2_2types.Storage{Files: files}},
+			},
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range map[string]string{
+		// appended to a file of the image.
+		"/etc/appended": "image\nchunk\n",
+		"/etc/based":    "base\nchunk\n",
+		"/etc/modified": "image\nchunk\nedited\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, path), []byte(contents), DefaultFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldConfig := newConfig("old",
+		newFile("/etc/appended", "chunk%0A", true),
+		newFile("/etc/based", "base%0A", false),
+		newFile("/etc/based", "chunk%0A", true),
+		newFile("/etc/modified", "chunk%0A", true),
+	)
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	if err := d.updateFiles(oldConfig, newConfig("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, exists := range map[string]bool{
+		"/etc/appended": false,
+		"/etc/based":    false,
+		"/etc/modified": true,
+	} {
+		if _, err := os.Stat(filepath.Join(root, path)); exists != (err == nil) {
+			t.Errorf("expected %s to exist: %v, got: %v", path, exists, err)
+		}
+	}
+	report, err := readFileReport(d.fileSystemClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary := report.summary(); summary != "0 written, 0 unchanged, 2 removed, 1 kept modified, 0 failed, 0 rolled back" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
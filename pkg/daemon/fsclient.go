@@ -4,8 +4,13 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"syscall"
 )
 
+// stReadOnly is the statfs mount flag for read-only filesystems (ST_RDONLY).
+const stReadOnly = 0x1
+
 // FileSystemClient abstracts file/directory manipulation operations
 type FileSystemClient interface {
 	Create(string) (*os.File, error)
@@ -13,12 +18,17 @@ type FileSystemClient interface {
 	RemoveAll(string) error
 	MkdirAll(string, os.FileMode) error
 	Stat(string) (os.FileInfo, error)
+	Lstat(string) (os.FileInfo, error)
 	Symlink(string, string) error
+	Readlink(string) (string, error)
 	Chmod(string, os.FileMode) error
 	Chown(string, int, int) error
 	WriteFile(filename string, data []byte, perm os.FileMode) error
 	ReadAll(reader io.Reader) ([]byte, error)
 	ReadFile(filename string) ([]byte, error)
+	Fsync(file *os.File) error
+	SyncAll()
+	IsReadOnly(path string) (bool, error)
 }
 
 // FsClient is used to hang the FileSystemClient functions on.
@@ -49,11 +59,21 @@ func (f FsClient) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
 
+// Lstat implements os.Lstat
+func (f FsClient) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
 // Symlink implements os.Symlink
 func (f FsClient) Symlink(oldname, newname string) error {
 	return os.Symlink(oldname, newname)
 }
 
+// Readlink implements os.Readlink
+func (f FsClient) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
 // Chmod implements os.Chmod
 func (f FsClient) Chmod(name string, mode os.FileMode) error {
 	return os.Chmod(name, mode)
@@ -79,6 +99,33 @@ func (f FsClient) ReadAll(reader io.Reader) ([]byte, error) {
 	return ioutil.ReadAll(reader)
 }
 
+// Fsync implements os.File.Sync
+func (f FsClient) Fsync(file *os.File) error {
+	return file.Sync()
+}
+
+// SyncAll implements syscall.Sync
+func (f FsClient) SyncAll() {
+	syscall.Sync()
+}
+
+// IsReadOnly returns true if path, or its closest existing parent when path
+// doesn't exist yet, is on a read-only mount.
+func (f FsClient) IsReadOnly(path string) (bool, error) {
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return st.Flags&stReadOnly != 0, nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return false, err
+		}
+		path = parent
+	}
+}
+
 // NewFileSystemClient creates a new file system client using the default
 // implementations provided by the os package.
 func NewFileSystemClient() FileSystemClient {
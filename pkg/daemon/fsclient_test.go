@@ -3,6 +3,7 @@ package daemon
 import (
 	"io"
 	"os"
+	"strings"
 )
 
 // CreateReturn is a structure used for testing. It holds a single return value
@@ -39,6 +40,10 @@ type FsClientMock struct {
 	WriteFileReturns []error
 	ReadAllReturns   []ReadFileReturn
 	ReadFileReturns  []ReadFileReturn
+	FsyncReturns     []error
+	// ReadOnlyPaths are the paths reported as being on a read-only mount,
+	// including everything below them.
+	ReadOnlyPaths []string
 }
 
 // updateErrorReturns is a shortcut to pop out the error and shift
@@ -85,11 +90,21 @@ func (f FsClientMock) Stat(name string) (os.FileInfo, error) {
 	return returnValues.OsFileInfo, returnValues.Error
 }
 
+// Lstat provides a mocked implemention, it returns the same as Stat
+func (f FsClientMock) Lstat(name string) (os.FileInfo, error) {
+	return f.Stat(name)
+}
+
 // Symlink provides a mocked implemention
 func (f FsClientMock) Symlink(oldname, newname string) error {
 	return updateErrorReturns(&f.SymlinkReturns)
 }
 
+// Readlink provides a mocked implemention, there are no links
+func (f FsClientMock) Readlink(name string) (string, error) {
+	return "", os.ErrInvalid
+}
+
 // Chmod provides a mocked implemention
 func (f FsClientMock) Chmod(name string, mode os.FileMode) error {
 	return updateErrorReturns(&f.ChmodReturns)
@@ -122,3 +137,21 @@ func (f FsClientMock) ReadFile(filename string) ([]byte, error) {
 	}
 	return returnValues.Bytes, returnValues.Error
 }
+
+// Fsync provides a mocked implemention
+func (f FsClientMock) Fsync(file *os.File) error {
+	return updateErrorReturns(&f.FsyncReturns)
+}
+
+// SyncAll provides a mocked implemention
+func (f FsClientMock) SyncAll() {}
+
+// IsReadOnly provides a mocked implemention
+func (f FsClientMock) IsReadOnly(path string) (bool, error) {
+	for _, p := range f.ReadOnlyPaths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
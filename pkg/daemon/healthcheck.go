@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	// defaultHealthCheckRetryInterval is the time between attempts of a
+	// health check that doesn't set it.
+	defaultHealthCheckRetryInterval = 10 * time.Second
+	// defaultHealthCheckTimeout is the time after which an attempt of a
+	// health check that doesn't set it is failed.
+	defaultHealthCheckTimeout = 30 * time.Second
+	// maxHealthCheckOutput is how much of the output of a failed health
+	// check is reported.
+	maxHealthCheckOutput = 1024
+)
+
+// healthCheckRunner runs the command until the context is done and returns its
+// combined output.
+type healthCheckRunner func(ctx context.Context, command []string) ([]byte, error)
+
+// runHealthCheckCommand runs the health check command on the machine.
+func runHealthCheckCommand(ctx context.Context, command []string) ([]byte, error) {
+	glog.Infof("Running health check: %s", strings.Join(command, " "))
+	return exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+}
+
+// runHealthCheck runs the health check, retrying it as configured, and returns
+// an error with the output of the last attempt if it never passes.
+func runHealthCheck(hc mcfgv1.HealthCheck, run healthCheckRunner, sleep func(time.Duration)) error {
+	if len(hc.Command) == 0 {
+		return fmt.Errorf("health check %q has no command", hc.Name)
+	}
+	interval := defaultHealthCheckRetryInterval
+	if hc.RetryIntervalSeconds > 0 {
+		interval = time.Duration(hc.RetryIntervalSeconds) * time.Second
+	}
+	timeout := defaultHealthCheckTimeout
+	if hc.TimeoutSeconds > 0 {
+		timeout = time.Duration(hc.TimeoutSeconds) * time.Second
+	}
+
+	var (
+		out []byte
+		err error
+	)
+	attempts := int(hc.Retries) + 1
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			glog.Infof("Health check %q failed: %v; retrying in %v", hc.Name, err, interval)
+			sleep(interval)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		out, err = run(ctx, hc.Command)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", timeout)
+		}
+		cancel()
+		if err == nil {
+			glog.Infof("Health check %q passed", hc.Name)
+			return nil
+		}
+	}
+
+	output := strings.TrimSpace(string(out))
+	if len(output) > maxHealthCheckOutput {
+		output = output[len(output)-maxHealthCheckOutput:]
+	}
+	return fmt.Errorf("health check %q failed after %d attempts: %v; output: %q", hc.Name, attempts, err, output)
+}
+
+// runHealthChecks runs the health checks of the config, stopping at the first
+// one failing.
+func runHealthChecks(config *mcfgv1.MachineConfig, run healthCheckRunner, sleep func(time.Duration)) error {
+	for _, hc := range config.Spec.HealthChecks {
+		if err := runHealthCheck(hc, run, sleep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHealth runs the health checks of the applied config on the machine.
+func (dn *Daemon) checkHealth(config *mcfgv1.MachineConfig) error {
+	if len(config.Spec.HealthChecks) == 0 {
+		return nil
+	}
+	glog.Infof("Running %d health checks of config %s", len(config.Spec.HealthChecks), config.GetName())
+	return runHealthChecks(config, runHealthCheckCommand, time.Sleep)
+}
@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// fakeHealthCheck fails the first failures runs of the health check.
+type fakeHealthCheck struct {
+	failures int
+	runs     int
+	sleeps   []time.Duration
+}
+
+func (f *fakeHealthCheck) run(ctx context.Context, command []string) ([]byte, error) {
+	f.runs++
+	if f.runs <= f.failures {
+		return []byte(fmt.Sprintf("connection refused %d", f.runs)), fmt.Errorf("exit status 7")
+	}
+	return []byte("ok"), nil
+}
+
+func (f *fakeHealthCheck) sleep(d time.Duration) {
+	f.sleeps = append(f.sleeps, d)
+}
+
+func TestRunHealthCheck(t *testing.T) {
+	hc := mcfgv1.HealthCheck{
+		Name:                 "api",
+		Command:              []string{"curl", "-f", "http://localhost:8080/healthz"},
+		Retries:              2,
+		RetryIntervalSeconds: 5,
+	}
+
+	// passing
+	f := &fakeHealthCheck{}
+	if err := runHealthCheck(hc, f.run, f.sleep); err != nil {
+		t.Errorf("Expected health check to pass. Got %s.", err)
+	}
+	if f.runs != 1 || len(f.sleeps) != 0 {
+		t.Errorf("Expected a single run without retries. Got %d runs and %v sleeps.", f.runs, f.sleeps)
+	}
+
+	// passing after retries
+	f = &fakeHealthCheck{failures: 2}
+	if err := runHealthCheck(hc, f.run, f.sleep); err != nil {
+		t.Errorf("Expected health check to pass after retries. Got %s.", err)
+	}
+	if expected := []time.Duration{5 * time.Second, 5 * time.Second}; f.runs != 3 || !reflect.DeepEqual(f.sleeps, expected) {
+		t.Errorf("Expected 3 runs with %v sleeps. Got %d runs and %v sleeps.", expected, f.runs, f.sleeps)
+	}
+
+	// failing after all retries
+	f = &fakeHealthCheck{failures: 3}
+	err := runHealthCheck(hc, f.run, f.sleep)
+	if err == nil {
+		t.Fatal("Expected health check to fail")
+	}
+	if f.runs != 3 {
+		t.Errorf("Expected 3 runs. Got %d.", f.runs)
+	}
+	for _, s := range []string{`"api"`, "3 attempts", "exit status 7", "connection refused 3"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("Expected error to contain %s. Got %s.", s, err)
+		}
+	}
+}
+
+func TestRunHealthCheckTimeout(t *testing.T) {
+	hc := mcfgv1.HealthCheck{
+		Name:           "hangs",
+		Command:        []string{"sleep", "60"},
+		TimeoutSeconds: 1,
+	}
+	run := func(ctx context.Context, command []string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	err := runHealthCheck(hc, run, func(time.Duration) {})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1s") {
+		t.Errorf("Expected health check to time out. Got %v.", err)
+	}
+}
+
+func TestRunHealthChecks(t *testing.T) {
+	config := &mcfgv1.MachineConfig{
+		Spec: mcfgv1.MachineConfigSpec{
+			HealthChecks: []mcfgv1.HealthCheck{
+				{Name: "first", Command: []string{"true"}},
+				{Name: "second", Command: []string{"false"}},
+				{Name: "third", Command: []string{"true"}},
+			},
+		},
+	}
+	var ran []string
+	run := func(ctx context.Context, command []string) ([]byte, error) {
+		ran = append(ran, command[0])
+		if command[0] == "false" {
+			return nil, fmt.Errorf("exit status 1")
+		}
+		return nil, nil
+	}
+	err := runHealthChecks(config, run, func(time.Duration) {})
+	if err == nil || !strings.Contains(err.Error(), `"second"`) {
+		t.Errorf("Expected the second health check to fail. Got %v.", err)
+	}
+	if expected := []string{"true", "false"}; !reflect.DeepEqual(ran, expected) {
+		t.Errorf("Expected %v to run. Got %v.", expected, ran)
+	}
+
+	// the real runner runs the commands on the machine
+	config.Spec.HealthChecks = config.Spec.HealthChecks[:1]
+	if err := runHealthChecks(config, runHealthCheckCommand, time.Sleep); err != nil {
+		t.Errorf("Expected health checks to pass. Got %s.", err)
+	}
+}
@@ -0,0 +1,501 @@
+package daemon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// The Ignition v3 types are not vendored, these are the parts of the v3 spec
+// the daemon applies: the files, directories and links of the storage section
+// and the systemd units. Configs using anything else are rejected when they
+// are parsed.
+
+// ignV3Config is an Ignition v3 config.
+type ignV3Config struct {
+	Ignition ignV3Ignition `json:"ignition"`
+	Storage  ignV3Storage  `json:"storage,omitempty"`
+	Systemd  ignV3Systemd  `json:"systemd,omitempty"`
+}
+
+// ignV3Ignition is the metadata of an Ignition v3 config.
+type ignV3Ignition struct {
+	Version string `json:"version"`
+}
+
+// ignV3Storage is the storage section of an Ignition v3 config.
+type ignV3Storage struct {
+	Directories []ignV3Directory `json:"directories,omitempty"`
+	Files       []ignV3File      `json:"files,omitempty"`
+	Links       []ignV3Link      `json:"links,omitempty"`
+}
+
+// ignV3Node is the part common to files, directories and links.
+type ignV3Node struct {
+	Path      string         `json:"path"`
+	Overwrite *bool          `json:"overwrite,omitempty"`
+	User      ignV3NodeOwner `json:"user,omitempty"`
+	Group     ignV3NodeOwner `json:"group,omitempty"`
+}
+
+// ignV3NodeOwner is the user or group owning a node, by id or by name.
+type ignV3NodeOwner struct {
+	ID   *int    `json:"id,omitempty"`
+	Name *string `json:"name,omitempty"`
+}
+
+// ignV3File is a file of an Ignition v3 config.
+type ignV3File struct {
+	ignV3Node
+	Contents ignV3Resource   `json:"contents,omitempty"`
+	Append   []ignV3Resource `json:"append,omitempty"`
+	Mode     *int            `json:"mode,omitempty"`
+}
+
+// ignV3Resource is the contents of a file or one appended to it.
+type ignV3Resource struct {
+	Compression  *string           `json:"compression,omitempty"`
+	Source       *string           `json:"source,omitempty"`
+	Verification ignV3Verification `json:"verification,omitempty"`
+}
+
+// ignV3Verification is the expected hash of a resource.
+type ignV3Verification struct {
+	Hash *string `json:"hash,omitempty"`
+}
+
+// ignV3Directory is a directory of an Ignition v3 config.
+type ignV3Directory struct {
+	ignV3Node
+	Mode *int `json:"mode,omitempty"`
+}
+
+// ignV3Link is a link of an Ignition v3 config.
+type ignV3Link struct {
+	ignV3Node
+	Target string `json:"target"`
+	Hard   *bool  `json:"hard,omitempty"`
+}
+
+// ignV3Systemd is the systemd section of an Ignition v3 config.
+type ignV3Systemd struct {
+	Units []ignV3Unit `json:"units,omitempty"`
+}
+
+// ignV3Unit is a systemd unit of an Ignition v3 config.
+type ignV3Unit struct {
+	Name     string        `json:"name"`
+	Enabled  *bool         `json:"enabled,omitempty"`
+	Mask     *bool         `json:"mask,omitempty"`
+	Contents *string       `json:"contents,omitempty"`
+	Dropins  []ignV3Dropin `json:"dropins,omitempty"`
+}
+
+// ignV3Dropin is a dropin of a systemd unit of an Ignition v3 config.
+type ignV3Dropin struct {
+	Name     string  `json:"name"`
+	Contents *string `json:"contents,omitempty"`
+}
+
+// ignitionConfigVersion returns the version of the Ignition config, empty if
+// content is not an Ignition config.
+func ignitionConfigVersion(content []byte) string {
+	var conf struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(content, &conf); err != nil {
+		return ""
+	}
+	return conf.Ignition.Version
+}
+
+// isIgnitionV3 returns true if the config version is an Ignition v3 one.
+func isIgnitionV3(version string) bool {
+	return strings.HasPrefix(version, "3.")
+}
+
+// parseIgnitionV3 parses and validates the Ignition v3 config. Sections the
+// daemon doesn't apply are rejected, so a config is never half applied.
+func parseIgnitionV3(content []byte) (*ignV3Config, error) {
+	var conf ignV3Config
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&conf); err != nil {
+		return nil, fmt.Errorf("Failed to parse Ignition v3 config: %v", err)
+	}
+	if !isIgnitionV3(conf.Ignition.Version) {
+		return nil, fmt.Errorf("Failed to parse Ignition v3 config: unsupported version %q", conf.Ignition.Version)
+	}
+
+	for _, d := range conf.Storage.Directories {
+		if !filepath.IsAbs(d.Path) {
+			return nil, fmt.Errorf("Invalid Ignition v3 config: directory path %q is not absolute", d.Path)
+		}
+	}
+	for _, f := range conf.Storage.Files {
+		if !filepath.IsAbs(f.Path) {
+			return nil, fmt.Errorf("Invalid Ignition v3 config: file path %q is not absolute", f.Path)
+		}
+		for _, r := range append([]ignV3Resource{f.Contents}, f.Append...) {
+			if r.Compression != nil && *r.Compression != "" && *r.Compression != "gzip" {
+				return nil, fmt.Errorf("Invalid Ignition v3 config: file %q has unsupported compression %q", f.Path, *r.Compression)
+			}
+			if r.Verification.Hash != nil {
+				if _, _, err := parseHashV3(*r.Verification.Hash); err != nil {
+					return nil, fmt.Errorf("Invalid Ignition v3 config: file %q: %v", f.Path, err)
+				}
+			}
+		}
+	}
+	for _, l := range conf.Storage.Links {
+		if !filepath.IsAbs(l.Path) {
+			return nil, fmt.Errorf("Invalid Ignition v3 config: link path %q is not absolute", l.Path)
+		}
+		if l.Target == "" {
+			return nil, fmt.Errorf("Invalid Ignition v3 config: link %q has no target", l.Path)
+		}
+		if l.Hard != nil && *l.Hard {
+			return nil, fmt.Errorf("Invalid Ignition v3 config: link %q is a hard link, which is not supported", l.Path)
+		}
+	}
+	for _, u := range conf.Systemd.Units {
+		if u.Name == "" {
+			return nil, fmt.Errorf("Invalid Ignition v3 config: unit has no name")
+		}
+		for _, d := range u.Dropins {
+			if d.Name == "" {
+				return nil, fmt.Errorf("Invalid Ignition v3 config: dropin of unit %q has no name", u.Name)
+			}
+		}
+	}
+	return &conf, nil
+}
+
+// parseHashV3 returns the hash function and the expected sum of the
+// verification hash of a resource, in the form <type>-<hex sum>.
+func parseHashV3(verification string) (func() hash.Hash, []byte, error) {
+	parts := strings.SplitN(verification, "-", 2)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("malformed verification hash %q", verification)
+	}
+	var newHash func() hash.Hash
+	switch parts[0] {
+	case "sha512":
+		newHash = sha512.New
+	case "sha256":
+		newHash = sha256.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported verification hash type %q", parts[0])
+	}
+	sum, err := hex.DecodeString(parts[1])
+	if err != nil || len(sum) != newHash().Size() {
+		return nil, nil, fmt.Errorf("malformed verification hash %q", verification)
+	}
+	return newHash, sum, nil
+}
+
+// writeIgnitionV3 writes the directories, files, links and systemd units of
+// the Ignition v3 config to disk. It only runs for configs given with
+// --once-from, the configs of cluster driven updates are always spec 2.
+func (dn *Daemon) writeIgnitionV3(conf *ignV3Config) error {
+	if err := dn.checkOverwriteV3(conf); err != nil {
+		return err
+	}
+	if err := dn.writeDirectoriesV3(conf.Storage.Directories); err != nil {
+		return err
+	}
+	if err := dn.writeFilesV3(conf.Storage.Files); err != nil {
+		return err
+	}
+	if err := dn.writeLinksV3(conf.Storage.Links); err != nil {
+		return err
+	}
+	return dn.writeUnitsV3(conf.Systemd.Units)
+}
+
+// checkOverwriteV3 returns an error for the files and links of the config
+// that set overwrite to false while there is already something at their path,
+// so that nothing is written. A link already pointing to its target is left
+// as it is.
+func (dn *Daemon) checkOverwriteV3(conf *ignV3Config) error {
+	exists := func(n ignV3Node) (string, bool, error) {
+		path, err := dn.writablePath(n.Path)
+		if err != nil {
+			return "", false, err
+		}
+		if _, err := dn.fileSystemClient.Lstat(path); os.IsNotExist(err) {
+			return path, false, nil
+		} else if err != nil {
+			return "", false, fmt.Errorf("Failed to check %q: %v", n.Path, err)
+		}
+		return path, true, nil
+	}
+	for _, f := range conf.Storage.Files {
+		if f.Overwrite == nil || *f.Overwrite {
+			continue
+		}
+		if _, ok, err := exists(f.ignV3Node); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("Failed to write file %q: it exists and overwrite is false", f.Path)
+		}
+	}
+	for _, l := range conf.Storage.Links {
+		if l.Overwrite == nil || *l.Overwrite {
+			continue
+		}
+		path, ok, err := exists(l.ignV3Node)
+		if err != nil {
+			return err
+		}
+		if ok && !dn.isLinkTo(path, l.Target) {
+			return fmt.Errorf("Failed to link %q: it exists and overwrite is false", l.Path)
+		}
+	}
+	return nil
+}
+
+// isLinkTo returns true if path is a symbolic link to target.
+func (dn *Daemon) isLinkTo(path, target string) bool {
+	got, err := dn.fileSystemClient.Readlink(path)
+	return err == nil && got == target
+}
+
+// ownershipV3 returns the uid and gid owning the node and whether they have
+// to be set, taking the default owner for the user or group it doesn't set.
+func (dn *Daemon) ownershipV3(n ignV3Node) (bool, int, int, error) {
+	var (
+		uid, gid            *int
+		userName, groupName string
+	)
+	if n.User.ID != nil || n.User.Name != nil {
+		uid = n.User.ID
+		if n.User.Name != nil {
+			userName = *n.User.Name
+		}
+	} else if dn.fileUser != nil {
+		uid, userName = dn.fileUser.ID, dn.fileUser.Name
+	}
+	if n.Group.ID != nil || n.Group.Name != nil {
+		gid = n.Group.ID
+		if n.Group.Name != nil {
+			groupName = *n.Group.Name
+		}
+	} else if dn.fileGroup != nil {
+		gid, groupName = dn.fileGroup.ID, dn.fileGroup.Name
+	}
+	if uid == nil && userName == "" && gid == nil && groupName == "" {
+		return false, 0, 0, nil
+	}
+	u, g, err := lookupOwnership(uid, userName, gid, groupName)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("Failed to retrieve file ownership for file %q: %v", n.Path, err)
+	}
+	return true, u, g, nil
+}
+
+// resourceContentsV3 returns the decompressed contents of the resource of the
+// file at path, and true if they were read from a secret on the machine. The
+// contents are checked against the verification hash of the resource.
+func (dn *Daemon) resourceContentsV3(path string, r ignV3Resource) ([]byte, bool, error) {
+	if r.Source == nil {
+		return nil, false, nil
+	}
+	contents, secret, err := dn.sourceContents(path, *r.Source)
+	if err != nil {
+		return nil, false, err
+	}
+	if r.Compression != nil && *r.Compression == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(contents))
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to decompress contents of file %q: %v", path, err)
+		}
+		defer zr.Close()
+		if contents, err = ioutil.ReadAll(zr); err != nil {
+			return nil, false, fmt.Errorf("Failed to decompress contents of file %q: %v", path, err)
+		}
+	}
+	// like Ignition, the hash is of the decompressed contents.
+	if r.Verification.Hash != nil {
+		newHash, sum, err := parseHashV3(*r.Verification.Hash)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to verify contents of file %q: %v", path, err)
+		}
+		h := newHash()
+		h.Write(contents)
+		if !bytes.Equal(h.Sum(nil), sum) {
+			return nil, false, fmt.Errorf("Failed to verify contents of file %q: hash mismatch, expected %s", path, *r.Verification.Hash)
+		}
+	}
+	return contents, secret, nil
+}
+
+// writeFilesV3 writes the files of an Ignition v3 config to disk. The
+// resources appended to a file are written after its contents.
+func (dn *Daemon) writeFilesV3(files []ignV3File) error {
+	var writes []fileWrite
+	for _, f := range files {
+		path, err := dn.writablePath(f.Path)
+		if err != nil {
+			return err
+		}
+
+		var contents []byte
+		fromSecret := false
+		for _, r := range append([]ignV3Resource{f.Contents}, f.Append...) {
+			data, secret, err := dn.resourceContentsV3(f.Path, r)
+			if err != nil {
+				return err
+			}
+			contents = append(contents, data...)
+			fromSecret = fromSecret || secret
+		}
+
+		w := fileWrite{name: f.Path, path: path, contents: contents, mode: dn.defaultFileMode()}
+		switch {
+		case fromSecret:
+			w.mode = SecretFilePermissions
+		case f.Mode != nil:
+			w.mode = os.FileMode(*f.Mode)
+		}
+		if w.chown, w.uid, w.gid, err = dn.ownershipV3(f.ignV3Node); err != nil {
+			return err
+		}
+		writes = append(writes, w)
+	}
+	return dn.writeResolvedFiles(writes, nil)
+}
+
+// writeDirectoriesV3 creates the directories of an Ignition v3 config.
+func (dn *Daemon) writeDirectoriesV3(dirs []ignV3Directory) error {
+	for _, d := range dirs {
+		path, err := dn.writablePath(d.Path)
+		if err != nil {
+			return err
+		}
+		glog.Infof("Creating directory %q", d.Path)
+		mode := os.FileMode(DefaultDirectoryPermissions)
+		if d.Mode != nil {
+			mode = os.FileMode(*d.Mode)
+		}
+		if err := dn.fileSystemClient.MkdirAll(path, mode); err != nil {
+			return fmt.Errorf("Failed to create directory %q: %v", d.Path, err)
+		}
+		// the directory may already exist with another mode.
+		if err := dn.fileSystemClient.Chmod(path, mode); err != nil {
+			return fmt.Errorf("Failed to set mode of directory %q: %v", d.Path, err)
+		}
+		chown, uid, gid, err := dn.ownershipV3(d.ignV3Node)
+		if err != nil {
+			return err
+		}
+		if chown {
+			if err := dn.fileSystemClient.Chown(path, uid, gid); err != nil {
+				return fmt.Errorf("Failed to set ownership of directory %q: %v", d.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeLinksV3 creates the symbolic links of an Ignition v3 config, replacing
+// the file or link at their path. Links already pointing to their target are
+// left as they are, and a directory is never replaced.
+func (dn *Daemon) writeLinksV3(links []ignV3Link) error {
+	for _, l := range links {
+		path, err := dn.writablePath(l.Path)
+		if err != nil {
+			return err
+		}
+		if dn.isLinkTo(path, l.Target) {
+			glog.Infof("Link %q to %q is unchanged, skipping", l.Path, l.Target)
+			continue
+		}
+		glog.Infof("Linking %q to %q", l.Path, l.Target)
+		if err := dn.fileSystemClient.MkdirAll(filepath.Dir(path), DefaultDirectoryPermissions); err != nil {
+			return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(path), err)
+		}
+		if info, err := dn.fileSystemClient.Lstat(path); err == nil && info.IsDir() {
+			return fmt.Errorf("Failed to link %q: it is a directory", l.Path)
+		}
+		if err := dn.fileSystemClient.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove %q: %v", l.Path, err)
+		}
+		if err := dn.fileSystemClient.Symlink(l.Target, path); err != nil {
+			return fmt.Errorf("Failed to symlink %q to %q: %v", l.Path, l.Target, err)
+		}
+	}
+	return nil
+}
+
+// writeUnitsV3 writes the systemd units of an Ignition v3 config to disk.
+// Units and dropins without contents only have their state changed.
+func (dn *Daemon) writeUnitsV3(units []ignV3Unit) error {
+	for _, u := range units {
+		for _, d := range u.Dropins {
+			if d.Contents == nil {
+				continue
+			}
+			glog.Infof("Writing systemd unit dropin %q", d.Name)
+			path := filepath.Join(pathSystemd, u.Name+".d", d.Name)
+			if err := dn.fileSystemClient.MkdirAll(filepath.Dir(path), DefaultDirectoryPermissions); err != nil {
+				return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(path), err)
+			}
+			if err := dn.fileSystemClient.WriteFile(path, []byte(*d.Contents), os.FileMode(0644)); err != nil {
+				return fmt.Errorf("Failed to write systemd unit dropin %q: %v", d.Name, err)
+			}
+		}
+
+		path := filepath.Join(pathSystemd, u.Name)
+		if u.Mask != nil && *u.Mask {
+			glog.Infof("Masking systemd unit %q", u.Name)
+			if err := dn.fileSystemClient.MkdirAll(filepath.Dir(path), DefaultDirectoryPermissions); err != nil {
+				return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(path), err)
+			}
+			if err := dn.fileSystemClient.RemoveAll(path); err != nil {
+				return fmt.Errorf("Failed to remove unit %q: %v", u.Name, err)
+			}
+			if err := dn.fileSystemClient.Symlink(pathDevNull, path); err != nil {
+				return fmt.Errorf("Failed to symlink unit %q to %s: %v", u.Name, pathDevNull, err)
+			}
+			continue
+		}
+
+		if u.Contents != nil {
+			glog.Infof("Writing systemd unit %q", u.Name)
+			if err := dn.fileSystemClient.MkdirAll(filepath.Dir(path), DefaultDirectoryPermissions); err != nil {
+				return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(path), err)
+			}
+			if err := dn.fileSystemClient.WriteFile(path, []byte(*u.Contents), os.FileMode(DefaultFilePermissions)); err != nil {
+				return fmt.Errorf("Failed to write systemd unit %q: %v", u.Name, err)
+			}
+		}
+
+		if u.Enabled != nil {
+			if *u.Enabled {
+				if err := dn.fileSystemClient.MkdirAll(wantsPathSystemd, DefaultDirectoryPermissions); err != nil {
+					return fmt.Errorf("Failed to create directory %q: %v", wantsPathSystemd, err)
+				}
+				if err := dn.enableUnit(u.Name); err != nil {
+					return err
+				}
+			} else if err := dn.disableUnit(u.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
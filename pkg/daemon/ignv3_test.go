@@ -0,0 +1,371 @@
+package daemon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rootFsClient is a FsClient rooted at root, as if the daemon had chrooted
+// into it. Absolute symlinks are resolved in root too.
+type rootFsClient struct {
+	FsClient
+	root string
+}
+
+func (f rootFsClient) path(name string) string {
+	return filepath.Join(f.root, name)
+}
+
+func (f rootFsClient) Create(name string) (*os.File, error) {
+	return f.FsClient.Create(f.path(name))
+}
+
+func (f rootFsClient) Remove(name string) error {
+	return f.FsClient.Remove(f.path(name))
+}
+
+func (f rootFsClient) RemoveAll(name string) error {
+	return f.FsClient.RemoveAll(f.path(name))
+}
+
+func (f rootFsClient) MkdirAll(name string, perm os.FileMode) error {
+	return f.FsClient.MkdirAll(f.path(name), perm)
+}
+
+func (f rootFsClient) Stat(name string) (os.FileInfo, error) {
+	if target, err := os.Readlink(f.path(name)); err == nil && filepath.IsAbs(target) {
+		return f.Stat(target)
+	}
+	return f.FsClient.Stat(f.path(name))
+}
+
+func (f rootFsClient) Lstat(name string) (os.FileInfo, error) {
+	return f.FsClient.Lstat(f.path(name))
+}
+
+func (f rootFsClient) Symlink(oldname, newname string) error {
+	return f.FsClient.Symlink(oldname, f.path(newname))
+}
+
+func (f rootFsClient) Readlink(name string) (string, error) {
+	return f.FsClient.Readlink(f.path(name))
+}
+
+func (f rootFsClient) Chmod(name string, mode os.FileMode) error {
+	return f.FsClient.Chmod(f.path(name), mode)
+}
+
+func (f rootFsClient) Chown(name string, uid, gid int) error {
+	return f.FsClient.Chown(f.path(name), uid, gid)
+}
+
+func (f rootFsClient) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return f.FsClient.WriteFile(f.path(name), data, perm)
+}
+
+func (f rootFsClient) ReadFile(name string) ([]byte, error) {
+	return f.FsClient.ReadFile(f.path(name))
+}
+
+func (f rootFsClient) IsReadOnly(name string) (bool, error) {
+	return f.FsClient.IsReadOnly(f.path(name))
+}
+
+func TestParseIgnitionV3(t *testing.T) {
+	if version := ignitionConfigVersion([]byte(`{"ignition":{"version":"2.2.0"}}`)); isIgnitionV3(version) {
+		t.Errorf("expected version %s not to be v3", version)
+	}
+	if version := ignitionConfigVersion([]byte(`{"ignition":{"version":"3.0.0"}}`)); !isIgnitionV3(version) {
+		t.Errorf("expected version %s to be v3", version)
+	}
+	if version := ignitionConfigVersion([]byte(`kind: MachineConfig`)); version != "" {
+		t.Errorf("expected no version for a MachineConfig, got %s", version)
+	}
+
+	conf, err := parseIgnitionV3([]byte(`{
+		"ignition": {"version": "3.0.0"},
+		"storage": {"files": [{"path": "/etc/foo", "contents": {"source": "data:,foo"}, "mode": 420}]},
+		"systemd": {"units": [{"name": "foo.service", "enabled": true, "contents": "[Unit]\n"}]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Storage.Files) != 1 || conf.Storage.Files[0].Path != "/etc/foo" || *conf.Storage.Files[0].Mode != 0644 {
+		t.Errorf("unexpected files %+v", conf.Storage.Files)
+	}
+	if len(conf.Systemd.Units) != 1 || conf.Systemd.Units[0].Name != "foo.service" || !*conf.Systemd.Units[0].Enabled {
+		t.Errorf("unexpected units %+v", conf.Systemd.Units)
+	}
+
+	for name, config := range map[string]string{
+		"unsupported section": `{"ignition": {"version": "3.0.0"}, "passwd": {"users": [{"name": "core"}]}}`,
+		"relative path":       `{"ignition": {"version": "3.0.0"}, "storage": {"files": [{"path": "etc/foo"}]}}`,
+		"hard link":           `{"ignition": {"version": "3.0.0"}, "storage": {"links": [{"path": "/etc/foo", "target": "/etc/bar", "hard": true}]}}`,
+		"unknown compression": `{"ignition": {"version": "3.0.0"}, "storage": {"files": [{"path": "/etc/foo", "contents": {"compression": "xz"}}]}}`,
+		"unit without a name": `{"ignition": {"version": "3.0.0"}, "systemd": {"units": [{"enabled": true}]}}`,
+		"unknown hash":        `{"ignition": {"version": "3.0.0"}, "storage": {"files": [{"path": "/etc/foo", "contents": {"verification": {"hash": "md5-d3b07384d113edec49eaa6238ad5ff00"}}}]}}`,
+		"malformed hash":      `{"ignition": {"version": "3.0.0"}, "storage": {"files": [{"path": "/etc/foo", "contents": {"verification": {"hash": "sha512-foo"}}}]}}`,
+		"not a v3 config":     `{"ignition": {"version": "2.2.0"}}`,
+	} {
+		if _, err := parseIgnitionV3([]byte(config)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestWriteIgnitionV3 applies the files, directories, links and units of a v3
+// config against a fake root.
+func TestWriteIgnitionV3(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-ignv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("compressed"))
+	zw.Close()
+
+	// the unit disabled by the config is enabled on the machine.
+	if err := os.MkdirAll(filepath.Join(root, wantsPathSystemd), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldUnit := filepath.Join(root, pathSystemd, "old.service")
+	if err := ioutil.WriteFile(oldUnit, []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(pathSystemd, "old.service"), filepath.Join(root, wantsPathSystemd, "old.service")); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := parseIgnitionV3([]byte(`{
+		"ignition": {"version": "3.0.0"},
+		"storage": {
+			"directories": [{"path": "/etc/private", "mode": 448}],
+			"files": [
+				{"path": "/etc/plain", "contents": {"source": "data:,plain"}, "mode": 384},
+				{"path": "/etc/private/compressed", "contents": {"compression": "gzip", "source": "data:;base64,` + base64.StdEncoding.EncodeToString(compressed.Bytes()) + `"}},
+				{"path": "/etc/appended", "contents": {"source": "data:,first"}, "append": [{"source": "data:,-second"}]}
+			],
+			"links": [{"path": "/etc/link", "target": "/etc/plain"}]
+		},
+		"systemd": {"units": [
+			{"name": "foo.service", "enabled": true, "contents": "[Service]\nExecStart=/bin/true\n", "dropins": [{"name": "10-foo.conf", "contents": "[Service]\nUser=core\n"}]},
+			{"name": "masked.service", "mask": true},
+			{"name": "old.service", "enabled": false}
+		]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	if err := d.writeIgnitionV3(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, exp := range map[string]struct {
+		contents string
+		mode     os.FileMode
+	}{
+		"/etc/plain":                                    {"plain", 0600},
+		"/etc/private/compressed":                       {"compressed", DefaultFilePermissions},
+		"/etc/appended":                                 {"first-second", DefaultFilePermissions},
+		"/etc/systemd/system/foo.service":               {"[Service]\nExecStart=/bin/true\n", DefaultFilePermissions},
+		"/etc/systemd/system/foo.service.d/10-foo.conf": {"[Service]\nUser=core\n", 0644},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Errorf("expected %s to be written: %v", path, err)
+			continue
+		}
+		if string(data) != exp.contents {
+			t.Errorf("expected %s to contain %q, got %q", path, exp.contents, data)
+		}
+		if info, err := os.Stat(filepath.Join(root, path)); err != nil || info.Mode().Perm() != exp.mode {
+			t.Errorf("expected %s to have mode %v, got %v, %v", path, exp.mode, info.Mode().Perm(), err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(root, "/etc/private")); err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Errorf("expected /etc/private to be a directory with mode 0700, got %v, %v", info, err)
+	}
+	for link, target := range map[string]string{
+		"/etc/link":                          "/etc/plain",
+		"/etc/systemd/system/masked.service": pathDevNull,
+		"/etc/systemd/system/multi-user.target.wants/foo.service": "/etc/systemd/system/foo.service",
+	} {
+		if got, err := os.Readlink(filepath.Join(root, link)); err != nil || got != target {
+			t.Errorf("expected %s to link to %s, got %q, %v", link, target, got, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(root, wantsPathSystemd, "old.service")); !os.IsNotExist(err) {
+		t.Errorf("expected old.service to be disabled, got %v", err)
+	}
+}
+
+// TestWriteIgnitionV3Verification verifies the contents of files are checked
+// against their verification hash before anything is written.
+func TestWriteIgnitionV3Verification(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-ignv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+
+	sum := sha512.Sum512([]byte("foo"))
+	for hash, valid := range map[string]bool{
+		"sha512-" + hex.EncodeToString(sum[:]):        true,
+		"sha512-" + strings.Repeat("00", sha512.Size): false,
+	} {
+		os.RemoveAll(filepath.Join(root, "etc"))
+		conf, err := parseIgnitionV3([]byte(`{"ignition": {"version": "3.0.0"}, "storage": {"files": [
+			{"path": "/etc/other", "contents": {"source": "data:,other"}},
+			{"path": "/etc/foo", "contents": {"source": "data:,foo", "verification": {"hash": "` + hash + `"}}}
+		]}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.writeIgnitionV3(conf)
+		if valid {
+			if err != nil {
+				t.Errorf("%s: expected the contents to verify, got: %v", hash, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+			t.Errorf("%s: expected a hash mismatch, got: %v", hash, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "etc", "other")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no file to be written, got: %v", hash, err)
+		}
+	}
+}
+
+// TestWriteIgnitionV3Overwrite verifies files and links that can't be
+// overwritten fail the config before anything is written, and that links
+// never replace directories.
+func TestWriteIgnitionV3Overwrite(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-ignv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	d := &Daemon{
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	etc := filepath.Join(root, "etc")
+	if err := os.MkdirAll(filepath.Join(etc, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, contents := range map[string]string{"existing": "old", "replaced": "old"} {
+		if err := ioutil.WriteFile(filepath.Join(etc, path), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc/existing", filepath.Join(etc, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{{
+		name:   "file not overwritten",
+		config: `"files": [{"path": "/etc/new", "contents": {"source": "data:,new"}}, {"path": "/etc/existing", "overwrite": false, "contents": {"source": "data:,new"}}]`,
+		err:    `Failed to write file "/etc/existing": it exists and overwrite is false`,
+	}, {
+		name:   "link not overwritten",
+		config: `"files": [{"path": "/etc/new", "contents": {"source": "data:,new"}}], "links": [{"path": "/etc/link", "overwrite": false, "target": "/etc/new"}]`,
+		err:    `Failed to link "/etc/link": it exists and overwrite is false`,
+	}, {
+		name:   "link over a directory",
+		config: `"links": [{"path": "/etc/dir", "target": "/etc/existing"}]`,
+		err:    `Failed to link "/etc/dir": it is a directory`,
+	}, {
+		name:   "links already in place and replacing a file",
+		config: `"links": [{"path": "/etc/link", "overwrite": false, "target": "/etc/existing"}, {"path": "/etc/replaced", "target": "/etc/existing"}]`,
+	}}
+	for _, test := range tests {
+		conf, err := parseIgnitionV3([]byte(`{"ignition": {"version": "3.0.0"}, "storage": {` + test.config + `}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.writeIgnitionV3(conf)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+		}
+		if _, err := os.Stat(filepath.Join(etc, "new")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no file to be written, got: %v", test.name, err)
+		}
+	}
+
+	if !checkFileContentsAndMode(filepath.Join(etc, "existing"), "old", 0644) {
+		t.Errorf("expected /etc/existing to be left as it was")
+	}
+	if info, err := os.Lstat(filepath.Join(etc, "dir")); err != nil || !info.IsDir() {
+		t.Errorf("expected /etc/dir to be left as it was, got %v, %v", info, err)
+	}
+	for _, link := range []string{"link", "replaced"} {
+		if got, err := os.Readlink(filepath.Join(etc, link)); err != nil || got != "/etc/existing" {
+			t.Errorf("expected /etc/%s to link to /etc/existing, got %q, %v", link, got, err)
+		}
+	}
+}
+
+// TestSenseIgnitionV3 verifies onceFrom configs are picked by their version.
+func TestSenseIgnitionV3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-ignv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.ign")
+	if err := ioutil.WriteFile(path, []byte(`{"ignition": {"version": "3.0.0"}, "storage": {"files": [{"path": "/etc/foo"}]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{onceFrom: path, fileSystemClient: FsClient{}}
+	conf, configType, _, err := d.SenseAndLoadOnceFrom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configType != MachineConfigIgnitionV3FileType {
+		t.Errorf("expected config type %s, got %s", MachineConfigIgnitionV3FileType, configType)
+	}
+	if v3, ok := conf.(*ignV3Config); !ok || len(v3.Storage.Files) != 1 {
+		t.Errorf("expected the v3 config, got %+v", conf)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"ignition": {"version": "2.2.0"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, configType, _, err = d.SenseAndLoadOnceFrom(); err != nil || configType != MachineConfigIgnitionFileType {
+		t.Errorf("expected config type %s, got %s, %v", MachineConfigIgnitionFileType, configType, err)
+	}
+}
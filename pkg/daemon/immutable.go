@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// isImmutableFile returns true if path is one of the immutable base files, or
+// is under one of them that ends in a slash.
+func isImmutableFile(path string, immutableFiles []string) bool {
+	for _, f := range immutableFiles {
+		if path == f || (strings.HasSuffix(f, "/") && strings.HasPrefix(path, f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// immutableFileEntries returns the entries of the config for immutable base
+// files by path. Like when writing the files, later entries win.
+func immutableFileEntries(files []ignv2_2types.File, immutableFiles []string) map[string]ignv2_2types.File {
+	entries := map[string]ignv2_2types.File{}
+	for _, f := range files {
+		if isImmutableFile(f.Path, immutableFiles) {
+			entries[f.Path] = f
+		}
+	}
+	return entries
+}
+
+// changedImmutableFiles returns the immutable base files that the new config
+// adds, removes or changes compared to the old config, sorted.
+func changedImmutableFiles(oldConfig, newConfig *mcfgv1.MachineConfig, immutableFiles []string) []string {
+	oldFiles := immutableFileEntries(oldConfig.Spec.Config.Storage.Files, immutableFiles)
+	newFiles := immutableFileEntries(newConfig.Spec.Config.Storage.Files, immutableFiles)
+	var changed []string
+	for path, f := range newFiles {
+		if old, ok := oldFiles[path]; !ok || !reflect.DeepEqual(old, f) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range oldFiles {
+		if _, ok := newFiles[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// checkImmutableFiles refuses the update if the new config changes any of the
+// immutable base files of the daemon.
+func (dn *Daemon) checkImmutableFiles(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	// like reconcilable, there is no prior state to compare against.
+	if oldConfig.Kind == "" && dn.onceFrom != "" {
+		return nil
+	}
+	changed := changedImmutableFiles(oldConfig, newConfig, dn.immutableFiles)
+	if len(changed) == 0 {
+		return nil
+	}
+	if dn.recorder != nil {
+		dn.recorder.Eventf(newConfig, corev1.EventTypeWarning, "ImmutableFileChanged", "New config changes immutable base files: %s", strings.Join(changed, ", "))
+	}
+	return fmt.Errorf("refusing to apply config %s: it changes immutable base files %s", newConfig.GetName(), strings.Join(changed, ", "))
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckImmutableFiles(t *testing.T) {
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	newConfig := func(name string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			TypeMeta:   metav1.TypeMeta{Kind: "MachineConfig"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: mcfgv1.MachineConfigSpec{
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+				},
+			},
+		}
+	}
+
+	policy := newFile("/etc/selinux/config", "SELINUX=enforcing")
+	audit := newFile("/etc/audit/rules.d/base.rules", "-e 2")
+	other := newFile("/etc/foo", "old")
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		changed   []string
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig("old", policy, audit, other),
+		newConfig: newConfig("new", policy, audit, other),
+	}, {
+		name:      "other file changed",
+		oldConfig: newConfig("old", policy, audit, other),
+		newConfig: newConfig("new", policy, audit, newFile("/etc/foo", "new")),
+	}, {
+		name:      "immutable file reordered",
+		oldConfig: newConfig("old", policy, audit, other),
+		newConfig: newConfig("new", other, audit, policy),
+	}, {
+		name:      "immutable file changed",
+		oldConfig: newConfig("old", policy, audit, other),
+		newConfig: newConfig("new", policy, audit, other, newFile("/etc/selinux/config", "SELINUX=permissive")),
+		changed:   []string{"/etc/selinux/config"},
+	}, {
+		name:      "file under immutable directory added and immutable file removed",
+		oldConfig: newConfig("old", policy, audit, other),
+		newConfig: newConfig("new", audit, other, newFile("/etc/audit/rules.d/user.rules", "-D")),
+		changed:   []string{"/etc/audit/rules.d/user.rules", "/etc/selinux/config"},
+	}}
+
+	d := &Daemon{immutableFiles: []string{"/etc/selinux/config", "/etc/audit/rules.d/"}}
+	for _, test := range tests {
+		err := d.checkImmutableFiles(test.oldConfig, test.newConfig)
+		if len(test.changed) == 0 {
+			if err != nil {
+				t.Errorf("%s: expected the update to be allowed, got: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected the update to be refused", test.name)
+			continue
+		}
+		if exp := "refusing to apply config new: it changes immutable base files " + strings.Join(test.changed, ", "); err.Error() != exp {
+			t.Errorf("%s: expected error %q, got: %q", test.name, exp, err)
+		}
+		if got := changedImmutableFiles(test.oldConfig, test.newConfig, d.immutableFiles); !reflect.DeepEqual(got, test.changed) {
+			t.Errorf("%s: expected changed files %v, got: %v", test.name, test.changed, got)
+		}
+	}
+
+	// without immutable base files any change is allowed.
+	d = &Daemon{}
+	if err := d.checkImmutableFiles(newConfig("old", policy), newConfig("new", newFile("/etc/selinux/config", "SELINUX=disabled"))); err != nil {
+		t.Errorf("expected the update to be allowed without immutable base files, got: %v", err)
+	}
+}
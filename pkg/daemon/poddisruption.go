@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/liveupdate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// podDisruptionReasonRuntimeRestart is the reason of the pod disruption
+	// condition when the update restarts the container runtime or kubelet.
+	podDisruptionReasonRuntimeRestart = "RuntimeRestart"
+	// podDisruptionReasonNone is the reason of the pod disruption condition
+	// when the update leaves the running pods alone.
+	podDisruptionReasonNone = "NoRuntimeRestart"
+)
+
+// runtimeRestarts returns the node critical units update restarts in place to
+// apply newConfig, sorted by name. Their restart disrupts the pods running on
+// the node, as it is not drained before units are restarted in place. Changes
+// to node critical units themselves always drain and reboot the node, so
+// only changes to their environment files restart them in place.
+func runtimeRestarts(oldConfig, newConfig *mcfgv1.MachineConfig) []string {
+	// these are checked in the order update applies them in place.
+	switch {
+	case liveupdate.IsUdevRulesOnlyChange(oldConfig, newConfig), liveupdate.IsCATrustAnchorsOnlyChange(oldConfig, newConfig),
+		liveupdate.IsSysusersTmpfilesOnlyChange(oldConfig, newConfig), !liveupdate.IsEnvironmentFilesOnlyChange(oldConfig, newConfig):
+		return nil
+	}
+
+	var units []string
+	for _, u := range environmentFileUnits(oldConfig, newConfig) {
+		if liveupdate.IsNodeCriticalUnit(u.Name) {
+			units = append(units, u.Name)
+		}
+	}
+	sort.Strings(units)
+	return units
+}
+
+// setPodDisruptionCondition sets the MachineConfigPodDisruption condition of
+// the node, leaving its transition time alone if the status doesn't change.
+func setPodDisruptionCondition(node *corev1.Node, status corev1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
+	condition := corev1.NodeCondition{
+		Type:               MachineConfigDaemonPodDisruptionConditionType,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	}
+	for i, c := range node.Status.Conditions {
+		if c.Type != MachineConfigDaemonPodDisruptionConditionType {
+			continue
+		}
+		if c.Status == status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		node.Status.Conditions[i] = condition
+		return
+	}
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+}
+
+// recordPodDisruption records on the node, before the update from oldConfig
+// to newConfig is applied, whether it restarts the container runtime or
+// kubelet in place and so disrupts the running pods.
+func (dn *Daemon) recordPodDisruption(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	// We'll only have a kube client if we're cluster driven
+	if dn.kubeClient == nil {
+		return nil
+	}
+	units := runtimeRestarts(oldConfig, newConfig)
+	if len(units) > 0 {
+		glog.Warningf("Config %s restarts %s in place; running pods will be disrupted", newConfig.GetName(), strings.Join(units, ", "))
+	}
+
+	client := dn.kubeClient.CoreV1().Nodes()
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		node, err := client.Get(dn.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if len(units) > 0 {
+			setPodDisruptionCondition(node, corev1.ConditionTrue, podDisruptionReasonRuntimeRestart,
+				fmt.Sprintf("Config %s restarts %s in place; running pods will be disrupted", newConfig.GetName(), strings.Join(units, ", ")))
+		} else {
+			setPodDisruptionCondition(node, corev1.ConditionFalse, podDisruptionReasonNone,
+				fmt.Sprintf("Config %s leaves the container runtime and kubelet running", newConfig.GetName()))
+		}
+		_, err = client.UpdateStatus(node)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record the pod disruption of config %s: %v", newConfig.GetName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newPodDisruptionConfig(crio, envFile string) *mcfgv1.MachineConfig {
+	return &mcfgv1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "config"},
+		Spec: mcfgv1.MachineConfigSpec{
+			Config: ignv2_2types.Config{
+				Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+				Storage: ignv2_2types.Storage{Files: []ignv2_2types.File{{
+					Node:          ignv2_2types.Node{Path: "/etc/kubernetes/kubelet-env"},
+					FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:," + envFile}},
+				}}},
+				Systemd: ignv2_2types.Systemd{Units: []ignv2_2types.Unit{
+					{Name: "crio.service", Contents: crio},
+					{Name: "kubelet.service", Contents: "[Service]\nEnvironmentFile=/etc/kubernetes/kubelet-env\n"},
+					{Name: "foo.service", Contents: "[Unit]\nX-MachineConfigRestartInPlace=true\n[Service]\nExecStart=/bin/true\n"},
+				}},
+			},
+		},
+	}
+}
+
+func TestRuntimeRestarts(t *testing.T) {
+	base := newPodDisruptionConfig("[Service]\nExecStart=/usr/bin/crio\n", "A=1")
+	benign := base.DeepCopy()
+	benign.Spec.Config.Systemd.Units[2].Contents = "[Unit]\nX-MachineConfigRestartInPlace=true\n[Service]\nExecStart=/bin/false\n"
+
+	tests := []struct {
+		name      string
+		newConfig *mcfgv1.MachineConfig
+		units     []string
+	}{{
+		name:      "no changes",
+		newConfig: base,
+	}, {
+		name:      "benign unit changed",
+		newConfig: benign,
+	}, {
+		// node critical units are never restarted in place.
+		name:      "crio changed",
+		newConfig: newPodDisruptionConfig("[Service]\nExecStart=/usr/bin/crio --log-level=debug\n", "A=1"),
+	}, {
+		name:      "kubelet environment file changed",
+		newConfig: newPodDisruptionConfig("[Service]\nExecStart=/usr/bin/crio\n", "A=2"),
+		units:     []string{"kubelet.service"},
+	}}
+	for _, test := range tests {
+		if units := runtimeRestarts(base, test.newConfig); !reflect.DeepEqual(units, test.units) {
+			t.Errorf("%s: expected %v to be restarted, got %v", test.name, test.units, units)
+		}
+	}
+}
+
+func TestRecordPodDisruption(t *testing.T) {
+	dn := &Daemon{
+		name:       "nodeName",
+		kubeClient: k8sfake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "nodeName"}}),
+	}
+	condition := func() corev1.NodeCondition {
+		node, err := dn.kubeClient.CoreV1().Nodes().Get("nodeName", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range node.Status.Conditions {
+			if c.Type == MachineConfigDaemonPodDisruptionConditionType {
+				return c
+			}
+		}
+		t.Fatalf("expected the node to have a %s condition", MachineConfigDaemonPodDisruptionConditionType)
+		return corev1.NodeCondition{}
+	}
+
+	base := newPodDisruptionConfig("[Service]\nExecStart=/usr/bin/crio\n", "A=1")
+	envFile := newPodDisruptionConfig("[Service]\nExecStart=/usr/bin/crio\n", "A=2")
+	if err := dn.recordPodDisruption(base, envFile); err != nil {
+		t.Fatal(err)
+	}
+	if c := condition(); c.Status != corev1.ConditionTrue || c.Reason != podDisruptionReasonRuntimeRestart {
+		t.Errorf("expected the condition to be True with reason %s, got %s %s", podDisruptionReasonRuntimeRestart, c.Status, c.Reason)
+	}
+
+	benign := envFile.DeepCopy()
+	benign.Spec.Config.Systemd.Units[2].Contents = "[Unit]\nX-MachineConfigRestartInPlace=true\n[Service]\nExecStart=/bin/false\n"
+	if err := dn.recordPodDisruption(envFile, benign); err != nil {
+		t.Fatal(err)
+	}
+	if c := condition(); c.Status != corev1.ConditionFalse || c.Reason != podDisruptionReasonNone {
+		t.Errorf("expected the condition to be False with reason %s, got %s %s", podDisruptionReasonNone, c.Status, c.Reason)
+	}
+}
@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// rebootApprovalPollInterval is how often the node is checked for the
+// approval of a reboot.
+const rebootApprovalPollInterval = 10 * time.Second
+
+// errStagedConfigSuperseded is returned when the desired config of the node
+// changes while the reboot into the staged config waits for approval.
+var errStagedConfigSuperseded = errors.New("desired config changed while waiting for reboot approval")
+
+// rebootApprovalRequired returns true if the node may only reboot into a new
+// config once the reboot is approved.
+func rebootApprovalRequired(node *corev1.Node) bool {
+	return node.Labels[MachineConfigDaemonRebootApprovalRequiredLabelKey] == "true"
+}
+
+// isRebootApproved returns true if the reboot of the node into config is
+// approved. An approval only holds for the config it names.
+func isRebootApproved(node *corev1.Node, config string) bool {
+	return node.Annotations[MachineConfigDaemonRebootApprovedAnnotationKey] == config
+}
+
+// waitForRebootApproval blocks until the reboot into the staged config is
+// approved, if the node requires approval. While waiting the node is in the
+// WaitingForRebootApproval state, it is back to Working once approved. It
+// gives up when the daemon stops, and returns errStagedConfigSuperseded if
+// the desired config of the node no longer is the staged config.
+func (dn *Daemon) waitForRebootApproval(config string, interval time.Duration) error {
+	if dn.kubeClient == nil {
+		return nil
+	}
+	nodes := dn.kubeClient.CoreV1().Nodes()
+	node, err := nodes.Get(dn.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %v", dn.name, err)
+	}
+	if !rebootApprovalRequired(node) || isRebootApproved(node, config) {
+		return nil
+	}
+
+	glog.Infof("Config %s is staged; waiting for the reboot to be approved by setting %s=%s on the node", config, MachineConfigDaemonRebootApprovedAnnotationKey, config)
+	if err := dn.nodeWriter.SetUpdateWaitingForRebootApproval(nodes, dn.name); err != nil {
+		return err
+	}
+	if dn.recorder != nil {
+		dn.recorder.Eventf(node, corev1.EventTypeNormal, "WaitingForRebootApproval", "Config %s is staged, the reboot into it is waiting for approval", config)
+	}
+
+	superseded := false
+	err = wait.PollUntil(interval, func() (bool, error) {
+		node, err := nodes.Get(dn.name, metav1.GetOptions{})
+		if err != nil {
+			glog.Warningf("Failed to get node %s while waiting for reboot approval: %v", dn.name, err)
+			return false, nil
+		}
+		if desired := node.Annotations[DesiredMachineConfigAnnotationKey]; desired != config {
+			glog.Infof("Desired config changed to %s; no longer waiting for the reboot into config %s", desired, config)
+			superseded = true
+			return true, nil
+		}
+		return isRebootApproved(node, config), nil
+	}, dn.stopCh)
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("daemon stopped while waiting for the reboot into config %s to be approved", config)
+	} else if err != nil {
+		return err
+	}
+	if superseded {
+		if err := dn.nodeWriter.SetUpdateWorking(nodes, dn.name); err != nil {
+			return err
+		}
+		return errStagedConfigSuperseded
+	}
+
+	glog.Infof("Reboot into config %s approved", config)
+	return dn.nodeWriter.SetUpdateWorking(nodes, dn.name)
+}
+
+// discardStagedConfig undoes the staging of newConfig over oldConfig when the
+// update is superseded before the reboot into it. The pending deployment, with
+// the OS and kernel arguments of newConfig, is removed and the files, units
+// and tuning profile of oldConfig are put back, so the node is left on
+// oldConfig for the update to the newer config.
+func (dn *Daemon) discardStagedConfig(oldConfig, newConfig *mcfgv1.MachineConfig, run func(string, ...string) error) error {
+	glog.Infof("Discarding staged config %s; restoring config %s", newConfig.GetName(), oldConfig.GetName())
+	if dn.OperatingSystem == MachineConfigDaemonOSRHCOS {
+		// updateOS stages the OS when it isn't the booted one.
+		osStaged := !dn.isUnspecifiedOS(newConfig.Spec.OSImageURL) && newConfig.Spec.OSImageURL != dn.bootedOSImageURL
+		deleted, added := kernelArgumentChanges(oldConfig.Spec.TuningProfile, newConfig.Spec.TuningProfile)
+		if osStaged || len(deleted) > 0 || len(added) > 0 {
+			if err := run("rpm-ostree", "cleanup", "--pending"); err != nil {
+				return fmt.Errorf("Failed to remove the pending deployment: %v", err)
+			}
+		}
+	}
+	return dn.restoreConfig(newConfig, oldConfig, run)
+}
@@ -0,0 +1,279 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestWaitForRebootApproval stages a config on a node that requires reboot
+// approval and verifies the daemon waits until the reboot is approved.
+func TestWaitForRebootApproval(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	newNode := func(labels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "nodeName",
+				Labels: labels,
+				Annotations: map[string]string{
+					CurrentMachineConfigAnnotationKey:     "old",
+					DesiredMachineConfigAnnotationKey:     "new",
+					MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateWorking,
+				},
+			},
+		}
+	}
+	state := func(d *Daemon) string {
+		node, err := d.kubeClient.CoreV1().Nodes().Get("nodeName", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node.Annotations[MachineConfigDaemonStateAnnotationKey]
+	}
+
+	// nodes that don't require approval reboot right away
+	d := &Daemon{
+		name:       "nodeName",
+		kubeClient: k8sfake.NewSimpleClientset(newNode(nil)),
+		nodeWriter: nw,
+	}
+	if err := d.waitForRebootApproval("new", time.Millisecond); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	if got := state(d); got != MachineConfigDaemonStateWorking {
+		t.Errorf("Expected state %s without approval required. Got %s.", MachineConfigDaemonStateWorking, got)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	d = &Daemon{
+		name:       "nodeName",
+		kubeClient: k8sfake.NewSimpleClientset(newNode(map[string]string{MachineConfigDaemonRebootApprovalRequiredLabelKey: "true"})),
+		nodeWriter: nw,
+		recorder:   recorder,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- d.waitForRebootApproval("new", time.Millisecond)
+	}()
+
+	// the daemon reports it is waiting and keeps waiting
+	select {
+	case event := <-recorder.Events:
+		if event != "Normal WaitingForRebootApproval Config new is staged, the reboot into it is waiting for approval" {
+			t.Errorf("Unexpected event: %s", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the daemon to wait for reboot approval")
+	}
+	if got := state(d); got != MachineConfigDaemonStateWaitingForRebootApproval {
+		t.Errorf("Expected state %s while waiting. Got %s.", MachineConfigDaemonStateWaitingForRebootApproval, got)
+	}
+	setApproval := func(config string) {
+		node, err := d.kubeClient.CoreV1().Nodes().Get("nodeName", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.Annotations[MachineConfigDaemonRebootApprovedAnnotationKey] = config
+		if _, err := d.kubeClient.CoreV1().Nodes().Update(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an approval for another config doesn't count
+	setApproval("old")
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the daemon to keep waiting after approving another config. Got %v.", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// once approved the daemon goes on to reboot
+	setApproval("new")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected no error. Got %s.", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the daemon to continue after reboot approval")
+	}
+	if got := state(d); got != MachineConfigDaemonStateWorking {
+		t.Errorf("Expected state %s once approved. Got %s.", MachineConfigDaemonStateWorking, got)
+	}
+}
+
+// TestWaitForRebootApprovalAborts verifies the daemon stops waiting for
+// approval when the desired config changes or the daemon stops.
+func TestWaitForRebootApprovalAborts(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	go nw.Run(stopCh)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "nodeName",
+			Labels: map[string]string{MachineConfigDaemonRebootApprovalRequiredLabelKey: "true"},
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey:     "old",
+				DesiredMachineConfigAnnotationKey:     "new",
+				MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateWorking,
+			},
+		},
+	}
+	d := &Daemon{
+		name:       "nodeName",
+		kubeClient: k8sfake.NewSimpleClientset(node.DeepCopy()),
+		nodeWriter: nw,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- d.waitForRebootApproval("new", time.Millisecond)
+	}()
+	// a newer config is rolled out before the reboot into new is approved
+	if err := wait.PollImmediate(time.Millisecond, 10*time.Second, func() (bool, error) {
+		n, err := d.kubeClient.CoreV1().Nodes().Get("nodeName", metav1.GetOptions{})
+		if err != nil || n.Annotations[MachineConfigDaemonStateAnnotationKey] != MachineConfigDaemonStateWaitingForRebootApproval {
+			return false, err
+		}
+		n.Annotations[DesiredMachineConfigAnnotationKey] = "newer"
+		_, err = d.kubeClient.CoreV1().Nodes().Update(n)
+		return err == nil, err
+	}); err != nil {
+		t.Fatalf("Timed out waiting for the daemon to wait for reboot approval: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != errStagedConfigSuperseded {
+			t.Fatalf("Expected %v. Got %v.", errStagedConfigSuperseded, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the daemon to stop waiting for a superseded config")
+	}
+	n, err := d.kubeClient.CoreV1().Nodes().Get("nodeName", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Annotations[MachineConfigDaemonStateAnnotationKey]; got != MachineConfigDaemonStateWorking {
+		t.Errorf("Expected state %s once superseded. Got %s.", MachineConfigDaemonStateWorking, got)
+	}
+
+	daemonStopCh := make(chan struct{})
+	d = &Daemon{
+		name:       "nodeName",
+		kubeClient: k8sfake.NewSimpleClientset(node.DeepCopy()),
+		nodeWriter: nw,
+		stopCh:     daemonStopCh,
+	}
+	go func() {
+		done <- d.waitForRebootApproval("new", time.Millisecond)
+	}()
+	close(daemonStopCh)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Expected an error when the daemon stops while waiting")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the daemon to stop waiting once stopped")
+	}
+}
+
+// TestDiscardStagedConfig stages a config and verifies discarding it removes
+// the pending deployment and puts back the files and the tuning profile of
+// the old config.
+func TestDiscardStagedConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "mcd-discard-staged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node:          ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:," + contents}},
+		}
+	}
+	oldConfig := &mcfgv1.MachineConfig{}
+	oldConfig.Name = "old"
+	oldConfig.Spec.OSImageURL = "quay.io/openshift/os:1"
+	oldConfig.Spec.TuningProfile = &mcfgv1.TuningProfile{Sysctls: map[string]string{"vm.swappiness": "10"}}
+	oldConfig.Spec.Config.Storage.Files = []ignv2_2types.File{newFile("/etc/changed", "old")}
+	newConfig := oldConfig.DeepCopy()
+	newConfig.Name = "new"
+	newConfig.Spec.OSImageURL = "quay.io/openshift/os:2"
+	newConfig.Spec.TuningProfile = &mcfgv1.TuningProfile{KernelArguments: []string{"nosmt"}, Sysctls: map[string]string{"vm.swappiness": "60"}}
+	newConfig.Spec.Config.Storage.Files = []ignv2_2types.File{newFile("/etc/changed", "new"), newFile("/etc/added", "new")}
+
+	d := &Daemon{
+		OperatingSystem:  MachineConfigDaemonOSRHCOS,
+		bootedOSImageURL: oldConfig.Spec.OSImageURL,
+		fileSystemClient: rootFsClient{root: root},
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 1,
+	}
+	var commands []string
+	run := func(name string, args ...string) error {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+	// the node is on the old config, then the new one is staged.
+	if err := d.updateFiles(&mcfgv1.MachineConfig{}, oldConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyTuningProfile(nil, oldConfig.Spec.TuningProfile, true, d.fileSystemClient, run); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.updateFiles(oldConfig, newConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyTuningProfile(oldConfig.Spec.TuningProfile, newConfig.Spec.TuningProfile, true, d.fileSystemClient, run); err != nil {
+		t.Fatal(err)
+	}
+
+	commands = nil
+	if err := d.discardStagedConfig(oldConfig, newConfig, run); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	if expected := []string{"rpm-ostree cleanup --pending"}; !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected commands %v. Got %v.", expected, commands)
+	}
+	if !checkFileContentsAndMode(filepath.Join(root, "/etc/changed"), "old", DefaultFilePermissions) {
+		t.Error("Expected /etc/changed to be restored")
+	}
+	if _, err := os.Stat(filepath.Join(root, "/etc/added")); !os.IsNotExist(err) {
+		t.Errorf("Expected /etc/added to be removed. Got %v.", err)
+	}
+	if !checkFileContentsAndMode(filepath.Join(root, tuningSysctlsPath), "vm.swappiness = 10\n", DefaultFilePermissions) {
+		t.Error("Expected the sysctls of the old config to be restored")
+	}
+
+	// nothing is pending when neither the OS nor the kernel arguments change.
+	commands = nil
+	sameDeployment := newConfig.DeepCopy()
+	sameDeployment.Spec.OSImageURL = oldConfig.Spec.OSImageURL
+	sameDeployment.Spec.TuningProfile.KernelArguments = nil
+	if err := d.discardStagedConfig(oldConfig, sameDeployment, run); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	if len(commands) != 0 {
+		t.Errorf("Expected no commands. Got %v.", commands)
+	}
+}
@@ -1,11 +1,27 @@
 package daemon
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
 	"strings"
+
+	"github.com/golang/glog"
 )
 
+// pivotAuthErrors are the messages that podman and the registries report when
+// the pull credentials are missing or rejected.
+var pivotAuthErrors = []string{
+	"unauthorized",
+	"authentication required",
+	"access denied",
+	"denied: requested access",
+}
+
 // RpmOstreeState houses zero or more RpmOstreeDeployments
 // Subset of `rpm-ostree status --json`
 // https://github.com/projectatomic/rpm-ostree/blob/bce966a9812df141d38e3290f845171ec745aa4e/src/daemon/rpmostreed-deployment-utils.c#L227
@@ -35,11 +51,23 @@ type NodeUpdaterClient interface {
 
 // RpmOstreeClient provides all RpmOstree related methods in one structure.
 // This structure implements DeploymentClient
-type RpmOstreeClient struct{}
+type RpmOstreeClient struct {
+	// pullSecretPath is the path of the registry credentials used to pull
+	// the OS image. When empty podman uses its default credentials.
+	pullSecretPath string
+
+	// pivot runs the pivot tool with the environment and returns what it
+	// wrote to stderr.
+	pivot func(env []string, osImageURL string) ([]byte, error)
+}
 
 // NewNodeUpdaterClient returns a new instance of the default DeploymentClient (RpmOstreeClient)
-func NewNodeUpdaterClient() NodeUpdaterClient {
-	return &RpmOstreeClient{}
+// that pulls OS images using the credentials at pullSecretPath, if set.
+func NewNodeUpdaterClient(pullSecretPath string) NodeUpdaterClient {
+	return &RpmOstreeClient{
+		pullSecretPath: pullSecretPath,
+		pivot:          runPivot,
+	}
 }
 
 // getBootedDeployment returns the current deployment found
@@ -83,6 +111,70 @@ func (r *RpmOstreeClient) GetBootedOSImageURL(rootMount string) (string, string,
 
 // RunPivot executes a pivot from one deployment to another as found in the referenced
 // osImageURL. See https://github.com/openshift/pivot.
+// If a pull secret is configured, it is passed to podman which pulls the image.
 func (r *RpmOstreeClient) RunPivot(osImageURL string) error {
-	return Run("/bin/pivot", osImageURL)
+	var env []string
+	if r.pullSecretPath != "" {
+		if err := validatePullSecret(r.pullSecretPath); err != nil {
+			return fmt.Errorf("Failed to pull %s: %v", osImageURL, err)
+		}
+		// podman reads the credentials from REGISTRY_AUTH_FILE
+		env = append(env, "REGISTRY_AUTH_FILE="+r.pullSecretPath)
+	}
+
+	stderr, err := r.pivot(env, osImageURL)
+	if err == nil {
+		return nil
+	}
+	if isPivotAuthError(stderr) {
+		if r.pullSecretPath == "" {
+			return fmt.Errorf("Failed to pull %s: registry requires authentication and no pull secret is configured: %v", osImageURL, err)
+		}
+		return fmt.Errorf("Failed to pull %s: registry rejected the credentials in pull secret %s: %v", osImageURL, r.pullSecretPath, err)
+	}
+	return fmt.Errorf("Failed to pivot to %s: %v", osImageURL, err)
+}
+
+// validatePullSecret checks that the pull secret at path exists and holds
+// registry credentials.
+func validatePullSecret(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read pull secret: %v", err)
+	}
+	var secret struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return fmt.Errorf("could not parse pull secret %s: %v", path, err)
+	}
+	if len(secret.Auths) == 0 {
+		return fmt.Errorf("pull secret %s has no registry credentials", path)
+	}
+	return nil
+}
+
+// isPivotAuthError returns true if the output of the pivot tool says that the
+// image couldn't be pulled because of the credentials.
+func isPivotAuthError(stderr []byte) bool {
+	out := strings.ToLower(string(stderr))
+	for _, msg := range pivotAuthErrors {
+		if strings.Contains(out, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPivot runs the pivot tool with the additional environment and returns
+// its stderr, which is also logged.
+func runPivot(env []string, osImageURL string) ([]byte, error) {
+	glog.Infof("Running: /bin/pivot %s\n", osImageURL)
+	var stderr bytes.Buffer
+	cmd := exec.Command("/bin/pivot", osImageURL)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err := cmd.Run()
+	return stderr.Bytes(), err
 }
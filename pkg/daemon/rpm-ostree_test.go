@@ -1,5 +1,15 @@
 package daemon
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
 /*
  * This file contains test code for the rpm-ostree client. It is meant to be used when
  * testing the daemon and mocking the responses that would normally be executed by the
@@ -40,3 +50,105 @@ func (r RpmOstreeClientMock) RunPivot(string) error {
 	}
 	return err
 }
+
+// TestRunPivotPullSecret verifies that the pull secret is passed to the pivot
+// and that missing or rejected credentials are reported clearly.
+func TestRunPivotPullSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pull-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	validSecret := filepath.Join(dir, "valid.json")
+	if err := ioutil.WriteFile(validSecret, []byte(`{"auths":{"registry.example.com":{"auth":"Zm9vOmJhcg=="}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptySecret := filepath.Join(dir, "empty.json")
+	if err := ioutil.WriteFile(emptySecret, []byte(`{"auths":{}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// pullFn mocks a pull from a registry that only accepts the valid secret.
+	pullFn := func(env []string, osImageURL string) ([]byte, error) {
+		for _, e := range env {
+			if e == "REGISTRY_AUTH_FILE="+validSecret {
+				return nil, nil
+			}
+		}
+		return []byte("error pulling image: unauthorized: authentication required"), fmt.Errorf("exit status 1")
+	}
+
+	tests := []struct {
+		name          string
+		pullSecret    string
+		pivot         func([]string, string) ([]byte, error)
+		expectedErr   string
+		expectedPulls int
+	}{{
+		name:          "valid credentials",
+		pullSecret:    validSecret,
+		pivot:         pullFn,
+		expectedPulls: 1,
+	}, {
+		name:          "no credentials",
+		pullSecret:    "",
+		pivot:         pullFn,
+		expectedErr:   "no pull secret is configured",
+		expectedPulls: 1,
+	}, {
+		name:       "rejected credentials",
+		pullSecret: validSecret,
+		pivot: func([]string, string) ([]byte, error) {
+			return []byte("Error: denied: requested access to the resource is denied"), fmt.Errorf("exit status 1")
+		},
+		expectedErr:   "registry rejected the credentials",
+		expectedPulls: 1,
+	}, {
+		name:          "missing pull secret",
+		pullSecret:    filepath.Join(dir, "missing.json"),
+		pivot:         pullFn,
+		expectedErr:   "could not read pull secret",
+		expectedPulls: 0,
+	}, {
+		name:          "pull secret without credentials",
+		pullSecret:    emptySecret,
+		pivot:         pullFn,
+		expectedErr:   "has no registry credentials",
+		expectedPulls: 0,
+	}, {
+		name:       "other failure",
+		pullSecret: validSecret,
+		pivot: func([]string, string) ([]byte, error) {
+			return []byte("error: no space left on device"), fmt.Errorf("exit status 1")
+		},
+		expectedErr:   "Failed to pivot",
+		expectedPulls: 1,
+	}}
+
+	for _, test := range tests {
+		var pulls int
+		var pulled []string
+		client := &RpmOstreeClient{
+			pullSecretPath: test.pullSecret,
+			pivot: func(env []string, osImageURL string) ([]byte, error) {
+				pulls++
+				pulled = append(pulled, osImageURL)
+				return test.pivot(env, osImageURL)
+			},
+		}
+		err := client.RunPivot("registry.example.com/os@sha256:abc")
+		if test.expectedErr == "" && err != nil {
+			t.Errorf("%s: expected no error, got %v", test.name, err)
+		}
+		if test.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), test.expectedErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.expectedErr, err)
+		}
+		if pulls != test.expectedPulls {
+			t.Errorf("%s: expected %d pulls, got %d", test.name, test.expectedPulls, pulls)
+		}
+		if pulls > 0 && !reflect.DeepEqual(pulled, []string{"registry.example.com/os@sha256:abc"}) {
+			t.Errorf("%s: unexpected images pulled: %v", test.name, pulled)
+		}
+	}
+}
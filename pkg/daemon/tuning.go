@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	// tuningSysctlsPath is the sysctl.d config holding the sysctls of the
+	// tuning profile, systemd-sysctl applies it on boot.
+	tuningSysctlsPath = "/etc/sysctl.d/99-machine-config-tuning.conf"
+	// tunedActiveProfilePath is the profile tuned activates when it starts.
+	tunedActiveProfilePath = "/etc/tuned/active_profile"
+	// tunedProfileModePath is whether tuned keeps the active profile
+	// ("manual") or picks its recommended profile ("auto").
+	tunedProfileModePath = "/etc/tuned/profile_mode"
+)
+
+// kernelArgumentChanges returns the kernel arguments of the old profile that
+// the new profile doesn't have and the kernel arguments the new profile adds.
+func kernelArgumentChanges(oldProfile, newProfile *mcfgv1.TuningProfile) ([]string, []string) {
+	var oldArgs, newArgs []string
+	if oldProfile != nil {
+		oldArgs = oldProfile.KernelArguments
+	}
+	if newProfile != nil {
+		newArgs = newProfile.KernelArguments
+	}
+	contains := func(args []string, arg string) bool {
+		for _, a := range args {
+			if a == arg {
+				return true
+			}
+		}
+		return false
+	}
+
+	var deleted, added []string
+	for _, arg := range oldArgs {
+		if !contains(newArgs, arg) {
+			deleted = append(deleted, arg)
+		}
+	}
+	for _, arg := range newArgs {
+		if !contains(oldArgs, arg) {
+			added = append(added, arg)
+		}
+	}
+	return deleted, added
+}
+
+// tuningSysctlsContents returns the sysctl.d config setting the sysctls,
+// sorted by name.
+func tuningSysctlsContents(sysctls map[string]string) string {
+	var names []string
+	for name := range sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = %s\n", name, sysctls[name])
+	}
+	return b.String()
+}
+
+// applyTuningProfile stages the new tuning profile for the next boot. The
+// kernel argument changes are staged in the pending deployment with a single
+// rpm-ostree call, if kargs is set, and the sysctls and the tuned profile are
+// written to the configs read on boot. None of it takes effect until the
+// reboot, so the whole profile is applied together.
+func applyTuningProfile(oldProfile, newProfile *mcfgv1.TuningProfile, kargs bool, fs FileSystemClient, run func(string, ...string) error) error {
+	if newProfile == nil {
+		newProfile = &mcfgv1.TuningProfile{}
+	}
+	if oldProfile == nil {
+		oldProfile = &mcfgv1.TuningProfile{}
+	}
+
+	deleted, added := kernelArgumentChanges(oldProfile, newProfile)
+	if len(deleted) > 0 || len(added) > 0 {
+		if !kargs {
+			glog.Warningf("Updating kernel arguments of non RHCOS nodes is not supported; skipping %v", added)
+		} else {
+			args := []string{"kargs"}
+			for _, arg := range deleted {
+				args = append(args, "--delete="+arg)
+			}
+			for _, arg := range added {
+				args = append(args, "--append="+arg)
+			}
+			if err := run("rpm-ostree", args...); err != nil {
+				return fmt.Errorf("Failed to update kernel arguments: %v", err)
+			}
+		}
+	}
+
+	writeFile := func(path, contents string) error {
+		if err := fs.MkdirAll(filepath.Dir(path), DefaultDirectoryPermissions); err != nil {
+			return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(path), err)
+		}
+		if err := fs.WriteFile(path, []byte(contents), DefaultFilePermissions); err != nil {
+			return fmt.Errorf("Failed to write %q: %v", path, err)
+		}
+		return nil
+	}
+	removeFile := func(path string) error {
+		if err := fs.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove %q: %v", path, err)
+		}
+		return nil
+	}
+
+	if len(newProfile.Sysctls) > 0 {
+		if err := writeFile(tuningSysctlsPath, tuningSysctlsContents(newProfile.Sysctls)); err != nil {
+			return err
+		}
+	} else if len(oldProfile.Sysctls) > 0 {
+		if err := removeFile(tuningSysctlsPath); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case newProfile.TunedProfile != "":
+		if err := writeFile(tunedActiveProfilePath, newProfile.TunedProfile+"\n"); err != nil {
+			return err
+		}
+		return writeFile(tunedProfileModePath, "manual\n")
+	case oldProfile.TunedProfile != "":
+		// hand the choice of the profile back to tuned.
+		if err := removeFile(tunedActiveProfilePath); err != nil {
+			return err
+		}
+		return writeFile(tunedProfileModePath, "auto\n")
+	}
+	return nil
+}
+
+// updateTuningProfile stages the tuning profile of the new config, to be
+// applied by the reboot into the config.
+func (dn *Daemon) updateTuningProfile(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	if reflect.DeepEqual(oldConfig.Spec.TuningProfile, newConfig.Spec.TuningProfile) {
+		return nil
+	}
+	glog.Infof("Staging tuning profile of config %s", newConfig.GetName())
+	return applyTuningProfile(oldConfig.Spec.TuningProfile, newConfig.Spec.TuningProfile,
+		dn.OperatingSystem == MachineConfigDaemonOSRHCOS, dn.fileSystemClient, Run)
+}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/liveupdate"
+)
+
+// tuningFsClient records the files written and removed in memory.
+type tuningFsClient struct {
+	FsClient
+	files map[string]string
+}
+
+func (f *tuningFsClient) MkdirAll(name string, perm os.FileMode) error {
+	return nil
+}
+
+func (f *tuningFsClient) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	f.files[filename] = string(data)
+	return nil
+}
+
+func (f *tuningFsClient) Remove(name string) error {
+	if _, ok := f.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+func newRealtimeTuningProfile() *mcfgv1.TuningProfile {
+	return &mcfgv1.TuningProfile{
+		KernelArguments: []string{"isolcpus=2-7", "nohz_full=2-7", "rcu_nocbs=2-7"},
+		Sysctls:         map[string]string{"kernel.sched_rt_runtime_us": "-1", "kernel.hung_task_timeout_secs": "600"},
+		TunedProfile:    "realtime",
+	}
+}
+
+func TestKernelArgumentChanges(t *testing.T) {
+	oldProfile := &mcfgv1.TuningProfile{KernelArguments: []string{"isolcpus=2-3", "nohz_full=2-7"}}
+	deleted, added := kernelArgumentChanges(oldProfile, newRealtimeTuningProfile())
+	if exp := []string{"isolcpus=2-3"}; !reflect.DeepEqual(deleted, exp) {
+		t.Errorf("expected %v to be deleted, got %v", exp, deleted)
+	}
+	if exp := []string{"isolcpus=2-7", "rcu_nocbs=2-7"}; !reflect.DeepEqual(added, exp) {
+		t.Errorf("expected %v to be added, got %v", exp, added)
+	}
+	if deleted, added := kernelArgumentChanges(nil, nil); deleted != nil || added != nil {
+		t.Errorf("expected no changes, got %v and %v", deleted, added)
+	}
+}
+
+// TestApplyTuningProfile verifies that the whole profile is staged for the
+// next boot with a single kernel arguments update and nothing else run.
+func TestApplyTuningProfile(t *testing.T) {
+	fs := &tuningFsClient{files: map[string]string{}}
+	var commands []string
+	run := func(name string, args ...string) error {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+
+	oldProfile := &mcfgv1.TuningProfile{KernelArguments: []string{"isolcpus=2-3"}}
+	if err := applyTuningProfile(oldProfile, newRealtimeTuningProfile(), true, fs, run); err != nil {
+		t.Fatal(err)
+	}
+	expCommands := []string{"rpm-ostree kargs --delete=isolcpus=2-3 --append=isolcpus=2-7 --append=nohz_full=2-7 --append=rcu_nocbs=2-7"}
+	if !reflect.DeepEqual(commands, expCommands) {
+		t.Errorf("expected commands %v, got %v", expCommands, commands)
+	}
+	expFiles := map[string]string{
+		tuningSysctlsPath:      "kernel.hung_task_timeout_secs = 600\nkernel.sched_rt_runtime_us = -1\n",
+		tunedActiveProfilePath: "realtime\n",
+		tunedProfileModePath:   "manual\n",
+	}
+	if !reflect.DeepEqual(fs.files, expFiles) {
+		t.Errorf("expected files %v, got %v", expFiles, fs.files)
+	}
+
+	// removing the profile reverts all of it.
+	commands = nil
+	if err := applyTuningProfile(newRealtimeTuningProfile(), nil, true, fs, run); err != nil {
+		t.Fatal(err)
+	}
+	expCommands = []string{"rpm-ostree kargs --delete=isolcpus=2-7 --delete=nohz_full=2-7 --delete=rcu_nocbs=2-7"}
+	if !reflect.DeepEqual(commands, expCommands) {
+		t.Errorf("expected commands %v, got %v", expCommands, commands)
+	}
+	if exp := map[string]string{tunedProfileModePath: "auto\n"}; !reflect.DeepEqual(fs.files, exp) {
+		t.Errorf("expected files %v, got %v", exp, fs.files)
+	}
+
+	// kernel arguments are only updated on RHCOS.
+	commands = nil
+	if err := applyTuningProfile(nil, newRealtimeTuningProfile(), false, fs, run); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 0 {
+		t.Errorf("expected no commands, got %v", commands)
+	}
+
+	failing := func(string, ...string) error { return fmt.Errorf("broken") }
+	if err := applyTuningProfile(nil, newRealtimeTuningProfile(), true, fs, failing); err == nil {
+		t.Errorf("expected an error when the kernel arguments can't be updated")
+	}
+}
+
+// TestTuningProfileRequiresReboot verifies that a tuning profile change is
+// never applied in place, so the update goes through the reboot that applies
+// the whole profile, even when it comes with changes applied in place
+// otherwise.
+func TestTuningProfileRequiresReboot(t *testing.T) {
+	newConfig := func(tp *mcfgv1.TuningProfile, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			Spec: mcfgv1.MachineConfigSpec{
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+				},
+				TuningProfile: tp,
+			},
+		}
+	}
+	rule := ignv2_2types.File{Node: ignv2_2types.Node{Path: "/etc/udev/rules.d/99-test.rules"}}
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		reboot    bool
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig(newRealtimeTuningProfile()),
+		newConfig: newConfig(newRealtimeTuningProfile()),
+		reboot:    false,
+	}, {
+		name:      "profile added",
+		oldConfig: newConfig(nil),
+		newConfig: newConfig(newRealtimeTuningProfile()),
+		reboot:    true,
+	}, {
+		name:      "profile and udev rule added",
+		oldConfig: newConfig(nil),
+		newConfig: newConfig(newRealtimeTuningProfile(), rule),
+		reboot:    true,
+	}, {
+		name:      "udev rule added",
+		oldConfig: newConfig(newRealtimeTuningProfile()),
+		newConfig: newConfig(newRealtimeTuningProfile(), rule),
+		reboot:    false,
+	}}
+	for _, test := range tests {
+		if reboot := liveupdate.RebootRequired(test.oldConfig, test.newConfig); reboot != test.reboot {
+			t.Errorf("%s: expected reboot required to be %v, got %v", test.name, test.reboot, reboot)
+		}
+	}
+}
@@ -1,21 +1,26 @@
 package daemon
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net/url"
 	"os"
 	"os/user"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
 	"github.com/golang/glog"
-	drain "github.com/openshift/kubernetes-drain"
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/liveupdate"
 	"github.com/vincent-petithory/dataurl"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,6 +31,8 @@ const (
 	DefaultDirectoryPermissions os.FileMode = 0755
 	// DefaultFilePermissions houses the default mode to use when no file permissions are provided
 	DefaultFilePermissions os.FileMode = 0644
+	// SecretFilePermissions is the mode of files whose contents are read from a secret
+	SecretFilePermissions os.FileMode = 0600
 )
 
 // update the node to the provided node configuration.
@@ -35,23 +42,100 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) error {
 	oldConfigName := oldConfig.GetName()
 	newConfigName := newConfig.GetName()
 	glog.Infof("Updating machineconfig from %v to %v", oldConfigName, newConfigName)
+	dn.applyLogger.Log(newConfigName, ApplyLogPhaseUpdate, ApplyLogResultStarted, nil)
 
 	// make sure we can actually reconcile this state
-	reconcilable, err := dn.reconcilable(oldConfig, newConfig)
+	err = dn.applyPhase(newConfigName, ApplyLogPhaseReconcile, func() error {
+		if err := validateUserUIDs(newConfig.Spec.Config.Passwd.Users); err != nil {
+			return fmt.Errorf("Failed to validate config %v: %v", newConfigName, err)
+		}
+		reconcilable, err := dn.reconcilable(oldConfig, newConfig)
+		if err != nil {
+			return err
+		}
+		if !reconcilable {
+			dn.recorder.Eventf(newConfig, corev1.EventTypeWarning, "FailedToReconcile", "New config could not be reconciled.")
+			return fmt.Errorf("daemon can't reconcile config %v with %v", oldConfigName, newConfigName)
+		}
+		return dn.checkImmutableFiles(oldConfig, newConfig)
+	})
 	if err != nil {
 		return err
 	}
-	if !reconcilable {
-		dn.recorder.Eventf(newConfig, corev1.EventTypeWarning, "FailedToReconcile", "New config could not be reconciled.")
-		return fmt.Errorf("daemon can't reconcile config %v with %v", oldConfigName, newConfigName)
+
+	// announce the disruption of the running pods before it happens. this is
+	// only informational, failing to record it doesn't stop the update.
+	if perr := dn.applyPhase(newConfigName, ApplyLogPhasePodDisruption, func() error {
+		return dn.recordPodDisruption(oldConfig, newConfig)
+	}); perr != nil {
+		glog.Warningf("%v", perr)
 	}
 
 	// update files on disk that need updating
-	if err = dn.updateFiles(oldConfig, newConfig); err != nil {
+	if err = dn.applyPhase(newConfigName, ApplyLogPhaseFiles, func() error {
+		return dn.updateFiles(oldConfig, newConfig)
+	}); err != nil {
 		return err
 	}
 
-	if err = dn.updateOS(oldConfig, newConfig); err != nil {
+	// udev can pick up rule changes without a reboot, so when those are
+	// the only changes we reload the rules in place and finish the update.
+	if liveupdate.IsUdevRulesOnlyChange(oldConfig, newConfig) {
+		return dn.applyLive(newConfig, ApplyLogPhaseUdevRules, dn.reloadUdevRules)
+	}
+
+	// the same goes for CA trust anchors, which only need to be extracted
+	// into the trust stores.
+	if liveupdate.IsCATrustAnchorsOnlyChange(oldConfig, newConfig) {
+		return dn.applyLive(newConfig, ApplyLogPhaseCATrust, dn.updateCATrust)
+	}
+
+	// and for sysusers.d and tmpfiles.d configs, which systemd-sysusers and
+	// systemd-tmpfiles can apply right away.
+	if liveupdate.IsSysusersTmpfilesOnlyChange(oldConfig, newConfig) {
+		return dn.applyLive(newConfig, ApplyLogPhaseSysusersTmpfiles, func() error {
+			return dn.applySysusersTmpfiles(oldConfig, newConfig)
+		})
+	}
+
+	// likewise, changed units can be restarted in place.
+	if liveupdate.IsUnitsOnlyChange(oldConfig, newConfig) {
+		return dn.applyLive(newConfig, ApplyLogPhaseUnits, func() error {
+			return dn.restartChangedUnits(oldConfig, newConfig)
+		})
+	}
+
+	// and so can the units whose environment files changed.
+	if liveupdate.IsEnvironmentFilesOnlyChange(oldConfig, newConfig) {
+		return dn.applyLive(newConfig, ApplyLogPhaseEnvironmentFiles, func() error {
+			return dn.restartEnvironmentFileUnits(oldConfig, newConfig)
+		})
+	}
+
+	if err = dn.applyPhase(newConfigName, ApplyLogPhaseOS, func() error {
+		return dn.updateOS(oldConfig, newConfig)
+	}); err != nil {
+		return err
+	}
+
+	// kernel arguments are staged in the deployment the node reboots into,
+	// with the rest of the tuning profile, so the single reboot below
+	// applies all of it.
+	if err = dn.applyPhase(newConfigName, ApplyLogPhaseTuningProfile, func() error {
+		return dn.updateTuningProfile(oldConfig, newConfig)
+	}); err != nil {
+		return err
+	}
+
+	// the update is staged, nodes that require approval wait for it before
+	// draining and rebooting. If the desired config changes meanwhile, the
+	// staged config is discarded and the update to the newer config, which
+	// starts from the old one, replaces this one.
+	if err = dn.applyPhase(newConfigName, ApplyLogPhaseRebootApproval, func() error {
+		return dn.waitForRebootApproval(newConfigName, rebootApprovalPollInterval)
+	}); err == errStagedConfigSuperseded {
+		return dn.discardStagedConfig(oldConfig, newConfig, Run)
+	} else if err != nil {
 		return err
 	}
 
@@ -59,29 +143,71 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig) error {
 	// We need to skip draining of the node when we are running once
 	// and there is no cluster.
 	if dn.onceFrom != "" && !ValidPath(dn.onceFrom) {
-		glog.Info("Update prepared; draining the node")
+		err = dn.applyPhase(newConfigName, ApplyLogPhaseDrain, func() error {
+			glog.Info("Update prepared; draining the node")
 
-		node, err := dn.kubeClient.CoreV1().Nodes().Get(dn.name, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
+			node, err := dn.kubeClient.CoreV1().Nodes().Get(dn.name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
 
-		dn.recorder.Eventf(node, corev1.EventTypeNormal, "Drain", "Draining node to update config.")
+			dn.recorder.Eventf(node, corev1.EventTypeNormal, "Drain", "Draining node to update config.")
 
-		err = drain.Drain(dn.kubeClient, []*corev1.Node{node}, &drain.DrainOptions{
-			DeleteLocalData:    true,
-			Force:              true,
-			GracePeriodSeconds: 600,
-			IgnoreDaemonsets:   true,
+			err = dn.drainNode(node)
+			if err != nil {
+				return err
+			}
+			glog.V(2).Infof("Node successfully drained")
+			return nil
 		})
 		if err != nil {
 			return err
 		}
-		glog.V(2).Infof("Node successfully drained")
+	}
+
+	// the boot into the new config is rolled back unless it is confirmed.
+	if dn.onceFrom == "" {
+		if err = dn.applyPhase(newConfigName, ApplyLogPhaseBootCheckpoint, func() error {
+			return dn.stageBootCheckpoint(oldConfig, newConfig)
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the reboot doesn't return, so deliver what we have before going down.
+	// Anything left over is spooled and delivered after the reboot.
+	dn.applyLogger.Log(newConfigName, ApplyLogPhaseReboot, ApplyLogResultStarted, nil)
+	if err := dn.applyLogger.Flush(); err != nil {
+		glog.Warningf("%v; delivering after reboot", err)
 	}
 
 	// reboot. this function shouldn't actually return.
-	return dn.reboot(fmt.Sprintf("Node will reboot into config %v", newConfigName))
+	return dn.applyPhase(newConfigName, ApplyLogPhaseReboot, func() error {
+		return dn.reboot(fmt.Sprintf("Node will reboot into config %v", newConfigName))
+	})
+}
+
+// applyPhase runs f as the phase of the update to config and records its
+// result in the apply log.
+func (dn *Daemon) applyPhase(config, phase string, f func() error) error {
+	err := f()
+	result := ApplyLogResultSucceeded
+	if err != nil {
+		result = ApplyLogResultFailed
+	}
+	dn.applyLogger.Log(config, phase, result, err)
+	return err
+}
+
+// applyLive runs apply as the phase of the update to newConfig, changing the
+// running node instead of rebooting it, then completes the update.
+func (dn *Daemon) applyLive(newConfig *mcfgv1.MachineConfig, phase string, apply func() error) error {
+	return dn.applyPhase(newConfig.GetName(), phase, func() error {
+		if err := apply(); err != nil {
+			return err
+		}
+		return dn.completeLiveUpdate(newConfig)
+	})
 }
 
 // reconcilable checks the configs to make sure that the only changes requested
@@ -178,6 +304,276 @@ func (dn *Daemon) reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (bool
 	return true, nil
 }
 
+// reloadUdevRules asks udev to reload its rules and replay the device events
+// so the rules written to disk take effect on the devices already present.
+func (dn *Daemon) reloadUdevRules() error {
+	glog.Info("Only udev rules changed; reloading udev rules instead of rebooting")
+	if err := Run("udevadm", "control", "--reload"); err != nil {
+		return fmt.Errorf("Failed to reload udev rules: %v", err)
+	}
+	if err := Run("udevadm", "trigger"); err != nil {
+		return fmt.Errorf("Failed to trigger udev events: %v", err)
+	}
+	glog.V(2).Infof("Reloaded udev rules")
+	return nil
+}
+
+// runCATrustUpdate extracts the CA trust anchors into the trust stores used by
+// the system, using run to execute the command.
+func runCATrustUpdate(run func(string, ...string) error) error {
+	if err := run("update-ca-trust", "extract"); err != nil {
+		return fmt.Errorf("Failed to update CA trust: %v", err)
+	}
+	return nil
+}
+
+// updateCATrust extracts the CA trust anchors written to disk into the trust
+// stores, so new CAs are trusted by the running processes right away.
+func (dn *Daemon) updateCATrust() error {
+	glog.Info("Only CA trust anchors changed; updating CA trust instead of rebooting")
+	if err := runCATrustUpdate(Run); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Updated CA trust")
+	return nil
+}
+
+// runSysusersTmpfiles provisions the users and groups of the sysusers.d
+// configs if they changed, then creates the files and directories of the
+// tmpfiles.d configs if they changed, using run to execute the commands.
+// Users are created first since tmpfiles.d entries may be owned by them.
+func runSysusersTmpfiles(oldConfig, newConfig *mcfgv1.MachineConfig, run func(string, ...string) error) error {
+	oldSysusers, oldFiles := liveupdate.SplitFiles(oldConfig.Spec.Config.Storage.Files, liveupdate.IsSysusersConfig)
+	newSysusers, newFiles := liveupdate.SplitFiles(newConfig.Spec.Config.Storage.Files, liveupdate.IsSysusersConfig)
+	if !reflect.DeepEqual(oldSysusers, newSysusers) {
+		if err := run("systemd-sysusers"); err != nil {
+			return fmt.Errorf("Failed to create sysusers.d users and groups: %v", err)
+		}
+	}
+	oldTmpfiles, _ := liveupdate.SplitFiles(oldFiles, liveupdate.IsTmpfilesConfig)
+	newTmpfiles, _ := liveupdate.SplitFiles(newFiles, liveupdate.IsTmpfilesConfig)
+	if !reflect.DeepEqual(oldTmpfiles, newTmpfiles) {
+		if err := run("systemd-tmpfiles", "--create"); err != nil {
+			return fmt.Errorf("Failed to create tmpfiles.d files and directories: %v", err)
+		}
+	}
+	return nil
+}
+
+// applySysusersTmpfiles applies the sysusers.d and tmpfiles.d configs written
+// to disk, provisioning their users and directories as systemd does at boot.
+func (dn *Daemon) applySysusersTmpfiles(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	glog.Info("Only sysusers.d and tmpfiles.d configs changed; applying them instead of rebooting")
+	if err := runSysusersTmpfiles(oldConfig, newConfig, Run); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Applied sysusers.d and tmpfiles.d configs")
+	return nil
+}
+
+// environmentFileUnits returns the units of the new config using an
+// environment file that differs from the old config and should be restarted.
+// Masked and explicitly disabled units are never restarted.
+func environmentFileUnits(oldConfig, newConfig *mcfgv1.MachineConfig) []ignv2_2types.Unit {
+	units := newConfig.Spec.Config.Systemd.Units
+	match := liveupdate.EnvironmentFileMatcher(units)
+	oldFiles, _ := liveupdate.SplitFiles(oldConfig.Spec.Config.Storage.Files, match)
+	newFiles, _ := liveupdate.SplitFiles(newConfig.Spec.Config.Storage.Files, match)
+	entries := func(files []ignv2_2types.File) map[string][]ignv2_2types.File {
+		byPath := map[string][]ignv2_2types.File{}
+		for _, f := range files {
+			byPath[f.Path] = append(byPath[f.Path], f)
+		}
+		return byPath
+	}
+	oldEntries, newEntries := entries(oldFiles), entries(newFiles)
+
+	var restart []ignv2_2types.Unit
+	for _, u := range units {
+		if u.Mask || (u.Enabled != nil && !*u.Enabled) {
+			continue
+		}
+		for _, path := range liveupdate.UnitEnvironmentFiles(u) {
+			if !reflect.DeepEqual(oldEntries[path], newEntries[path]) {
+				restart = append(restart, u)
+				break
+			}
+		}
+	}
+	return restart
+}
+
+// restartEnvironmentFileUnits restarts the units whose environment files
+// changed between the configs, so they read the new environment, in the same
+// order as changed units.
+func (dn *Daemon) restartEnvironmentFileUnits(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	glog.Info("Only environment files of systemd units changed; restarting units instead of rebooting")
+	return dn.reloadAndRestartUnits(environmentFileUnits(oldConfig, newConfig))
+}
+
+// changedUnits returns the units of the new config that are new or differ
+// from the old config and should be restarted. Masked and explicitly disabled
+// units are never restarted.
+func changedUnits(oldUnits, newUnits []ignv2_2types.Unit) []ignv2_2types.Unit {
+	old := make(map[string]ignv2_2types.Unit, len(oldUnits))
+	for _, u := range oldUnits {
+		old[u.Name] = u
+	}
+	var changed []ignv2_2types.Unit
+	for _, u := range newUnits {
+		if u.Mask || (u.Enabled != nil && !*u.Enabled) {
+			continue
+		}
+		if o, ok := old[u.Name]; ok && reflect.DeepEqual(o, u) {
+			continue
+		}
+		changed = append(changed, u)
+	}
+	return changed
+}
+
+// stoppedUnits returns the names of the units of the old config that the new
+// config removes, masks or disables and should be stopped. Units masked or
+// explicitly disabled in the old config already are left alone.
+func stoppedUnits(oldUnits, newUnits []ignv2_2types.Unit) []string {
+	updated := make(map[string]ignv2_2types.Unit, len(newUnits))
+	for _, u := range newUnits {
+		updated[u.Name] = u
+	}
+	var stopped []string
+	for _, u := range oldUnits {
+		if u.Mask || (u.Enabled != nil && !*u.Enabled) {
+			continue
+		}
+		if n, ok := updated[u.Name]; ok && !n.Mask && (n.Enabled == nil || *n.Enabled) {
+			continue
+		}
+		stopped = append(stopped, u.Name)
+	}
+	sort.Strings(stopped)
+	return stopped
+}
+
+// unitAfter returns the units listed in the After= directives of the unit.
+func unitAfter(u ignv2_2types.Unit) []string {
+	return liveupdate.UnitDirective(u, "After")
+}
+
+// orderUnitsForRestart returns the names of the units ordered so that every
+// unit comes after the units it is ordered After=. Units that don't have an
+// ordering between them are sorted by name. If the ordering has a cycle, the
+// units in the cycle are restarted in name order.
+func orderUnitsForRestart(units []ignv2_2types.Unit) []string {
+	// before maps a unit to the units that have to be restarted after it.
+	before := make(map[string][]string)
+	pending := make(map[string]int)
+	for _, u := range units {
+		pending[u.Name] = 0
+	}
+	for _, u := range units {
+		for _, dep := range unitAfter(u) {
+			if _, ok := pending[dep]; !ok || dep == u.Name {
+				continue
+			}
+			before[dep] = append(before[dep], u.Name)
+			pending[u.Name]++
+		}
+	}
+
+	var order []string
+	for len(pending) > 0 {
+		var ready []string
+		for name, count := range pending {
+			if count == 0 {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			glog.Warningf("Units have cyclic After= ordering; restarting the remaining units by name")
+			for name := range pending {
+				ready = append(ready, name)
+			}
+		}
+		sort.Strings(ready)
+		// restart one unit at a time so that units that become ready are
+		// still sorted by name with the ones already waiting.
+		name := ready[0]
+		order = append(order, name)
+		delete(pending, name)
+		for _, next := range before[name] {
+			if _, ok := pending[next]; ok {
+				pending[next]--
+			}
+		}
+	}
+	return order
+}
+
+// restartUnits restarts the named units in order, waiting for delay between
+// two restarts.
+func restartUnits(names []string, delay time.Duration, restart func(string) error, sleep func(time.Duration)) error {
+	for i, name := range names {
+		if i > 0 && delay > 0 {
+			glog.V(2).Infof("Waiting %v before restarting %s", delay, name)
+			sleep(delay)
+		}
+		glog.Infof("Restarting systemd unit %q", name)
+		if err := restart(name); err != nil {
+			return fmt.Errorf("Failed to restart systemd unit %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// restartChangedUnits reloads systemd, restarts the units that changed between
+// the configs, honoring their After= ordering and the configured delay between
+// restarts, and stops the units the new config removes, masks or disables.
+func (dn *Daemon) restartChangedUnits(oldConfig, newConfig *mcfgv1.MachineConfig) error {
+	glog.Info("Only systemd units changed; restarting units instead of rebooting")
+	oldUnits, newUnits := oldConfig.Spec.Config.Systemd.Units, newConfig.Spec.Config.Systemd.Units
+	if err := dn.reloadAndRestartUnits(changedUnits(oldUnits, newUnits)); err != nil {
+		return err
+	}
+	for _, name := range stoppedUnits(oldUnits, newUnits) {
+		glog.Infof("Stopping systemd unit %q", name)
+		if err := Run("systemctl", "stop", name); err != nil {
+			return fmt.Errorf("Failed to stop systemd unit %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// completeLiveUpdate finishes an update applied without rebooting: it runs the
+// health checks of the new config and marks the update as complete.
+func (dn *Daemon) completeLiveUpdate(newConfig *mcfgv1.MachineConfig) error {
+	if err := dn.checkHealth(newConfig); err != nil {
+		return err
+	}
+
+	// We'll only have a kube client if we're cluster driven
+	if dn.kubeClient == nil {
+		return nil
+	}
+	return dn.completeUpdate(newConfig.GetName())
+}
+
+// reloadAndRestartUnits reloads systemd and restarts the units, honoring their
+// After= ordering and the configured delay between restarts.
+func (dn *Daemon) reloadAndRestartUnits(units []ignv2_2types.Unit) error {
+	if err := Run("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("Failed to reload systemd: %v", err)
+	}
+
+	restart := func(name string) error {
+		return Run("systemctl", "restart", name)
+	}
+	if err := restartUnits(orderUnitsForRestart(units), dn.unitRestartDelay, restart, time.Sleep); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Restarted systemd units")
+	return nil
+}
+
 // updateFiles writes files specified by the nodeconfig to disk. it also writes
 // systemd units. there is no support for multiple filesystems at this point.
 //
@@ -198,7 +594,20 @@ func (dn *Daemon) reconcilable(oldConfig, newConfig *mcfgv1.MachineConfig) (bool
 func (dn *Daemon) updateFiles(oldConfig, newConfig *mcfgv1.MachineConfig) error {
 	glog.Info("Updating files")
 
-	if err := dn.writeFiles(newConfig.Spec.Config.Storage.Files); err != nil {
+	// what the last update wrote tells the files of the old config apart
+	// from files modified on the node.
+	last, err := readFileReport(dn.fileSystemClient)
+	if err != nil {
+		glog.Warningf("%v; checking stale files against the old config", err)
+	}
+
+	files := &fileRecorder{}
+	err = dn.writeFilesRecorded(newConfig.Spec.Config.Storage.Files, files)
+	if err == nil {
+		dn.deleteStaleFiles(oldConfig, newConfig, last.writtenContents(), files)
+	}
+	dn.writeFileReport(newConfig.GetName(), files)
+	if err != nil {
 		return err
 	}
 
@@ -206,30 +615,137 @@ func (dn *Daemon) updateFiles(oldConfig, newConfig *mcfgv1.MachineConfig) error
 		return err
 	}
 
-	dn.deleteStaleData(oldConfig, newConfig)
+	dn.deleteStaleUnits(oldConfig, newConfig)
 
 	return nil
 }
 
-// deleteStaleData performs a diff of the new and the old config. It then deletes
-// all the files, units that are present in the old config but not in the new one.
-// this function doesn't cause the agent to stop on failures and logs any errors
-// it encounters.
-func (dn *Daemon) deleteStaleData(oldConfig, newConfig *mcfgv1.MachineConfig) {
-	var path string
-	glog.Info("Deleting stale data")
+// deleteStaleFiles removes the files of the old config that the new config
+// doesn't have anymore, and records the outcome of each. A file that isn't on
+// disk as the old config wrote it was modified outside of MachineConfigs, it
+// is left in place with a warning. written has the hash of the contents the
+// last update wrote to each path. Like deleteStaleUnits, failures don't stop
+// the update.
+func (dn *Daemon) deleteStaleFiles(oldConfig, newConfig *mcfgv1.MachineConfig, written map[string]string, recorder *fileRecorder) {
 	newFileSet := make(map[string]struct{})
 	for _, f := range newConfig.Spec.Config.Storage.Files {
 		newFileSet[f.Path] = struct{}{}
 	}
 
-	glog.V(2).Info("Removing stale config storage files")
+	var paths []string
+	stale := map[string][]ignv2_2types.File{}
 	for _, f := range oldConfig.Spec.Config.Storage.Files {
-		if _, ok := newFileSet[f.Path]; !ok {
-			dn.fileSystemClient.RemoveAll(path)
+		if _, ok := newFileSet[f.Path]; ok {
+			continue
 		}
+		if _, ok := stale[f.Path]; !ok {
+			paths = append(paths, f.Path)
+		}
+		stale[f.Path] = append(stale[f.Path], f)
+	}
+	if len(paths) > 0 {
+		glog.V(2).Info("Removing stale config storage files")
+	}
+	for _, path := range paths {
+		dn.deleteStaleFile(path, stale[path], written, recorder)
+	}
+}
+
+// deleteStaleFile removes the file at path, with the entries of the old
+// config, if it is on disk as the old config wrote it. A file the last update
+// wrote is checked against the contents written, as sources such as secrets
+// may have changed since. Otherwise a file from a secret can't be checked and
+// is left in place, and appended files are checked against all their entries.
+func (dn *Daemon) deleteStaleFile(name string, entries []ignv2_2types.File, written map[string]string, recorder *fileRecorder) {
+	path, err := dn.writablePath(name)
+	if err != nil {
+		glog.Warningf("Leaving stale file %q, can't check it wasn't modified: %v", name, err)
+		recorder.record(name, FileResultFailed, err)
+		return
 	}
+	if _, err := dn.fileSystemClient.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	unchanged, err := dn.staleFileUnchanged(path, entries, written[name])
+	if err != nil {
+		glog.Warningf("Leaving stale file %q, can't check it wasn't modified: %v", name, err)
+		recorder.record(name, FileResultFailed, err)
+		return
+	}
+	if !unchanged {
+		glog.Warningf("Leaving stale file %q, it was modified outside of MachineConfigs", name)
+		recorder.record(name, FileResultModified, nil)
+		return
+	}
+	if err := dn.fileSystemClient.Remove(path); err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("Failed to remove stale file %q: %v", name, err)
+		glog.Warning(err)
+		recorder.record(name, FileResultFailed, err)
+		return
+	}
+	glog.Infof("Removed stale file %q", name)
+	recorder.record(name, FileResultRemoved, nil)
+}
 
+// staleFileUnchanged returns true if the stale file at path is on disk as it
+// was written, checked against the hash of the contents written if there is
+// one, or against its entries otherwise.
+func (dn *Daemon) staleFileUnchanged(path string, entries []ignv2_2types.File, hash string) (bool, error) {
+	if hash != "" {
+		contents, err := dn.fileSystemClient.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		return contentsHash(contents) == hash, nil
+	}
+
+	last := entries[len(entries)-1]
+	if IsSecretFileSource(last.Contents.Source) {
+		return false, fmt.Errorf("its contents come from a secret and weren't recorded when written")
+	}
+	appended := false
+	for _, f := range entries {
+		appended = appended || f.Append
+	}
+	if !appended {
+		w, err := dn.resolveFile(last)
+		if err != nil {
+			return false, err
+		}
+		return dn.fileUnchanged(w), nil
+	}
+
+	// the file has the contents of the last entry that isn't appended to,
+	// if any, followed by the entries appended to it.
+	var expected []byte
+	whole := false
+	for _, f := range entries {
+		contents, _, err := dn.fileContents(f)
+		if err != nil {
+			return false, err
+		}
+		if f.Append {
+			expected = append(expected, contents...)
+		} else {
+			expected, whole = contents, true
+		}
+	}
+	contents, err := dn.fileSystemClient.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if whole {
+		return bytes.Equal(contents, expected), nil
+	}
+	return bytes.HasSuffix(contents, expected), nil
+}
+
+// deleteStaleUnits performs a diff of the new and the old config. It then
+// deletes all the units that are present in the old config but not in the new
+// one. this function doesn't cause the agent to stop on failures and logs any
+// errors it encounters.
+func (dn *Daemon) deleteStaleUnits(oldConfig, newConfig *mcfgv1.MachineConfig) {
+	var path string
 	glog.V(2).Info("Removing stale config systemd units")
 	newUnitSet := make(map[string]struct{})
 	newDropinSet := make(map[string]struct{})
@@ -251,7 +767,7 @@ func (dn *Daemon) deleteStaleData(oldConfig, newConfig *mcfgv1.MachineConfig) {
 		}
 		path = filepath.Join(pathSystemd, u.Name)
 		if _, ok := newUnitSet[path]; !ok {
-			if err := dn.disableUnit(u); err != nil {
+			if err := dn.disableUnit(u.Name); err != nil {
 				glog.Warningf("Unable to disable %s: %s", u.Name, err)
 			}
 			dn.fileSystemClient.RemoveAll(path)
@@ -260,30 +776,30 @@ func (dn *Daemon) deleteStaleData(oldConfig, newConfig *mcfgv1.MachineConfig) {
 }
 
 // enableUnit enables a systemd unit via symlink
-func (dn *Daemon) enableUnit(unit ignv2_2types.Unit) error {
+func (dn *Daemon) enableUnit(name string) error {
 	// The link location
-	wantsPath := filepath.Join(wantsPathSystemd, unit.Name)
+	wantsPath := filepath.Join(wantsPathSystemd, name)
 	// sanity check that we don't return an error when the link already exists
 	if _, err := dn.fileSystemClient.Stat(wantsPath); err == nil {
 		glog.Infof("%s already exists. Not making a new symlink", wantsPath)
 		return nil
 	}
 	// The originating file to link
-	servicePath := filepath.Join(pathSystemd, unit.Name)
+	servicePath := filepath.Join(pathSystemd, name)
 	err := dn.fileSystemClient.Symlink(servicePath, wantsPath)
 	if err != nil {
-		glog.Warningf("Cannot enable unit %s: %s", unit.Name, err)
+		glog.Warningf("Cannot enable unit %s: %s", name, err)
 	} else {
-		glog.Infof("Enabled %s", unit.Name)
+		glog.Infof("Enabled %s", name)
 		glog.V(2).Infof("Symlinked %s to %s", servicePath, wantsPath)
 	}
 	return err
 }
 
 // disableUnit disables a systemd unit via symlink removal
-func (dn *Daemon) disableUnit(unit ignv2_2types.Unit) error {
+func (dn *Daemon) disableUnit(name string) error {
 	// The link location
-	wantsPath := filepath.Join(wantsPathSystemd, unit.Name)
+	wantsPath := filepath.Join(wantsPathSystemd, name)
 	// sanity check so we don't return an error when the unit was already disabled
 	if _, err := dn.fileSystemClient.Stat(wantsPath); err != nil {
 		glog.Infof("%s was not present. No need to remove", wantsPath)
@@ -294,8 +810,67 @@ func (dn *Daemon) disableUnit(unit ignv2_2types.Unit) error {
 	return dn.fileSystemClient.Remove(wantsPath)
 }
 
+// unitRequirementDirectives are the unit dependency directives whose units
+// have to exist for the unit to start.
+var unitRequirementDirectives = []string{"Requires", "Requisite", "BindsTo"}
+
+// unitSearchPaths are the directories where systemd looks for units on disk.
+var unitSearchPaths = []string{pathSystemd, "/run/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"}
+
+// unitExists returns true if the unit is defined on disk. Instances of
+// template units exist if the template does.
+func (dn *Daemon) unitExists(name string) bool {
+	names := []string{name}
+	if at, dot := strings.Index(name, "@"), strings.LastIndex(name, "."); at >= 0 && dot > at {
+		names = append(names, name[:at+1]+name[dot:])
+	}
+	for _, dir := range unitSearchPaths {
+		for _, n := range names {
+			if _, err := dn.fileSystemClient.Stat(filepath.Join(dir, n)); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkUnitDependencies verifies that the units required by the unit are
+// either part of the config or already on disk.
+func (dn *Daemon) checkUnitDependencies(u ignv2_2types.Unit, units []ignv2_2types.Unit) error {
+	inConfig := make(map[string]bool, len(units))
+	for _, unit := range units {
+		if !unit.Mask {
+			inConfig[unit.Name] = true
+		}
+	}
+	for _, directive := range unitRequirementDirectives {
+		for _, dep := range liveupdate.UnitDirective(u, directive) {
+			if inConfig[dep] || dn.unitExists(dep) {
+				continue
+			}
+			return fmt.Errorf("Failed to enable systemd unit %q: %s=%s refers to a unit that is neither in the config nor on disk", u.Name, directive, dep)
+		}
+	}
+	return nil
+}
+
+// isUnitEnabled returns true if the config asks for the unit to be enabled.
+func isUnitEnabled(u ignv2_2types.Unit) bool {
+	return u.Enable || (u.Enabled != nil && *u.Enabled)
+}
+
 // writeUnits writes the systemd units to disk
 func (dn *Daemon) writeUnits(units []ignv2_2types.Unit) error {
+	// make sure the units we enable can start before touching the disk.
+	for _, u := range units {
+		if u.Mask || !isUnitEnabled(u) {
+			continue
+		}
+		if err := dn.checkUnitDependencies(u, units); err != nil {
+			return err
+		}
+	}
+
 	var path string
 	for _, u := range units {
 		// write the dropin to disk
@@ -307,7 +882,7 @@ func (dn *Daemon) writeUnits(units []ignv2_2types.Unit) error {
 			}
 			glog.V(2).Infof("Created directory: %s", path)
 
-			err := ioutil.WriteFile(path, []byte(u.Dropins[i].Contents), os.FileMode(0644))
+			err := dn.fileSystemClient.WriteFile(path, []byte(u.Dropins[i].Contents), os.FileMode(0644))
 			if err != nil {
 				return fmt.Errorf("Failed to write systemd unit dropin %q: %v", u.Dropins[i].Name, err)
 			}
@@ -343,7 +918,7 @@ func (dn *Daemon) writeUnits(units []ignv2_2types.Unit) error {
 		}
 
 		// write the unit to disk
-		err := ioutil.WriteFile(path, []byte(u.Contents), os.FileMode(DefaultFilePermissions))
+		err := dn.fileSystemClient.WriteFile(path, []byte(u.Contents), os.FileMode(DefaultFilePermissions))
 		if err != nil {
 			return fmt.Errorf("Failed to write systemd unit %q: %v", u.Name, err)
 		}
@@ -358,19 +933,19 @@ func (dn *Daemon) writeUnits(units []ignv2_2types.Unit) error {
 		// Note: we have to check for legacy unit.Enable and honor it
 		glog.Infof("Enabling systemd unit %q", u.Name)
 		if u.Enable == true {
-			if err := dn.enableUnit(u); err != nil {
+			if err := dn.enableUnit(u.Name); err != nil {
 				return err
 			}
 			glog.V(2).Infof("Enabled systemd unit %q: ", u.Name)
 		}
 		if u.Enabled != nil {
 			if *u.Enabled {
-				if err := dn.enableUnit(u); err != nil {
+				if err := dn.enableUnit(u.Name); err != nil {
 					return err
 				}
 				glog.V(2).Infof("Enabled systemd unit %q: ", u.Name)
 			} else {
-				if err := dn.disableUnit(u); err != nil {
+				if err := dn.disableUnit(u.Name); err != nil {
 					return err
 				}
 				glog.V(2).Infof("Disabled systemd unit %q: ", u.Name)
@@ -380,95 +955,431 @@ func (dn *Daemon) writeUnits(units []ignv2_2types.Unit) error {
 	return nil
 }
 
-// writeFiles writes the given files to disk.
-// it doesn't fetch remote files and expects a flattened config file.
-func (dn *Daemon) writeFiles(files []ignv2_2types.File) error {
-	for _, f := range files {
-		glog.Infof("Writing file %q", f.Path)
-		// create any required directories for the file
-		if err := dn.fileSystemClient.MkdirAll(filepath.Dir(f.Path), DefaultDirectoryPermissions); err != nil {
-			return fmt.Errorf("Failed to create directory %q: %v", filepath.Dir(f.Path), err)
+// readOnlyRootRedirects maps the managed paths that are read-only on systems
+// with a read-only root (e.g. OSTree based ones) to the writable locations
+// under /var that back them.
+var readOnlyRootRedirects = map[string]string{
+	"/usr/local": "/var/usrlocal",
+	"/opt":       "/var/opt",
+	"/srv":       "/var/srv",
+	"/home":      "/var/home",
+	"/root":      "/var/roothome",
+	"/mnt":       "/var/mnt",
+}
+
+// writablePath returns the path where the file at path should be written. If
+// path is on a read-only mount and it is managed through a writable location,
+// the path in the writable location is returned. It returns an error if the
+// file can't be written because its path is on a read-only mount.
+func (dn *Daemon) writablePath(path string) (string, error) {
+	path = filepath.Clean(path)
+	readOnly, err := dn.fileSystemClient.IsReadOnly(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to check if %q is on a read-only mount: %v", path, err)
+	}
+	if !readOnly {
+		return path, nil
+	}
+
+	for prefix, target := range readOnlyRootRedirects {
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		redirected := filepath.Join(target, strings.TrimPrefix(path, prefix))
+		readOnly, err := dn.fileSystemClient.IsReadOnly(redirected)
+		if err != nil {
+			return "", fmt.Errorf("Failed to check if %q is on a read-only mount: %v", redirected, err)
 		}
+		if readOnly {
+			return "", fmt.Errorf("Failed to write %q: both it and %q are on a read-only mount", path, redirected)
+		}
+		glog.V(2).Infof("%q is on a read-only mount; writing it to %q", path, redirected)
+		return redirected, nil
+	}
+	return "", fmt.Errorf("Failed to write %q: it is on a read-only mount and is not a path that can be written under /etc or /var", path)
+}
+
+// IsSecretFileSource returns true if the file source references a secret
+// mounted on the machine instead of embedding the contents.
+func IsSecretFileSource(source string) bool {
+	return strings.HasPrefix(source, SecretFileSourceScheme+"://")
+}
+
+// fileContents returns the contents and mode the file is to be written with.
+// Files sourced from a secret are read from the machine and are always only
+// readable by their owner.
+func (dn *Daemon) fileContents(f ignv2_2types.File) ([]byte, os.FileMode, error) {
+	contents, secret, err := dn.sourceContents(f.Path, f.Contents.Source)
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret {
+		return contents, SecretFilePermissions, nil
+	}
+	mode := dn.defaultFileMode()
+	if f.Mode != nil {
+		mode = os.FileMode(*f.Mode)
+	}
+	return contents, mode, nil
+}
 
-		// create the file
-		file, err := dn.fileSystemClient.Create(f.Path)
+// sourceContents returns the contents of the source of the file at path, and
+// true if they were read from a secret on the machine.
+func (dn *Daemon) sourceContents(path, source string) ([]byte, bool, error) {
+	if IsSecretFileSource(source) {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to parse secret source of file %q: %v", path, err)
+		}
+		if u.Host != "" || !filepath.IsAbs(u.Path) {
+			return nil, false, fmt.Errorf("Failed to parse secret source of file %q: %q is not an absolute path", path, source)
+		}
+		contents, err := dn.fileSystemClient.ReadFile(u.Path)
 		if err != nil {
-			return fmt.Errorf("Failed to create file %q: %v", f.Path, err)
+			return nil, false, fmt.Errorf("Failed to read secret %q for file %q: %v", u.Path, path, err)
 		}
+		return contents, true, nil
+	}
+
+	contents, err := dataurl.DecodeString(source)
+	if err != nil {
+		return nil, false, err
+	}
+	return contents.Data, false, nil
+}
 
-		// write the file to disk, using the inlined file contents
-		contents, err := dataurl.DecodeString(f.Contents.Source)
+// fileWrite is a file of the config resolved for writing.
+type fileWrite struct {
+	// name is the path of the file in the config, path where it is
+	// written.
+	name     string
+	path     string
+	contents []byte
+	mode     os.FileMode
+	// chown is true if the file is owned by uid and gid rather than root.
+	chown    bool
+	uid, gid int
+}
+
+// writeFiles writes the given files to disk.
+// it doesn't fetch remote files and expects a flattened config file.
+// the directories of the files are created first, then the files are written
+// by up to fileWriteWorkers workers; entries for the same path are written in
+// order, so the last one wins. Files already on disk as they are in the
+// config are skipped. If writing a file fails, the files already written are
+// restored to what they were before.
+func (dn *Daemon) writeFiles(files []ignv2_2types.File) error {
+	return dn.writeFilesRecorded(files, nil)
+}
+
+// writeFilesRecorded is writeFiles recording the outcome of each file.
+func (dn *Daemon) writeFilesRecorded(files []ignv2_2types.File, recorder *fileRecorder) error {
+	var writes []fileWrite
+	for _, f := range files {
+		// resolve the contents before touching any file, so a missing
+		// secret doesn't leave an empty file behind
+		w, err := dn.resolveFile(f)
 		if err != nil {
+			recorder.record(f.Path, FileResultFailed, err)
 			return err
 		}
-		_, err = file.WriteString(string(contents.Data))
-		if err != nil {
-			return fmt.Errorf("Failed to write inline contents to file %q: %v", f.Path, err)
+		writes = append(writes, w)
+	}
+	return dn.writeResolvedFiles(writes, recorder)
+}
+
+// resolveFile returns where the file is written with which contents, mode and
+// ownership.
+func (dn *Daemon) resolveFile(f ignv2_2types.File) (fileWrite, error) {
+	// on a read-only root the file may have to go to a writable location
+	path, err := dn.writablePath(f.Path)
+	if err != nil {
+		return fileWrite{}, err
+	}
+	contents, mode, err := dn.fileContents(f)
+	if err != nil {
+		return fileWrite{}, err
+	}
+	w := fileWrite{name: f.Path, path: path, contents: contents, mode: mode}
+
+	// set chown if file information is provided, or there is a default owner
+	if owned := dn.withDefaultOwnership(f); owned.User != nil || owned.Group != nil {
+		if w.uid, w.gid, err = getFileOwnership(owned); err != nil {
+			return fileWrite{}, fmt.Errorf("Failed to retrieve file ownership for file %q: %v", f.Path, err)
 		}
+		w.chown = true
+	}
+	return w, nil
+}
 
-		// chmod and chown
-		mode := DefaultFilePermissions
-		if f.Mode != nil {
-			mode = os.FileMode(*f.Mode)
+// writeResolvedFiles writes the resolved files to disk, skipping the ones
+// that are unchanged, and records the outcome of each file.
+func (dn *Daemon) writeResolvedFiles(writes []fileWrite, recorder *fileRecorder) error {
+	var dirs []string
+	seenDirs := map[string]bool{}
+	for _, w := range writes {
+		if dir := filepath.Dir(w.path); !seenDirs[dir] {
+			seenDirs[dir] = true
+			dirs = append(dirs, dir)
 		}
-		err = file.Chmod(mode)
-		if err != nil {
-			return fmt.Errorf("Failed to set file mode for file %q: %v", f.Path, err)
+	}
+
+	// create any required directories for the files
+	for _, dir := range dirs {
+		if err := dn.fileSystemClient.MkdirAll(dir, DefaultDirectoryPermissions); err != nil {
+			return fmt.Errorf("Failed to create directory %q: %v", dir, err)
 		}
+	}
 
-		// set chown if file information is provided
-		if f.User != nil || f.Group != nil {
-			uid, gid, err := getFileOwnership(f)
-			if err != nil {
-				return fmt.Errorf("Failed to retrieve file ownership for file %q: %v", f.Path, err)
+	var byPath [][]fileWrite
+	index := map[string]int{}
+	for _, w := range writes {
+		i, ok := index[w.path]
+		if !ok {
+			i = len(byPath)
+			index[w.path] = i
+			byPath = append(byPath, nil)
+		}
+		byPath[i] = append(byPath[i], w)
+	}
+	// the files on disk before the first write to each path, to restore them
+	// if writing any file fails.
+	snapshots := make([]*fileSnapshot, len(byPath))
+	if err := parallelize(len(byPath), dn.fileWriteWorkers, func(i int) error {
+		for _, w := range byPath[i] {
+			if dn.fileUnchanged(w) {
+				glog.Infof("File %q is unchanged, skipping", w.name)
+				recorder.recordContents(w.name, FileResultUnchanged, w.contents)
+				continue
 			}
-			err = file.Chown(uid, gid)
-			if err != nil {
-				return fmt.Errorf("Failed to set file ownership for file %q: %v", f.Path, err)
+			if snapshots[i] == nil {
+				snapshot, err := dn.snapshotFile(w.path)
+				if err != nil {
+					recorder.record(w.name, FileResultFailed, err)
+					return err
+				}
+				snapshots[i] = snapshot
+			}
+			if err := dn.writeFile(w); err != nil {
+				recorder.record(w.name, FileResultFailed, err)
+				return err
+			}
+			recorder.recordContents(w.name, FileResultWritten, w.contents)
+		}
+		return nil
+	}); err != nil {
+		for i, snapshot := range snapshots {
+			if snapshot != nil {
+				dn.restoreFile(byPath[i][0].name, snapshot, recorder)
 			}
 		}
+		if dn.fileDurability == FileDurabilityFsyncBatch {
+			dn.fileSystemClient.SyncAll()
+		}
+		return err
+	}
+
+	// in batch mode the files haven't been synced yet; flush them all at once
+	// so they're durable before the update can be declared done.
+	if dn.fileDurability == FileDurabilityFsyncBatch && len(writes) > 0 {
+		glog.V(2).Infof("Syncing %d files to disk", len(writes))
+		dn.fileSystemClient.SyncAll()
+	}
+	return nil
+}
+
+// writeFile writes the file to disk, its directory must exist.
+func (dn *Daemon) writeFile(w fileWrite) error {
+	glog.Infof("Writing file %q", w.name)
+
+	// create the file
+	file, err := dn.fileSystemClient.Create(w.path)
+	if err != nil {
+		return fmt.Errorf("Failed to create file %q: %v", w.path, err)
+	}
+
+	// write the file to disk, using the inlined file contents
+	_, err = file.Write(w.contents)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Failed to write inline contents to file %q: %v", w.name, err)
+	}
 
-		err = file.Sync()
+	// chmod and chown
+	err = file.Chmod(w.mode)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Failed to set file mode for file %q: %v", w.name, err)
+	}
+
+	if w.chown {
+		err = file.Chown(w.uid, w.gid)
 		if err != nil {
-			return fmt.Errorf("Failed to sync file %q: %v", f.Path, err)
+			file.Close()
+			return fmt.Errorf("Failed to set file ownership for file %q: %v", w.name, err)
 		}
+	}
 
-		err = file.Close()
+	if dn.fileDurability != FileDurabilityFsyncBatch {
+		err = dn.fileSystemClient.Fsync(file)
 		if err != nil {
-			return fmt.Errorf("Failed to close file %q: %v", f.Path, err)
+			file.Close()
+			return fmt.Errorf("Failed to sync file %q: %v", w.name, err)
 		}
 	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to close file %q: %v", w.name, err)
+	}
 	return nil
 }
 
+// fileSnapshot is a file as it was on disk before it was written.
+type fileSnapshot struct {
+	path     string
+	exists   bool
+	contents []byte
+	mode     os.FileMode
+	uid, gid int
+}
+
+// snapshotFile returns the file at path as it is on disk, or a snapshot of a
+// missing file if there is none. It returns nil for anything but a regular
+// file, there is no file contents to restore.
+func (dn *Daemon) snapshotFile(path string) (*fileSnapshot, error) {
+	info, err := dn.fileSystemClient.Stat(path)
+	if os.IsNotExist(err) {
+		return &fileSnapshot{path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to back up file %q: %v", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("Failed to back up file %q: no ownership", path)
+	}
+	contents, err := dn.fileSystemClient.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to back up file %q: %v", path, err)
+	}
+	return &fileSnapshot{path: path, exists: true, contents: contents, mode: info.Mode().Perm(), uid: int(st.Uid), gid: int(st.Gid)}, nil
+}
+
+// restoreFile puts the file back on disk as it was in the snapshot, removing
+// it if it didn't exist, and records the outcome. Like deleteStaleFiles,
+// failures are only logged: the update fails already.
+func (dn *Daemon) restoreFile(name string, snapshot *fileSnapshot, recorder *fileRecorder) {
+	glog.Infof("Rolling back file %q", name)
+	var err error
+	if snapshot.exists {
+		err = dn.writeFile(fileWrite{name: name, path: snapshot.path, contents: snapshot.contents, mode: snapshot.mode, chown: true, uid: snapshot.uid, gid: snapshot.gid})
+	} else if err = dn.fileSystemClient.Remove(snapshot.path); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		glog.Warningf("Failed to roll back file %q: %v", name, err)
+		recorder.record(name, FileResultFailed, err)
+		return
+	}
+	recorder.record(name, FileResultRolledBack, nil)
+}
+
+// parallelize runs f for 0 to n-1 on up to workers goroutines. Once f fails
+// no more runs are started, and the first error is returned after the runs in
+// progress are done.
+func parallelize(n, workers int, f func(int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	jobs := make(chan int)
+	abort := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := f(i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(abort)
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < n; i++ {
+		// don't start more runs once one failed, even if a worker is free.
+		select {
+		case <-abort:
+			break dispatch
+		default:
+		}
+		select {
+		case jobs <- i:
+		case <-abort:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
 // This is essentially ResolveNodeUidAndGid() from Ignition; XXX should dedupe
 func getFileOwnership(file ignv2_2types.File) (int, int, error) {
-	uid, gid := 0, 0 // default to root
+	var (
+		uid, gid            *int
+		userName, groupName string
+	)
 	if file.User != nil {
-		if file.User.ID != nil {
-			uid = *file.User.ID
-		} else if file.User.Name != "" {
-			osUser, err := user.Lookup(file.User.Name)
-			if err != nil {
-				return uid, gid, fmt.Errorf("Failed to retrieve UserID for username: %s", file.User.Name)
-			}
-			glog.V(2).Infof("Retrieved UserId: %s for username: %s", osUser.Uid, file.User.Name)
-			uid, _ = strconv.Atoi(osUser.Uid)
-		}
+		uid, userName = file.User.ID, file.User.Name
 	}
 	if file.Group != nil {
-		if file.Group.ID != nil {
-			gid = *file.Group.ID
-		} else if file.Group.Name != "" {
-			osGroup, err := user.LookupGroup(file.Group.Name)
-			if err != nil {
-				return uid, gid, fmt.Errorf("Failed to retrieve GroupID for group: %s", file.Group.Name)
-			}
-			glog.V(2).Infof("Retrieved GroupID: %s for group: %s", osGroup.Gid, file.Group.Name)
-			gid, _ = strconv.Atoi(osGroup.Gid)
+		gid, groupName = file.Group.ID, file.Group.Name
+	}
+	return lookupOwnership(uid, userName, gid, groupName)
+}
+
+// lookupOwnership returns the uid and gid of the user and group, given by id
+// or by name. Both default to root.
+func lookupOwnership(uid *int, userName string, gid *int, groupName string) (int, int, error) {
+	u, g := 0, 0 // default to root
+	if uid != nil {
+		u = *uid
+	} else if userName != "" {
+		osUser, err := user.Lookup(userName)
+		if err != nil {
+			return u, g, fmt.Errorf("Failed to retrieve UserID for username: %s", userName)
 		}
+		glog.V(2).Infof("Retrieved UserId: %s for username: %s", osUser.Uid, userName)
+		u, _ = strconv.Atoi(osUser.Uid)
 	}
-	return uid, gid, nil
+	if gid != nil {
+		g = *gid
+	} else if groupName != "" {
+		osGroup, err := user.LookupGroup(groupName)
+		if err != nil {
+			return u, g, fmt.Errorf("Failed to retrieve GroupID for group: %s", groupName)
+		}
+		glog.V(2).Infof("Retrieved GroupID: %s for group: %s", osGroup.Gid, groupName)
+		g, _ = strconv.Atoi(osGroup.Gid)
+	}
+	return u, g, nil
 }
 
 // updateOS updates the system OS to the one specified in newConfig
@@ -525,6 +1436,12 @@ func (dn *Daemon) reboot(rationale string) error {
 	if (dn.recorder != nil) {
 		dn.recorder.Eventf(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: dn.name}}, corev1.EventTypeNormal, "Reboot", rationale)
 	}
+	// Record when we rebooted so the downtime can be measured once we're back
+	if dn.kubeClient != nil {
+		if err := dn.nodeWriter.SetRebootStart(dn.kubeClient.CoreV1().Nodes(), dn.name, time.Now()); err != nil {
+			glog.Warningf("Unable to record reboot start time: %v", err)
+		}
+	}
 	dn.logSystem("machine-config-daemon initiating reboot: %s", rationale)
 
 	// reboot
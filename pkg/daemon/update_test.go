@@ -2,12 +2,20 @@ package daemon
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
 
 	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
 	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/liveupdate"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 )
 
@@ -191,3 +199,980 @@ func TestReconcilable(t *testing.T) {
 	isReconcilable, err = d.reconcilable(oldConfig, newConfig)
 	checkReconcilableResults("raid", err, isReconcilable)
 }
+
+func TestRunCATrustUpdate(t *testing.T) {
+	var ran [][]string
+	run := func(command string, args ...string) error {
+		ran = append(ran, append([]string{command}, args...))
+		return nil
+	}
+	if err := runCATrustUpdate(run); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	if expected := [][]string{{"update-ca-trust", "extract"}}; !reflect.DeepEqual(ran, expected) {
+		t.Errorf("Expected %v to run. Got %v.", expected, ran)
+	}
+
+	failing := func(string, ...string) error { return fmt.Errorf("exit status 1") }
+	if err := runCATrustUpdate(failing); err == nil {
+		t.Error("Expected an error when update-ca-trust fails")
+	}
+}
+
+func TestSysusersTmpfilesOnlyChange(t *testing.T) {
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	newConfig := func(osImageURL string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			Spec: mcfgv1.MachineConfigSpec{
+				OSImageURL: osImageURL,
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+				},
+			},
+		}
+	}
+
+	sysusers := newFile("/etc/sysusers.d/app.conf", "old")
+	newSysusers := newFile("/etc/sysusers.d/app.conf", "new")
+	tmpfiles := newFile("/etc/tmpfiles.d/app.conf", "old")
+	newTmpfiles := newFile("/etc/tmpfiles.d/app.conf", "new")
+	other := newFile("/etc/foo", "old")
+	newOther := newFile("/etc/foo", "new")
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		live      bool
+		ran       [][]string
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig("", sysusers, tmpfiles, other),
+		newConfig: newConfig("", sysusers, tmpfiles, other),
+		live:      false,
+	}, {
+		name:      "sysusers changed",
+		oldConfig: newConfig("", sysusers, tmpfiles, other),
+		newConfig: newConfig("", newSysusers, tmpfiles, other),
+		live:      true,
+		ran:       [][]string{{"systemd-sysusers"}},
+	}, {
+		name:      "tmpfiles added",
+		oldConfig: newConfig("", other),
+		newConfig: newConfig("", other, tmpfiles),
+		live:      true,
+		ran:       [][]string{{"systemd-tmpfiles", "--create"}},
+	}, {
+		name:      "sysusers and tmpfiles changed",
+		oldConfig: newConfig("", tmpfiles, sysusers),
+		newConfig: newConfig("", newTmpfiles, newSysusers),
+		live:      true,
+		ran:       [][]string{{"systemd-sysusers"}, {"systemd-tmpfiles", "--create"}},
+	}, {
+		name:      "tmpfiles and other file changed",
+		oldConfig: newConfig("", tmpfiles, other),
+		newConfig: newConfig("", newTmpfiles, newOther),
+		live:      false,
+	}, {
+		name:      "sysusers and OS changed",
+		oldConfig: newConfig("", sysusers),
+		newConfig: newConfig("somethingDifferent", newSysusers),
+		live:      false,
+	}}
+
+	for _, test := range tests {
+		if live := liveupdate.IsSysusersTmpfilesOnlyChange(test.oldConfig, test.newConfig); live != test.live {
+			t.Errorf("%s: expected sysusers and tmpfiles only change to be %v, got %v", test.name, test.live, live)
+		}
+		if !test.live {
+			continue
+		}
+		if liveupdate.RebootRequired(test.oldConfig, test.newConfig) {
+			t.Errorf("%s: expected no reboot for a sysusers and tmpfiles only change", test.name)
+		}
+		var ran [][]string
+		run := func(command string, args ...string) error {
+			ran = append(ran, append([]string{command}, args...))
+			return nil
+		}
+		if err := runSysusersTmpfiles(test.oldConfig, test.newConfig, run); err != nil {
+			t.Fatalf("%s: Expected no error. Got %s.", test.name, err)
+		}
+		if !reflect.DeepEqual(ran, test.ran) {
+			t.Errorf("%s: Expected %v to run. Got %v.", test.name, test.ran, ran)
+		}
+	}
+
+	failing := func(string, ...string) error { return fmt.Errorf("exit status 1") }
+	if err := runSysusersTmpfiles(newConfig("", sysusers), newConfig("", newSysusers), failing); err == nil {
+		t.Error("Expected an error when systemd-sysusers fails")
+	}
+}
+
+// syncCountingFsClient is a FileSystemClient that writes to disk and counts
+// the sync calls made.
+type syncCountingFsClient struct {
+	FsClient
+	fsyncs   int
+	syncAlls int
+}
+
+func (f *syncCountingFsClient) Fsync(file *os.File) error {
+	f.fsyncs++
+	return f.FsClient.Fsync(file)
+}
+
+func (f *syncCountingFsClient) SyncAll() {
+	f.syncAlls++
+}
+
+// TestWriteFilesDurability verifies how files are synced in each file durability mode.
+func TestWriteFilesDurability(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-durability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []ignv2_2types.File
+	for i := 0; i < 3; i++ {
+		files = append(files, ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: filepath.Join(dir, "etc", fmt.Sprintf("file%d", i))},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:,hello"},
+			},
+		})
+	}
+
+	tests := []struct {
+		durability       string
+		expectedFsyncs   int
+		expectedSyncAlls int
+	}{
+		{durability: FileDurabilityFsyncFile, expectedFsyncs: 3, expectedSyncAlls: 0},
+		{durability: FileDurabilityFsyncBatch, expectedFsyncs: 0, expectedSyncAlls: 1},
+	}
+	for _, test := range tests {
+		fsClient := &syncCountingFsClient{}
+		d := Daemon{
+			fileSystemClient: fsClient,
+			fileDurability:   test.durability,
+		}
+		if err := d.writeFiles(files); err != nil {
+			t.Fatalf("%s: expected no error. Got %s.", test.durability, err)
+		}
+		if fsClient.fsyncs != test.expectedFsyncs {
+			t.Errorf("%s: expected %d file fsyncs, got %d", test.durability, test.expectedFsyncs, fsClient.fsyncs)
+		}
+		if fsClient.syncAlls != test.expectedSyncAlls {
+			t.Errorf("%s: expected %d batch syncs, got %d", test.durability, test.expectedSyncAlls, fsClient.syncAlls)
+		}
+		for _, f := range files {
+			if !checkFileContentsAndMode(f.Path, "hello", DefaultFilePermissions) {
+				t.Errorf("%s: file %s was not written as expected", test.durability, f.Path)
+			}
+		}
+	}
+}
+
+// orderRecordingFsClient is a FileSystemClient that writes to disk and records
+// the directories and files created, in order.
+type orderRecordingFsClient struct {
+	FsClient
+	mu     sync.Mutex
+	events []string
+}
+
+func (f *orderRecordingFsClient) record(event string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event)
+}
+
+func (f *orderRecordingFsClient) MkdirAll(name string, perm os.FileMode) error {
+	f.record("mkdir " + name)
+	return f.FsClient.MkdirAll(name, perm)
+}
+
+func (f *orderRecordingFsClient) Create(name string) (*os.File, error) {
+	f.record("create " + name)
+	return f.FsClient.Create(name)
+}
+
+// newTestFiles returns n files under dir spread over nested directories.
+func newTestFiles(dir string, n int) []ignv2_2types.File {
+	var files []ignv2_2types.File
+	for i := 0; i < n; i++ {
+		files = append(files, ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: filepath.Join(dir, "etc", fmt.Sprintf("d%d", i%10), fmt.Sprintf("d%d", i%3), fmt.Sprintf("file%d", i))},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: fmt.Sprintf("data:,file%d", i)},
+			},
+		})
+	}
+	return files
+}
+
+// TestWriteFilesConcurrent verifies files written by several workers are
+// written as with a single one, after their directories.
+func TestWriteFilesConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := newTestFiles(dir, 200)
+	// later entries for the same path win.
+	mode := 0600
+	overwritten := files[7]
+	overwritten.Contents.Source = "data:,overwritten"
+	overwritten.Mode = &mode
+	files = append(files, overwritten)
+
+	fsClient := &orderRecordingFsClient{}
+	d := Daemon{
+		fileSystemClient: fsClient,
+		fileDurability:   FileDurabilityFsyncFile,
+		fileWriteWorkers: 8,
+	}
+	if err := d.writeFiles(files); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	for i, f := range files[:200] {
+		contents, mode := fmt.Sprintf("file%d", i), DefaultFilePermissions
+		if i == 7 {
+			contents, mode = "overwritten", 0600
+		}
+		if !checkFileContentsAndMode(f.Path, contents, mode) {
+			t.Errorf("file %s was not written as expected", f.Path)
+		}
+	}
+
+	created := false
+	creates := map[string]int{}
+	for _, event := range fsClient.events {
+		if strings.HasPrefix(event, "create ") {
+			created = true
+			creates[strings.TrimPrefix(event, "create ")]++
+		} else if created {
+			t.Errorf("expected the directories to be created before the files, got %s after a file", event)
+		}
+	}
+	if creates[files[7].Path] != 2 || len(creates) != 200 {
+		t.Errorf("expected every entry to be written once, got %v", creates)
+	}
+}
+
+// TestWriteFilesRollback verifies the files written before a file fails are
+// restored, and the ones that didn't exist are removed.
+func TestWriteFilesRollback(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		dir, err := ioutil.TempDir("", "mcd-rollback")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		existing, added, broken := filepath.Join(dir, "existing"), filepath.Join(dir, "added"), filepath.Join(dir, "broken")
+		if err := ioutil.WriteFile(existing, []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// writing a file where there is a directory fails.
+		if err := os.Mkdir(broken, 0755); err != nil {
+			t.Fatal(err)
+		}
+		newFile := func(path string) ignv2_2types.File {
+			return ignv2_2types.File{
+				Node:          ignv2_2types.Node{Path: path},
+				FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:,new"}},
+			}
+		}
+
+		d := Daemon{
+			fileSystemClient: FsClient{},
+			fileDurability:   FileDurabilityFsyncFile,
+			fileWriteWorkers: workers,
+		}
+		recorder := &fileRecorder{}
+		if err := d.writeFilesRecorded([]ignv2_2types.File{newFile(existing), newFile(added), newFile(broken)}, recorder); err == nil {
+			t.Fatalf("%d workers: expected writing a file over a directory to fail", workers)
+		}
+		if !checkFileContentsAndMode(existing, "old", 0600) {
+			t.Errorf("%d workers: expected %s to be restored", workers, existing)
+		}
+		if _, err := os.Stat(added); !os.IsNotExist(err) {
+			t.Errorf("%d workers: expected %s to be removed, got: %v", workers, added, err)
+		}
+		if workers > 1 {
+			continue
+		}
+		results := map[string][]string{}
+		for _, f := range recorder.report("test").Files {
+			results[f.Path] = append(results[f.Path], f.Result)
+		}
+		expected := map[string][]string{
+			existing: {FileResultWritten, FileResultRolledBack},
+			added:    {FileResultWritten, FileResultRolledBack},
+			broken:   {FileResultFailed},
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("expected the results %v, got: %v", expected, results)
+		}
+	}
+}
+
+// TestParallelize verifies the bound on the workers and that a failure stops
+// starting new runs.
+func TestParallelize(t *testing.T) {
+	var (
+		mu             sync.Mutex
+		running, peak  int
+		ran            = map[int]bool{}
+		expectedErr    = fmt.Errorf("failed")
+		releaseRunning = make(chan struct{})
+	)
+	close(releaseRunning)
+	run := func(fail int) func(int) error {
+		return func(i int) error {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			ran[i] = true
+			mu.Unlock()
+			<-releaseRunning
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			if i == fail {
+				return expectedErr
+			}
+			return nil
+		}
+	}
+
+	if err := parallelize(50, 4, run(-1)); err != nil {
+		t.Fatalf("Expected no error. Got %s.", err)
+	}
+	if len(ran) != 50 {
+		t.Errorf("expected 50 runs, got %d", len(ran))
+	}
+	if peak > 4 {
+		t.Errorf("expected at most 4 concurrent runs, got %d", peak)
+	}
+
+	ran = map[int]bool{}
+	if err := parallelize(50, 1, run(5)); err != expectedErr {
+		t.Fatalf("expected error %v, got %v", expectedErr, err)
+	}
+	for i := 0; i < 50; i++ {
+		if ran[i] != (i <= 5) {
+			t.Errorf("expected only the runs up to the failed one to run with one worker, got %v", ran)
+			break
+		}
+	}
+
+	ran = map[int]bool{}
+	if err := parallelize(50, 4, run(5)); err != expectedErr {
+		t.Fatalf("expected error %v, got %v", expectedErr, err)
+	}
+	if len(ran) == 50 {
+		t.Errorf("expected the failure to stop starting new runs")
+	}
+}
+
+// BenchmarkWriteFiles compares writing many files with one and several workers.
+func BenchmarkWriteFiles(b *testing.B) {
+	dir, err := ioutil.TempDir("", "mcd-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := newTestFiles(dir, 1000)
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			d := Daemon{
+				fileSystemClient: NewFileSystemClient(),
+				fileDurability:   FileDurabilityFsyncFile,
+				fileWriteWorkers: workers,
+			}
+			for i := 0; i < b.N; i++ {
+				if err := d.writeFiles(files); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestOrderUnitsForRestart verifies that units are restarted after the units
+// they are ordered After=.
+func TestOrderUnitsForRestart(t *testing.T) {
+	newUnit := func(name, after string) ignv2_2types.Unit {
+		return ignv2_2types.Unit{
+			Name:     name,
+			Contents: fmt.Sprintf("[Unit]\nDescription=%s\nAfter=%s\n\n[Service]\nExecStart=/bin/true\n", name, after),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		units    []ignv2_2types.Unit
+		expected []string
+	}{{
+		name:     "no ordering",
+		units:    []ignv2_2types.Unit{newUnit("c.service", ""), newUnit("a.service", ""), newUnit("b.service", "")},
+		expected: []string{"a.service", "b.service", "c.service"},
+	}, {
+		name:     "chain",
+		units:    []ignv2_2types.Unit{newUnit("a.service", "b.service"), newUnit("b.service", "c.service"), newUnit("c.service", "")},
+		expected: []string{"c.service", "b.service", "a.service"},
+	}, {
+		name:     "multiple dependencies and units outside the set",
+		units:    []ignv2_2types.Unit{newUnit("a.service", "network.target c.service b.service"), newUnit("b.service", ""), newUnit("c.service", "")},
+		expected: []string{"b.service", "c.service", "a.service"},
+	}, {
+		name: "ordering from dropin",
+		units: []ignv2_2types.Unit{
+			newUnit("a.service", ""),
+			{Name: "b.service", Dropins: []ignv2_2types.SystemdDropin{{Name: "10-after.conf", Contents: "[Unit]\nAfter=c.service\n"}}},
+			newUnit("c.service", ""),
+		},
+		expected: []string{"a.service", "c.service", "b.service"},
+	}, {
+		name:     "cycle",
+		units:    []ignv2_2types.Unit{newUnit("a.service", "b.service"), newUnit("b.service", "a.service"), newUnit("c.service", "b.service")},
+		expected: []string{"a.service", "b.service", "c.service"},
+	}}
+
+	for _, test := range tests {
+		order := orderUnitsForRestart(test.units)
+		if !reflect.DeepEqual(order, test.expected) {
+			t.Errorf("%s: expected restart order %v, got %v", test.name, test.expected, order)
+		}
+	}
+}
+
+// TestRestartUnitsDelay verifies the delay between restarts and that a failed
+// restart stops the remaining restarts.
+func TestRestartUnitsDelay(t *testing.T) {
+	var events []string
+	restart := func(name string) error {
+		events = append(events, "restart "+name)
+		if name == "fail.service" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}
+	sleep := func(d time.Duration) {
+		events = append(events, "sleep "+d.String())
+	}
+
+	if err := restartUnits([]string{"a.service", "b.service", "c.service"}, 5*time.Second, restart, sleep); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []string{"restart a.service", "sleep 5s", "restart b.service", "sleep 5s", "restart c.service"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	events = nil
+	if err := restartUnits([]string{"a.service", "b.service"}, 0, restart, sleep); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected = []string{"restart a.service", "restart b.service"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	events = nil
+	if err := restartUnits([]string{"fail.service", "b.service"}, time.Second, restart, sleep); err == nil {
+		t.Errorf("expected an error when a restart fails")
+	}
+	expected = []string{"restart fail.service"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+}
+
+// TestChangedUnits verifies which units are restarted when units change.
+func TestChangedUnits(t *testing.T) {
+	disabled := false
+	oldUnits := []ignv2_2types.Unit{
+		{Name: "same.service", Contents: "same"},
+		{Name: "changed.service", Contents: "old"},
+	}
+	newUnits := []ignv2_2types.Unit{
+		{Name: "same.service", Contents: "same"},
+		{Name: "changed.service", Contents: "new"},
+		{Name: "added.service", Contents: "new"},
+		{Name: "masked.service", Mask: true},
+		{Name: "disabled.service", Contents: "new", Enabled: &disabled},
+	}
+
+	var names []string
+	for _, u := range changedUnits(oldUnits, newUnits) {
+		names = append(names, u.Name)
+	}
+	expected := []string{"changed.service", "added.service"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+// TestStoppedUnits verifies which units are stopped when units are removed,
+// masked or disabled.
+func TestStoppedUnits(t *testing.T) {
+	enabled, disabled := true, false
+	oldUnits := []ignv2_2types.Unit{
+		{Name: "same.service", Contents: "same"},
+		{Name: "removed.service", Contents: "old"},
+		{Name: "masked.service", Contents: "old"},
+		{Name: "disabled.service", Contents: "old", Enabled: &enabled},
+		{Name: "already-disabled.service", Contents: "old", Enabled: &disabled},
+	}
+	newUnits := []ignv2_2types.Unit{
+		{Name: "same.service", Contents: "same"},
+		{Name: "masked.service", Mask: true},
+		{Name: "disabled.service", Contents: "old", Enabled: &disabled},
+		{Name: "added.service", Contents: "new"},
+	}
+
+	expected := []string{"disabled.service", "masked.service", "removed.service"}
+	if names := stoppedUnits(oldUnits, newUnits); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+// TestEnvironmentFileUnits verifies which units are restarted when their
+// environment files change.
+func TestEnvironmentFileUnits(t *testing.T) {
+	newConfig := func(osImageURL string, files []ignv2_2types.File) *mcfgv1.MachineConfig {
+		disabled := false
+		return &mcfgv1.MachineConfig{
+			Spec: mcfgv1.MachineConfigSpec{
+				OSImageURL: osImageURL,
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+					Systemd: ignv2_2types.Systemd{Units: []ignv2_2types.Unit{
+						{Name: "a.service", Contents: "[Service]\nEnvironmentFile=/etc/sysconfig/a\n"},
+						{Name: "b.service", Contents: "[Unit]\nAfter=a.service\n[Service]\nEnvironmentFile=-/etc/sysconfig/b\nEnvironmentFile=/etc/sysconfig/shared\n"},
+						{Name: "c.service", Contents: "[Service]\nEnvironmentFile=/etc/sysconfig/shared\n"},
+						{Name: "disabled.service", Contents: "[Service]\nEnvironmentFile=/etc/sysconfig/shared\n", Enabled: &disabled},
+						{Name: "none.service", Contents: "[Service]\nExecStart=/bin/true\n"},
+					}},
+				},
+			},
+		}
+	}
+	file := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node:           ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:," + contents}},
+		}
+	}
+	base := []ignv2_2types.File{file("/etc/sysconfig/a", "A=1"), file("/etc/sysconfig/shared", "S=1"), file("/etc/foo", "foo")}
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		live      bool
+		restarted []string
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("", base),
+		live:      false,
+	}, {
+		name:      "environment file changed",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("", []ignv2_2types.File{file("/etc/sysconfig/a", "A=2"), base[1], base[2]}),
+		live:      true,
+		restarted: []string{"a.service"},
+	}, {
+		name:      "shared environment file changed",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("", []ignv2_2types.File{base[0], file("/etc/sysconfig/shared", "S=2"), base[2]}),
+		live:      true,
+		restarted: []string{"b.service", "c.service"},
+	}, {
+		name:      "optional environment file added",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("", append([]ignv2_2types.File{file("/etc/sysconfig/b", "B=1")}, base...)),
+		live:      true,
+		restarted: []string{"b.service"},
+	}, {
+		name:      "environment file removed",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("", base[1:]),
+		live:      true,
+		restarted: []string{"a.service"},
+	}, {
+		name:      "environment file and other file changed",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("", []ignv2_2types.File{file("/etc/sysconfig/a", "A=2"), base[1], file("/etc/foo", "bar")}),
+		live:      false,
+		restarted: []string{"a.service"},
+	}, {
+		name:      "environment file and OS changed",
+		oldConfig: newConfig("", base),
+		newConfig: newConfig("somethingDifferent", []ignv2_2types.File{file("/etc/sysconfig/a", "A=2"), base[1], base[2]}),
+		live:      false,
+		restarted: []string{"a.service"},
+	}}
+
+	for _, test := range tests {
+		if live := liveupdate.IsEnvironmentFilesOnlyChange(test.oldConfig, test.newConfig); live != test.live {
+			t.Errorf("%s: expected environment files only change to be %v, got %v", test.name, test.live, live)
+		}
+		if reboot := liveupdate.RebootRequired(test.oldConfig, test.newConfig); test.live && reboot {
+			t.Errorf("%s: expected no reboot to be required", test.name)
+		}
+		var names []string
+		for _, u := range environmentFileUnits(test.oldConfig, test.newConfig) {
+			names = append(names, u.Name)
+		}
+		if !reflect.DeepEqual(names, test.restarted) {
+			t.Errorf("%s: expected %v to be restarted, got %v", test.name, test.restarted, names)
+		}
+	}
+}
+
+// TestWritablePath verifies where files are written when parts of the root
+// are mounted read-only.
+func TestWritablePath(t *testing.T) {
+	tests := []struct {
+		name          string
+		readOnlyPaths []string
+		path          string
+		expected      string
+		expectErr     bool
+	}{{
+		name:     "writable root",
+		path:     "/usr/local/bin/foo",
+		expected: "/usr/local/bin/foo",
+	}, {
+		name:          "writable /etc on read-only root",
+		readOnlyPaths: []string{"/usr"},
+		path:          "/etc/foo",
+		expected:      "/etc/foo",
+	}, {
+		name:          "managed path on read-only root",
+		readOnlyPaths: []string{"/usr"},
+		path:          "/usr/local/bin/foo",
+		expected:      "/var/usrlocal/bin/foo",
+	}, {
+		name:          "managed path is cleaned",
+		readOnlyPaths: []string{"/opt"},
+		path:          "/opt//foo/../bar",
+		expected:      "/var/opt/bar",
+	}, {
+		name:          "managed path with read-only /var",
+		readOnlyPaths: []string{"/usr", "/opt", "/var"},
+		path:          "/opt/bar",
+		expectErr:     true,
+	}, {
+		name:          "unmanaged read-only path",
+		readOnlyPaths: []string{"/usr"},
+		path:          "/usr/lib/foo",
+		expectErr:     true,
+	}}
+
+	for _, test := range tests {
+		d := Daemon{fileSystemClient: FsClientMock{ReadOnlyPaths: test.readOnlyPaths}}
+		path, err := d.writablePath(test.path)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got path %q", test.name, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", test.name, err)
+			continue
+		}
+		if path != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, path)
+		}
+	}
+}
+
+// TestWriteFilesReadOnly verifies that writing a file to an unmanaged
+// read-only path fails without touching the filesystem.
+func TestWriteFilesReadOnly(t *testing.T) {
+	d := Daemon{fileSystemClient: FsClientMock{ReadOnlyPaths: []string{"/usr"}}}
+	files := []ignv2_2types.File{{
+		Node: ignv2_2types.Node{Path: "/usr/lib/foo"},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{
+			Contents: ignv2_2types.FileContents{Source: "data:,foo"},
+		},
+	}}
+	err := d.writeFiles(files)
+	if err == nil {
+		t.Fatalf("expected writing to a read-only path to fail")
+	}
+	if !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("expected a read-only error, got %v", err)
+	}
+}
+
+// unitsOnDiskFsClient is a FileSystemClient where only the given paths exist.
+type unitsOnDiskFsClient struct {
+	FsClientMock
+	existing map[string]bool
+}
+
+func (f unitsOnDiskFsClient) Stat(name string) (os.FileInfo, error) {
+	if f.existing[name] {
+		return nil, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// TestCheckUnitDependencies verifies that required units must either be in the
+// config or on disk.
+func TestCheckUnitDependencies(t *testing.T) {
+	d := Daemon{fileSystemClient: unitsOnDiskFsClient{existing: map[string]bool{
+		"/usr/lib/systemd/system/network-online.target": true,
+		"/usr/lib/systemd/system/getty@.service":        true,
+	}}}
+	newUnit := func(name, unitSection string) ignv2_2types.Unit {
+		return ignv2_2types.Unit{Name: name, Contents: "[Unit]\n" + unitSection + "\n[Service]\nExecStart=/bin/true\n"}
+	}
+	dep := newUnit("dep.service", "")
+
+	tests := []struct {
+		name      string
+		unit      ignv2_2types.Unit
+		expectErr bool
+	}{{
+		name: "no requirements",
+		unit: newUnit("foo.service", "After=network-online.target"),
+	}, {
+		name: "required unit on disk",
+		unit: newUnit("foo.service", "Requires=network-online.target"),
+	}, {
+		name: "required unit in config",
+		unit: newUnit("foo.service", "BindsTo=dep.service"),
+	}, {
+		name: "required template instance on disk",
+		unit: newUnit("foo.service", "Requisite=getty@tty1.service"),
+	}, {
+		name:      "missing required unit",
+		unit:      newUnit("foo.service", "Requires=dep.service missing.service"),
+		expectErr: true,
+	}, {
+		name: "missing required unit reset by dropin",
+		unit: func() ignv2_2types.Unit {
+			u := newUnit("foo.service", "Requires=missing.service")
+			u.Dropins = []ignv2_2types.SystemdDropin{{Name: "10-reset.conf", Contents: "[Unit]\nRequires=\n"}}
+			return u
+		}(),
+	}, {
+		name:      "missing unit in wrong section is ignored",
+		unit:      ignv2_2types.Unit{Name: "foo.service", Contents: "[Install]\nRequires=missing.service\n"},
+		expectErr: false,
+	}}
+
+	for _, test := range tests {
+		err := d.checkUnitDependencies(test.unit, []ignv2_2types.Unit{test.unit, dep})
+		if test.expectErr && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if !test.expectErr && err != nil {
+			t.Errorf("%s: expected no error, got %v", test.name, err)
+		}
+	}
+
+	// writing an enabled unit with a dangling requirement fails before
+	// anything is written.
+	enabled := true
+	unit := newUnit("foo.service", "Requires=missing.service")
+	unit.Enabled = &enabled
+	err := d.writeUnits([]ignv2_2types.Unit{unit})
+	if err == nil || !strings.Contains(err.Error(), "missing.service") {
+		t.Errorf("expected an error about missing.service, got %v", err)
+	}
+}
+
+// systemdTree returns the files under the systemd units directory of root by
+// their path, with the target of symlinks instead of their contents.
+func systemdTree(t *testing.T, root string) map[string]string {
+	tree := map[string]string{}
+	dir := filepath.Join(root, pathSystemd)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			tree[path[len(dir):]] = "-> " + target
+			return err
+		}
+		contents, err := ioutil.ReadFile(path)
+		tree[path[len(dir):]] = string(contents)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// TestWriteUnitsOverlay verifies that on a machine with the units of base,
+// writing them merged with their overlay gives the same units as writing the
+// units of desired.
+func TestWriteUnitsOverlay(t *testing.T) {
+	enabled, disabled := true, false
+	foo := ignv2_2types.Unit{
+		Name:     "foo.service",
+		Enabled:  &enabled,
+		Contents: "[Service]\nExecStart=/usr/bin/foo\n",
+		Dropins:  []ignv2_2types.SystemdDropin{{Name: "10-env.conf", Contents: "[Service]\nEnvironment=FOO=1\n"}},
+	}
+	bar := ignv2_2types.Unit{Name: "bar.service", Enabled: &enabled, Contents: "[Service]\nExecStart=/usr/bin/bar\n"}
+	base := &mcfgv1.MachineConfig{}
+	base.Name = "00-base"
+	base.Spec.Config.Systemd.Units = []ignv2_2types.Unit{foo}
+
+	tests := []struct {
+		name    string
+		desired func(*ignv2_2types.Unit)
+	}{{
+		name: "contents and dropin changed",
+		desired: func(u *ignv2_2types.Unit) {
+			u.Contents = "[Service]\nExecStart=/usr/bin/foo --verbose\n"
+			u.Dropins = append(u.Dropins, ignv2_2types.SystemdDropin{Name: "20-limits.conf", Contents: "[Service]\nLimitNOFILE=65536\n"})
+		},
+	}, {
+		name: "disabled",
+		desired: func(u *ignv2_2types.Unit) {
+			u.Enabled = &disabled
+		},
+	}, {
+		name: "masked",
+		desired: func(u *ignv2_2types.Unit) {
+			u.Mask = true
+		},
+	}}
+
+	for _, test := range tests {
+		desired := base.DeepCopy()
+		test.desired(&desired.Spec.Config.Systemd.Units[0])
+		desired.Spec.Config.Systemd.Units = append(desired.Spec.Config.Systemd.Units, bar)
+
+		overlay, err := mcfgv1.ComputeOverlay(base, desired)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		overlay.Name = "99-overlay"
+		merged, err := mcfgv1.MergeMachineConfigs([]*mcfgv1.MachineConfig{base.DeepCopy(), overlay}, mcfgv1.MergeConflictLastWins)
+		if err != nil {
+			t.Fatalf("%s: unexpected merge error: %v", test.name, err)
+		}
+
+		var trees []map[string]string
+		for _, units := range [][]ignv2_2types.Unit{merged.Spec.Config.Systemd.Units, desired.Spec.Config.Systemd.Units} {
+			root, err := ioutil.TempDir("", "mcd-overlay")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			if err := os.MkdirAll(filepath.Join(root, wantsPathSystemd), 0755); err != nil {
+				t.Fatal(err)
+			}
+			d := Daemon{fileSystemClient: rootFsClient{root: root}}
+			if err := d.writeUnits(base.Spec.Config.Systemd.Units); err != nil {
+				t.Fatalf("%s: unexpected error writing the base units: %v", test.name, err)
+			}
+			if err := d.writeUnits(units); err != nil {
+				t.Fatalf("%s: unexpected error writing units: %v", test.name, err)
+			}
+			trees = append(trees, systemdTree(t, root))
+		}
+		if !reflect.DeepEqual(trees[0], trees[1]) {
+			t.Errorf("%s: expected the units written %v, got: %v", test.name, trees[1], trees[0])
+		}
+	}
+}
+
+// TestWriteFilesFromSecret verifies that files sourced from a secret on the
+// machine are written with its contents and only readable by their owner, and
+// that a missing secret fails the write.
+func TestWriteFilesFromSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcd-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "secrets", "token")
+	if err := os.MkdirAll(filepath.Dir(secret), DefaultDirectoryPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secret, []byte("s3cr3t"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	mode := 0644
+	newSecretFile := func(path, source string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Mode:     &mode,
+				Contents: ignv2_2types.FileContents{Source: source},
+			},
+		}
+	}
+	d := Daemon{fileSystemClient: NewFileSystemClient()}
+
+	target := filepath.Join(dir, "etc", "token")
+	files := []ignv2_2types.File{newSecretFile(target, "secret://"+secret)}
+	if err := d.writeFiles(files); err != nil {
+		t.Fatalf("Expected no error writing secret file. Got %s.", err)
+	}
+	if !checkFileContentsAndMode(target, "s3cr3t", SecretFilePermissions) {
+		t.Errorf("Expected %s to have the secret contents and mode %v", target, SecretFilePermissions)
+	}
+	if !d.checkFiles(files) {
+		t.Errorf("Expected written secret file to be in its desired state")
+	}
+
+	// the secret changed on the machine, the file has drifted
+	if err := os.Chmod(secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secret, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if d.checkFiles(files) {
+		t.Errorf("Expected file to differ from the rotated secret")
+	}
+
+	for _, source := range []string{
+		"secret://" + filepath.Join(dir, "secrets", "missing"),
+		"secret://relative/path",
+	} {
+		missing := filepath.Join(dir, "etc", "missing")
+		err := d.writeFiles([]ignv2_2types.File{newSecretFile(missing, source)})
+		if err == nil {
+			t.Errorf("%s: expected writing the file to fail", source)
+		}
+		if _, err := os.Stat(missing); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no file to be written, got %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package daemon
+
+import (
+	"fmt"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// validateUserUIDs returns an error if users with different names are created
+// with the same uid, they would own each other's files.
+func validateUserUIDs(users []ignv2_2types.PasswdUser) error {
+	names := map[int]string{}
+	for _, u := range users {
+		if u.UID == nil {
+			continue
+		}
+		name, ok := names[*u.UID]
+		if !ok {
+			names[*u.UID] = u.Name
+			continue
+		}
+		if name != u.Name {
+			return fmt.Errorf("users %s and %s have the same uid %d", name, u.Name, *u.UID)
+		}
+	}
+	return nil
+}
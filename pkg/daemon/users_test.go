@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestValidateUserUIDs(t *testing.T) {
+	newUser := func(name string, uid int) ignv2_2types.PasswdUser {
+		return ignv2_2types.PasswdUser{Name: name, UID: &uid}
+	}
+
+	tests := []struct {
+		name  string
+		users []ignv2_2types.PasswdUser
+		err   string
+	}{{
+		name:  "distinct uids",
+		users: []ignv2_2types.PasswdUser{newUser("core", 1000), newUser("backup", 1001), {Name: "admin"}, {Name: "monitor"}},
+	}, {
+		name:  "the same user listed twice",
+		users: []ignv2_2types.PasswdUser{newUser("core", 1000), newUser("core", 1000)},
+	}, {
+		name:  "users with the same uid",
+		users: []ignv2_2types.PasswdUser{newUser("core", 1000), newUser("backup", 1001), newUser("admin", 1000)},
+		err:   "users core and admin have the same uid 1000",
+	}}
+	for _, test := range tests {
+		err := validateUserUIDs(test.users)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+		}
+	}
+}
@@ -2,11 +2,14 @@ package daemon
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 )
 
@@ -21,11 +24,18 @@ type message struct {
 	node            string
 	annos           map[string]string
 	responseChannel chan error
+	// degradedReason is why the node is marked Degraded.
+	degradedReason string
 }
 
 // NodeWriter A single writer to Kubernetes to prevent race conditions
 type NodeWriter struct {
 	writer chan message
+
+	recorderLock sync.Mutex
+	// recorder records events on the node when it enters and recovers from
+	// the Degraded state.
+	recorder record.EventRecorder
 }
 
 // NewNodeWriter Create a new NodeWriter
@@ -43,11 +53,69 @@ func (nw *NodeWriter) Run(stop <-chan struct{}) {
 		case <-stop:
 			return
 		case msg := <-nw.writer:
-			msg.responseChannel <- setNodeAnnotations(msg.client, msg.node, msg.annos)
+			msg.responseChannel <- nw.write(msg)
 		}
 	}
 }
 
+// SetEventRecorder sets the recorder of the events on the node when it enters
+// and recovers from the Degraded state.
+func (nw *NodeWriter) SetEventRecorder(recorder record.EventRecorder) {
+	nw.recorderLock.Lock()
+	defer nw.recorderLock.Unlock()
+	nw.recorder = recorder
+}
+
+// write sets the annotations of the message on the node and records an event
+// if that moves the node into or out of the Degraded state.
+func (nw *NodeWriter) write(msg message) error {
+	var (
+		updated  *v1.Node
+		oldState string
+	)
+	err := updateNodeRetry(msg.client, msg.node, func(node *v1.Node) {
+		oldState = node.Annotations[MachineConfigDaemonStateAnnotationKey]
+		for k, v := range msg.annos {
+			node.Annotations[k] = v
+		}
+		updated = node
+	})
+	if err != nil {
+		return err
+	}
+
+	nw.recorderLock.Lock()
+	recorder := nw.recorder
+	nw.recorderLock.Unlock()
+	if recorder != nil {
+		recordStateTransition(recorder, updated, oldState, msg.degradedReason)
+	}
+	return nil
+}
+
+// recordStateTransition records an event on the node if it entered the
+// Degraded state, with the reason and the configs involved, or recovered from
+// it. The configs are also attached to the event as annotations.
+func recordStateTransition(recorder record.EventRecorder, node *v1.Node, oldState, degradedReason string) {
+	newState := node.Annotations[MachineConfigDaemonStateAnnotationKey]
+	if (oldState == MachineConfigDaemonStateDegraded) == (newState == MachineConfigDaemonStateDegraded) {
+		return
+	}
+	current := node.Annotations[CurrentMachineConfigAnnotationKey]
+	desired := node.Annotations[DesiredMachineConfigAnnotationKey]
+	annotations := map[string]string{
+		CurrentMachineConfigAnnotationKey: current,
+		DesiredMachineConfigAnnotationKey: desired,
+	}
+	if newState == MachineConfigDaemonStateDegraded {
+		recorder.AnnotatedEventf(node, annotations, v1.EventTypeWarning, "NodeDegraded",
+			"Node is Degraded updating from config %s to %s: %s", current, desired, degradedReason)
+		return
+	}
+	recorder.AnnotatedEventf(node, annotations, v1.EventTypeNormal, "NodeRecovered",
+		"Node recovered from Degraded and is %s with config %s", newState, current)
+}
+
 // SetUpdateDone Sets the state to UpdateDone.
 func (nw *NodeWriter) SetUpdateDone(client corev1.NodeInterface, node string, dcAnnotation string) error {
 	annos := map[string]string{
@@ -79,6 +147,99 @@ func (nw *NodeWriter) SetUpdateWorking(client corev1.NodeInterface, node string)
 	return <-respChan
 }
 
+// SetUpdateWaitingForRebootApproval Sets the state to WaitingForRebootApproval.
+func (nw *NodeWriter) SetUpdateWaitingForRebootApproval(client corev1.NodeInterface, node string) error {
+	annos := map[string]string{
+		MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateWaitingForRebootApproval,
+	}
+	respChan := make(chan error, 1)
+	nw.writer <- message{
+		client:          client,
+		node:            node,
+		annos:           annos,
+		responseChannel: respChan,
+	}
+	return <-respChan
+}
+
+// SetRebootStart records the time at which the daemon triggered a reboot.
+func (nw *NodeWriter) SetRebootStart(client corev1.NodeInterface, node string, start time.Time) error {
+	annos := map[string]string{
+		MachineConfigDaemonRebootStartAnnotationKey: start.UTC().Format(time.RFC3339),
+	}
+	respChan := make(chan error, 1)
+	nw.writer <- message{
+		client:          client,
+		node:            node,
+		annos:           annos,
+		responseChannel: respChan,
+	}
+	return <-respChan
+}
+
+// SetRebootDowntime records how long the last reboot took and clears the
+// reboot start time so that it is only accounted for once.
+func (nw *NodeWriter) SetRebootDowntime(client corev1.NodeInterface, node string, downtime time.Duration) error {
+	annos := map[string]string{
+		MachineConfigDaemonRebootStartAnnotationKey:    "",
+		MachineConfigDaemonRebootDowntimeAnnotationKey: downtime.String(),
+	}
+	respChan := make(chan error, 1)
+	nw.writer <- message{
+		client:          client,
+		node:            node,
+		annos:           annos,
+		responseChannel: respChan,
+	}
+	return <-respChan
+}
+
+// SetDaemonVersion records the version of the daemon running on the node.
+func (nw *NodeWriter) SetDaemonVersion(client corev1.NodeInterface, node string, version string) error {
+	annos := map[string]string{
+		MachineConfigDaemonVersionAnnotationKey: version,
+	}
+	respChan := make(chan error, 1)
+	nw.writer <- message{
+		client:          client,
+		node:            node,
+		annos:           annos,
+		responseChannel: respChan,
+	}
+	return <-respChan
+}
+
+// ClearForceSync marks the force sync request on the node as handled.
+func (nw *NodeWriter) ClearForceSync(client corev1.NodeInterface, node string) error {
+	annos := map[string]string{
+		MachineConfigDaemonForceSyncAnnotationKey: "",
+	}
+	respChan := make(chan error, 1)
+	nw.writer <- message{
+		client:          client,
+		node:            node,
+		annos:           annos,
+		responseChannel: respChan,
+	}
+	return <-respChan
+}
+
+// SetDrainBlockedBy records the pods blocking the drain of the node, an empty
+// value clears them.
+func (nw *NodeWriter) SetDrainBlockedBy(client corev1.NodeInterface, node string, blockedBy string) error {
+	annos := map[string]string{
+		MachineConfigDaemonDrainBlockedByAnnotationKey: blockedBy,
+	}
+	respChan := make(chan error, 1)
+	nw.writer <- message{
+		client:          client,
+		node:            node,
+		annos:           annos,
+		responseChannel: respChan,
+	}
+	return <-respChan
+}
+
 // SetUpdateDegraded logs the error and sets the state to UpdateDegraded.
 // Returns an error if it couldn't set the annotation.
 func (nw *NodeWriter) SetUpdateDegraded(err error, client corev1.NodeInterface, node string) error {
@@ -92,6 +253,7 @@ func (nw *NodeWriter) SetUpdateDegraded(err error, client corev1.NodeInterface,
 		node:            node,
 		annos:           annos,
 		responseChannel: respChan,
+		degradedReason:  err.Error(),
 	}
 	return <-respChan
 }
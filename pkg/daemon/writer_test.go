@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// recordedEvent is an event recorded by eventsRecorder.
+type recordedEvent struct {
+	annotations map[string]string
+	eventtype   string
+	reason      string
+	message     string
+}
+
+// eventsRecorder records the annotated events, unlike record.FakeRecorder
+// which loses their annotations.
+type eventsRecorder struct {
+	record.EventRecorder
+	events []recordedEvent
+}
+
+func (r *eventsRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.events = append(r.events, recordedEvent{
+		annotations: annotations,
+		eventtype:   eventtype,
+		reason:      reason,
+		message:     fmt.Sprintf(messageFmt, args...),
+	})
+}
+
+// TestNodeWriterDegradedEvents verifies that events are recorded when the node
+// enters and recovers from the Degraded state, and only then.
+func TestNodeWriterDegradedEvents(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	nw := NewNodeWriter()
+	recorder := &eventsRecorder{}
+	nw.SetEventRecorder(recorder)
+	go nw.Run(stopCh)
+
+	client := k8sfake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nodeName",
+			Annotations: map[string]string{
+				CurrentMachineConfigAnnotationKey:     "old",
+				DesiredMachineConfigAnnotationKey:     "new",
+				MachineConfigDaemonStateAnnotationKey: MachineConfigDaemonStateWorking,
+			},
+		},
+	}).CoreV1().Nodes()
+
+	if err := nw.SetUpdateDegraded(fmt.Errorf("failed to write file"), client, "nodeName"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected 1 event, got %v", recorder.events)
+	}
+	event := recorder.events[0]
+	if event.eventtype != corev1.EventTypeWarning || event.reason != "NodeDegraded" {
+		t.Errorf("expected a Warning NodeDegraded event, got %s %s", event.eventtype, event.reason)
+	}
+	if !strings.Contains(event.message, "failed to write file") {
+		t.Errorf("expected the event to contain the reason, got %q", event.message)
+	}
+	if event.annotations[CurrentMachineConfigAnnotationKey] != "old" || event.annotations[DesiredMachineConfigAnnotationKey] != "new" {
+		t.Errorf("expected the event to be annotated with the configs, got %v", event.annotations)
+	}
+
+	// staying Degraded records no further events.
+	if err := nw.SetUpdateDegraded(fmt.Errorf("failed again"), client, "nodeName"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.events) != 1 {
+		t.Fatalf("expected no new event while Degraded, got %v", recorder.events)
+	}
+
+	if err := nw.SetUpdateDone(client, "nodeName", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.events) != 2 {
+		t.Fatalf("expected 2 events, got %v", recorder.events)
+	}
+	event = recorder.events[1]
+	if event.eventtype != corev1.EventTypeNormal || event.reason != "NodeRecovered" {
+		t.Errorf("expected a Normal NodeRecovered event, got %s %s", event.eventtype, event.reason)
+	}
+	if event.annotations[CurrentMachineConfigAnnotationKey] != "new" {
+		t.Errorf("expected the event to be annotated with the new config, got %v", event.annotations)
+	}
+
+	if err := nw.SetUpdateWorking(client, "nodeName"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.events) != 2 {
+		t.Errorf("expected no event outside of Degraded, got %v", recorder.events)
+	}
+}
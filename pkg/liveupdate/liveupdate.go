@@ -0,0 +1,289 @@
+// Package liveupdate decides which changes between two MachineConfigs the
+// daemon applies to a running node, and which take a reboot. It is shared by
+// the daemon applying the changes and the controllers predicting their impact.
+package liveupdate
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	// pathUdevRules is the path where local udev rules reside
+	pathUdevRules = "/etc/udev/rules.d"
+	// pathCATrustAnchors is the path where local CA trust anchors reside
+	pathCATrustAnchors = "/etc/pki/ca-trust/source/anchors"
+	// pathSysusers is the path where local systemd-sysusers configs reside
+	pathSysusers = "/etc/sysusers.d"
+	// pathTmpfiles is the path where local systemd-tmpfiles configs reside
+	pathTmpfiles = "/etc/tmpfiles.d"
+)
+
+// IsUdevRule returns true if the given path is a udev rule managed by the
+// daemon.
+func IsUdevRule(path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), pathUdevRules+"/")
+}
+
+// IsCATrustAnchor returns true if the given path is a CA trust anchor managed
+// by the daemon.
+func IsCATrustAnchor(path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), pathCATrustAnchors+"/")
+}
+
+// IsSysusersConfig returns true if the given path is a systemd-sysusers
+// config managed by the daemon.
+func IsSysusersConfig(path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), pathSysusers+"/")
+}
+
+// IsTmpfilesConfig returns true if the given path is a systemd-tmpfiles config
+// managed by the daemon.
+func IsTmpfilesConfig(path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), pathTmpfiles+"/")
+}
+
+// isSysusersOrTmpfilesConfig returns true if the given path is either a
+// systemd-sysusers or a systemd-tmpfiles config.
+func isSysusersOrTmpfilesConfig(path string) bool {
+	return IsSysusersConfig(path) || IsTmpfilesConfig(path)
+}
+
+// SplitFiles splits the files into the ones whose path matches and everything
+// else.
+func SplitFiles(files []ignv2_2types.File, match func(string) bool) ([]ignv2_2types.File, []ignv2_2types.File) {
+	var matched, others []ignv2_2types.File
+	for _, f := range files {
+		if match(f.Path) {
+			matched = append(matched, f)
+		} else {
+			others = append(others, f)
+		}
+	}
+	return matched, others
+}
+
+// isOSOrTuningChange returns true if the configs have a different OS image or
+// tuning profile, which always take a reboot to apply.
+func isOSOrTuningChange(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	return oldConfig.Spec.OSImageURL != newConfig.Spec.OSImageURL ||
+		!reflect.DeepEqual(oldConfig.Spec.TuningProfile, newConfig.Spec.TuningProfile)
+}
+
+// isMatchingFilesOnlyChange returns true if the only differences between the
+// old and the new config are in the files whose path matches.
+func isMatchingFilesOnlyChange(oldConfig, newConfig *mcfgv1.MachineConfig, match func(string) bool) bool {
+	if isOSOrTuningChange(oldConfig, newConfig) {
+		return false
+	}
+
+	oldIgn := oldConfig.Spec.Config
+	newIgn := newConfig.Spec.Config
+	oldMatched, oldFiles := SplitFiles(oldIgn.Storage.Files, match)
+	newMatched, newFiles := SplitFiles(newIgn.Storage.Files, match)
+	if reflect.DeepEqual(oldMatched, newMatched) {
+		// nothing changed in the matching files
+		return false
+	}
+
+	// compare everything but the matching files.
+	oldIgn.Storage.Files = oldFiles
+	newIgn.Storage.Files = newFiles
+	return reflect.DeepEqual(oldIgn, newIgn)
+}
+
+// IsUdevRulesOnlyChange returns true if the only differences between the old
+// and the new config are in udev rule files. Such changes can be applied
+// without rebooting the node.
+func IsUdevRulesOnlyChange(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	return isMatchingFilesOnlyChange(oldConfig, newConfig, IsUdevRule)
+}
+
+// IsCATrustAnchorsOnlyChange returns true if the only differences between the
+// old and the new config are in CA trust anchor files. Such changes can be
+// applied without rebooting the node.
+func IsCATrustAnchorsOnlyChange(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	return isMatchingFilesOnlyChange(oldConfig, newConfig, IsCATrustAnchor)
+}
+
+// IsSysusersTmpfilesOnlyChange returns true if the only differences between
+// the old and the new config are in sysusers.d and tmpfiles.d configs. Such
+// changes can be applied without rebooting the node.
+func IsSysusersTmpfilesOnlyChange(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	return isMatchingFilesOnlyChange(oldConfig, newConfig, isSysusersOrTmpfilesConfig)
+}
+
+// unitRestartInPlaceDirective is the [Unit] directive with which a unit opts
+// in to having its changes applied by restarting it instead of draining and
+// rebooting the node. systemd ignores the directives prefixed with X-.
+const unitRestartInPlaceDirective = "X-MachineConfigRestartInPlace"
+
+// nodeCriticalUnits are the units running the workloads of the node.
+var nodeCriticalUnits = map[string]bool{
+	"crio.service":    true,
+	"kubelet.service": true,
+}
+
+// IsNodeCriticalUnit returns true if the unit runs the workloads of the node.
+// Changes to such units are always applied by draining and rebooting the
+// node, even if they opt in to being restarted in place.
+func IsNodeCriticalUnit(name string) bool {
+	return nodeCriticalUnits[name]
+}
+
+// UnitRestartsInPlace returns true if the unit opted in to being restarted in
+// place and isn't node critical.
+func UnitRestartsInPlace(u ignv2_2types.Unit) bool {
+	if IsNodeCriticalUnit(u.Name) {
+		return false
+	}
+	values := UnitDirective(u, unitRestartInPlaceDirective)
+	return len(values) > 0 && values[len(values)-1] == "true"
+}
+
+// IsUnitsOnlyChange returns true if the only differences between the old and
+// the new config are in systemd units that all restart in place. Such changes
+// can be applied by restarting the changed units and stopping the removed
+// ones instead of rebooting the node. A unit removed by the new config or
+// left without contents, as masked units are, restarts in place if it did in
+// the old config.
+func IsUnitsOnlyChange(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	if isOSOrTuningChange(oldConfig, newConfig) {
+		return false
+	}
+
+	oldIgn := oldConfig.Spec.Config
+	newIgn := newConfig.Spec.Config
+	if reflect.DeepEqual(oldIgn.Systemd, newIgn.Systemd) {
+		// nothing changed in the units, so this isn't a unit change
+		return false
+	}
+
+	old := make(map[string]ignv2_2types.Unit, len(oldIgn.Systemd.Units))
+	for _, u := range oldIgn.Systemd.Units {
+		old[u.Name] = u
+	}
+	for _, u := range newIgn.Systemd.Units {
+		o, ok := old[u.Name]
+		delete(old, u.Name)
+		if ok && reflect.DeepEqual(o, u) {
+			continue
+		}
+		if u.Contents == "" && ok {
+			u = o
+		}
+		if !UnitRestartsInPlace(u) {
+			return false
+		}
+	}
+	for _, u := range old {
+		if !UnitRestartsInPlace(u) {
+			return false
+		}
+	}
+
+	// compare everything but the units.
+	oldIgn.Systemd = newIgn.Systemd
+	return reflect.DeepEqual(oldIgn, newIgn)
+}
+
+// UnitEnvironmentFiles returns the paths of the EnvironmentFile= directives in
+// the [Service] section of the unit and its dropins. A leading "-", marking the
+// file optional, is dropped, and an empty assignment resets the list.
+func UnitEnvironmentFiles(u ignv2_2types.Unit) []string {
+	contents := []string{u.Contents}
+	for _, d := range u.Dropins {
+		contents = append(contents, d.Contents)
+	}
+
+	var paths []string
+	for _, c := range contents {
+		section := ""
+		for _, line := range strings.Split(c, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				section = line
+				continue
+			}
+			if section != "[Service]" || !strings.HasPrefix(line, "EnvironmentFile=") {
+				continue
+			}
+			path := strings.TrimSpace(strings.TrimPrefix(line, "EnvironmentFile="))
+			if path == "" {
+				paths = nil
+				continue
+			}
+			paths = append(paths, strings.TrimPrefix(path, "-"))
+		}
+	}
+	return paths
+}
+
+// EnvironmentFileMatcher returns a func matching the paths that are
+// environment files of the units.
+func EnvironmentFileMatcher(units []ignv2_2types.Unit) func(string) bool {
+	envFiles := map[string]bool{}
+	for _, u := range units {
+		for _, path := range UnitEnvironmentFiles(u) {
+			envFiles[path] = true
+		}
+	}
+	return func(path string) bool {
+		return envFiles[path]
+	}
+}
+
+// IsEnvironmentFilesOnlyChange returns true if the only differences between
+// the old and the new config are in the environment files of its units. Such
+// changes can be applied by restarting the units using them instead of
+// rebooting the node.
+func IsEnvironmentFilesOnlyChange(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	return isMatchingFilesOnlyChange(oldConfig, newConfig, EnvironmentFileMatcher(newConfig.Spec.Config.Systemd.Units))
+}
+
+// RebootRequired returns true if the daemon has to reboot the node to update
+// it from oldConfig to newConfig.
+func RebootRequired(oldConfig, newConfig *mcfgv1.MachineConfig) bool {
+	if reflect.DeepEqual(oldConfig.Spec, newConfig.Spec) {
+		return false
+	}
+	return !IsUdevRulesOnlyChange(oldConfig, newConfig) && !IsCATrustAnchorsOnlyChange(oldConfig, newConfig) &&
+		!IsSysusersTmpfilesOnlyChange(oldConfig, newConfig) && !IsUnitsOnlyChange(oldConfig, newConfig) &&
+		!IsEnvironmentFilesOnlyChange(oldConfig, newConfig)
+}
+
+// UnitDirective returns the values of the directive in the [Unit] section of
+// the unit and its dropins. An empty assignment resets the list, the same way
+// systemd handles it.
+func UnitDirective(u ignv2_2types.Unit, directive string) []string {
+	contents := []string{u.Contents}
+	for _, d := range u.Dropins {
+		contents = append(contents, d.Contents)
+	}
+
+	var values []string
+	for _, c := range contents {
+		section := ""
+		for _, line := range strings.Split(c, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				section = line
+				continue
+			}
+			if section != "[Unit]" || !strings.HasPrefix(line, directive+"=") {
+				continue
+			}
+			value := strings.TrimSpace(strings.TrimPrefix(line, directive+"="))
+			if value == "" {
+				values = nil
+				continue
+			}
+			values = append(values, strings.Fields(value)...)
+		}
+	}
+	return values
+}
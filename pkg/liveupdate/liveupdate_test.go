@@ -0,0 +1,267 @@
+package liveupdate
+
+import (
+	"reflect"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+// TestUdevRulesOnlyChange verifies that only diffs touching udev rules are
+// applied by reloading udev instead of rebooting.
+func TestUdevRulesOnlyChange(t *testing.T) {
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	newConfig := func(osImageURL string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			Spec: mcfgv1.MachineConfigSpec{
+				OSImageURL: osImageURL,
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+				},
+			},
+		}
+	}
+
+	rule := newFile("/etc/udev/rules.d/99-test.rules", "old")
+	newRule := newFile("/etc/udev/rules.d/99-test.rules", "new")
+	other := newFile("/etc/foo", "old")
+	newOther := newFile("/etc/foo", "new")
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		live      bool
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig("", rule, other),
+		newConfig: newConfig("", rule, other),
+		live:      false,
+	}, {
+		name:      "udev rule changed",
+		oldConfig: newConfig("", rule, other),
+		newConfig: newConfig("", newRule, other),
+		live:      true,
+	}, {
+		name:      "udev rule added",
+		oldConfig: newConfig("", other),
+		newConfig: newConfig("", other, rule),
+		live:      true,
+	}, {
+		name:      "udev rule and other file changed",
+		oldConfig: newConfig("", rule, other),
+		newConfig: newConfig("", newRule, newOther),
+		live:      false,
+	}, {
+		name:      "udev rule and OS changed",
+		oldConfig: newConfig("", rule),
+		newConfig: newConfig("somethingDifferent", newRule),
+		live:      false,
+	}, {
+		name:      "unit changed",
+		oldConfig: newConfig("", rule),
+		newConfig: func() *mcfgv1.MachineConfig {
+			mc := newConfig("", newRule)
+			mc.Spec.Config.Systemd.Units = []ignv2_2types.Unit{{Name: "test.service"}}
+			return mc
+		}(),
+		live: false,
+	}}
+
+	for _, test := range tests {
+		if live := IsUdevRulesOnlyChange(test.oldConfig, test.newConfig); live != test.live {
+			t.Errorf("%s: expected udev rules only change to be %v, got %v", test.name, test.live, live)
+		}
+	}
+}
+
+// TestCATrustAnchorsOnlyChange verifies that only diffs touching CA trust
+// anchors are applied by updating the CA trust instead of rebooting.
+func TestCATrustAnchorsOnlyChange(t *testing.T) {
+	newFile := func(path, contents string) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node: ignv2_2types.Node{Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{
+				Contents: ignv2_2types.FileContents{Source: "data:," + contents},
+			},
+		}
+	}
+	newConfig := func(osImageURL string, files ...ignv2_2types.File) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			Spec: mcfgv1.MachineConfigSpec{
+				OSImageURL: osImageURL,
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+				},
+			},
+		}
+	}
+
+	anchor := newFile("/etc/pki/ca-trust/source/anchors/corp-ca.pem", "old")
+	newAnchor := newFile("/etc/pki/ca-trust/source/anchors/corp-ca.pem", "new")
+	rule := newFile("/etc/udev/rules.d/99-test.rules", "old")
+	newRule := newFile("/etc/udev/rules.d/99-test.rules", "new")
+	other := newFile("/etc/foo", "old")
+	newOther := newFile("/etc/foo", "new")
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		live      bool
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig("", anchor, other),
+		newConfig: newConfig("", anchor, other),
+		live:      false,
+	}, {
+		name:      "anchor changed",
+		oldConfig: newConfig("", anchor, other),
+		newConfig: newConfig("", newAnchor, other),
+		live:      true,
+	}, {
+		name:      "anchor added",
+		oldConfig: newConfig("", other),
+		newConfig: newConfig("", other, anchor),
+		live:      true,
+	}, {
+		name:      "anchor removed",
+		oldConfig: newConfig("", other, anchor),
+		newConfig: newConfig("", other),
+		live:      true,
+	}, {
+		name:      "anchor and other file changed",
+		oldConfig: newConfig("", anchor, other),
+		newConfig: newConfig("", newAnchor, newOther),
+		live:      false,
+	}, {
+		name:      "anchor and udev rule changed",
+		oldConfig: newConfig("", anchor, rule),
+		newConfig: newConfig("", newAnchor, newRule),
+		live:      false,
+	}, {
+		name:      "anchor and OS changed",
+		oldConfig: newConfig("", anchor),
+		newConfig: newConfig("somethingDifferent", newAnchor),
+		live:      false,
+	}}
+
+	for _, test := range tests {
+		if live := IsCATrustAnchorsOnlyChange(test.oldConfig, test.newConfig); live != test.live {
+			t.Errorf("%s: expected CA trust anchors only change to be %v, got %v", test.name, test.live, live)
+		}
+		if test.live && RebootRequired(test.oldConfig, test.newConfig) {
+			t.Errorf("%s: expected no reboot for a CA trust anchors only change", test.name)
+		}
+	}
+}
+
+func TestUnitsOnlyChange(t *testing.T) {
+	newConfig := func(osImageURL string, files []ignv2_2types.File, units ...ignv2_2types.Unit) *mcfgv1.MachineConfig {
+		return &mcfgv1.MachineConfig{
+			Spec: mcfgv1.MachineConfigSpec{
+				OSImageURL: osImageURL,
+				Config: ignv2_2types.Config{
+					Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+					Storage:  ignv2_2types.Storage{Files: files},
+					Systemd:  ignv2_2types.Systemd{Units: units},
+				},
+			},
+		}
+	}
+	restartInPlace := "[Unit]\nX-MachineConfigRestartInPlace=true\n"
+	unit := ignv2_2types.Unit{Name: "test.service", Contents: restartInPlace + "[Service]\nExecStart=/bin/old\n"}
+	newUnit := ignv2_2types.Unit{Name: "test.service", Contents: restartInPlace + "[Service]\nExecStart=/bin/new\n"}
+	optedOut := ignv2_2types.Unit{Name: "test.service", Contents: "[Service]\nExecStart=/bin/new\n"}
+	masked := ignv2_2types.Unit{Name: "test.service", Mask: true}
+	other := ignv2_2types.Unit{Name: "other.service", Contents: "[Service]\nExecStart=/bin/other\n"}
+	kubelet := ignv2_2types.Unit{Name: "kubelet.service", Contents: restartInPlace + "[Service]\nExecStart=/bin/old\n"}
+	newKubelet := ignv2_2types.Unit{Name: "kubelet.service", Contents: restartInPlace + "[Service]\nExecStart=/bin/new\n"}
+	files := []ignv2_2types.File{{Node: ignv2_2types.Node{Path: "/etc/foo"}}}
+
+	tests := []struct {
+		name      string
+		oldConfig *mcfgv1.MachineConfig
+		newConfig *mcfgv1.MachineConfig
+		live      bool
+	}{{
+		name:      "no changes",
+		oldConfig: newConfig("", nil, unit),
+		newConfig: newConfig("", nil, unit),
+		live:      false,
+	}, {
+		name:      "unit changed",
+		oldConfig: newConfig("", files, unit),
+		newConfig: newConfig("", files, newUnit),
+		live:      true,
+	}, {
+		name:      "unit and file changed",
+		oldConfig: newConfig("", nil, unit),
+		newConfig: newConfig("", files, newUnit),
+		live:      false,
+	}, {
+		name:      "unit and OS changed",
+		oldConfig: newConfig("", nil, unit),
+		newConfig: newConfig("somethingDifferent", nil, newUnit),
+		live:      false,
+	}, {
+		name:      "unit not restarting in place changed",
+		oldConfig: newConfig("", nil, unit),
+		newConfig: newConfig("", nil, optedOut),
+		live:      false,
+	}, {
+		name:      "node critical unit changed",
+		oldConfig: newConfig("", nil, kubelet),
+		newConfig: newConfig("", nil, newKubelet),
+		live:      false,
+	}, {
+		name:      "unit masked",
+		oldConfig: newConfig("", nil, unit, other),
+		newConfig: newConfig("", nil, masked, other),
+		live:      true,
+	}, {
+		name:      "unit removed",
+		oldConfig: newConfig("", nil, unit, other),
+		newConfig: newConfig("", nil, other),
+		live:      true,
+	}, {
+		name:      "unit not restarting in place removed",
+		oldConfig: newConfig("", nil, unit, other),
+		newConfig: newConfig("", nil, unit),
+		live:      false,
+	}}
+
+	for _, test := range tests {
+		if live := IsUnitsOnlyChange(test.oldConfig, test.newConfig); live != test.live {
+			t.Errorf("%s: expected units only change to be %v, got %v", test.name, test.live, live)
+		}
+	}
+}
+
+func TestUnitEnvironmentFiles(t *testing.T) {
+	unit := ignv2_2types.Unit{
+		Name:     "test.service",
+		Contents: "[Unit]\nEnvironmentFile=/etc/unit\n[Service]\nEnvironmentFile=/etc/a\nEnvironmentFile=-/etc/b\n",
+		Dropins: []ignv2_2types.SystemdDropin{
+			{Name: "10-reset.conf", Contents: "[Service]\nEnvironmentFile=\nEnvironmentFile=/etc/c\n"},
+			{Name: "20-add.conf", Contents: "[Service]\nEnvironmentFile=/etc/d\n"},
+		},
+	}
+	if got, expected := UnitEnvironmentFiles(unit), []string{"/etc/c", "/etc/d"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	unit.Dropins = nil
+	if got, expected := UnitEnvironmentFiles(unit), []string{"/etc/a", "/etc/b"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -341,6 +341,7 @@ spec:
         args:
         - "start"
         - "--resourcelock-namespace={{.TargetNamespace}}"
+        - "--rollout-pause-configmap={{.TargetNamespace}}/machine-config-rollout-pause"
         - "--v=2"
         resources:
           limits:
@@ -434,6 +435,12 @@ rules:
 - apiGroups: [""]
   resources: ["pods/eviction"]
   verbs: ["create"]
+- apiGroups: ["policy"]
+  resources: ["poddisruptionbudgets"]
+  verbs: ["list"]
+- apiGroups: [""]
+  resources: ["nodes/status"]
+  verbs: ["update"]
 - apiGroups: ["machineconfiguration.openshift.io"]
   resources: ["machineconfigs"]
   verbs: ["*"]
@@ -698,6 +705,23 @@ spec:
     singular: machineconfigpool
     # kind is normally the CamelCased singular type. Your resource manifests use this.
     kind: MachineConfigPool
+  # columns shown by oc get machineconfigpools, the prediction of rolling out
+  # a pending config is only set while the pool is pinned.
+  additionalPrinterColumns:
+    - name: Config
+      type: string
+      JSONPath: .status.currentMachineConfig
+    - name: Pending
+      type: string
+      description: The generated MachineConfig that isn't rolled out yet
+      JSONPath: .status.pendingBlastRadius.machineConfig
+    - name: RebootRequired
+      type: boolean
+      description: Whether rolling out the pending MachineConfig reboots the machines
+      JSONPath: .status.pendingBlastRadius.rebootRequired
+    - name: Age
+      type: date
+      JSONPath: .metadata.creationTimestamp
 `)
 
 func manifestsMachineconfigpoolCrdYamlBytes() ([]byte, error) {
@@ -724,6 +748,9 @@ rules:
 - apiGroups: ["machineconfiguration.openshift.io"]
   resources: ["machineconfigs", "machineconfigpools"]
   verbs: ["*"]
+- apiGroups: [""]
+  resources: ["nodes"]
+  verbs: ["get"]
 `)
 
 func manifestsMachineconfigserverClusterroleYamlBytes() ([]byte, error) {
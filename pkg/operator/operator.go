@@ -72,6 +72,10 @@ type Operator struct {
 
 	// queue only ever has one item, but it has nice error handling backoff/retry semantics
 	queue workqueue.RateLimitingInterface
+
+	// orphanedConfigs are the names of the MachineConfigs last found to not
+	// be selected by any pool, used to record an event only once per config.
+	orphanedConfigs map[string]bool
 }
 
 // New returns a new machine config operator.
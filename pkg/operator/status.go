@@ -2,15 +2,31 @@ package operator
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/golang/glog"
 	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
 	"github.com/openshift/machine-config-operator/pkg/version"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 )
 
+// OperatorDaemonVersionSkew is set to true when one or more nodes are running a
+// machine-config-daemon whose version does not match the operator's.
+const OperatorDaemonVersionSkew configv1.ClusterStatusConditionType = "DaemonVersionSkew"
+
+// OperatorOrphanedMachineConfigs is set to true when one or more MachineConfigs
+// are not selected by any MachineConfigPool and so are never applied.
+const OperatorOrphanedMachineConfigs configv1.ClusterStatusConditionType = "OrphanedMachineConfigs"
+
 // syncAvailableStatus applies the new condition to the mco's ClusterOperator object.
 func (optr *Operator) syncAvailableStatus() error {
 	co, err := optr.fetchClusterOperator()
@@ -28,6 +44,9 @@ func (optr *Operator) syncAvailableStatus() error {
 	// clear failure
 	SetClusterOperatorStatusCondition(&co.Status.Conditions, configv1.ClusterOperatorStatusCondition{Type: configv1.OperatorFailing, Status: configv1.ConditionFalse, LastTransitionTime: now})
 
+	optr.syncDaemonVersionSkewCondition(co)
+	optr.syncOrphanedMachineConfigsCondition(co)
+
 	co.Status.Version = version.Version.String()
 	_, err = optr.configClient.ConfigV1().ClusterOperators().UpdateStatus(co)
 	return err
@@ -58,6 +77,9 @@ func (optr *Operator) syncProgressingStatus() error {
 		SetClusterOperatorStatusCondition(&co.Status.Conditions, configv1.ClusterOperatorStatusCondition{Type: configv1.OperatorProgressing, Status: configv1.ConditionTrue, Message: fmt.Sprintf("Progressing towards %s", version.Version.String()), LastTransitionTime: now})
 	}
 
+	optr.syncDaemonVersionSkewCondition(co)
+	optr.syncOrphanedMachineConfigsCondition(co)
+
 	co.Status.Version = version.Version.String()
 	_, err = optr.configClient.ConfigV1().ClusterOperators().UpdateStatus(co)
 	return err
@@ -89,11 +111,142 @@ func (optr *Operator) syncFailingStatus(ierr error) error {
 		SetClusterOperatorStatusCondition(&co.Status.Conditions, configv1.ClusterOperatorStatusCondition{Type: configv1.OperatorProgressing, Status: configv1.ConditionFalse, Message: fmt.Sprintf("Error while reconciling %s", version.Version.String()), LastTransitionTime: now})
 	}
 
+	optr.syncDaemonVersionSkewCondition(co)
+	optr.syncOrphanedMachineConfigsCondition(co)
+
 	co.Status.Version = version.Version.String()
 	_, err = optr.configClient.ConfigV1().ClusterOperators().UpdateStatus(co)
 	return err
 }
 
+// syncDaemonVersionSkewCondition sets the DaemonVersionSkew condition on co
+// based on the daemon versions reported by the nodes.
+func (optr *Operator) syncDaemonVersionSkewCondition(co *configv1.ClusterOperator) {
+	nodes, err := optr.kubeClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Warningf("Failed to list nodes for daemon version check: %v", err)
+		return
+	}
+	SetClusterOperatorStatusCondition(&co.Status.Conditions, daemonVersionSkewCondition(nodes.Items, version.Raw))
+}
+
+// daemonVersionSkewCondition returns the DaemonVersionSkew condition for nodes
+// given the version of the operator.
+func daemonVersionSkewCondition(nodes []corev1.Node, operatorVersion string) configv1.ClusterOperatorStatusCondition {
+	now := metav1.Now()
+	mismatched := mismatchedDaemonNodes(nodes, operatorVersion)
+	if len(mismatched) == 0 {
+		return configv1.ClusterOperatorStatusCondition{Type: OperatorDaemonVersionSkew, Status: configv1.ConditionFalse, LastTransitionTime: now}
+	}
+	return configv1.ClusterOperatorStatusCondition{
+		Type:               OperatorDaemonVersionSkew,
+		Status:             configv1.ConditionTrue,
+		Reason:             "DaemonVersionMismatch",
+		Message:            fmt.Sprintf("Nodes not running daemon version %s: %s", operatorVersion, strings.Join(mismatched, ", ")),
+		LastTransitionTime: now,
+	}
+}
+
+// mismatchedDaemonNodes returns the sorted names of the nodes whose reported
+// daemon version differs from operatorVersion. Nodes that do not report a
+// version are considered mismatched.
+func mismatchedDaemonNodes(nodes []corev1.Node, operatorVersion string) []string {
+	var mismatched []string
+	for _, node := range nodes {
+		if node.Annotations[daemon.MachineConfigDaemonVersionAnnotationKey] != operatorVersion {
+			mismatched = append(mismatched, node.Name)
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched
+}
+
+// syncOrphanedMachineConfigsCondition sets the OrphanedMachineConfigs condition
+// on co and records an event for each MachineConfig that became orphaned.
+func (optr *Operator) syncOrphanedMachineConfigsCondition(co *configv1.ClusterOperator) {
+	pools, err := optr.client.MachineconfigurationV1().MachineConfigPools().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Warningf("Failed to list machineconfigpools for orphaned machineconfigs check: %v", err)
+		return
+	}
+	configs, err := optr.client.MachineconfigurationV1().MachineConfigs().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Warningf("Failed to list machineconfigs for orphaned machineconfigs check: %v", err)
+		return
+	}
+
+	orphaned := orphanedMachineConfigs(pools.Items, configs.Items)
+	current := make(map[string]bool, len(orphaned))
+	for _, mc := range orphaned {
+		current[mc.Name] = true
+		if !optr.orphanedConfigs[mc.Name] {
+			optr.eventRecorder.Eventf(mc, corev1.EventTypeWarning, "OrphanedMachineConfig", "MachineConfig %s is not selected by any MachineConfigPool", mc.Name)
+		}
+	}
+	optr.orphanedConfigs = current
+
+	SetClusterOperatorStatusCondition(&co.Status.Conditions, orphanedMachineConfigsCondition(orphaned))
+}
+
+// orphanedMachineConfigsCondition returns the OrphanedMachineConfigs condition
+// for the orphaned configs.
+func orphanedMachineConfigsCondition(orphaned []*mcfgv1.MachineConfig) configv1.ClusterOperatorStatusCondition {
+	now := metav1.Now()
+	if len(orphaned) == 0 {
+		return configv1.ClusterOperatorStatusCondition{Type: OperatorOrphanedMachineConfigs, Status: configv1.ConditionFalse, LastTransitionTime: now}
+	}
+	var names []string
+	for _, mc := range orphaned {
+		names = append(names, mc.Name)
+	}
+	return configv1.ClusterOperatorStatusCondition{
+		Type:               OperatorOrphanedMachineConfigs,
+		Status:             configv1.ConditionTrue,
+		Reason:             "NoMatchingMachineConfigPool",
+		Message:            fmt.Sprintf("MachineConfigs not selected by any MachineConfigPool: %s", strings.Join(names, ", ")),
+		LastTransitionTime: now,
+	}
+}
+
+// orphanedMachineConfigs returns the MachineConfigs, sorted by name, that are
+// not selected by any of the pools. MachineConfigs rendered by the controller
+// for a pool are not considered.
+func orphanedMachineConfigs(pools []mcfgv1.MachineConfigPool, configs []mcfgv1.MachineConfig) []*mcfgv1.MachineConfig {
+	var selectors []labels.Selector
+	for _, pool := range pools {
+		selector, err := metav1.LabelSelectorAsSelector(pool.Spec.MachineConfigSelector)
+		if err != nil {
+			glog.Warningf("Invalid machineconfig selector for pool %s: %v", pool.Name, err)
+			continue
+		}
+		// pools with an empty selector match nothing.
+		if selector.Empty() {
+			continue
+		}
+		selectors = append(selectors, selector)
+	}
+
+	var orphaned []*mcfgv1.MachineConfig
+	for idx := range configs {
+		mc := &configs[idx]
+		if ref := metav1.GetControllerOf(mc); ref != nil && ref.Kind == "MachineConfigPool" {
+			continue
+		}
+		matched := false
+		for _, selector := range selectors {
+			if selector.Matches(labels.Set(mc.Labels)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			orphaned = append(orphaned, mc)
+		}
+	}
+	sort.Slice(orphaned, func(i, j int) bool { return orphaned[i].Name < orphaned[j].Name })
+	return orphaned
+}
+
 func (optr *Operator) fetchClusterOperator() (*configv1.ClusterOperator, error) {
 	co, err := optr.configClient.ConfigV1().ClusterOperators().Get(optr.name, metav1.GetOptions{})
 	if meta.IsNoMatchError(err) {
@@ -0,0 +1,155 @@
+package operator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
+)
+
+func newNodeWithDaemonVersion(name, version string) corev1.Node {
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if version != "" {
+		node.Annotations = map[string]string{daemon.MachineConfigDaemonVersionAnnotationKey: version}
+	}
+	return node
+}
+
+func TestMismatchedDaemonNodes(t *testing.T) {
+	tests := []struct {
+		nodes []corev1.Node
+		exp   []string
+	}{{
+		nodes: nil,
+		exp:   nil,
+	}, {
+		nodes: []corev1.Node{
+			newNodeWithDaemonVersion("node-0", "v1.0.0"),
+			newNodeWithDaemonVersion("node-1", "v1.0.0"),
+		},
+		exp: nil,
+	}, {
+		nodes: []corev1.Node{
+			newNodeWithDaemonVersion("node-2", "v0.9.0"),
+			newNodeWithDaemonVersion("node-0", "v1.0.0"),
+			newNodeWithDaemonVersion("node-1", "v1.1.0"),
+		},
+		exp: []string{"node-1", "node-2"},
+	}, {
+		nodes: []corev1.Node{
+			newNodeWithDaemonVersion("node-0", "v1.0.0"),
+			newNodeWithDaemonVersion("node-1", ""),
+		},
+		exp: []string{"node-1"},
+	}}
+	for idx, test := range tests {
+		t.Run(fmt.Sprintf("case#%d", idx), func(t *testing.T) {
+			got := mismatchedDaemonNodes(test.nodes, "v1.0.0")
+			if !reflect.DeepEqual(got, test.exp) {
+				t.Fatalf("mismatch: got %v, expected %v", got, test.exp)
+			}
+		})
+	}
+}
+
+func TestDaemonVersionSkewCondition(t *testing.T) {
+	cond := daemonVersionSkewCondition([]corev1.Node{
+		newNodeWithDaemonVersion("node-0", "v1.0.0"),
+	}, "v1.0.0")
+	if cond.Type != OperatorDaemonVersionSkew || cond.Status != configv1.ConditionFalse {
+		t.Fatalf("expected %s to be false, got %s", OperatorDaemonVersionSkew, cond.Status)
+	}
+
+	cond = daemonVersionSkewCondition([]corev1.Node{
+		newNodeWithDaemonVersion("node-0", "v1.0.0"),
+		newNodeWithDaemonVersion("node-1", "v0.9.0"),
+		newNodeWithDaemonVersion("node-2", "v0.9.0"),
+	}, "v1.0.0")
+	if cond.Status != configv1.ConditionTrue {
+		t.Fatalf("expected %s to be true, got %s", OperatorDaemonVersionSkew, cond.Status)
+	}
+	if exp := "Nodes not running daemon version v1.0.0: node-1, node-2"; cond.Message != exp {
+		t.Fatalf("expected message %q, got %q", exp, cond.Message)
+	}
+}
+
+func newPool(name string, selector map[string]string) *mcfgv1.MachineConfigPool {
+	return &mcfgv1.MachineConfigPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: mcfgv1.MachineConfigPoolSpec{
+			MachineConfigSelector: &metav1.LabelSelector{MatchLabels: selector},
+		},
+	}
+}
+
+func newMachineConfig(name string, labels map[string]string) *mcfgv1.MachineConfig {
+	return &mcfgv1.MachineConfig{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestSyncOrphanedMachineConfigsCondition(t *testing.T) {
+	isController := true
+	rendered := newMachineConfig("rendered-worker", nil)
+	rendered.OwnerReferences = []metav1.OwnerReference{{Kind: "MachineConfigPool", Name: "worker", Controller: &isController}}
+
+	client := fake.NewSimpleClientset(
+		newPool("master", map[string]string{"machineconfiguration.openshift.io/role": "master"}),
+		newPool("worker", map[string]string{"machineconfiguration.openshift.io/role": "worker"}),
+		newMachineConfig("00-master", map[string]string{"machineconfiguration.openshift.io/role": "master"}),
+		newMachineConfig("00-worker", map[string]string{"machineconfiguration.openshift.io/role": "worker"}),
+		newMachineConfig("99-infra", map[string]string{"machineconfiguration.openshift.io/role": "infra"}),
+		newMachineConfig("99-unlabeled", nil),
+		rendered,
+	)
+	recorder := record.NewFakeRecorder(10)
+	optr := &Operator{client: client, eventRecorder: recorder}
+
+	co := &configv1.ClusterOperator{}
+	optr.syncOrphanedMachineConfigsCondition(co)
+
+	cond := FindClusterOperatorStatusCondition(co.Status.Conditions, OperatorOrphanedMachineConfigs)
+	if cond == nil || cond.Status != configv1.ConditionTrue {
+		t.Fatalf("expected %s to be true, got %v", OperatorOrphanedMachineConfigs, cond)
+	}
+	if exp := "MachineConfigs not selected by any MachineConfigPool: 99-infra, 99-unlabeled"; cond.Message != exp {
+		t.Fatalf("expected message %q, got %q", exp, cond.Message)
+	}
+	if len(recorder.Events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(recorder.Events))
+	}
+	<-recorder.Events
+	<-recorder.Events
+
+	// syncing again doesn't record the events again.
+	optr.syncOrphanedMachineConfigsCondition(co)
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expected no new events, got %d", len(recorder.Events))
+	}
+}
+
+func TestSyncOrphanedMachineConfigsConditionMatched(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		newPool("master", map[string]string{"machineconfiguration.openshift.io/role": "master"}),
+		newMachineConfig("00-master", map[string]string{"machineconfiguration.openshift.io/role": "master"}),
+	)
+	recorder := record.NewFakeRecorder(10)
+	optr := &Operator{client: client, eventRecorder: recorder}
+
+	co := &configv1.ClusterOperator{}
+	optr.syncOrphanedMachineConfigsCondition(co)
+
+	cond := FindClusterOperatorStatusCondition(co.Status.Conditions, OperatorOrphanedMachineConfigs)
+	if cond == nil || cond.Status != configv1.ConditionFalse {
+		t.Fatalf("expected %s to be false, got %v", OperatorOrphanedMachineConfigs, cond)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expected no events, got %d", len(recorder.Events))
+	}
+}
@@ -0,0 +1,65 @@
+package operator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/openshift/machine-config-operator/lib/resourceread"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/operator/assets"
+)
+
+// TestMachineConfigPoolPrinterColumns verifies the columns of the
+// MachineConfigPool CRD show the fields of a pinned pool.
+func TestMachineConfigPoolPrinterColumns(t *testing.T) {
+	crd := resourceread.ReadCustomResourceDefinitionV1Beta1OrDie(assets.MustAsset("manifests/machineconfigpool.crd.yaml"))
+	pool := mcfgv1.MachineConfigPool{
+		Status: mcfgv1.MachineConfigPoolStatus{
+			CurrentMachineConfig: "old-config",
+			PendingBlastRadius: &mcfgv1.MachineConfigPoolBlastRadius{
+				MachineConfig:  "new-config",
+				RebootRequired: true,
+			},
+		},
+	}
+	data, err := json.Marshal(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		t.Fatal(err)
+	}
+	// lookup resolves the simple JSONPaths of the columns.
+	lookup := func(path string) interface{} {
+		v := obj
+		for _, field := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[field]
+		}
+		return v
+	}
+
+	exp := map[string]interface{}{
+		"Config":         "old-config",
+		"Pending":        "new-config",
+		"RebootRequired": true,
+	}
+	for _, col := range crd.Spec.AdditionalPrinterColumns {
+		e, ok := exp[col.Name]
+		if !ok {
+			continue
+		}
+		delete(exp, col.Name)
+		if got := lookup(col.JSONPath); got != e {
+			t.Errorf("expected column %s to show %v, got: %v", col.Name, e, got)
+		}
+	}
+	for name := range exp {
+		t.Errorf("expected column %s", name)
+	}
+}
@@ -3,19 +3,42 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	yaml "github.com/ghodss/yaml"
 	"github.com/golang/glog"
 )
 
 const (
 	apiPathConfig = "/config/"
+	apiPathByHash = "by-hash"
 	apiParamEtcd  = "etcd_index"
+	apiParamNode  = "node"
+
+	contentTypeJSON = "application/json"
+	contentTypeYAML = "application/yaml"
 )
 
+// yamlContentTypes are the media types that ask for the config as YAML.
+var yamlContentTypes = map[string]bool{
+	contentTypeYAML:      true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+}
+
 type poolRequest struct {
 	machinePool string
+	// node is the name of the node requesting the config, if it is known.
+	node string
+	// hash is the rendered config requested, the current config of the
+	// pool if it is empty.
+	hash string
 }
 
 // APIServer provides the HTTP(s) endpoint
@@ -26,18 +49,30 @@ type APIServer struct {
 	insecure bool
 	cert     string
 	key      string
+	sni      *SNIConfig
+	debug    http.Handler
+	stats    http.Handler
 }
 
 // NewAPIServer initializes a new API server
 // that runs the Machine Config Server as a
 // handler.
-func NewAPIServer(a *APIHandler, p int, is bool, c, k string) *APIServer {
+// When sni is set, the secure server picks the
+// serving certificate based on the SNI hostname.
+// When debug is set, it serves the recent errors
+// of the server.
+// When stats is set, it serves the config serving
+// statistics of the server.
+func NewAPIServer(a *APIHandler, p int, is bool, c, k string, sni *SNIConfig, debug, stats http.Handler) *APIServer {
 	return &APIServer{
 		handler:  a,
 		port:     p,
 		insecure: is,
 		cert:     c,
 		key:      k,
+		sni:      sni,
+		debug:    debug,
+		stats:    stats,
 	}
 }
 
@@ -45,6 +80,12 @@ func NewAPIServer(a *APIHandler, p int, is bool, c, k string) *APIServer {
 func (a *APIServer) Serve() {
 	mux := http.NewServeMux()
 	mux.Handle(apiPathConfig, a.handler)
+	if a.debug != nil {
+		mux.Handle(apiPathDebugErrors, a.debug)
+	}
+	if a.stats != nil {
+		mux.Handle(apiPathStats, a.stats)
+	}
 
 	mcs := &http.Server{
 		Addr:    fmt.Sprintf(":%v", a.port),
@@ -57,6 +98,16 @@ func (a *APIServer) Serve() {
 		if err := mcs.ListenAndServe(); err != http.ErrServerClosed {
 			glog.Exitf("Machine Config Server exited with error: %v", err)
 		}
+	} else if a.sni != nil {
+		cs, err := newCertificateSelector(a.cert, a.key, a.sni)
+		if err != nil {
+			glog.Exitf("Machine Config Server exited with error: %v", err)
+		}
+		mcs.TLSConfig = cs.TLSConfig()
+		// the certificates are provided by the TLSConfig.
+		if err := mcs.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			glog.Exitf("Machine Config Server exited with error: %v", err)
+		}
 	} else {
 		if err := mcs.ListenAndServeTLS(a.cert, a.key); err != http.ErrServerClosed {
 			glog.Exitf("Machine Config Server exited with error: %v", err)
@@ -67,17 +118,60 @@ func (a *APIServer) Serve() {
 // APIHandler is the HTTP Handler for the
 // Machine Config Server.
 type APIHandler struct {
-	server Server
+	server         Server
+	validateSchema bool
+	signer         *Signer
+	errorLog       *ErrorLog
+	configTTL      time.Duration
+	stats          *Stats
+	headers        PoolHeaders
+	transforms     []ConfigTransform
+	renders        renderGroup
+}
+
+// APIHandlerOptions are the optional features of an APIHandler, the zero value
+// serves configs as the server returns them.
+type APIHandlerOptions struct {
+	// ValidateSchema validates configs against the JSON schema of their
+	// Ignition version before they are served.
+	ValidateSchema bool
+	// Signer attaches a signed provenance attestation over the served bytes
+	// to every config.
+	Signer *Signer
+	// ErrorLog records the errors returned to clients.
+	ErrorLog *ErrorLog
+	// ConfigTTL sets the Expires header of served configs to ConfigTTL
+	// after they are served.
+	ConfigTTL time.Duration
+	// Stats records the served configs.
+	Stats *Stats
+	// Headers are added to the configs served for their pool.
+	Headers PoolHeaders
 }
 
 // NewServerAPIHandler initializes a new API handler
 // for the Machine Config Server.
-func NewServerAPIHandler(s Server) *APIHandler {
+func NewServerAPIHandler(s Server, opts APIHandlerOptions) *APIHandler {
 	return &APIHandler{
-		server: s,
+		server:         s,
+		validateSchema: opts.ValidateSchema,
+		signer:         opts.Signer,
+		errorLog:       opts.ErrorLog,
+		configTTL:      opts.ConfigTTL,
+		stats:          opts.Stats,
+		headers:        opts.Headers,
 	}
 }
 
+// internalError fails the request for the pool with an internal server
+// error, logging and recording the error.
+func (sh *APIHandler) internalError(w http.ResponseWriter, pool, format string, args ...interface{}) {
+	w.WriteHeader(http.StatusInternalServerError)
+	msg := fmt.Sprintf(format, args...)
+	glog.Error(msg)
+	sh.errorLog.Record(pool, msg)
+}
+
 // ServeHTTP handles the requests for the machine config server
 // API handler.
 func (sh *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -87,24 +181,168 @@ func (sh *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pool, subresource := parseConfigPath(r.URL.Path)
 	cr := poolRequest{
-		machinePool: path.Base(r.URL.Path),
+		machinePool: pool,
+		node:        r.URL.Query().Get(apiParamNode),
 	}
 
-	conf, err := sh.server.GetConfig(cr)
+	switch subresource {
+	case "":
+		sh.serveConfig(w, r, cr, nil)
+	case apiPathDiff:
+		sh.serveDiff(w, r, pool)
+	case apiPathFirstboot:
+		sh.serveConfig(w, r, cr, func(conf *ignv2_2types.Config) *ignv2_2types.Config {
+			firstboot, _ := splitFirstbootConfig(conf)
+			return firstboot
+		})
+	case apiPathTopup:
+		sh.serveConfig(w, r, cr, func(conf *ignv2_2types.Config) *ignv2_2types.Config {
+			_, topup := splitFirstbootConfig(conf)
+			return topup
+		})
+	default:
+		// /config/<pool>/by-hash/<hash> serves the rendered config <hash> of
+		// the pool, if it still exists.
+		hash := strings.TrimPrefix(subresource, apiPathByHash+"/")
+		if hash == subresource || hash == "" || strings.Contains(hash, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		cr.hash = hash
+		sh.serveConfig(w, r, cr, nil)
+	}
+}
+
+// parseConfigPath splits a request path of the form
+// /config/<pool>[/<subresource>] into the pool and the subresource.
+func parseConfigPath(p string) (string, string) {
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(p, apiPathConfig), "/"), "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return path.Base(p), ""
+}
+
+// renderedConfig is a config rendered for a request, ready to be served.
+type renderedConfig struct {
+	data        []byte
+	contentType string
+	attestation string
+	nodeToken   string
+}
+
+// serveConfig writes the config for the request, as YAML if the request
+// accepts it before JSON. If part is set, only the part of the config it
+// returns is served. Concurrent requests for the same config share a
+// single render.
+func (sh *APIHandler) serveConfig(w http.ResponseWriter, r *http.Request, cr poolRequest, part func(*ignv2_2types.Config) *ignv2_2types.Config) {
+	yamlRequested := acceptsYAML(r)
+	key := fmt.Sprintf("%s?node=%s&yaml=%t", r.URL.Path, cr.node, yamlRequested)
+	rendered, err := sh.renders.do(key, func() (*renderedConfig, error) {
+		return sh.renderConfig(r.URL.Path, cr, part, yamlRequested)
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		glog.Errorf("couldn't get config for req: %v, error: %v", cr, err)
+		sh.internalError(w, cr.machinePool, "%v", err)
 		return
 	}
-	if conf == nil && err == nil {
+	if rendered == nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	encoder := json.NewEncoder(w)
-	if err := encoder.Encode(conf); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		glog.Errorf("couldn't encode the config for req: %v, error: %v", cr, err)
+	sh.headers.addHeaders(w, cr.machinePool)
+	if rendered.attestation != "" {
+		w.Header().Set(attestationHeader, rendered.attestation)
+	}
+	if rendered.nodeToken != "" {
+		w.Header().Set(nodeTokenHeader, rendered.nodeToken)
+	}
+	// the expiry is left out of the config, so it stays the same for
+	// every request.
+	if sh.configTTL > 0 {
+		w.Header().Set("Expires", time.Now().Add(sh.configTTL).UTC().Format(http.TimeFormat))
+	}
+	etag := configETag(rendered.data)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", rendered.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(rendered.data)))
+	w.Write(rendered.data)
+	sh.stats.Record(cr.machinePool, etag, len(rendered.data))
+}
+
+// renderConfig renders the config for the request to urlPath, or returns nil
+// if there is no config for it.
+func (sh *APIHandler) renderConfig(urlPath string, cr poolRequest, part func(*ignv2_2types.Config) *ignv2_2types.Config, yamlRequested bool) (*renderedConfig, error) {
+	conf, err := sh.server.GetConfig(cr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get config for req: %v, error: %v", cr, err)
+	}
+	if conf == nil && err == nil {
+		return nil, nil
+	}
+	if conf, err = sh.transformConfig(cr, conf); err != nil {
+		return nil, fmt.Errorf("couldn't transform the config for req: %v, error: %v", cr, err)
+	}
+	if part != nil {
+		conf = part(conf)
+	}
+	rendered := &renderedConfig{contentType: contentTypeJSON}
+	// identical configs are served byte-identical, with the same ETag.
+	conf = sortConfig(conf)
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't encode the config for req: %v, error: %v", cr, err)
+	}
+
+	if sh.validateSchema {
+		if err := validateIgnitionSchema(data); err != nil {
+			return nil, fmt.Errorf("config for req: %v does not conform to the ignition schema: %v", cr, err)
+		}
+	}
+
+	data = append(data, '\n')
+	if yamlRequested {
+		rendered.contentType = contentTypeYAML
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return nil, fmt.Errorf("couldn't encode the config as yaml for req: %v, error: %v", cr, err)
+		}
+	}
+	rendered.data = data
+
+	if sh.signer != nil {
+		if rendered.attestation, err = sh.signer.Attest(urlPath, data); err != nil {
+			return nil, fmt.Errorf("couldn't attest the config for req: %v, error: %v", cr, err)
+		}
+
+		// bind the config to the node it is served to.
+		if cr.node != "" {
+			if rendered.nodeToken, err = sh.signer.NodeToken(cr.node, cr.machinePool, data); err != nil {
+				return nil, fmt.Errorf("couldn't bind the config to the node for req: %v, error: %v", cr, err)
+			}
+		}
+	}
+	return rendered, nil
+}
+
+// acceptsYAML returns true if the first media type of the Accept header of the
+// request that is either YAML or JSON is YAML. JSON is the default.
+func acceptsYAML(r *http.Request) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+			if yamlContentTypes[mediaType] {
+				return true
+			}
+			if mediaType == contentTypeJSON {
+				return false
+			}
+		}
 	}
+	return false
 }
@@ -1,22 +1,37 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	"github.com/coreos/ignition/config/validate"
+	yaml "github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 )
 
 type mockServer struct {
-	GetConfigFn func(poolRequest) (*ignv2_2types.Config, error)
+	GetConfigFn         func(poolRequest) (*ignv2_2types.Config, error)
+	GetRenderedConfigFn func(poolRequest, string) (*mcfgv1.MachineConfig, error)
 }
 
 func (ms *mockServer) GetConfig(pr poolRequest) (*ignv2_2types.Config, error) {
 	return ms.GetConfigFn(pr)
 }
 
+func (ms *mockServer) GetRenderedConfig(pr poolRequest, hash string) (*mcfgv1.MachineConfig, error) {
+	return ms.GetRenderedConfigFn(pr, hash)
+}
+
 type scenario struct {
 	name           string
 	expectedStatus int
@@ -54,7 +69,7 @@ func TestAPIHandler(t *testing.T) {
 		ms := &mockServer{
 			GetConfigFn: scenarios[i].serverFunc,
 		}
-		handler := NewServerAPIHandler(ms)
+		handler := NewServerAPIHandler(ms, APIHandlerOptions{})
 		handler.ServeHTTP(w, req)
 
 		resp := w.Result()
@@ -64,3 +79,337 @@ func TestAPIHandler(t *testing.T) {
 		}
 	}
 }
+
+func TestAPIHandlerValidateSchema(t *testing.T) {
+	valid := ignv2_2types.Config{
+		Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()},
+		Passwd: ignv2_2types.Passwd{
+			Users: []ignv2_2types.PasswdUser{{Name: "core"}},
+		},
+		Systemd: ignv2_2types.Systemd{
+			Units: []ignv2_2types.Unit{{Name: "kubelet.service", Contents: "[Unit]"}},
+		},
+	}
+	appendFileToIgnition(&valid, "/etc/kubernetes/kubeconfig", "kubeconfig")
+	// a user without a name is fine for the report based validation,
+	// but the schema requires it.
+	invalid := ignv2_2types.Config{
+		Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()},
+		Passwd: ignv2_2types.Passwd{
+			Users: []ignv2_2types.PasswdUser{{SSHAuthorizedKeys: []ignv2_2types.SSHAuthorizedKey{"ssh-rsa AAAA"}}},
+		},
+	}
+	if rpt := validate.ValidateWithoutSource(reflect.ValueOf(invalid)); rpt.IsFatal() {
+		t.Fatalf("expected config to pass report validation: %v", rpt)
+	}
+
+	scenarios := []struct {
+		name           string
+		validateSchema bool
+		config         ignv2_2types.Config
+		expectedStatus int
+	}{{
+		name:           "valid",
+		validateSchema: true,
+		config:         valid,
+		expectedStatus: http.StatusOK,
+	}, {
+		name:           "missing-version",
+		validateSchema: true,
+		config:         ignv2_2types.Config{},
+		expectedStatus: http.StatusInternalServerError,
+	}, {
+		name:           "schema-violation",
+		validateSchema: true,
+		config:         invalid,
+		expectedStatus: http.StatusInternalServerError,
+	}, {
+		name:           "schema-violation-not-validated",
+		validateSchema: false,
+		config:         invalid,
+		expectedStatus: http.StatusOK,
+	}}
+
+	for _, s := range scenarios {
+		conf := s.config
+		req := httptest.NewRequest("GET", "http://testrequest/config/master", nil)
+		w := httptest.NewRecorder()
+		ms := &mockServer{
+			GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+				return &conf, nil
+			},
+		}
+		NewServerAPIHandler(ms, APIHandlerOptions{ValidateSchema: s.validateSchema}).ServeHTTP(w, req)
+
+		if resp := w.Result(); resp.StatusCode != s.expectedStatus {
+			t.Errorf("API Handler test failed for: %s, expected: %d, received: %d", s.name, s.expectedStatus, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPIHandlerYAML(t *testing.T) {
+	conf := ignv2_2types.Config{
+		Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()},
+		Systemd: ignv2_2types.Systemd{
+			Units: []ignv2_2types.Unit{{Name: "kubelet.service", Contents: "[Unit]\nDescription=kubelet\n"}},
+		},
+	}
+	appendFileToIgnition(&conf, "/etc/kubernetes/kubeconfig", "kubeconfig")
+	jsonData, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		name         string
+		path         string
+		accept       []string
+		expectedType string
+		expected     ignv2_2types.Config
+	}{{
+		name:         "default",
+		path:         "/config/master",
+		expectedType: "application/json",
+		expected:     conf,
+	}, {
+		name:         "json",
+		path:         "/config/master",
+		accept:       []string{"application/json"},
+		expectedType: "application/json",
+		expected:     conf,
+	}, {
+		name:         "yaml",
+		path:         "/config/master",
+		accept:       []string{"application/yaml"},
+		expectedType: "application/yaml",
+		expected:     conf,
+	}, {
+		name:         "yaml-preferred",
+		path:         "/config/master",
+		accept:       []string{"text/html, application/x-yaml;q=0.9, application/json;q=0.8"},
+		expectedType: "application/yaml",
+		expected:     conf,
+	}, {
+		name:         "json-preferred",
+		path:         "/config/master",
+		accept:       []string{"application/json", "application/yaml"},
+		expectedType: "application/json",
+		expected:     conf,
+	}, {
+		name:         "yaml-not-acceptable",
+		path:         "/config/master",
+		accept:       []string{"application/yaml;q=0"},
+		expectedType: "application/json",
+		expected:     conf,
+	}, {
+		name:         "yaml-topup",
+		path:         "/config/master/topup",
+		accept:       []string{"application/yaml"},
+		expectedType: "application/yaml",
+		expected: func() ignv2_2types.Config {
+			_, topup := splitFirstbootConfig(&conf)
+			return *topup
+		}(),
+	}}
+
+	for _, s := range scenarios {
+		req := httptest.NewRequest("GET", "http://testrequest"+s.path, nil)
+		for _, a := range s.accept {
+			req.Header.Add("Accept", a)
+		}
+		w := httptest.NewRecorder()
+		ms := &mockServer{
+			GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+				c := conf
+				return &c, nil
+			},
+		}
+		NewServerAPIHandler(ms, APIHandlerOptions{}).ServeHTTP(w, req)
+
+		resp := w.Result()
+		body := w.Body.Bytes()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status %d, received: %d", s.name, http.StatusOK, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("Content-Type"); got != s.expectedType {
+			t.Errorf("%s: expected Content-Type %s, received: %s", s.name, s.expectedType, got)
+		}
+		if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(body)) {
+			t.Errorf("%s: expected Content-Length %d, received: %s", s.name, len(body), got)
+		}
+
+		data := body
+		if s.expectedType == "application/yaml" {
+			if json.Valid(body) {
+				t.Errorf("%s: expected a YAML body, received JSON: %s", s.name, body)
+			}
+			if data, err = yaml.YAMLToJSON(body); err != nil {
+				t.Errorf("%s: couldn't decode YAML body: %v", s.name, err)
+				continue
+			}
+		} else if s.path == "/config/master" && string(body) != string(jsonData)+"\n" {
+			t.Errorf("%s: expected JSON body to be unchanged, received: %s", s.name, body)
+		}
+		var got ignv2_2types.Config
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: couldn't decode body: %v", s.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, s.expected) {
+			t.Errorf("%s: expected config %+v, received: %+v", s.name, s.expected, got)
+		}
+	}
+}
+
+func TestAPIHandlerConfigTTL(t *testing.T) {
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			return new(ignv2_2types.Config), nil
+		},
+	}
+	serve := func(ttl time.Duration) (*http.Response, []byte) {
+		w := httptest.NewRecorder()
+		NewServerAPIHandler(ms, APIHandlerOptions{ConfigTTL: ttl}).ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/master", nil))
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, received: %d", http.StatusOK, resp.StatusCode)
+		}
+		return resp, w.Body.Bytes()
+	}
+
+	resp, body := serve(0)
+	if got := resp.Header.Get("Expires"); got != "" {
+		t.Errorf("expected no Expires header without a TTL, received: %s", got)
+	}
+
+	before := time.Now().Truncate(time.Second)
+	expiringResp, expiringBody := serve(time.Hour)
+	after := time.Now()
+	expires, err := http.ParseTime(expiringResp.Header.Get("Expires"))
+	if err != nil {
+		t.Fatalf("expected an HTTP date in the Expires header, received: %q", expiringResp.Header.Get("Expires"))
+	}
+	if expires.Before(before.Add(time.Hour)) || expires.After(after.Add(time.Hour)) {
+		t.Errorf("expected the config to expire an hour after being served, received: %s", expires)
+	}
+
+	// the expiry doesn't change the config served.
+	if !bytes.Equal(body, expiringBody) || resp.Header.Get("ETag") != expiringResp.Header.Get("ETag") {
+		t.Errorf("expected the same config with and without a TTL, received %s and %s", body, expiringBody)
+	}
+}
+
+func TestAPIHandlerDiff(t *testing.T) {
+	newRenderedConfig := func(name, pool, osImageURL string, files ...string) *mcfgv1.MachineConfig {
+		isController := true
+		mc := &mcfgv1.MachineConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				OwnerReferences: []metav1.OwnerReference{{
+					Kind:       "MachineConfigPool",
+					Name:       pool,
+					Controller: &isController,
+				}},
+			},
+			Spec: mcfgv1.MachineConfigSpec{
+				OSImageURL: osImageURL,
+				Config:     ignv2_2types.Config{Ignition: ignv2_2types.Ignition{Version: "2.2.0"}},
+			},
+		}
+		for _, f := range files {
+			appendFileToIgnition(&mc.Spec.Config, f, f)
+		}
+		return mc
+	}
+	configs := map[string]*mcfgv1.MachineConfig{
+		"gen1":   newRenderedConfig("gen1", "master", "registry/os:1", "/etc/a"),
+		"gen2":   newRenderedConfig("gen2", "master", "registry/os:2", "/etc/a", "/etc/b"),
+		"worker": newRenderedConfig("worker", "worker", "registry/os:1"),
+	}
+	ms := &mockServer{
+		GetRenderedConfigFn: func(pr poolRequest, hash string) (*mcfgv1.MachineConfig, error) {
+			mc, ok := configs[hash]
+			if !ok || !isRenderedForPool(mc, pr.machinePool) {
+				return nil, nil
+			}
+			return mc, nil
+		},
+	}
+
+	fileB, err := toJSONDocument(configs["gen2"].Spec.Config.Storage.Files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedPatch  []jsonPatchOperation
+	}{{
+		name:           "forward",
+		url:            "http://testrequest/config/master/diff?from=gen1&to=gen2",
+		expectedStatus: http.StatusOK,
+		expectedPatch: []jsonPatchOperation{
+			{Op: "add", Path: "/config/storage/files/1", Value: fileB},
+			{Op: "replace", Path: "/osImageURL", Value: "registry/os:2"},
+		},
+	}, {
+		name:           "backward",
+		url:            "http://testrequest/config/master/diff?from=gen2&to=gen1",
+		expectedStatus: http.StatusOK,
+		expectedPatch: []jsonPatchOperation{
+			{Op: "remove", Path: "/config/storage/files/1"},
+			{Op: "replace", Path: "/osImageURL", Value: "registry/os:1"},
+		},
+	}, {
+		name:           "same",
+		url:            "http://testrequest/config/master/diff?from=gen1&to=gen1",
+		expectedStatus: http.StatusOK,
+		expectedPatch:  []jsonPatchOperation{},
+	}, {
+		name:           "unknown-hash",
+		url:            "http://testrequest/config/master/diff?from=gen1&to=unknown",
+		expectedStatus: http.StatusNotFound,
+	}, {
+		name:           "other-pool",
+		url:            "http://testrequest/config/master/diff?from=gen1&to=worker",
+		expectedStatus: http.StatusNotFound,
+	}, {
+		name:           "missing-param",
+		url:            "http://testrequest/config/master/diff?from=gen1",
+		expectedStatus: http.StatusBadRequest,
+	}}
+
+	for _, s := range scenarios {
+		req := httptest.NewRequest("GET", s.url, nil)
+		w := httptest.NewRecorder()
+		NewServerAPIHandler(ms, APIHandlerOptions{}).ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != s.expectedStatus {
+			t.Errorf("%s: expected status %d, received: %d", s.name, s.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if s.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var got []map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: couldn't decode patch: %v", s.name, err)
+		}
+		var expected []map[string]interface{}
+		data, err := json.Marshal(s.expectedPatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &expected); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected patch %v, received: %v", s.name, expected, got)
+		}
+	}
+}
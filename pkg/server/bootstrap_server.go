@@ -57,7 +57,17 @@ func NewBootstrapServer(dir, kubeconfig string) (Server, error) {
 // 4. Execute the etcd template function based on the etcd_index, if passed in the request .
 // 5. Append the machine annotations file.
 // 6. Append the KubeConfig file.
+//
+// If the request asks for a rendered config by hash, that config is read
+// instead of the currentConfig of the pool.
 func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*ignv2_2types.Config, error) {
+	if cr.hash != "" {
+		mc, err := bsc.GetRenderedConfig(cr, cr.hash)
+		if mc == nil || err != nil {
+			return nil, err
+		}
+		return bsc.appendConfig(cr, mc)
+	}
 
 	// 1. Read the Machine Config Pool object.
 	fileName := path.Join(bsc.serverBaseDir, "machine-pools", cr.machinePool+".yaml")
@@ -96,8 +106,13 @@ func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*ignv2_2types.Config, err
 	if err != nil {
 		return nil, fmt.Errorf("server: could not unmarshal file %s, err: %v", fileName, err)
 	}
+	return bsc.appendConfig(cr, mc)
+}
 
-	appenders := getAppenders(cr, currConf, bsc.kubeconfigFunc)
+// appendConfig returns the Ignition config of the machine config with the
+// files appended for the request.
+func (bsc *bootstrapServer) appendConfig(cr poolRequest, mc *v1.MachineConfig) (*ignv2_2types.Config, error) {
+	appenders := getAppenders(cr, mc.Name, bsc.kubeconfigFunc)
 	for _, a := range appenders {
 		if err := a(&mc.Spec.Config); err != nil {
 			return nil, err
@@ -106,6 +121,35 @@ func (bsc *bootstrapServer) GetConfig(cr poolRequest) (*ignv2_2types.Config, err
 	return &mc.Spec.Config, nil
 }
 
+// GetRenderedConfig reads the machine config named by hash from
+// "<serverBaseDir>/machine-configs/<hash>.yaml".
+// It returns nil if the config isn't found or wasn't rendered for the pool.
+func (bsc *bootstrapServer) GetRenderedConfig(cr poolRequest, hash string) (*v1.MachineConfig, error) {
+	// the hash is used as a file name, don't let it point outside of the directory.
+	if hash != path.Base(hash) {
+		return nil, nil
+	}
+	fileName := path.Join(bsc.serverBaseDir, "machine-configs", hash+".yaml")
+	glog.Infof("reading file %q", fileName)
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		glog.Errorf("could not find file: %s", fileName)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("server: could not read file %s, err: %v", fileName, err)
+	}
+
+	mc := new(v1.MachineConfig)
+	if err := yaml.Unmarshal(data, mc); err != nil {
+		return nil, fmt.Errorf("server: could not unmarshal file %s, err: %v", fileName, err)
+	}
+	if !isRenderedForPool(mc, cr.machinePool) {
+		return nil, nil
+	}
+	return mc, nil
+}
+
 func kubeconfigFromFile(path string) ([]byte, []byte, error) {
 	kcData, err := ioutil.ReadFile(path)
 	if err != nil {
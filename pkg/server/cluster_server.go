@@ -7,12 +7,18 @@ import (
 
 	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
 	yaml "github.com/ghodss/yaml"
+	"github.com/golang/glog"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	rest "k8s.io/client-go/rest"
 	clientcmd "k8s.io/client-go/tools/clientcmd"
 	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
 	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/typed/machineconfiguration.openshift.io/v1"
 )
 
@@ -33,6 +39,10 @@ type clusterServer struct {
 	// machine config, pool objects.
 	machineClient v1.MachineconfigurationV1Interface
 
+	// nodeClient is used to look up the rollout wave
+	// and the current config of the requesting node.
+	nodeClient corev1client.NodesGetter
+
 	kubeconfigFunc kubeconfigFunc
 }
 
@@ -49,28 +59,49 @@ func NewClusterServer(kubeConfig, apiserverURL string) (Server, error) {
 	}
 
 	mc := v1.NewForConfigOrDie(restConfig)
+	kc := kubernetes.NewForConfigOrDie(restConfig)
 	return &clusterServer{
 		machineClient:  mc,
+		nodeClient:     kc.CoreV1(),
 		kubeconfigFunc: func() ([]byte, []byte, error) { return kubeconfigFromSecret(bootstrapTokenDir, apiserverURL) },
 	}, nil
 }
 
 // GetConfig fetches the machine config(type - Ignition) from the cluster,
-// based on the pool request.
+// based on the pool request. It returns nil if the request asks for a
+// rendered config by hash that doesn't exist.
 func (cs *clusterServer) GetConfig(cr poolRequest) (*ignv2_2types.Config, error) {
+	if cr.hash != "" {
+		mc, err := cs.GetRenderedConfig(cr, cr.hash)
+		if mc == nil || err != nil {
+			return nil, err
+		}
+		return cs.appendConfig(cr, mc)
+	}
+
 	mp, err := cs.machineClient.MachineConfigPools().Get(cr.machinePool, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch pool. err: %v", err)
 	}
 
 	currConf := mp.Status.CurrentMachineConfig
+	if cr.node != "" && mp.Spec.RolloutWave != nil {
+		if currConf, err = cs.getRolloutWaveConfig(mp, cr.node); err != nil {
+			return nil, err
+		}
+	}
 
 	mc, err := cs.machineClient.MachineConfigs().Get(currConf, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch config %s, err: %v", currConf, err)
 	}
+	return cs.appendConfig(cr, mc)
+}
 
-	appenders := getAppenders(cr, currConf, cs.kubeconfigFunc)
+// appendConfig returns the Ignition config of the machine config with the
+// files appended for the request.
+func (cs *clusterServer) appendConfig(cr poolRequest, mc *mcfgv1.MachineConfig) (*ignv2_2types.Config, error) {
+	appenders := getAppenders(cr, mc.Name, cs.kubeconfigFunc)
 	for _, a := range appenders {
 		if err := a(&mc.Spec.Config); err != nil {
 			return nil, err
@@ -79,6 +110,55 @@ func (cs *clusterServer) GetConfig(cr poolRequest) (*ignv2_2types.Config, error)
 	return &mc.Spec.Config, nil
 }
 
+// getRolloutWaveConfig returns the name of the config to serve to the node of
+// the pool: the CurrentMachineConfig of the pool if the node is in a promoted
+// rollout wave, and the config the node is on otherwise. Nodes that haven't
+// joined the cluster or aren't on a config rendered for the pool yet get the
+// CurrentMachineConfig.
+func (cs *clusterServer) getRolloutWaveConfig(mp *mcfgv1.MachineConfigPool, name string) (string, error) {
+	currConf := mp.Status.CurrentMachineConfig
+	node, err := cs.nodeClient.Nodes().Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return currConf, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not fetch node %s, err: %v", name, err)
+	}
+	if mcfgv1.IsInPromotedRolloutWave(mp, node.Labels) {
+		return currConf, nil
+	}
+
+	nodeConf := node.Annotations[daemon.CurrentMachineConfigAnnotationKey]
+	if nodeConf == "" || nodeConf == currConf {
+		return currConf, nil
+	}
+	mc, err := cs.GetRenderedConfig(poolRequest{machinePool: mp.Name}, nodeConf)
+	if err != nil {
+		return "", err
+	}
+	if mc == nil {
+		return currConf, nil
+	}
+	glog.Infof("node %s is not in a promoted rollout wave of pool %s, serving its current config %s", name, mp.Name, nodeConf)
+	return nodeConf, nil
+}
+
+// GetRenderedConfig fetches the machine config named by hash from the cluster.
+// It returns nil if the config doesn't exist or wasn't rendered for the pool.
+func (cs *clusterServer) GetRenderedConfig(cr poolRequest, hash string) (*mcfgv1.MachineConfig, error) {
+	mc, err := cs.machineClient.MachineConfigs().Get(hash, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch config %s, err: %v", hash, err)
+	}
+	if !isRenderedForPool(mc, cr.machinePool) {
+		return nil, nil
+	}
+	return mc, nil
+}
+
 // getClientConfig returns a Kubernetes client Config.
 func getClientConfig(path string) (*rest.Config, error) {
 	if path != inClusterConfig {
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
+)
+
+const (
+	apiPathDiff      = "diff"
+	apiParamDiffFrom = "from"
+	apiParamDiffTo   = "to"
+)
+
+// jsonPatchOperation is a single RFC6902 JSON patch operation.
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON omits the value of remove operations, the value of the other
+// operations is always set even if it is null.
+func (o jsonPatchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	type operation jsonPatchOperation
+	return json.Marshal(operation(o))
+}
+
+// isRenderedForPool returns true if the machine config was rendered for the
+// machine pool.
+func isRenderedForPool(mc *mcfgv1.MachineConfig, pool string) bool {
+	ref := metav1.GetControllerOf(mc)
+	return ref != nil && ref.Kind == "MachineConfigPool" && ref.Name == pool
+}
+
+// serveDiff writes the JSON patch that transforms the spec of the rendered
+// config `from` of the pool into the spec of the rendered config `to`.
+func (sh *APIHandler) serveDiff(w http.ResponseWriter, r *http.Request, pool string) {
+	from := r.URL.Query().Get(apiParamDiffFrom)
+	to := r.URL.Query().Get(apiParamDiffTo)
+	if from == "" || to == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var specs []mcfgv1.MachineConfigSpec
+	for _, hash := range []string{from, to} {
+		cr := poolRequest{machinePool: pool}
+		mc, err := sh.server.GetRenderedConfig(cr, hash)
+		if err != nil {
+			sh.internalError(w, cr.machinePool, "couldn't get rendered config %s for req: %v, error: %v", hash, cr, err)
+			return
+		}
+		if mc == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		specs = append(specs, mc.Spec)
+	}
+
+	patch, err := createJSONPatch(specs[0], specs[1])
+	if err != nil {
+		sh.internalError(w, pool, "couldn't create diff for pool %s from %s to %s, error: %v", pool, from, to, err)
+		return
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		sh.internalError(w, pool, "couldn't encode diff for pool %s from %s to %s, error: %v", pool, from, to, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json-patch+json")
+	w.Write(append(data, '\n'))
+}
+
+// createJSONPatch returns the RFC6902 JSON patch that transforms the JSON
+// representation of from into the JSON representation of to.
+func createJSONPatch(from, to interface{}) ([]jsonPatchOperation, error) {
+	fromDoc, err := toJSONDocument(from)
+	if err != nil {
+		return nil, err
+	}
+	toDoc, err := toJSONDocument(to)
+	if err != nil {
+		return nil, err
+	}
+	patch := []jsonPatchOperation{}
+	return diffJSON(patch, "", fromDoc, toDoc), nil
+}
+
+func toJSONDocument(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// diffJSON appends the operations needed to turn from into to at path.
+func diffJSON(patch []jsonPatchOperation, path string, from, to interface{}) []jsonPatchOperation {
+	if reflect.DeepEqual(from, to) {
+		return patch
+	}
+
+	switch f := from.(type) {
+	case map[string]interface{}:
+		t, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, k := range sortedKeys(f) {
+			if _, ok := t[k]; !ok {
+				patch = append(patch, jsonPatchOperation{Op: "remove", Path: path + "/" + escapeJSONPointer(k)})
+			}
+		}
+		for _, k := range sortedKeys(t) {
+			p := path + "/" + escapeJSONPointer(k)
+			if fv, ok := f[k]; ok {
+				patch = diffJSON(patch, p, fv, t[k])
+			} else {
+				patch = append(patch, jsonPatchOperation{Op: "add", Path: p, Value: t[k]})
+			}
+		}
+		return patch
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		common := len(f)
+		if len(t) < common {
+			common = len(t)
+		}
+		for i := 0; i < common; i++ {
+			patch = diffJSON(patch, path+"/"+strconv.Itoa(i), f[i], t[i])
+		}
+		// remove from the end so the indices of the remaining elements don't shift.
+		for i := len(f) - 1; i >= common; i-- {
+			patch = append(patch, jsonPatchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(t); i++ {
+			patch = append(patch, jsonPatchOperation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: t[i]})
+		}
+		return patch
+	}
+
+	return append(patch, jsonPatchOperation{Op: "replace", Path: path, Value: to})
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeJSONPointer escapes a reference token of a JSON pointer as defined
+// in RFC6901.
+func escapeJSONPointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiPathDebugErrors = "/debug/errors"
+
+// ErrorEntry is an error the server returned to a client.
+type ErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Pool    string    `json:"pool"`
+	Message string    `json:"message"`
+}
+
+// ErrorLog keeps the last errors returned by the server in a bounded ring
+// buffer. It is safe for concurrent use.
+type ErrorLog struct {
+	mu      sync.Mutex
+	entries []ErrorEntry
+	// next is the index the next entry is written at.
+	next int
+	full bool
+}
+
+// NewErrorLog returns an ErrorLog keeping the last size errors.
+// It returns nil if size is not positive.
+func NewErrorLog(size int) *ErrorLog {
+	if size <= 0 {
+		return nil
+	}
+	return &ErrorLog{entries: make([]ErrorEntry, size)}
+}
+
+// Record adds an error for the pool, overwriting the oldest error once the
+// log is full. Recording to a nil ErrorLog does nothing.
+func (l *ErrorLog) Record(pool, message string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[l.next] = ErrorEntry{Time: time.Now().UTC(), Pool: pool, Message: message}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Entries returns the recorded errors, oldest first.
+func (l *ErrorLog) Entries() []ErrorEntry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]ErrorEntry{}, l.entries[:l.next]...)
+	}
+	return append(append([]ErrorEntry{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// hasBearerToken returns true if the request has an
+// `Authorization: Bearer <token>` header with the token.
+func hasBearerToken(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	return token != "" && strings.HasPrefix(auth, "Bearer ") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// errorLogHandler serves the errors of an ErrorLog as JSON to requests that
+// present the bearer token.
+type errorLogHandler struct {
+	log   *ErrorLog
+	token string
+}
+
+// NewErrorLogHandler returns the handler serving the recorded errors to
+// requests with an `Authorization: Bearer <token>` header.
+func NewErrorLogHandler(l *ErrorLog, token string) http.Handler {
+	return &errorLogHandler{log: l, token: token}
+}
+
+func (h *errorLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !hasBearerToken(r, h.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	entries := h.log.Entries()
+	if entries == nil {
+		entries = []ErrorEntry{}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(append(data, '\n'))
+}
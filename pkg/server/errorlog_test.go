@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestErrorLog(t *testing.T) {
+	if l := NewErrorLog(0); l != nil {
+		t.Errorf("expected no error log for size 0, got: %v", l)
+	}
+
+	l := NewErrorLog(3)
+	messages := func() []string {
+		var msgs []string
+		for _, e := range l.Entries() {
+			msgs = append(msgs, e.Message)
+		}
+		return msgs
+	}
+	if got := messages(); len(got) != 0 {
+		t.Errorf("expected no errors, got: %v", got)
+	}
+
+	l.Record("master", "1")
+	l.Record("worker", "2")
+	if got, exp := messages(), []string{"1", "2"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got: %v", exp, got)
+	}
+
+	// the oldest errors are dropped once the log is full
+	for _, msg := range []string{"3", "4", "5"} {
+		l.Record("master", msg)
+	}
+	if got, exp := messages(), []string{"3", "4", "5"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got: %v", exp, got)
+	}
+	l.Record("master", "6")
+	if got, exp := messages(), []string{"4", "5", "6"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got: %v", exp, got)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Record("master", fmt.Sprint(i))
+			l.Entries()
+		}(i)
+	}
+	wg.Wait()
+	if got := len(l.Entries()); got != 3 {
+		t.Errorf("expected the log to stay bounded to 3 errors, got: %d", got)
+	}
+}
+
+func TestErrorLogHandler(t *testing.T) {
+	errorLog := NewErrorLog(10)
+	ms := &mockServer{
+		GetConfigFn: func(pr poolRequest) (*ignv2_2types.Config, error) {
+			return nil, fmt.Errorf("%s is broken", pr.machinePool)
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{ErrorLog: errorLog})
+	for _, pool := range []string{"master", "worker", "infra"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/"+pool, nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected %d, received: %d", http.StatusInternalServerError, w.Code)
+		}
+	}
+
+	debug := NewErrorLogHandler(errorLog, "s3cr3t")
+	for _, auth := range []string{"", "Bearer wrong", "s3cr3t"} {
+		req := httptest.NewRequest("GET", "http://testrequest/debug/errors", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		debug.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected %d for authorization %q, received: %d", http.StatusUnauthorized, auth, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://testrequest/debug/errors", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	debug.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, received: %d", http.StatusOK, w.Code)
+	}
+	var entries []ErrorEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	var pools []string
+	for i, e := range entries {
+		pools = append(pools, e.Pool)
+		if e.Time.IsZero() {
+			t.Errorf("expected error %d to have a time", i)
+		}
+		if i > 0 && e.Time.Before(entries[i-1].Time) {
+			t.Errorf("expected error %d to be after error %d", i, i-1)
+		}
+	}
+	if exp := []string{"master", "worker", "infra"}; !reflect.DeepEqual(pools, exp) {
+		t.Errorf("expected errors for %v, got: %v", exp, pools)
+	}
+	if entries[0].Message != "couldn't get config for req: {master  }, error: master is broken" {
+		t.Errorf("unexpected message: %s", entries[0].Message)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// reservedHeaders are set by the server on config responses and can't be
+// configured.
+var reservedHeaders = map[string]bool{
+	"Content-Type":    true,
+	"Content-Length":  true,
+	"Etag":            true,
+	"Expires":         true,
+	attestationHeader: true,
+	nodeTokenHeader:   true,
+}
+
+// PoolHeaders maps machine pool names to the headers added to the configs
+// served for them.
+type PoolHeaders map[string]http.Header
+
+// ParsePoolHeaders builds the PoolHeaders from headers of the form
+// `<pool>=<name>:<value>`. A header given several times for a pool is sent
+// with all its values. Header names must be valid tokens and values can't
+// contain control characters, so they can't inject other headers.
+// It returns nil if no headers are provided.
+func ParsePoolHeaders(headers []string) (PoolHeaders, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	ph := PoolHeaders{}
+	for _, h := range headers {
+		parts := strings.SplitN(h, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid pool header %q, expected <pool>=<name>:<value>", h)
+		}
+		header := strings.SplitN(parts[1], ":", 2)
+		if len(header) != 2 {
+			return nil, fmt.Errorf("invalid pool header %q, expected <pool>=<name>:<value>", h)
+		}
+		name, value := header[0], strings.TrimSpace(header[1])
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("invalid name %q of pool header %q", name, h)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("invalid value %q of pool header %q", value, h)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("pool header %q is set by the server", name)
+		}
+		if ph[parts[0]] == nil {
+			ph[parts[0]] = http.Header{}
+		}
+		ph[parts[0]].Add(name, value)
+	}
+	return ph, nil
+}
+
+// addHeaders adds the headers of the pool to the response.
+func (ph PoolHeaders) addHeaders(w http.ResponseWriter, pool string) {
+	for name, values := range ph[pool] {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestParsePoolHeaders(t *testing.T) {
+	ph, err := ParsePoolHeaders(nil)
+	if err != nil || ph != nil {
+		t.Errorf("expected no pool headers, got: %v, %v", ph, err)
+	}
+
+	ph, err = ParsePoolHeaders([]string{
+		"edge=cache-tag: edge,configs",
+		"edge=Cache-Tag:mcs",
+		"worker=X-Proxy-Route:workers",
+	})
+	if err != nil {
+		t.Fatalf("expected err to be nil, received: %v", err)
+	}
+	exp := PoolHeaders{
+		"edge":   http.Header{"Cache-Tag": {"edge,configs", "mcs"}},
+		"worker": http.Header{"X-Proxy-Route": {"workers"}},
+	}
+	if !reflect.DeepEqual(ph, exp) {
+		t.Errorf("expected pool headers %v, got: %v", exp, ph)
+	}
+
+	for _, invalid := range []string{
+		"cache-tag:edge",
+		"=Cache-Tag:edge",
+		"edge=Cache-Tag",
+		"edge=:edge",
+		"edge=Cache Tag:edge",
+		"edge=Cache-Tag\r\nX-Injected:edge",
+		"edge=Cache-Tag:edge\r\nX-Injected: true",
+		"edge=Cache-Tag:edge\x00",
+		"edge=content-type:text/plain",
+		"edge=ETag:\"1\"",
+	} {
+		if _, err := ParsePoolHeaders([]string{invalid}); err == nil {
+			t.Errorf("expected error for pool header %q", invalid)
+		}
+	}
+}
+
+func TestAPIHandlerPoolHeaders(t *testing.T) {
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			return &ignv2_2types.Config{Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()}}, nil
+		},
+	}
+	headers, err := ParsePoolHeaders([]string{"edge=Cache-Tag:edge", "edge=Cache-Tag:configs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{Headers: headers})
+
+	for _, test := range []struct {
+		url string
+		exp []string
+	}{{
+		url: "http://testrequest/config/edge",
+		exp: []string{"edge", "configs"},
+	}, {
+		url: "http://testrequest/config/edge/firstboot",
+		exp: []string{"edge", "configs"},
+	}, {
+		url: "http://testrequest/config/worker",
+	}} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d for %s, received: %d", http.StatusOK, test.url, w.Code)
+		}
+		if got := w.Header()["Cache-Tag"]; !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("expected Cache-Tag %v for %s, received: %v", test.exp, test.url, got)
+		}
+		if got := w.Header().Get("Content-Type"); got != contentTypeJSON {
+			t.Errorf("expected Content-Type %s for %s, received: %s", contentTypeJSON, test.url, got)
+		}
+	}
+}
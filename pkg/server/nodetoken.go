@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// nodeTokenHeader is the response header carrying the base64 encoded
+	// DSSE envelope binding the served config to the requesting node.
+	nodeTokenHeader = "X-Machine-Config-Node-Token"
+
+	// NodeBindingPredicateType is the predicate type of the tokens binding a
+	// served config to the node it was served to.
+	NodeBindingPredicateType = "https://github.com/openshift/machine-config-operator/node-binding@v1"
+)
+
+// NodeBindingStatement is an in-toto statement binding a served config to the
+// node it was served to.
+type NodeBindingStatement struct {
+	Type          string      `json:"_type"`
+	PredicateType string      `json:"predicateType"`
+	Subject       []Subject   `json:"subject"`
+	Predicate     NodeBinding `json:"predicate"`
+}
+
+// NodeBinding is the node a config was served to.
+type NodeBinding struct {
+	Node     string    `json:"node"`
+	Pool     string    `json:"pool"`
+	Issuer   string    `json:"issuer"`
+	IssuedOn time.Time `json:"issuedOn"`
+}
+
+// NodeToken returns the base64 encoded DSSE envelope of a signed statement
+// binding data, the config of pool served to node, to node. The node presents
+// it to attest which config it was given.
+func (s *Signer) NodeToken(node, pool string, data []byte) (string, error) {
+	if node == "" {
+		return "", errors.New("a node is required to bind the config to")
+	}
+	sum := sha256.Sum256(data)
+	statement := NodeBindingStatement{
+		Type:          intotoStatementType,
+		PredicateType: NodeBindingPredicateType,
+		Subject: []Subject{{
+			Name:   node,
+			Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		}},
+		Predicate: NodeBinding{
+			Node:     node,
+			Pool:     pool,
+			Issuer:   s.identity,
+			IssuedOn: time.Now().UTC(),
+		},
+	}
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return "", err
+	}
+	return s.signEnvelope(payload)
+}
+
+// VerifyNodeToken verifies the base64 encoded DSSE envelope token is signed by
+// pub and binds data to node. Tokens presented by any other node, or for any
+// other config, are rejected. It returns the verified statement.
+func VerifyNodeToken(token, node string, data []byte, pub *ecdsa.PublicKey) (*NodeBindingStatement, error) {
+	payload, err := openEnvelope(token, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var statement NodeBindingStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil, fmt.Errorf("could not decode node token statement: %v", err)
+	}
+	if statement.PredicateType != NodeBindingPredicateType {
+		return nil, fmt.Errorf("unexpected node token predicate type %q", statement.PredicateType)
+	}
+	if statement.Predicate.Node != node {
+		return nil, fmt.Errorf("node token is bound to node %q, not %q", statement.Predicate.Node, node)
+	}
+	if !subjectsInclude(statement.Subject, data) {
+		return nil, errors.New("node token is not about the served config")
+	}
+	return &statement, nil
+}
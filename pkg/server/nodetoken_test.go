@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestAPIHandlerNodeToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcs-signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile, key := writeTestSigningKey(t, dir)
+	signer, err := NewSigner(keyFile, "mcs@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every node gets its own config.
+	ms := &mockServer{
+		GetConfigFn: func(cr poolRequest) (*ignv2_2types.Config, error) {
+			conf := &ignv2_2types.Config{Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()}}
+			appendFileToIgnition(conf, "/etc/node-name", cr.node)
+			return conf, nil
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{Signer: signer})
+	serve := func(url string) (string, []byte, string) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Header().Get(nodeTokenHeader), w.Body.Bytes(), w.Header().Get(attestationHeader)
+	}
+
+	token, body, attestation := serve("http://testrequest/config/worker?node=worker-0")
+	if token == "" {
+		t.Fatal("expected a node token")
+	}
+	statement, err := VerifyNodeToken(token, "worker-0", body, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("expected the node token to verify, got: %v", err)
+	}
+	if statement.Predicate.Node != "worker-0" || statement.Predicate.Pool != "worker" || statement.Predicate.Issuer != "mcs@example.com" {
+		t.Errorf("expected the token to bind pool worker to node worker-0 by mcs@example.com, got: %+v", statement.Predicate)
+	}
+
+	// the token can't be replayed by another node, even with its own config.
+	if _, err := VerifyNodeToken(token, "worker-1", body, &key.PublicKey); err == nil || !strings.Contains(err.Error(), `bound to node "worker-0"`) {
+		t.Errorf("expected the token to be rejected for another node, got: %v", err)
+	}
+	otherToken, otherBody, _ := serve("http://testrequest/config/worker?node=worker-1")
+	if _, err := VerifyNodeToken(token, "worker-1", otherBody, &key.PublicKey); err == nil {
+		t.Error("expected the token of worker-0 to be rejected for the config of worker-1")
+	}
+	if _, err := VerifyNodeToken(otherToken, "worker-0", body, &key.PublicKey); err == nil {
+		t.Error("expected the token of worker-1 to be rejected for worker-0")
+	}
+	if _, err := VerifyNodeToken(otherToken, "worker-1", otherBody, &key.PublicKey); err != nil {
+		t.Errorf("expected the token of worker-1 to verify, got: %v", err)
+	}
+	// nor stand in for the config of the same node with other contents.
+	if _, err := VerifyNodeToken(token, "worker-0", otherBody, &key.PublicKey); err == nil {
+		t.Error("expected the token to be rejected for other contents")
+	}
+	// the provenance attestation doesn't bind the config to a node.
+	if _, err := VerifyNodeToken(attestation, "worker-0", body, &key.PublicKey); err == nil {
+		t.Error("expected the provenance attestation to be rejected as a node token")
+	}
+	// and the node token doesn't attest the provenance of the config.
+	if _, err := VerifyAttestation(token, body, &key.PublicKey); err == nil || !strings.Contains(err.Error(), "predicate type") {
+		t.Errorf("expected the node token to be rejected as an attestation, got: %v", err)
+	}
+
+	if token, _, _ := serve("http://testrequest/config/worker"); token != "" {
+		t.Errorf("expected no node token without a node, got: %s", token)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"sort"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// sortConfig returns a copy of the config with its files, directories and
+// links sorted by path, its systemd and networkd units and their dropins
+// sorted by name, and its users and groups sorted by name, so that the same
+// config is always served byte-identical whatever order it was assembled in.
+//
+// The sorts are stable: entries for the same path or name, such as a file
+// and the entries appending to it, keep their order.
+func sortConfig(conf *ignv2_2types.Config) *ignv2_2types.Config {
+	out := *conf
+
+	out.Storage.Files = append([]ignv2_2types.File(nil), conf.Storage.Files...)
+	sort.SliceStable(out.Storage.Files, func(i, j int) bool { return out.Storage.Files[i].Path < out.Storage.Files[j].Path })
+	out.Storage.Directories = append([]ignv2_2types.Directory(nil), conf.Storage.Directories...)
+	sort.SliceStable(out.Storage.Directories, func(i, j int) bool {
+		return out.Storage.Directories[i].Path < out.Storage.Directories[j].Path
+	})
+	out.Storage.Links = append([]ignv2_2types.Link(nil), conf.Storage.Links...)
+	sort.SliceStable(out.Storage.Links, func(i, j int) bool { return out.Storage.Links[i].Path < out.Storage.Links[j].Path })
+
+	out.Systemd.Units = make([]ignv2_2types.Unit, len(conf.Systemd.Units))
+	for i, u := range conf.Systemd.Units {
+		u.Dropins = append([]ignv2_2types.SystemdDropin(nil), u.Dropins...)
+		sort.SliceStable(u.Dropins, func(i, j int) bool { return u.Dropins[i].Name < u.Dropins[j].Name })
+		out.Systemd.Units[i] = u
+	}
+	sort.SliceStable(out.Systemd.Units, func(i, j int) bool { return out.Systemd.Units[i].Name < out.Systemd.Units[j].Name })
+
+	out.Networkd.Units = make([]ignv2_2types.Networkdunit, len(conf.Networkd.Units))
+	for i, u := range conf.Networkd.Units {
+		u.Dropins = append([]ignv2_2types.NetworkdDropin(nil), u.Dropins...)
+		sort.SliceStable(u.Dropins, func(i, j int) bool { return u.Dropins[i].Name < u.Dropins[j].Name })
+		out.Networkd.Units[i] = u
+	}
+	sort.SliceStable(out.Networkd.Units, func(i, j int) bool { return out.Networkd.Units[i].Name < out.Networkd.Units[j].Name })
+
+	out.Passwd.Users = append([]ignv2_2types.PasswdUser(nil), conf.Passwd.Users...)
+	sort.SliceStable(out.Passwd.Users, func(i, j int) bool { return out.Passwd.Users[i].Name < out.Passwd.Users[j].Name })
+	out.Passwd.Groups = append([]ignv2_2types.PasswdGroup(nil), conf.Passwd.Groups...)
+	sort.SliceStable(out.Passwd.Groups, func(i, j int) bool { return out.Passwd.Groups[i].Name < out.Passwd.Groups[j].Name })
+
+	return &out
+}
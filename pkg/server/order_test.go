@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// newOrderTestConfig returns the same config with its sections shuffled by r.
+func newOrderTestConfig(r *rand.Rand) *ignv2_2types.Config {
+	file := func(path, contents string, append bool) ignv2_2types.File {
+		return ignv2_2types.File{
+			Node:          ignv2_2types.Node{Filesystem: "root", Path: path},
+			FileEmbedded1: ignv2_2types.FileEmbedded1{Append: append, Contents: ignv2_2types.FileContents{Source: "data:," + contents}},
+		}
+	}
+	conf := &ignv2_2types.Config{
+		Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+		Storage: ignv2_2types.Storage{
+			Files: []ignv2_2types.File{file("/etc/c", "c", false), file("/etc/a", "a", false), file("/etc/b", "b", false)},
+			Directories: []ignv2_2types.Directory{
+				{Node: ignv2_2types.Node{Filesystem: "root", Path: "/etc/y"}},
+				{Node: ignv2_2types.Node{Filesystem: "root", Path: "/etc/x"}},
+			},
+			Links: []ignv2_2types.Link{
+				{Node: ignv2_2types.Node{Filesystem: "root", Path: "/etc/l2"}, LinkEmbedded1: ignv2_2types.LinkEmbedded1{Target: "/etc/a"}},
+				{Node: ignv2_2types.Node{Filesystem: "root", Path: "/etc/l1"}, LinkEmbedded1: ignv2_2types.LinkEmbedded1{Target: "/etc/b"}},
+			},
+		},
+		Systemd: ignv2_2types.Systemd{Units: []ignv2_2types.Unit{
+			{Name: "b.service", Contents: "b", Dropins: []ignv2_2types.SystemdDropin{{Name: "20-b.conf"}, {Name: "10-b.conf"}}},
+			{Name: "a.service", Contents: "a"},
+			{Name: "c.service", Contents: "c"},
+		}},
+		Networkd: ignv2_2types.Networkd{Units: []ignv2_2types.Networkdunit{{Name: "20-b.network"}, {Name: "10-a.network"}}},
+		Passwd: ignv2_2types.Passwd{
+			Users:  []ignv2_2types.PasswdUser{{Name: "core"}, {Name: "admin"}, {Name: "backup"}},
+			Groups: []ignv2_2types.PasswdGroup{{Name: "wheel"}, {Name: "adm"}},
+		},
+	}
+	r.Shuffle(len(conf.Storage.Files), func(i, j int) {
+		conf.Storage.Files[i], conf.Storage.Files[j] = conf.Storage.Files[j], conf.Storage.Files[i]
+	})
+	r.Shuffle(len(conf.Storage.Directories), func(i, j int) {
+		conf.Storage.Directories[i], conf.Storage.Directories[j] = conf.Storage.Directories[j], conf.Storage.Directories[i]
+	})
+	r.Shuffle(len(conf.Storage.Links), func(i, j int) {
+		conf.Storage.Links[i], conf.Storage.Links[j] = conf.Storage.Links[j], conf.Storage.Links[i]
+	})
+	r.Shuffle(len(conf.Systemd.Units), func(i, j int) {
+		conf.Systemd.Units[i], conf.Systemd.Units[j] = conf.Systemd.Units[j], conf.Systemd.Units[i]
+	})
+	r.Shuffle(len(conf.Passwd.Users), func(i, j int) {
+		conf.Passwd.Users[i], conf.Passwd.Users[j] = conf.Passwd.Users[j], conf.Passwd.Users[i]
+	})
+	// appending to a file only makes sense after it, so these are never shuffled.
+	conf.Storage.Files = append(conf.Storage.Files, file("/etc/a", "more", true), file("/etc/a", "even more", true))
+	return conf
+}
+
+func TestSortConfig(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var expected []byte
+	for i := 0; i < 20; i++ {
+		conf := newOrderTestConfig(r)
+		orig, err := json.Marshal(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(sortConfig(conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after, _ := json.Marshal(conf); !bytes.Equal(after, orig) {
+			t.Errorf("expected the config to be unchanged")
+		}
+		if expected == nil {
+			expected = data
+			continue
+		}
+		if !bytes.Equal(data, expected) {
+			t.Fatalf("expected the same serialization, got:\n%s\nand:\n%s", expected, data)
+		}
+	}
+
+	sorted := sortConfig(newOrderTestConfig(r))
+	var paths, contents []string
+	for _, f := range sorted.Storage.Files {
+		paths = append(paths, f.Path)
+		contents = append(contents, f.Contents.Source)
+	}
+	if exp := []string{"/etc/a", "/etc/a", "/etc/a", "/etc/b", "/etc/c"}; !reflect.DeepEqual(paths, exp) {
+		t.Errorf("expected files %v, got %v", exp, paths)
+	}
+	// the file comes before the entries appending to it.
+	if exp := []string{"data:,a", "data:,more", "data:,even more"}; !reflect.DeepEqual(contents[:3], exp) {
+		t.Errorf("expected contents %v, got %v", exp, contents[:3])
+	}
+	var units []string
+	for _, u := range sorted.Systemd.Units {
+		units = append(units, u.Name)
+		for _, d := range u.Dropins {
+			units = append(units, u.Name+"/"+d.Name)
+		}
+	}
+	if exp := []string{"a.service", "b.service", "b.service/10-b.conf", "b.service/20-b.conf", "c.service"}; !reflect.DeepEqual(units, exp) {
+		t.Errorf("expected units %v, got %v", exp, units)
+	}
+	var users []string
+	for _, u := range sorted.Passwd.Users {
+		users = append(users, u.Name)
+	}
+	if exp := []string{"admin", "backup", "core"}; !reflect.DeepEqual(users, exp) {
+		t.Errorf("expected users %v, got %v", exp, users)
+	}
+}
+
+func TestAPIHandlerDeterministicOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			return newOrderTestConfig(r), nil
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{})
+
+	var body []byte
+	var etag string
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/master", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d, received: %d", http.StatusOK, w.Code)
+		}
+		if i == 0 {
+			body, etag = w.Body.Bytes(), w.Header().Get("ETag")
+			continue
+		}
+		if !bytes.Equal(w.Body.Bytes(), body) {
+			t.Fatalf("expected byte-identical configs, got:\n%s\nand:\n%s", body, w.Body.Bytes())
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Errorf("expected ETag %s, got %s", etag, got)
+		}
+	}
+}
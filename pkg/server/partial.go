@@ -0,0 +1,56 @@
+package server
+
+import (
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+)
+
+const (
+	apiPathFirstboot = "firstboot"
+	apiPathTopup     = "topup"
+)
+
+// firstbootFiles are the files that are always part of the firstboot config,
+// as without them the machine can't join the cluster.
+var firstbootFiles = map[string]bool{
+	defaultMachineKubeConfPath:            true,
+	daemon.InitialNodeAnnotationsFilePath: true,
+}
+
+// splitFirstbootConfig splits the config into the essential config needed on
+// firstboot and the top-up config with the remaining sections, which can be
+// fetched once the network is up.
+//
+// The firstboot config holds the ignition, networkd and passwd sections, the
+// storage layout (disks, raid, filesystems, directories and links) and the
+// files added by the server. The top-up config holds all the other files and
+// the systemd units. Both configs declare the same ignition version, and
+// together they hold everything in the full config.
+func splitFirstbootConfig(conf *ignv2_2types.Config) (*ignv2_2types.Config, *ignv2_2types.Config) {
+	firstboot := &ignv2_2types.Config{
+		Ignition: conf.Ignition,
+		Networkd: conf.Networkd,
+		Passwd:   conf.Passwd,
+		Storage: ignv2_2types.Storage{
+			Directories: conf.Storage.Directories,
+			Disks:       conf.Storage.Disks,
+			Filesystems: conf.Storage.Filesystems,
+			Links:       conf.Storage.Links,
+			Raid:        conf.Storage.Raid,
+		},
+	}
+	topup := &ignv2_2types.Config{
+		Ignition: ignv2_2types.Ignition{Version: conf.Ignition.Version},
+		Systemd:  conf.Systemd,
+	}
+
+	for _, f := range conf.Storage.Files {
+		if firstbootFiles[f.Path] {
+			firstboot.Storage.Files = append(firstboot.Storage.Files, f)
+		} else {
+			topup.Storage.Files = append(topup.Storage.Files, f)
+		}
+	}
+	return firstboot, topup
+}
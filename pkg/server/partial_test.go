@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+)
+
+func newFullConfig() *ignv2_2types.Config {
+	mode := 0755
+	conf := &ignv2_2types.Config{
+		Ignition: ignv2_2types.Ignition{Version: "2.2.0"},
+		Networkd: ignv2_2types.Networkd{
+			Units: []ignv2_2types.Networkdunit{{Name: "00-eth0.network", Contents: "[Match]\nName=eth0"}},
+		},
+		Passwd: ignv2_2types.Passwd{
+			Users: []ignv2_2types.PasswdUser{{Name: "core", SSHAuthorizedKeys: []ignv2_2types.SSHAuthorizedKey{"ssh-rsa AAAA"}}},
+		},
+		Storage: ignv2_2types.Storage{
+			Directories: []ignv2_2types.Directory{{
+				Node:               ignv2_2types.Node{Filesystem: "root", Path: "/etc/foo.d"},
+				DirectoryEmbedded1: ignv2_2types.DirectoryEmbedded1{Mode: &mode},
+			}},
+			Links: []ignv2_2types.Link{{
+				Node:          ignv2_2types.Node{Filesystem: "root", Path: "/etc/foo"},
+				LinkEmbedded1: ignv2_2types.LinkEmbedded1{Target: "/etc/foo.d/foo"},
+			}},
+		},
+		Systemd: ignv2_2types.Systemd{
+			Units: []ignv2_2types.Unit{{Name: "kubelet.service", Contents: "[Unit]"}},
+		},
+	}
+	appendFileToIgnition(conf, "/etc/foo.d/foo", "foo")
+	appendFileToIgnition(conf, defaultMachineKubeConfPath, "kubeconfig")
+	appendFileToIgnition(conf, "/etc/bar", "bar")
+	appendFileToIgnition(conf, daemon.InitialNodeAnnotationsFilePath, "{}")
+	return conf
+}
+
+// mergePartialConfigs merges the firstboot and top-up configs back into one,
+// with the files sorted by path.
+func mergePartialConfigs(firstboot, topup *ignv2_2types.Config) *ignv2_2types.Config {
+	merged := *firstboot
+	merged.Systemd.Units = append(merged.Systemd.Units, topup.Systemd.Units...)
+	merged.Storage.Files = append(append([]ignv2_2types.File{}, firstboot.Storage.Files...), topup.Storage.Files...)
+	sortFiles(merged.Storage.Files)
+	return &merged
+}
+
+func sortFiles(files []ignv2_2types.File) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
+
+// TestSplitFirstbootConfig verifies that the firstboot and top-up configs
+// together are the full config.
+func TestSplitFirstbootConfig(t *testing.T) {
+	full := newFullConfig()
+	firstboot, topup := splitFirstbootConfig(full)
+
+	var firstbootPaths []string
+	for _, f := range firstboot.Storage.Files {
+		firstbootPaths = append(firstbootPaths, f.Path)
+	}
+	if exp := []string{defaultMachineKubeConfPath, daemon.InitialNodeAnnotationsFilePath}; !reflect.DeepEqual(firstbootPaths, exp) {
+		t.Errorf("expected firstboot files %v, got %v", exp, firstbootPaths)
+	}
+	if len(firstboot.Systemd.Units) != 0 {
+		t.Errorf("expected no units in the firstboot config, got %v", firstboot.Systemd.Units)
+	}
+	if topup.Ignition.Version != full.Ignition.Version {
+		t.Errorf("expected top-up config version %q, got %q", full.Ignition.Version, topup.Ignition.Version)
+	}
+
+	expected := *full
+	expected.Storage.Files = append([]ignv2_2types.File{}, full.Storage.Files...)
+	sortFiles(expected.Storage.Files)
+	if merged := mergePartialConfigs(firstboot, topup); !reflect.DeepEqual(merged, &expected) {
+		t.Errorf("expected firstboot and top-up configs to add up to the full config:\n%#v\ngot:\n%#v", &expected, merged)
+	}
+
+	// splitting again gives the same configs.
+	firstboot2, topup2 := splitFirstbootConfig(newFullConfig())
+	if !reflect.DeepEqual(firstboot, firstboot2) || !reflect.DeepEqual(topup, topup2) {
+		t.Errorf("expected the split to be deterministic")
+	}
+}
+
+func TestAPIHandlerFirstbootTopup(t *testing.T) {
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			return newFullConfig(), nil
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{ValidateSchema: true})
+
+	get := func(url string) *ignv2_2types.Config {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: expected status %d, received: %d", url, http.StatusOK, resp.StatusCode)
+		}
+		conf := new(ignv2_2types.Config)
+		if err := json.NewDecoder(resp.Body).Decode(conf); err != nil {
+			t.Fatalf("GET %s: couldn't decode config: %v", url, err)
+		}
+		return conf
+	}
+
+	full := get("http://testrequest/config/master")
+	firstboot := get("http://testrequest/config/master/firstboot")
+	topup := get("http://testrequest/config/master/topup")
+
+	expected := *full
+	sortFiles(expected.Storage.Files)
+	if merged := mergePartialConfigs(firstboot, topup); !reflect.DeepEqual(merged, &expected) {
+		t.Errorf("expected served firstboot and top-up configs to add up to the full config:\n%#v\ngot:\n%#v", &expected, merged)
+	}
+
+	req := httptest.NewRequest("GET", "http://testrequest/config/master/unknown", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if resp := w.Result(); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown subresource, received: %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
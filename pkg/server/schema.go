@@ -0,0 +1,432 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ignitionSchemas maps the Ignition spec versions that can be served to the
+// JSON schema published for that version.
+var ignitionSchemas = map[string]string{
+	"2.2.0": ignitionV2_2Schema,
+}
+
+// validateIgnitionSchema validates the serialized Ignition config raw against
+// the JSON schema of the version it declares.
+func validateIgnitionSchema(raw []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("failed to parse config: %v", err)
+	}
+	var version string
+	if m, ok := doc.(map[string]interface{}); ok {
+		if ign, ok := m["ignition"].(map[string]interface{}); ok {
+			version, _ = ign["version"].(string)
+		}
+	}
+	rawSchema, ok := ignitionSchemas[version]
+	if !ok {
+		return fmt.Errorf("no schema for ignition version %q", version)
+	}
+	var s schema
+	if err := json.Unmarshal([]byte(rawSchema), &s); err != nil {
+		return fmt.Errorf("failed to parse schema for ignition version %q: %v", version, err)
+	}
+	return s.validate(&s, "$", doc)
+}
+
+// schema is the subset of JSON schema used by the published Ignition schemas.
+type schema struct {
+	Ref         string             `json:"$ref"`
+	Type        schemaType         `json:"type"`
+	Properties  map[string]*schema `json:"properties"`
+	Required    []string           `json:"required"`
+	Items       *schema            `json:"items"`
+	Enum        []interface{}      `json:"enum"`
+	AllOf       []*schema          `json:"allOf"`
+	Definitions map[string]*schema `json:"definitions"`
+}
+
+// schemaType is the `type` keyword, which can either be a single type or a
+// list of allowed types.
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaType{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*t = schemaType(multi)
+	return nil
+}
+
+// validate checks that v at path conforms to s. root is used to resolve
+// references to definitions.
+func (s *schema) validate(root *schema, path string, v interface{}) error {
+	if s.Ref != "" {
+		ref, err := root.resolve(s.Ref)
+		if err != nil {
+			return err
+		}
+		return ref.validate(root, path, v)
+	}
+	for _, sub := range s.AllOf {
+		if err := sub.validate(root, path, v); err != nil {
+			return err
+		}
+	}
+	if len(s.Type) > 0 && !s.Type.matches(v) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonType(v))
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", path, v, s.Enum)
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, req := range s.Required {
+			if _, ok := val[req]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, req)
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := s.Properties[k]
+			if !ok {
+				continue
+			}
+			if err := prop.validate(root, path+"."+k, val[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range val {
+			if err := s.Items.validate(root, fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *schema) resolve(ref string) (*schema, error) {
+	const prefix = "#/definitions/"
+	if !strings.HasPrefix(ref, prefix) {
+		return nil, fmt.Errorf("unsupported schema reference %q", ref)
+	}
+	def, ok := s.Definitions[strings.TrimPrefix(ref, prefix)]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema reference %q", ref)
+	}
+	return def, nil
+}
+
+func (t schemaType) matches(v interface{}) bool {
+	got := jsonType(v)
+	for _, want := range t {
+		if want == got || (want == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == float64(int64(val)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ignitionV2_2Schema is the JSON schema for Ignition spec v2.2.0, reduced to
+// the keywords understood by schema.
+const ignitionV2_2Schema = `{
+  "type": "object",
+  "required": ["ignition"],
+  "properties": {
+    "ignition": {
+      "type": "object",
+      "required": ["version"],
+      "properties": {
+        "version": {"type": "string", "enum": ["2.2.0"]},
+        "config": {
+          "type": "object",
+          "properties": {
+            "append": {"type": "array", "items": {"$ref": "#/definitions/configReference"}},
+            "replace": {"$ref": "#/definitions/configReference"}
+          }
+        },
+        "timeouts": {
+          "type": "object",
+          "properties": {
+            "httpResponseHeaders": {"type": ["integer", "null"]},
+            "httpTotal": {"type": ["integer", "null"]}
+          }
+        },
+        "security": {
+          "type": "object",
+          "properties": {
+            "tls": {
+              "type": "object",
+              "properties": {
+                "certificateAuthorities": {"type": "array", "items": {"$ref": "#/definitions/caReference"}}
+              }
+            }
+          }
+        }
+      }
+    },
+    "networkd": {
+      "type": "object",
+      "properties": {
+        "units": {"type": "array", "items": {"$ref": "#/definitions/networkdUnit"}}
+      }
+    },
+    "passwd": {
+      "type": "object",
+      "properties": {
+        "users": {"type": "array", "items": {"$ref": "#/definitions/passwdUser"}},
+        "groups": {"type": "array", "items": {"$ref": "#/definitions/passwdGroup"}}
+      }
+    },
+    "storage": {
+      "type": "object",
+      "properties": {
+        "disks": {"type": "array", "items": {"$ref": "#/definitions/disk"}},
+        "raid": {"type": "array", "items": {"$ref": "#/definitions/raid"}},
+        "filesystems": {"type": "array", "items": {"$ref": "#/definitions/filesystem"}},
+        "files": {"type": "array", "items": {"$ref": "#/definitions/file"}},
+        "directories": {"type": "array", "items": {"$ref": "#/definitions/directory"}},
+        "links": {"type": "array", "items": {"$ref": "#/definitions/link"}}
+      }
+    },
+    "systemd": {
+      "type": "object",
+      "properties": {
+        "units": {"type": "array", "items": {"$ref": "#/definitions/systemdUnit"}}
+      }
+    }
+  },
+  "definitions": {
+    "verification": {
+      "type": "object",
+      "properties": {
+        "hash": {"type": ["string", "null"]}
+      }
+    },
+    "configReference": {
+      "type": "object",
+      "required": ["source"],
+      "properties": {
+        "source": {"type": ["string", "null"]},
+        "verification": {"$ref": "#/definitions/verification"}
+      }
+    },
+    "caReference": {
+      "type": "object",
+      "required": ["source"],
+      "properties": {
+        "source": {"type": "string"},
+        "verification": {"$ref": "#/definitions/verification"}
+      }
+    },
+    "dropin": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "contents": {"type": "string"}
+      }
+    },
+    "networkdUnit": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "contents": {"type": "string"},
+        "dropins": {"type": "array", "items": {"$ref": "#/definitions/dropin"}}
+      }
+    },
+    "systemdUnit": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "enable": {"type": "boolean"},
+        "enabled": {"type": ["boolean", "null"]},
+        "mask": {"type": "boolean"},
+        "contents": {"type": "string"},
+        "dropins": {"type": "array", "items": {"$ref": "#/definitions/dropin"}}
+      }
+    },
+    "passwdUser": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "passwordHash": {"type": ["string", "null"]},
+        "sshAuthorizedKeys": {"type": "array", "items": {"type": "string"}},
+        "uid": {"type": ["integer", "null"]},
+        "gecos": {"type": "string"},
+        "homeDir": {"type": "string"},
+        "noCreateHome": {"type": "boolean"},
+        "primaryGroup": {"type": "string"},
+        "groups": {"type": "array", "items": {"type": "string"}},
+        "noUserGroup": {"type": "boolean"},
+        "noLogInit": {"type": "boolean"},
+        "shell": {"type": "string"},
+        "system": {"type": "boolean"},
+        "create": {"type": "object"}
+      }
+    },
+    "passwdGroup": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "gid": {"type": ["integer", "null"]},
+        "passwordHash": {"type": "string"},
+        "system": {"type": "boolean"}
+      }
+    },
+    "disk": {
+      "type": "object",
+      "required": ["device"],
+      "properties": {
+        "device": {"type": "string"},
+        "wipeTable": {"type": "boolean"},
+        "partitions": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "label": {"type": ["string", "null"]},
+              "number": {"type": "integer"},
+              "size": {"type": ["integer", "null"]},
+              "start": {"type": ["integer", "null"]},
+              "typeGuid": {"type": ["string", "null"]},
+              "guid": {"type": ["string", "null"]}
+            }
+          }
+        }
+      }
+    },
+    "raid": {
+      "type": "object",
+      "required": ["name", "level", "devices"],
+      "properties": {
+        "name": {"type": "string"},
+        "level": {"type": "string"},
+        "devices": {"type": "array", "items": {"type": "string"}},
+        "spares": {"type": "integer"},
+        "options": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "filesystem": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "path": {"type": ["string", "null"]},
+        "mount": {
+          "type": "object",
+          "required": ["device", "format"],
+          "properties": {
+            "device": {"type": "string"},
+            "format": {"type": "string", "enum": ["ext4", "btrfs", "xfs", "vfat", "swap"]},
+            "wipeFilesystem": {"type": "boolean"},
+            "label": {"type": ["string", "null"]},
+            "uuid": {"type": ["string", "null"]},
+            "options": {"type": "array", "items": {"type": "string"}}
+          }
+        }
+      }
+    },
+    "nodeUser": {
+      "type": "object",
+      "properties": {
+        "id": {"type": ["integer", "null"]},
+        "name": {"type": ["string", "null"]}
+      }
+    },
+    "node": {
+      "type": "object",
+      "required": ["filesystem", "path"],
+      "properties": {
+        "filesystem": {"type": "string"},
+        "path": {"type": "string"},
+        "overwrite": {"type": ["boolean", "null"]},
+        "user": {"$ref": "#/definitions/nodeUser"},
+        "group": {"$ref": "#/definitions/nodeUser"}
+      }
+    },
+    "file": {
+      "allOf": [{"$ref": "#/definitions/node"}],
+      "type": "object",
+      "properties": {
+        "append": {"type": "boolean"},
+        "mode": {"type": ["integer", "null"]},
+        "contents": {
+          "type": "object",
+          "properties": {
+            "compression": {"type": "string"},
+            "source": {"type": "string"},
+            "verification": {"$ref": "#/definitions/verification"}
+          }
+        }
+      }
+    },
+    "directory": {
+      "allOf": [{"$ref": "#/definitions/node"}],
+      "type": "object",
+      "properties": {
+        "mode": {"type": ["integer", "null"]}
+      }
+    },
+    "link": {
+      "allOf": [{"$ref": "#/definitions/node"}],
+      "type": "object",
+      "required": ["target"],
+      "properties": {
+        "target": {"type": "string"},
+        "hard": {"type": "boolean"}
+      }
+    }
+  }
+}`
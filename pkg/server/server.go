@@ -7,6 +7,7 @@ import (
 	"net/url"
 
 	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+	mcfgv1 "github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	"github.com/openshift/machine-config-operator/pkg/daemon"
 	"github.com/vincent-petithory/dataurl"
 )
@@ -37,11 +38,18 @@ type appenderFunc func(*ignv2_2types.Config) error
 // Server defines the interface that is implemented by different
 // machine config server implementations.
 type Server interface {
+	// GetConfig returns the config served for the request: the rendered
+	// config it asks for by hash, or the current config of the pool.
 	GetConfig(poolRequest) (*ignv2_2types.Config, error)
+	// GetRenderedConfig returns the machine config named by hash that was
+	// rendered for the pool, or nil if there is no such config.
+	GetRenderedConfig(cr poolRequest, hash string) (*mcfgv1.MachineConfig, error)
 }
 
 func getAppenders(cr poolRequest, currMachineConfig string, f kubeconfigFunc) []appenderFunc {
 	appenders := []appenderFunc{
+		// drop files read from secrets on the machine.
+		func(config *ignv2_2types.Config) error { removeSecretFiles(config); return nil },
 		// append machine annotations file.
 		func(config *ignv2_2types.Config) error { return appendNodeAnnotations(config, currMachineConfig) },
 		// append kubeconfig.
@@ -50,6 +58,19 @@ func getAppenders(cr poolRequest, currMachineConfig string, f kubeconfigFunc) []
 	return appenders
 }
 
+// removeSecretFiles removes the files sourced from a secret mounted on the
+// machine. Ignition can't fetch them, MachineConfigDaemon writes them once it
+// runs on the machine.
+func removeSecretFiles(conf *ignv2_2types.Config) {
+	var files []ignv2_2types.File
+	for _, f := range conf.Storage.Files {
+		if !daemon.IsSecretFileSource(f.Contents.Source) {
+			files = append(files, f)
+		}
+	}
+	conf.Storage.Files = files
+}
+
 func appendKubeConfig(conf *ignv2_2types.Config, f kubeconfigFunc) error {
 	kcData, _, err := f()
 	if err != nil {
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"path"
 	"reflect"
 	"testing"
@@ -12,6 +15,9 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/apis/machineconfiguration.openshift.io/v1"
 	"github.com/openshift/machine-config-operator/pkg/daemon"
 	"github.com/openshift/machine-config-operator/pkg/generated/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 )
 
 const (
@@ -182,6 +188,202 @@ func TestClusterServer(t *testing.T) {
 	validateIgnitionSystemd(t, res.Systemd.Units, mc.Spec.Config.Systemd.Units)
 }
 
+// TestClusterServerRolloutWave verifies that only nodes in a promoted rollout
+// wave are served the current config of the pool, while the other nodes keep
+// getting the config they are on.
+func TestClusterServerRolloutWave(t *testing.T) {
+	newNode := func(name, wave, currentConfig string) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{daemon.CurrentMachineConfigAnnotationKey: currentConfig},
+		}}
+		if wave != "" {
+			node.Labels[v1.RolloutWaveLabelKey] = wave
+		}
+		return node
+	}
+
+	wave := int32(0)
+	mp := &v1.MachineConfigPool{
+		ObjectMeta: metav1.ObjectMeta{Name: testPool},
+		Spec:       v1.MachineConfigPoolSpec{RolloutWave: &wave},
+		Status:     v1.MachineConfigPoolStatus{CurrentMachineConfig: "new-config"},
+	}
+	cs := fake.NewSimpleClientset(mp, newPoolConfig("old-config", testPool), newPoolConfig("new-config", testPool), newPoolConfig("other-config", "other-pool"))
+	kc := k8sfake.NewSimpleClientset(
+		newNode("node-0", "0", "old-config"),
+		newNode("node-1", "1", "old-config"),
+		newNode("node-2", "", "old-config"),
+		newNode("node-3", "1", "other-config"),
+		newNode("node-4", "invalid", "old-config"),
+	)
+	csc := &clusterServer{
+		machineClient:  cs.MachineconfigurationV1(),
+		nodeClient:     kc.CoreV1(),
+		kubeconfigFunc: func() ([]byte, []byte, error) { return getKubeConfigContent(t) },
+	}
+	handler := NewServerAPIHandler(csc, APIHandlerOptions{})
+
+	servedConfig := func(query string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/"+testPool+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d for %s, received: %d", http.StatusOK, query, w.Code)
+		}
+		var conf ignv2_2types.Config
+		if err := json.Unmarshal(w.Body.Bytes(), &conf); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range conf.Storage.Files {
+			if f.Path == "/etc/config-name" {
+				return f.Contents.Source[len("data:,"):]
+			}
+		}
+		t.Fatalf("no config name served for %s", query)
+		return ""
+	}
+
+	tests := []struct {
+		query  string
+		config string
+	}{{
+		// no node, e.g. a new machine
+		query:  "",
+		config: "new-config",
+	}, {
+		// node in the promoted wave
+		query:  "?node=node-0",
+		config: "new-config",
+	}, {
+		// node in a later wave keeps its config
+		query:  "?node=node-1",
+		config: "old-config",
+	}, {
+		// node without a wave is in wave 0
+		query:  "?node=node-2",
+		config: "new-config",
+	}, {
+		// node on a config that wasn't rendered for the pool
+		query:  "?node=node-3",
+		config: "new-config",
+	}, {
+		// node with an invalid wave is never promoted
+		query:  "?node=node-4",
+		config: "old-config",
+	}, {
+		// node that didn't join the cluster yet
+		query:  "?node=node-5",
+		config: "new-config",
+	}}
+	for _, test := range tests {
+		if got := servedConfig(test.query); got != test.config {
+			t.Errorf("expected %s for %q, got: %s", test.config, test.query, got)
+		}
+	}
+
+	// once its wave is promoted the node gets the current config.
+	wave = 1
+	if _, err := cs.MachineconfigurationV1().MachineConfigPools().Update(mp); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedConfig("?node=node-1"); got != "new-config" {
+		t.Errorf("expected new-config for node-1 once its wave is promoted, got: %s", got)
+	}
+}
+
+// TestClusterServerByHash verifies the rendered configs of the pool are served
+// by hash, and any other config isn't.
+func TestClusterServerByHash(t *testing.T) {
+	mp := &v1.MachineConfigPool{
+		ObjectMeta: metav1.ObjectMeta{Name: testPool},
+		Status:     v1.MachineConfigPoolStatus{CurrentMachineConfig: "new-config"},
+	}
+	cs := fake.NewSimpleClientset(mp, newPoolConfig("old-config", testPool), newPoolConfig("new-config", testPool), newPoolConfig("other-config", "other-pool"))
+	csc := &clusterServer{
+		machineClient:  cs.MachineconfigurationV1(),
+		kubeconfigFunc: func() ([]byte, []byte, error) { return getKubeConfigContent(t) },
+	}
+	handler := NewServerAPIHandler(csc, APIHandlerOptions{})
+
+	tests := []struct {
+		path   string
+		status int
+		config string
+	}{{
+		path:   "/by-hash/old-config",
+		status: http.StatusOK,
+		config: "old-config",
+	}, {
+		path:   "/by-hash/new-config",
+		status: http.StatusOK,
+		config: "new-config",
+	}, {
+		// garbage collected or never rendered
+		path:   "/by-hash/missing-config",
+		status: http.StatusNotFound,
+	}, {
+		// rendered for another pool
+		path:   "/by-hash/other-config",
+		status: http.StatusNotFound,
+	}, {
+		path:   "/by-hash/",
+		status: http.StatusNotFound,
+	}}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/"+testPool+test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("expected %d for %s, received: %d", test.status, test.path, w.Code)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var conf ignv2_2types.Config
+		if err := json.Unmarshal(w.Body.Bytes(), &conf); err != nil {
+			t.Fatal(err)
+		}
+		exp := newPoolConfig(test.config, testPool).Spec.Config
+		if err := appendNodeAnnotations(&exp, test.config); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendKubeConfig(&exp, csc.kubeconfigFunc); err != nil {
+			t.Fatal(err)
+		}
+		validateIgnitionFiles(t, exp.Storage.Files, conf.Storage.Files)
+	}
+}
+
+// newPoolConfig returns a config rendered for pool, with a file holding
+// its name.
+func newPoolConfig(name, pool string) *v1.MachineConfig {
+	return &v1.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "MachineConfigPool",
+				Name:       pool,
+				Controller: boolToPtr(true),
+			}},
+		},
+		Spec: v1.MachineConfigSpec{
+			Config: ignv2_2types.Config{
+				Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()},
+				Storage: ignv2_2types.Storage{Files: []ignv2_2types.File{{
+					Node:          ignv2_2types.Node{Filesystem: defaultFileSystem, Path: "/etc/config-name"},
+					FileEmbedded1: ignv2_2types.FileEmbedded1{Contents: ignv2_2types.FileContents{Source: "data:," + name}},
+				}}},
+			},
+		},
+	}
+}
+
+func boolToPtr(b bool) *bool {
+	return &b
+}
+
 func getKubeConfigContent(t *testing.T) ([]byte, []byte, error) {
 	return []byte("dummy-kubeconfig"), []byte("dummy-root-ca"), nil
 }
@@ -246,3 +448,21 @@ func getTestMachinePool() (*v1.MachineConfigPool, error) {
 	}
 	return mp, nil
 }
+
+// TestRemoveSecretFiles verifies that files sourced from secrets on the
+// machine are not served, as Ignition can't fetch them.
+func TestRemoveSecretFiles(t *testing.T) {
+	conf := &ignv2_2types.Config{}
+	appendFileToIgnition(conf, "/etc/motd", "hello")
+	conf.Storage.Files = append(conf.Storage.Files, ignv2_2types.File{
+		Node: ignv2_2types.Node{Filesystem: defaultFileSystem, Path: "/etc/token"},
+		FileEmbedded1: ignv2_2types.FileEmbedded1{
+			Contents: ignv2_2types.FileContents{Source: "secret:///etc/kubernetes/secrets/token"},
+		},
+	})
+
+	removeSecretFiles(conf)
+	if len(conf.Storage.Files) != 1 || conf.Storage.Files[0].Path != "/etc/motd" {
+		t.Errorf("expected only /etc/motd to be served, got %v", conf.Storage.Files)
+	}
+}
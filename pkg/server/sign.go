@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// attestationHeader is the response header carrying the base64 encoded
+	// DSSE envelope of the attestation over the served config.
+	attestationHeader = "X-Machine-Config-Attestation"
+
+	// intotoPayloadType is the DSSE payload type of in-toto statements.
+	intotoPayloadType = "application/vnd.in-toto+json"
+	// intotoStatementType is the type of in-toto v0.1 statements.
+	intotoStatementType = "https://in-toto.io/Statement/v0.1"
+	// ProvenancePredicateType is the predicate type of the attestations
+	// attached to served configs.
+	ProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+	// provenanceBuildType identifies configs rendered by the machine config
+	// controller and served by the machine config server.
+	provenanceBuildType = "https://github.com/openshift/machine-config-operator/served-config@v1"
+)
+
+// Statement is an in-toto statement attesting the provenance of a served config.
+type Statement struct {
+	Type          string     `json:"_type"`
+	PredicateType string     `json:"predicateType"`
+	Subject       []Subject  `json:"subject"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is the artifact a Statement is about.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is the SLSA provenance predicate of a served config.
+type Provenance struct {
+	Builder   ProvenanceBuilder  `json:"builder"`
+	BuildType string             `json:"buildType"`
+	Metadata  ProvenanceMetadata `json:"metadata"`
+}
+
+// ProvenanceBuilder identifies who signed the served config.
+type ProvenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+// ProvenanceMetadata records when the config was served.
+type ProvenanceMetadata struct {
+	BuildFinishedOn time.Time `json:"buildFinishedOn"`
+}
+
+// Envelope is a DSSE envelope, as produced by `cosign attest`.
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is a signature of an Envelope.
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Signer signs attestations over the served configs with an ECDSA key, the
+// default key type of cosign.
+type Signer struct {
+	identity string
+	key      *ecdsa.PrivateKey
+	keyID    string
+}
+
+// NewSigner loads the PEM encoded ECDSA private key from keyFile.
+// identity is recorded as the builder of the attested configs.
+// It returns nil if no keyFile is provided.
+func NewSigner(keyFile, identity string) (*Signer, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	if identity == "" {
+		return nil, fmt.Errorf("a signing identity is required to sign configs")
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key: %v", err)
+	}
+	key, err := parseSigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key %s: %v", keyFile, err)
+	}
+	keyID, err := publicKeyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		identity: identity,
+		key:      key,
+		keyID:    keyID,
+	}, nil
+}
+
+// parseSigningKey parses a PEM encoded EC or PKCS#8 ECDSA private key.
+func parseSigningKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T, expected an ECDSA key", key)
+	}
+	return ecKey, nil
+}
+
+// publicKeyID is the hex encoded sha256 of the DER encoded public key.
+func publicKeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("could not encode public key: %v", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Attest returns the base64 encoded DSSE envelope of a signed provenance
+// statement over data, served as name.
+func (s *Signer) Attest(name string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	statement := Statement{
+		Type:          intotoStatementType,
+		PredicateType: ProvenancePredicateType,
+		Subject: []Subject{{
+			Name:   name,
+			Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		}},
+		Predicate: Provenance{
+			Builder:   ProvenanceBuilder{ID: s.identity},
+			BuildType: provenanceBuildType,
+			Metadata:  ProvenanceMetadata{BuildFinishedOn: time.Now().UTC()},
+		},
+	}
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return "", err
+	}
+	return s.signEnvelope(payload)
+}
+
+// signEnvelope returns the base64 encoded DSSE envelope of the signed in-toto
+// statement payload.
+func (s *Signer) signEnvelope(payload []byte) (string, error) {
+	digest := sha256.Sum256(preAuthEncoding(intotoPayloadType, payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign attestation: %v", err)
+	}
+
+	envelope, err := json.Marshal(Envelope{
+		PayloadType: intotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []EnvelopeSignature{{
+			KeyID: s.keyID,
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// VerifyAttestation verifies the base64 encoded DSSE envelope attestation
+// is signed by pub and is a provenance attestation about data. It returns the
+// attested statement.
+func VerifyAttestation(attestation string, data []byte, pub *ecdsa.PublicKey) (*Statement, error) {
+	payload, err := openEnvelope(attestation, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var statement Statement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil, fmt.Errorf("could not decode attestation statement: %v", err)
+	}
+	if statement.PredicateType != ProvenancePredicateType {
+		return nil, fmt.Errorf("unexpected attestation predicate type %q", statement.PredicateType)
+	}
+	if !subjectsInclude(statement.Subject, data) {
+		return nil, errors.New("attestation is not about the served config")
+	}
+	return &statement, nil
+}
+
+// openEnvelope verifies the base64 encoded DSSE envelope attestation is
+// signed by pub and returns its in-toto statement payload.
+func openEnvelope(attestation string, pub *ecdsa.PublicKey) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(attestation)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode attestation: %v", err)
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("could not decode attestation envelope: %v", err)
+	}
+	if envelope.PayloadType != intotoPayloadType {
+		return nil, fmt.Errorf("unexpected attestation payload type %q", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode attestation payload: %v", err)
+	}
+
+	digest := sha256.Sum256(preAuthEncoding(envelope.PayloadType, payload))
+	verified := false
+	for _, s := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		if ecdsa.VerifyASN1(pub, digest[:], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("no valid signature found on attestation")
+	}
+	return payload, nil
+}
+
+// subjectsInclude returns true if one of the subjects has the sha256 of data.
+func subjectsInclude(subjects []Subject, data []byte) bool {
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	for _, subject := range subjects {
+		if subject.Digest["sha256"] == want {
+			return true
+		}
+	}
+	return false
+}
+
+// preAuthEncoding is the DSSE v1 pre-authentication encoding signed over.
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	b.Write(payload)
+	return b.Bytes()
+}
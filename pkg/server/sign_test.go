@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// writeTestSigningKey writes a PKCS#8 PEM encoded ECDSA key to dir.
+func writeTestSigningKey(t *testing.T, dir string) (string, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "cosign.key")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func TestNewSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcs-signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile, _ := writeTestSigningKey(t, dir)
+
+	if s, err := NewSigner("", ""); s != nil || err != nil {
+		t.Errorf("expected signing to be off without a key, got signer: %v, error: %v", s, err)
+	}
+	if _, err := NewSigner(keyFile, ""); err == nil {
+		t.Error("expected an error without a signing identity")
+	}
+	if _, err := NewSigner(filepath.Join(dir, "missing.key"), "mcs@example.com"); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+	if _, err := NewSigner(keyFile, "mcs@example.com"); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestAPIHandlerAttestation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcs-signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile, key := writeTestSigningKey(t, dir)
+	signer, err := NewSigner(keyFile, "mcs@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			return &ignv2_2types.Config{
+				Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()},
+			}, nil
+		},
+	}
+
+	for _, accept := range []string{contentTypeJSON, contentTypeYAML} {
+		req := httptest.NewRequest("GET", "http://testrequest/config/master", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		NewServerAPIHandler(ms, APIHandlerOptions{Signer: signer}).ServeHTTP(w, req)
+
+		attestation := w.Header().Get(attestationHeader)
+		if attestation == "" {
+			t.Fatalf("expected an attestation for %s", accept)
+		}
+		body := w.Body.Bytes()
+		statement, err := VerifyAttestation(attestation, body, &key.PublicKey)
+		if err != nil {
+			t.Fatalf("expected the attestation for %s to verify, got: %v", accept, err)
+		}
+		if statement.PredicateType != ProvenancePredicateType {
+			t.Errorf("expected predicate type %s, got: %s", ProvenancePredicateType, statement.PredicateType)
+		}
+		if statement.Predicate.Builder.ID != "mcs@example.com" {
+			t.Errorf("expected builder mcs@example.com, got: %s", statement.Predicate.Builder.ID)
+		}
+		if statement.Subject[0].Name != "/config/master" {
+			t.Errorf("expected subject /config/master, got: %s", statement.Subject[0].Name)
+		}
+
+		// the attestation must not verify for other bytes or keys
+		if _, err := VerifyAttestation(attestation, append(body, ' '), &key.PublicKey); err == nil {
+			t.Errorf("expected the attestation for %s not to verify modified bytes", accept)
+		}
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyAttestation(attestation, body, &other.PublicKey); err == nil {
+			t.Errorf("expected the attestation for %s not to verify with another key", accept)
+		}
+	}
+
+	// no attestation is attached when signing is off
+	req := httptest.NewRequest("GET", "http://testrequest/config/master", nil)
+	w := httptest.NewRecorder()
+	NewServerAPIHandler(ms, APIHandlerOptions{}).ServeHTTP(w, req)
+	if got := w.Header().Get(attestationHeader); got != "" {
+		t.Errorf("expected no attestation without a signer, got: %s", got)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// renderCall is a render in flight, shared by the requests waiting for it.
+type renderCall struct {
+	wg sync.WaitGroup
+	// waiters is how many requests joined the render after it started.
+	waiters  int
+	rendered *renderedConfig
+	err      error
+}
+
+// renderGroup coalesces the concurrent renders of the same config, so a burst
+// of requests for a pool costs a single render. Results are not kept once
+// the render is done, the next request renders the config again.
+// The zero value is ready to use.
+type renderGroup struct {
+	mu    sync.Mutex
+	calls map[string]*renderCall
+}
+
+// do runs render and returns its result, unless a render for the key is
+// already in flight, in which case it waits for that render and returns its
+// result instead. The result is shared and must not be modified. A render
+// that panics fails with an error for every request waiting for it.
+func (g *renderGroup) do(key string, render func() (*renderedConfig, error)) (*renderedConfig, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*renderCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.rendered, c.err
+	}
+	c := &renderCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.render(key, c, render)
+	return c.rendered, c.err
+}
+
+// render runs render for the call c of key, and releases the requests
+// waiting for it however render returns.
+func (g *renderGroup) render(key string, c *renderCall, render func() (*renderedConfig, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("Rendering config %s panicked: %v\n%s", key, r, debug.Stack())
+			c.rendered, c.err = nil, fmt.Errorf("rendering config %s panicked: %v", key, r)
+		}
+	}()
+	c.rendered, c.err = render()
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// TestAPIHandlerCoalescesRenders fires many concurrent requests for a pool
+// and verifies they are all served from a single render.
+func TestAPIHandlerCoalescesRenders(t *testing.T) {
+	const requests = 50
+	var renders int32
+	release := make(chan struct{})
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			atomic.AddInt32(&renders, 1)
+			<-release
+			return &ignv2_2types.Config{Ignition: ignv2_2types.Ignition{Version: "2.2.0"}}, nil
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{})
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, requests)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/master", nil))
+		}(recorders[i])
+	}
+
+	// hold the render until every other request is waiting for it.
+	waiting := func() int {
+		handler.renders.mu.Lock()
+		defer handler.renders.mu.Unlock()
+		for _, c := range handler.renders.calls {
+			return c.waiters
+		}
+		return 0
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for waiting() < requests-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the requests to join the render, %d joined", waiting())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&renders); n != 1 {
+		t.Errorf("expected a single render, got %d", n)
+	}
+	for _, w := range recorders {
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d, received: %d", http.StatusOK, w.Code)
+		}
+		if !bytes.Equal(w.Body.Bytes(), recorders[0].Body.Bytes()) {
+			t.Errorf("expected the same config for every request, got:\n%s\nand:\n%s", recorders[0].Body.Bytes(), w.Body.Bytes())
+		}
+	}
+
+	// results aren't kept once the render is done.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/master", nil))
+	if n := atomic.LoadInt32(&renders); n != 2 {
+		t.Errorf("expected the config to be rendered again, got %d renders", n)
+	}
+}
+
+// TestRenderGroupKeys verifies renders of different keys are not coalesced.
+func TestRenderGroupKeys(t *testing.T) {
+	var g renderGroup
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan *renderedConfig)
+	go func() {
+		rendered, _ := g.do("master", func() (*renderedConfig, error) {
+			close(started)
+			<-release
+			return &renderedConfig{data: []byte("master")}, nil
+		})
+		done <- rendered
+	}()
+	<-started
+
+	rendered, err := g.do("worker", func() (*renderedConfig, error) {
+		return &renderedConfig{data: []byte("worker")}, nil
+	})
+	if err != nil || string(rendered.data) != "worker" {
+		t.Errorf("expected the worker config to be rendered, got %v, %v", rendered, err)
+	}
+	close(release)
+	if rendered := <-done; string(rendered.data) != "master" {
+		t.Errorf("expected the master config, got %s", rendered.data)
+	}
+}
+
+// TestRenderGroupPanic verifies a render that panics fails for the requests
+// waiting for it, and doesn't keep the key in flight.
+func TestRenderGroupPanic(t *testing.T) {
+	var g renderGroup
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := g.do("master", func() (*renderedConfig, error) {
+			close(started)
+			<-release
+			panic("broken")
+		})
+		done <- err
+	}()
+	<-started
+
+	waiter := make(chan error)
+	go func() {
+		_, err := g.do("master", func() (*renderedConfig, error) {
+			t.Error("expected the waiter to join the render in flight")
+			return nil, nil
+		})
+		waiter <- err
+	}()
+	// wait for the waiter to join before the render panics.
+	joined := func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["master"].waiters == 1
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !joined() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the request to join the render")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for _, ch := range []chan error{done, waiter} {
+		if err := <-ch; err == nil || !strings.Contains(err.Error(), "panicked: broken") {
+			t.Errorf("expected the panic to fail the render, got: %v", err)
+		}
+	}
+
+	rendered, err := g.do("master", func() (*renderedConfig, error) {
+		return &renderedConfig{data: []byte("master")}, nil
+	})
+	if err != nil || string(rendered.data) != "master" {
+		t.Errorf("expected the config to be rendered again, got %v, %v", rendered, err)
+	}
+}
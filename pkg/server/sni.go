@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// KeyPair is a pair of cert and key files used for serving TLS.
+type KeyPair struct {
+	Cert string
+	Key  string
+}
+
+// SNIConfig maps SNI hostnames to machine pools, and machine pools to
+// the certificate used to serve them.
+type SNIConfig struct {
+	// Hostnames maps SNI hostnames to machine pool names.
+	Hostnames map[string]string
+	// PoolCerts maps machine pool names to their serving certificate.
+	PoolCerts map[string]KeyPair
+}
+
+// ParseSNIConfig builds the SNIConfig from hostname mappings of the form
+// `<hostname>=<pool>` and pool certificates of the form `<pool>=<cert>:<key>`.
+// It returns nil if no hostnames are provided.
+func ParseSNIConfig(hostnames, poolCerts []string) (*SNIConfig, error) {
+	if len(hostnames) == 0 {
+		return nil, nil
+	}
+
+	sni := &SNIConfig{
+		Hostnames: map[string]string{},
+		PoolCerts: map[string]KeyPair{},
+	}
+	for _, pc := range poolCerts {
+		parts := strings.SplitN(pc, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid pool certificate %q, expected <pool>=<cert>:<key>", pc)
+		}
+		files := strings.SplitN(parts[1], ":", 2)
+		if len(files) != 2 || files[0] == "" || files[1] == "" {
+			return nil, fmt.Errorf("invalid pool certificate %q, expected <pool>=<cert>:<key>", pc)
+		}
+		sni.PoolCerts[parts[0]] = KeyPair{Cert: files[0], Key: files[1]}
+	}
+	for _, h := range hostnames {
+		parts := strings.SplitN(h, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid SNI hostname %q, expected <hostname>=<pool>", h)
+		}
+		if _, ok := sni.PoolCerts[parts[1]]; !ok {
+			return nil, fmt.Errorf("no certificate provided for pool %q of SNI hostname %q", parts[1], parts[0])
+		}
+		sni.Hostnames[strings.ToLower(parts[0])] = parts[1]
+	}
+	return sni, nil
+}
+
+// certificateSelector selects the serving certificate for a TLS handshake
+// based on the SNI hostname requested by the client.
+type certificateSelector struct {
+	defaultCert *tls.Certificate
+	// hostnames maps SNI hostnames to machine pool names.
+	hostnames map[string]string
+	// certs maps machine pool names to their serving certificate.
+	certs map[string]*tls.Certificate
+}
+
+// newCertificateSelector loads the default certificate and all the pool
+// certificates from disk.
+func newCertificateSelector(cert, key string, sni *SNIConfig) (*certificateSelector, error) {
+	defaultCert, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not load default certificate: %v", err)
+	}
+
+	cs := &certificateSelector{
+		defaultCert: &defaultCert,
+		hostnames:   sni.Hostnames,
+		certs:       map[string]*tls.Certificate{},
+	}
+	for pool, kp := range sni.PoolCerts {
+		c, err := tls.LoadX509KeyPair(kp.Cert, kp.Key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load certificate for pool %s: %v", pool, err)
+		}
+		cs.certs[pool] = &c
+	}
+	return cs, nil
+}
+
+// TLSConfig returns the tls.Config serving the certificates of the selector.
+func (cs *certificateSelector) TLSConfig() *tls.Config {
+	return &tls.Config{
+		// used when the client doesn't send an SNI hostname.
+		Certificates:   []tls.Certificate{*cs.defaultCert},
+		GetCertificate: cs.GetCertificate,
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Unknown hostnames are
+// served the default certificate.
+func (cs *certificateSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	pool, ok := cs.hostnames[strings.ToLower(hello.ServerName)]
+	if !ok {
+		return cs.defaultCert, nil
+	}
+	return cs.certs[pool], nil
+}
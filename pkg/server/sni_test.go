@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestKeyPair writes a self-signed cert with the given common name
+// along with its key to dir.
+func writeTestKeyPair(t *testing.T, dir, cn string) KeyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp := KeyPair{
+		Cert: filepath.Join(dir, cn+".crt"),
+		Key:  filepath.Join(dir, cn+".key"),
+	}
+	if err := ioutil.WriteFile(kp.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(kp.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestParseSNIConfig(t *testing.T) {
+	sni, err := ParseSNIConfig(nil, nil)
+	if err != nil || sni != nil {
+		t.Errorf("expected no SNI config without hostnames, got: %v, %v", sni, err)
+	}
+
+	sni, err = ParseSNIConfig([]string{"Edge.example.com=edge"}, []string{"edge=/tls/edge.crt:/tls/edge.key"})
+	if err != nil {
+		t.Fatalf("expected err to be nil, received: %v", err)
+	}
+	if pool := sni.Hostnames["edge.example.com"]; pool != "edge" {
+		t.Errorf("expected hostname to map to pool edge, got: %q", pool)
+	}
+	if kp := sni.PoolCerts["edge"]; kp.Cert != "/tls/edge.crt" || kp.Key != "/tls/edge.key" {
+		t.Errorf("unexpected key pair for pool edge: %+v", kp)
+	}
+
+	if _, err := ParseSNIConfig([]string{"edge.example.com=edge"}, nil); err == nil {
+		t.Error("expected error for hostname with pool without certificate")
+	}
+	if _, err := ParseSNIConfig([]string{"edge.example.com=edge"}, []string{"edge=/tls/edge.crt"}); err == nil {
+		t.Error("expected error for pool certificate without key")
+	}
+}
+
+// TestSNICertificateSelection performs TLS handshakes with different SNI
+// hostnames and checks the certificate served for each.
+func TestSNICertificateSelection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcs-sni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	def := writeTestKeyPair(t, dir, "default")
+	sni := &SNIConfig{
+		Hostnames: map[string]string{
+			"edge-a.example.com": "edge-a",
+			"edge-b.example.com": "edge-b",
+			"edge-c.example.com": "edge-b",
+		},
+		PoolCerts: map[string]KeyPair{
+			"edge-a": writeTestKeyPair(t, dir, "edge-a"),
+			"edge-b": writeTestKeyPair(t, dir, "edge-b"),
+		},
+	}
+	cs, err := newCertificateSelector(def.Cert, def.Key, sni)
+	if err != nil {
+		t.Fatalf("expected err to be nil, received: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = cs.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	scenarios := []struct {
+		serverName string
+		expectedCN string
+	}{
+		{serverName: "edge-a.example.com", expectedCN: "edge-a"},
+		{serverName: "edge-b.example.com", expectedCN: "edge-b"},
+		{serverName: "EDGE-C.example.com", expectedCN: "edge-b"},
+		{serverName: "unknown.example.com", expectedCN: "default"},
+		{serverName: "", expectedCN: "default"},
+	}
+	for _, s := range scenarios {
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{
+			ServerName:         s.serverName,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("handshake with SNI %q failed: %v", s.serverName, err)
+		}
+		certs := conn.ConnectionState().PeerCertificates
+		conn.Close()
+		if len(certs) == 0 {
+			t.Errorf("no certificate served for SNI %q", s.serverName)
+			continue
+		}
+		if cn := certs[0].Subject.CommonName; cn != s.expectedCN {
+			t.Errorf("SNI %q: expected certificate %q, got %q", s.serverName, s.expectedCN, cn)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const apiPathStats = "/stats"
+
+// PoolStats are the config serving statistics of a pool.
+type PoolStats struct {
+	Pool string `json:"pool"`
+	// Requests is the number of configs served for the pool.
+	Requests int64 `json:"requests"`
+	// LastServed is when a config was last served for the pool.
+	LastServed time.Time `json:"lastServed"`
+	// ETag is the ETag of the config last served for the pool.
+	ETag string `json:"etag"`
+	// AverageResponseBytes is the average size of the configs served for
+	// the pool.
+	AverageResponseBytes int64 `json:"averageResponseBytes"`
+}
+
+// Stats keeps the config serving statistics of the server per pool. It is
+// safe for concurrent use.
+type Stats struct {
+	mu    sync.Mutex
+	pools map[string]*PoolStats
+	// bytes is the total size of the configs served per pool.
+	bytes map[string]int64
+}
+
+// NewStats returns an empty Stats.
+func NewStats() *Stats {
+	return &Stats{
+		pools: map[string]*PoolStats{},
+		bytes: map[string]int64{},
+	}
+}
+
+// configETag returns the ETag of the served config bytes.
+func configETag(data []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", sha256.Sum256(data)))
+}
+
+// Record adds a config of size bytes with the ETag served for the pool.
+// Recording to a nil Stats does nothing.
+func (s *Stats) Record(pool, etag string, size int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.pools[pool]
+	if !ok {
+		ps = &PoolStats{Pool: pool}
+		s.pools[pool] = ps
+	}
+	ps.Requests++
+	ps.LastServed = time.Now().UTC()
+	ps.ETag = etag
+	s.bytes[pool] += int64(size)
+	ps.AverageResponseBytes = s.bytes[pool] / ps.Requests
+}
+
+// Pools returns the statistics of the pools configs were served for, sorted
+// by pool.
+func (s *Stats) Pools() []PoolStats {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pools []PoolStats
+	for _, ps := range s.pools {
+		pools = append(pools, *ps)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Pool < pools[j].Pool })
+	return pools
+}
+
+// statsHandler serves the statistics of a Stats as JSON to requests that
+// present the bearer token.
+type statsHandler struct {
+	stats *Stats
+	token string
+}
+
+// NewStatsHandler returns the handler serving the config serving statistics to
+// requests with an `Authorization: Bearer <token>` header.
+func NewStatsHandler(s *Stats, token string) http.Handler {
+	return &statsHandler{stats: s, token: token}
+}
+
+func (h *statsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !hasBearerToken(r, h.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	pools := h.stats.Pools()
+	if pools == nil {
+		pools = []PoolStats{}
+	}
+	data, err := json.Marshal(pools)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestStats(t *testing.T) {
+	var nilStats *Stats
+	nilStats.Record("master", `"etag"`, 1)
+	if got := nilStats.Pools(); got != nil {
+		t.Errorf("expected no stats for a nil Stats, got: %v", got)
+	}
+
+	s := NewStats()
+	before := time.Now().UTC()
+	s.Record("worker", `"a"`, 100)
+	s.Record("master", `"b"`, 10)
+	s.Record("worker", `"c"`, 200)
+	pools := s.Pools()
+	if len(pools) != 2 {
+		t.Fatalf("expected stats for 2 pools, got: %v", pools)
+	}
+	for _, ps := range pools {
+		if ps.LastServed.Before(before) {
+			t.Errorf("expected %s to be last served after %s, got: %s", ps.Pool, before, ps.LastServed)
+		}
+	}
+	exp := []PoolStats{
+		{Pool: "master", Requests: 1, ETag: `"b"`, AverageResponseBytes: 10},
+		{Pool: "worker", Requests: 2, ETag: `"c"`, AverageResponseBytes: 150},
+	}
+	for i := range pools {
+		pools[i].LastServed = time.Time{}
+	}
+	if !reflect.DeepEqual(pools, exp) {
+		t.Errorf("expected %+v, got: %+v", exp, pools)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	stats := NewStats()
+	ms := &mockServer{
+		GetConfigFn: func(pr poolRequest) (*ignv2_2types.Config, error) {
+			if pr.machinePool == "infra" {
+				return nil, fmt.Errorf("infra is broken")
+			}
+			return &ignv2_2types.Config{Ignition: ignv2_2types.Ignition{Version: "2.2.0"}}, nil
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{Stats: stats})
+	etags := map[string]string{}
+	sizes := map[string]int{}
+	for _, pool := range []string{"master", "worker", "worker", "infra"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/"+pool, nil))
+		if pool == "infra" {
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d, received: %d", http.StatusOK, w.Code)
+		}
+		etags[pool] = w.Header().Get("ETag")
+		if etags[pool] != configETag(w.Body.Bytes()) {
+			t.Errorf("expected ETag %s of the served config, received: %s", configETag(w.Body.Bytes()), etags[pool])
+		}
+		sizes[pool] = w.Body.Len()
+	}
+
+	statsHandler := NewStatsHandler(stats, "s3cr3t")
+	for _, auth := range []string{"", "Bearer wrong", "s3cr3t"} {
+		req := httptest.NewRequest("GET", "http://testrequest/stats", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		statsHandler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected %d for authorization %q, received: %d", http.StatusUnauthorized, auth, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://testrequest/stats", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	statsHandler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, received: %d", http.StatusOK, w.Code)
+	}
+	var pools []PoolStats
+	if err := json.Unmarshal(w.Body.Bytes(), &pools); err != nil {
+		t.Fatal(err)
+	}
+	// failed requests are not counted.
+	requests := map[string]int64{"master": 1, "worker": 2}
+	if len(pools) != len(requests) {
+		t.Fatalf("expected stats for %v, got: %+v", requests, pools)
+	}
+	for _, ps := range pools {
+		if ps.Requests != requests[ps.Pool] {
+			t.Errorf("expected %d requests for %s, got: %d", requests[ps.Pool], ps.Pool, ps.Requests)
+		}
+		if ps.ETag != etags[ps.Pool] {
+			t.Errorf("expected ETag %s for %s, got: %s", etags[ps.Pool], ps.Pool, ps.ETag)
+		}
+		if ps.AverageResponseBytes != int64(sizes[ps.Pool]) {
+			t.Errorf("expected an average of %d bytes for %s, got: %d", sizes[ps.Pool], ps.Pool, ps.AverageResponseBytes)
+		}
+		if ps.LastServed.IsZero() {
+			t.Errorf("expected %s to have a last served time", ps.Pool)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+// ConfigRequest is the request a config is served for.
+type ConfigRequest struct {
+	// Pool is the name of the MachineConfigPool requested.
+	Pool string
+	// Node is the name of the node requesting the config, if it is known.
+	Node string
+}
+
+// ConfigTransform post-processes the config served for a request, for example
+// to inject data specific to the requesting node. It may modify the config in
+// place and returns the config to serve.
+type ConfigTransform func(ConfigRequest, *ignv2_2types.Config) (*ignv2_2types.Config, error)
+
+// RegisterConfigTransform adds t to the transforms applied, in the order they
+// are registered, to the configs served by the handler. Transforms must be
+// registered before the handler serves requests.
+func (sh *APIHandler) RegisterConfigTransform(t ConfigTransform) {
+	sh.transforms = append(sh.transforms, t)
+}
+
+// transformConfig applies the registered transforms to the config served for
+// the request.
+func (sh *APIHandler) transformConfig(cr poolRequest, conf *ignv2_2types.Config) (*ignv2_2types.Config, error) {
+	req := ConfigRequest{Pool: cr.machinePool, Node: cr.node}
+	for i, t := range sh.transforms {
+		var err error
+		if conf, err = t(req, conf); err != nil {
+			return nil, fmt.Errorf("transform %d failed: %v", i, err)
+		}
+		if conf == nil {
+			return nil, fmt.Errorf("transform %d returned no config", i)
+		}
+	}
+	return conf, nil
+}
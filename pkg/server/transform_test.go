@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ignv2_2types "github.com/coreos/ignition/config/v2_2/types"
+)
+
+func TestAPIHandlerConfigTransforms(t *testing.T) {
+	ms := &mockServer{
+		GetConfigFn: func(poolRequest) (*ignv2_2types.Config, error) {
+			return &ignv2_2types.Config{Ignition: ignv2_2types.Ignition{Version: ignv2_2types.MaxVersion.String()}}, nil
+		},
+	}
+	handler := NewServerAPIHandler(ms, APIHandlerOptions{})
+	handler.RegisterConfigTransform(func(req ConfigRequest, conf *ignv2_2types.Config) (*ignv2_2types.Config, error) {
+		appendFileToIgnition(conf, "/etc/node-name", req.Node)
+		return conf, nil
+	})
+	// transforms are applied in the order they are registered.
+	handler.RegisterConfigTransform(func(req ConfigRequest, conf *ignv2_2types.Config) (*ignv2_2types.Config, error) {
+		appendFileToIgnition(conf, "/etc/pool-name", req.Pool)
+		return conf, nil
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/worker?node=worker-0", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, received: %d", http.StatusOK, w.Code)
+	}
+	var conf ignv2_2types.Config
+	if err := json.Unmarshal(w.Body.Bytes(), &conf); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/etc/node-name": getEncodedContent("worker-0"),
+		"/etc/pool-name": getEncodedContent("worker"),
+	}
+	for _, f := range conf.Storage.Files {
+		if exp, ok := expected[f.Path]; ok && f.Contents.Source == exp {
+			delete(expected, f.Path)
+		}
+	}
+	if len(expected) > 0 {
+		t.Errorf("expected the transformed files %v to be served, got %v", expected, conf.Storage.Files)
+	}
+
+	handler.RegisterConfigTransform(func(ConfigRequest, *ignv2_2types.Config) (*ignv2_2types.Config, error) {
+		return nil, fmt.Errorf("broken")
+	})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://testrequest/config/worker", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d for a failing transform, received: %d", http.StatusInternalServerError, w.Code)
+	}
+}